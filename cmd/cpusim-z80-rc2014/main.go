@@ -4,241 +4,71 @@ package main
 // Scott Baker
 //
 // A Z80 CPU simulator written in Go. This emulates a simple RC2014 contifiguration with a
-// Zeta-2 style memory mapper, an ACIA, and 512K each of RAM and ROM.
+// Zeta-2 style memory mapper, 512K each of RAM and ROM, and the serial board that
+// --serial picks. These are the rc2014 machines of cpusim.
 
 import (
 	"fmt"
-	"os"
-	"sync"
-	"time"
 
-	"github.com/scottmbaker/gocpusim/pkg/cpusim"
-	"github.com/scottmbaker/gocpusim/pkg/cpusim/cpuz80"
+	"github.com/scottmbaker/gocpusim/pkg/cli"
 	"github.com/spf13/cobra"
 )
 
-const (
-	ACIA_DATA    = 0x81
-	ACIA_CONTROL = 0x80
-
-	SIO_CTRL_A = 0x80
-	SIO_DATA_A = 0x81
-	SIO_CTRL_B = 0x82
-	SIO_DATA_B = 0x83
-
-	SIO_SB_CTRL_A = 0x82
-	SIO_SB_DATA_A = 0x80
-	SIO_SB_CTRL_B = 0x83
-	SIO_SB_DATA_B = 0x81
-
-	ASCI_BASE = 0xC0
-
-	SCC_CTRL_A = 0x80
-	SCC_DATA_A = 0x81
-	SCC_CTRL_B = 0x82
-	SCC_DATA_B = 0x83
-
-	SCC_SB_CTRL_A = 0x81
-	SCC_SB_DATA_A = 0x83
-	SCC_SB_CTRL_B = 0x80
-	SCC_SB_DATA_B = 0x82
-
-	CF_BASE = 0x10
-
-	FDC_PORT_MSR  = 0x50 // Main Status Register (read)
-	FDC_PORT_DATA = 0x51 // Data Register (read/write)
-	FDC_PORT_DOR  = 0x58 // Digital Output Register (write)
-	FDC_PORT_DCR  = 0x48 // Configuration Control Register (write)
-)
+// machines are the built-in machines for each --serial
+var machines = map[string]string{
+	"acia":   "rc2014",
+	"sio":    "rc2014-sio",
+	"sio_sb": "rc2014-sio-sb",
+	"asci":   "rc2014-asci",
+	"scc":    "rc2014-scc",
+	"scc_sb": "rc2014-scc-sb",
+}
 
-var (
-	debug       bool
-	memDebug    bool
-	romFilename string
-	inFilename  string
-	noExitEof   bool
-	serial      string
-	cfImage     string
-	cfIdentify  string
-	cfOffset    int64
-	fdcImage    string
-	ips         int64
-	ioPollDelay time.Duration
-	rootCmd     = &cobra.Command{
-		Use:   "cpusimz80",
-		Short: "scott's Z80 cpu simulator",
-		Long:  "A simulator for the Z80 CPU.",
+func main() {
+	var (
+		serial     string
+		cfImage    string
+		cfIdentify string
+		cfOffset   int64
+		fdcImage   string
+	)
+	board := &cli.Board{
+		Use:     "cpusimz80",
+		Short:   "scott's Z80 cpu simulator",
+		Machine: "rc2014",
 	}
-)
-
-func newZ80Computer() (*cpusim.CpuSim, cpusim.UartInterface) {
-	sim := cpusim.NewCPUSim()
-	sim.SetDebug(debug)
-	sim.SetMemDebug(memDebug)
-
-	// Create a Z80 CPU and attach it to the simulator
-	cpu := cpuz80.NewZ80(sim, "cpu")
-	cpu.PortAddressMask = 0xFF // Use 8-bit port addresses for Z80
-	sim.AddCPU(cpu)
-
-	mapEnable := cpusim.NewEnableBit()
-
-	// ZETA-2 style memory mapper
-	// Port 0x78-0x7B are the page select bits for the mapper. Port 0x7C bit 0 is the enable bit for the mapper.
-	// D6 of the mapper output is used as the enable for the RAM/ROM. When low, ROM is selected. When high, RAM is selected.
-	ramRomEnable := cpusim.NewEnableBit()
-	mapper := cpusim.NewDual74670(sim, "mapper-lo", 0x78, cpusim.A14, cpusim.D0, cpusim.A14, cpusim.A15, cpusim.A16, cpusim.A17, cpusim.A18, -1, -1, -1, &cpusim.AlwaysEnabled, &mapEnable.HiEnable)
-	mapper.ConnectEnableBit(5, ramRomEnable)
-	sim.AddMapper(mapper)
-	sim.AddPort(mapper)
-
-	// When a "1" is written to D0 in the latch, the memory mapper should be enabled
-	mapperEnableLatch := cpusim.NewGenericOutputPort(sim, "mapper-enable-latch", 0x7C, 0, &cpusim.AlwaysEnabled)
-	mapperEnableLatch.ConnectEnableBit(0, mapEnable)
-	sim.AddPort(mapperEnableLatch)
-
-	// 512KB RAM
-	ram := cpusim.NewMemory(sim, "ram", cpusim.KIND_RAM, 0x0000, 0x7FFFF, 19, false, &ramRomEnable.HiEnable)
-	sim.AddMemory(ram)
-
-	// 512KB ROM
-	rom := cpusim.NewMemory(sim, "rom", cpusim.KIND_ROM, 0x0000, 0x7FFFF, 19, true, &ramRomEnable.LoEnable)
-	sim.AddMemory(rom)
-
-	speech := cpusim.NewSp0SpeechDevice(sim, "sp0256", 0x20, &cpusim.AlwaysEnabled)
-	sim.AddPort(speech)
-
-	// UART on I/O ports
-	var serialIO cpusim.SerialIO
-	if inFilename != "" {
-		fs, err := cpusim.NewFileSerial(inFilename, !noExitEof)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: failed to open input file '%s': %v\n", inFilename, err)
-			os.Exit(1)
+	cmd := cli.NewBoardCommand(board)
+	cmd.Long = "A simulator for the Z80 on an RC2014. This is the same as \"cpusim run --machine rc2014\", or rc2014-sio and the like for the other serial boards."
+	cmd.Flags().StringVarP(&serial, "serial", "s", "acia", "type of serial device to use (acia, sio, sio_sb, asci, scc, scc_sb)")
+
+	// The disk flags from before --disk, which they are turned into
+	cmd.Flags().StringVar(&cfImage, "cf-image", "", "CompactFlash disk image file")
+	cmd.Flags().StringVar(&cfIdentify, "cf-identify", "", "CompactFlash identify block file (512 bytes)")
+	cmd.Flags().Int64Var(&cfOffset, "cf-offset", 0, "byte offset to sector 0 in CF image (1024 for emulatorkit, 0 for raw)")
+	cmd.Flags().StringVar(&fdcImage, "fdc-image", "", "floppy disk image file (raw, default geometry 1.44MB)")
+
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		name, ok := machines[serial]
+		if !ok {
+			return fmt.Errorf("invalid serial device type '%s'; valid options are 'acia', 'sio', 'sio_sb', 'asci', 'scc' and 'scc_sb'", serial)
 		}
-		serialIO = fs
-	} else {
-		serialIO = cpusim.NewStdioSerial(true)
-	}
-	var uart cpusim.UartInterface
-	if serial == "acia" {
-		acia := cpusim.NewACIA(sim, serialIO, "uart", ACIA_DATA, ACIA_CONTROL, &cpusim.AlwaysEnabled)
-		sim.AddPort(acia)
-		uart = acia
-	} else if serial == "sio" {
-		sio := cpusim.NewSIO(sim, serialIO, "uart", SIO_DATA_A, SIO_DATA_B, SIO_CTRL_A, SIO_CTRL_B, &cpusim.AlwaysEnabled)
-		sim.AddPort(sio)
-		uart = sio
-	} else if serial == "sio_sb" {
-		sio := cpusim.NewSIO(sim, serialIO, "uart", SIO_SB_DATA_A, SIO_SB_DATA_B, SIO_SB_CTRL_A, SIO_SB_CTRL_B, &cpusim.AlwaysEnabled)
-		sim.AddPort(sio)
-		uart = sio
-	} else if serial == "asci" {
-		asci := cpusim.NewASCI(sim, serialIO, "uart", ASCI_BASE, &cpusim.AlwaysEnabled)
-		sim.AddPort(asci)
-		uart = asci
-	} else if serial == "scc" {
-		scc := cpusim.NewSCC(sim, serialIO, "uart", SCC_DATA_A, SCC_DATA_B, SCC_CTRL_A, SCC_CTRL_B, &cpusim.AlwaysEnabled)
-		sim.AddPort(scc)
-		uart = scc
-	} else if serial == "scc_sb" {
-		scc := cpusim.NewSCC(sim, serialIO, "uart", SCC_SB_DATA_A, SCC_SB_DATA_B, SCC_SB_CTRL_A, SCC_SB_CTRL_B, &cpusim.AlwaysEnabled)
-		sim.AddPort(scc)
-		uart = scc
-	} else {
-		fmt.Fprintf(os.Stderr, "Error: invalid serial device type '%s'. Valid options are 'acia', 'sio', 'asci', and 'scc'.\n", serial)
-		os.Exit(1)
-	}
+		board.Machine = name
 
-	// CompactFlash on I/O ports
-	if cfImage != "" {
-		cf := cpusim.NewCompactFlash(sim, "cf", CF_BASE, &cpusim.AlwaysEnabled)
-		err := cf.AttachImage(cfImage, cfOffset)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
-		}
-		if cfIdentify != "" {
-			err = cf.LoadIdentify(cfIdentify)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error loading identify from file '%s': %v\n", cfIdentify, err)
-				os.Exit(1)
+		if cfImage != "" {
+			disk := fmt.Sprintf("cf=%s,offset=%d", cfImage, cfOffset)
+			if cfIdentify != "" {
+				disk += ",identify=" + cfIdentify
 			}
-		} else if cfOffset > 0 {
-			// if the CF is offset and not identify file is given, assume it's an emulatorkit-style image with the identify block at offset 512
-			err = cf.LoadIdentifyFromImage()
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error loading identify from image: %v\n", err)
-				os.Exit(1)
+			if err := cmd.Flags().Set("disk", disk); err != nil {
+				return err
 			}
-		} else {
-			fmt.Fprintf(os.Stderr, "Error: --cf-identify is required when using a raw CF image\n")
 		}
-
-		sim.AddPort(cf)
-	}
-
-	// Floppy disk controller on I/O ports
-	if fdcImage != "" {
-		fdc := cpusim.NewFDC(sim, "fdc", FDC_PORT_MSR, FDC_PORT_DATA, FDC_PORT_DOR, FDC_PORT_DCR, &cpusim.AlwaysEnabled)
-		err := fdc.AttachImage(0, fdcImage)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
+		if fdcImage != "" {
+			if err := cmd.Flags().Set("disk", "fdc="+fdcImage); err != nil {
+				return err
+			}
 		}
-		sim.AddPort(fdc)
-	}
-
-	// Load ROM file into RAM at 0x0000
-	err := rom.Load(romFilename)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: failed to load ROM file '%s': %v\n", romFilename, err)
-		os.Exit(1)
-	}
-
-	return sim, uart
-}
-
-func mainCommand(cmd *cobra.Command, args []string) {
-	var wg sync.WaitGroup
-
-	if romFilename == "" {
-		fmt.Fprintf(os.Stderr, "Error: --rom-file is required\n")
-		_ = cmd.Help()
-		return
-	}
-
-	sim, uart := newZ80Computer()
-
-	if ips > 0 {
-		sim.SetIPS(ips)
-	}
-	sim.IOPollDelay = ioPollDelay
-
-	sim.Start(&wg)
-	uart.Start(&wg)
-	wg.Wait()
-	uart.RestoreTerminal()
-}
-
-func main() {
-	rootCmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "debug messages")
-	rootCmd.PersistentFlags().BoolVarP(&memDebug, "memDebug", "m", false, "memory debug messages")
-	rootCmd.PersistentFlags().StringVarP(&serial, "serial", "s", "acia", "type of serial device to use (acia, sio, sio_sb, asci, scc)")
-	rootCmd.PersistentFlags().StringVarP(&romFilename, "rom-file", "f", "", "rom filename")
-	rootCmd.PersistentFlags().StringVar(&cfImage, "cf-image", "", "CompactFlash disk image file")
-	rootCmd.PersistentFlags().StringVar(&cfIdentify, "cf-identify", "", "CompactFlash identify block file (512 bytes)")
-	rootCmd.PersistentFlags().Int64Var(&cfOffset, "cf-offset", 0, "byte offset to sector 0 in CF image (1024 for emulatorkit, 0 for raw)")
-	rootCmd.PersistentFlags().StringVar(&fdcImage, "fdc-image", "", "floppy disk image file (raw, default geometry 1.44MB)")
-	rootCmd.PersistentFlags().Int64Var(&ips, "ips", 0, "instructions per second throttle (0 = unlimited)")
-	rootCmd.PersistentFlags().DurationVar(&ioPollDelay, "io-poll-delay", 0, "delay when polling serial with no data available (e.g. 1ms)")
-	rootCmd.PersistentFlags().StringVarP(&inFilename, "in-file", "t", "", "pre-load UART input from file")
-	rootCmd.PersistentFlags().BoolVar(&noExitEof, "no-exit", false, "don't exit on EOF when using --in-file, fall through to stdin")
-	rootCmd.Run = mainCommand
-
-	err := rootCmd.Execute()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		return
+		return nil
 	}
+	cli.Execute(cmd)
 }
@@ -0,0 +1,16 @@
+package main
+
+// go-cpusim
+// Scott Baker
+//
+// A CPU simulator for any of the machines it knows, or for one described in
+// a YAML file. The boards that used to have commands of their own are
+// built-in machines; try "cpusim list-machines".
+
+import (
+	"github.com/scottmbaker/gocpusim/pkg/cli"
+)
+
+func main() {
+	cli.Execute(cli.NewCommand())
+}
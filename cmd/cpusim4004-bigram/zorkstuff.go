@@ -11,25 +11,14 @@ import (
 	"fmt"
 	"github.com/scottmbaker/gocpusim/pkg/cpusim"
 	"github.com/scottmbaker/gocpusim/pkg/cpusim/cpu4004"
-	"sort"
+	"os"
 )
 
-type ProfileOperation struct {
-	OpCode    string // use strings because opcode of multiple operand forms map to same base opcode
-	Count     int
-	Cycles    int
-	MaxCycles int
-}
-
 const (
 	OPCODE_STARTUP = "startup" // for the profiler, indicates startup
 	OPCODE_BETWEEN = "between" // for the profiler, indicates time between opcodes
 )
 
-var Profiler map[string]*ProfileOperation = map[string]*ProfileOperation{}
-var LastOpcode string = OPCODE_STARTUP
-var LastOpcodeCycleStart int = 0
-
 var opCodeName = map[int]string{}
 
 func initOpcodes() {
@@ -122,12 +111,6 @@ func closeProfilerOpcode(sim *cpusim.CpuSim, nextOpcode byte, runSignal bool) {
 		return
 	}
 
-	cpu := sim.CPU[0].(*cpu4004.CPU4004)
-
-	opcode := LastOpcode
-
-	cycles := cpu.Cycles - LastOpcodeCycleStart
-
 	nextOpcodeName := ""
 	var okay bool
 
@@ -140,50 +123,14 @@ func closeProfilerOpcode(sim *cpusim.CpuSim, nextOpcode byte, runSignal bool) {
 		}
 	}
 
-	LastOpcode = nextOpcodeName
-	LastOpcodeCycleStart = cpu.Cycles
-
-	prof, exists := Profiler[opcode]
-	if !exists {
-		prof = &ProfileOperation{OpCode: opcode, Count: 0, Cycles: 0, MaxCycles: 0}
-		Profiler[opcode] = prof
-	}
-	prof.Count++
-	prof.Cycles += cycles
-	if cycles > prof.MaxCycles {
-		prof.MaxCycles = cycles
-	}
+	// Each Z-machine opcode is a section of the profile, timed from the
+	// interpreter's hook for one opcode to the next
+	profiler.BeginSection(nextOpcodeName)
 }
 
 func printProfiler() {
 	fmt.Printf("\nProfiler Results:\n")
-	fmt.Printf("Opcode          Count       Cycles      Avg Cycles  Max Cycles\n")
-	fmt.Printf("---------------------------------------------------------------\n")
-	keys := make([]string, 0, len(Profiler))
-	for key := range Profiler {
-		keys = append(keys, key)
-	}
-
-	sort.Slice(keys, func(i, j int) bool {
-		avgCyclesI := 0
-		if Profiler[keys[i]].Count > 0 {
-			avgCyclesI = Profiler[keys[i]].Cycles / Profiler[keys[i]].Count
-		}
-		avgCyclesJ := 0
-		if Profiler[keys[j]].Count > 0 {
-			avgCyclesJ = Profiler[keys[j]].Cycles / Profiler[keys[j]].Count
-		}
-		return avgCyclesI > avgCyclesJ
-	})
-
-	for _, key := range keys {
-		prof := Profiler[key]
-		avgCycles := 0
-		if prof.Count > 0 {
-			avgCycles = prof.Cycles / prof.Count
-		}
-		fmt.Printf("%-15s %-11d %-11d %-11d %-11d\n", prof.OpCode, prof.Count, prof.Cycles, avgCycles, prof.MaxCycles)
-	}
+	_ = profiler.WriteSections(os.Stdout)
 }
 
 func insDebug(sim *cpusim.CpuSim) {
@@ -0,0 +1,227 @@
+// Package cli is the command line of cpusim, which runs any of the machines
+// it knows or one described in a YAML file, and of the per-board commands,
+// each of which runs one of the built-in machines.
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+	"github.com/scottmbaker/gocpusim/pkg/machine"
+	"github.com/spf13/cobra"
+)
+
+var (
+	debug         bool
+	memDebug      bool
+	machineName   string
+	romFilename   string
+	inFilename    string
+	noExitEof     bool
+	disks         []string
+	ips           int64
+	clock         string
+	loadState     string
+	symbols       []string
+	loadFiles     []string
+	erasedROM     bool
+	trace         cpusim.TraceOptions
+	profileFile   string
+	callgrind     string
+	profiler      *cpusim.Profiler
+	saveState     string
+	monitorOn     bool
+	rewind        uint64
+	gdbAddress    string
+	ioPollDelay   time.Duration
+	disasmStart   uint64
+	disasmEnd     uint64
+	disasmBase    uint64
+	disasmCPU     string
+	listKinds     bool
+	deterministic bool
+	inputScript   string
+	cpmDir        string
+
+	board *Board // set when a per-board command is running
+)
+
+// Board describes a command that runs one of the built-in machines, as the
+// per-board commands did before cpusim could run them all.
+type Board struct {
+	Use     string
+	Short   string
+	Machine string // the built-in machine, which may be changed until it runs
+
+	// Setup, if set, is called once the machine is built and its images
+	// loaded, and Finish once it has stopped.
+	Setup  func(m *machine.Machine) error
+	Finish func(m *machine.Machine)
+}
+
+// NewCommand returns the cpusim command.
+func NewCommand() *cobra.Command {
+	rootCmd := &cobra.Command{
+		Use:   "cpusim",
+		Short: "scott's cpu simulator",
+		Long:  "A simulator for the 8008, 4004, 4040 and Z80. For a quick demo, try \"cpusim run --machine sbc8008 -f roms/sbc-8251.rom\"",
+
+		PersistentPreRun: silenceUsage,
+	}
+	rootCmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "debug messages")
+	rootCmd.PersistentFlags().BoolVarP(&memDebug, "memDebug", "m", false, "memory debug messages")
+
+	runCmd := &cobra.Command{
+		Use:   "run [MACHINE.yaml]",
+		Short: "build a machine and run it",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  runCommand,
+	}
+	runCmd.Flags().StringVarP(&machineName, "machine", "M", "", "built-in machine, or YAML machine description")
+	addRunFlags(runCmd)
+	rootCmd.AddCommand(runCmd)
+
+	disasmCmd := &cobra.Command{
+		Use:   "disasm IMAGE",
+		Short: "disassemble a ROM image, or an Intel HEX or S-record file",
+		Args:  cobra.ExactArgs(1),
+		RunE:  disasmCommand,
+	}
+	disasmCmd.Flags().StringVarP(&machineName, "machine", "M", "", "built-in machine, or YAML machine description, whose CPU the code is for")
+	disasmCmd.Flags().StringVar(&disasmCPU, "cpu", "", "instruction set, z80, 8008, 4004 or 4040, instead of the machine's")
+	disasmCmd.Flags().Uint64Var(&disasmBase, "base", 0, "address that a binary image is loaded at")
+	disasmCmd.Flags().Uint64Var(&disasmStart, "start", 0, "first address to disassemble (default start of the image)")
+	disasmCmd.Flags().Uint64Var(&disasmEnd, "end", 0, "last address to disassemble (default end of the image)")
+	disasmCmd.Flags().StringArrayVar(&symbols, "symbols", nil, "load symbols from an asl listing, z88dk or SDCC map, or name=addr file")
+	rootCmd.AddCommand(disasmCmd)
+
+	cpmCmd := &cobra.Command{
+		Use:   "cpm PROGRAM.COM [ARGS...]",
+		Short: "run a CP/M program with an emulated BDOS, without booting CP/M",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  cpmCommand,
+	}
+	cpmCmd.Flags().StringVar(&cpmDir, "dir", ".", "host directory that holds the files on the CP/M drives")
+	addSpeedFlags(cpmCmd)
+	addConsoleFlags(cpmCmd)
+	addDebugFlags(cpmCmd)
+	cpmCmd.Flags().SetInterspersed(false)
+	rootCmd.AddCommand(cpmCmd)
+
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "inspect-image IMAGE...",
+		Short: "show the format, size and address ranges of images",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  inspectImageCommand,
+	})
+
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "list-machines",
+		Short: "list the built-in machines",
+		Args:  cobra.NoArgs,
+		RunE:  listMachinesCommand,
+	})
+
+	listDevicesCmd := &cobra.Command{
+		Use:   "list-devices [MACHINE.yaml]",
+		Short: "list the devices in a machine, with their addresses and wiring",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  listDevicesCommand,
+	}
+	listDevicesCmd.Flags().StringVarP(&machineName, "machine", "M", "", "built-in machine, or YAML machine description")
+	listDevicesCmd.Flags().BoolVar(&listKinds, "kinds", false, "list the kinds of device that a description's devices section can use")
+	rootCmd.AddCommand(listDevicesCmd)
+
+	return rootCmd
+}
+
+// NewBoardCommand returns a command that runs a board's machine, as
+// "cpusim run --machine" does, with the same flags.
+func NewBoardCommand(b *Board) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   b.Use,
+		Short: b.Short,
+		Long:  fmt.Sprintf("%s. This is the same as \"cpusim run --machine %s\".", b.Short, b.Machine),
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			machineName = b.Machine
+			board = b
+			return runCommand(cmd, nil)
+		},
+
+		PersistentPreRun: silenceUsage,
+	}
+	cmd.Flags().BoolVarP(&debug, "debug", "d", false, "debug messages")
+	cmd.Flags().BoolVarP(&memDebug, "memDebug", "m", false, "memory debug messages")
+	addRunFlags(cmd)
+	return cmd
+}
+
+// addRunFlags adds the flags of the run command, other than --machine.
+func addRunFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&romFilename, "rom-file", "f", "", "rom filename")
+	cmd.Flags().StringArrayVar(&loadFiles, "load", nil, "load an image into memory as [DEVICE=]FILE[@OFFSET|:BANK]; Intel HEX and S-record files go to their own addresses and set the PC (repeatable)")
+	cmd.Flags().BoolVar(&erasedROM, "erased-rom", false, "fill ROM with FF, like an erased EPROM, before loading")
+	cmd.Flags().StringArrayVar(&disks, "disk", nil, "attach an image to one of the machine's disks as NAME=IMAGE[,offset=N][,identify=FILE] (repeatable)")
+	addSpeedFlags(cmd)
+	addConsoleFlags(cmd)
+	cmd.Flags().BoolVar(&deterministic, "deterministic", false, "run on a virtual clock, on one goroutine, so that runs with the same input are identical; --in-file is all delivered at cycle 0")
+	cmd.Flags().StringVar(&inputScript, "input-script", "", "with --deterministic, deliver console input at the cycles this script gives")
+	cmd.Flags().StringVar(&loadState, "load-state", "", "restore a machine snapshot before starting")
+	addDebugFlags(cmd)
+	cmd.Flags().StringVar(&saveState, "save-state", "", "save a machine snapshot to this file on exit")
+	cmd.Flags().BoolVar(&monitorOn, "monitor", false, "enable the monitor console (Ctrl-A c to enter, Ctrl-A h for help)")
+	cmd.Flags().Uint64Var(&rewind, "rewind", 0, "with --monitor, record history for stepping back, checkpointing every N instructions")
+	cmd.Flags().StringVar(&gdbAddress, "gdb", "", "listen for a GDB remote debugger on this address (host:port or unix:path)")
+}
+
+// addSpeedFlags adds the throttle flags.
+func addSpeedFlags(cmd *cobra.Command) {
+	cmd.Flags().Int64Var(&ips, "ips", 0, "instructions per second throttle (0 = unlimited)")
+	cmd.Flags().StringVar(&clock, "clock", "", "clock frequency throttle, e.g. 7.3728MHz (default unlimited)")
+	cmd.Flags().DurationVar(&ioPollDelay, "io-poll-delay", 0, "delay when polling serial with no data available (e.g. 1ms)")
+}
+
+// addConsoleFlags adds the flags for the console's input.
+func addConsoleFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&inFilename, "in-file", "t", "", "pre-load console input from file")
+	cmd.Flags().BoolVar(&noExitEof, "no-exit", false, "don't exit on EOF when using --in-file, fall through to stdin")
+}
+
+// addDebugFlags adds the flags for symbols, tracing and profiling the guest
+// program.
+func addDebugFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&symbols, "symbols", nil, "load symbols from an asl listing, z88dk or SDCC map, or name=addr file; FILE:BANK or FILE@BANK ties them to a mapper bank")
+	cmd.Flags().StringVar(&trace.Filename, "trace", "", "write a record of every instruction to this file")
+	cmd.Flags().StringVar(&trace.Format, "trace-format", cpusim.TraceJSON, "trace file format (json, binary)")
+	cmd.Flags().StringVar(&trace.Start, "trace-start", "", "start tracing when the PC reaches this address or symbol")
+	cmd.Flags().StringVar(&trace.Stop, "trace-stop", "", "stop tracing after the instruction at this address or symbol")
+	cmd.Flags().StringArrayVar(&trace.Ranges, "trace-range", nil, "only trace instructions in this START-END range of addresses (repeatable)")
+	cmd.Flags().StringVar(&profileFile, "profile", "", "profile the guest program and write a report to this file on exit (- for stdout)")
+	cmd.Flags().StringVar(&callgrind, "profile-callgrind", "", "profile the guest program and write a callgrind file for KCachegrind on exit")
+}
+
+// errReported is returned by a command that has already said why it
+// failed, so that it exits with an error without saying so again.
+var errReported = errors.New("failed")
+
+// silenceUsage stops a command that was given valid arguments from printing
+// its usage when it fails.
+func silenceUsage(cmd *cobra.Command, args []string) {
+	cmd.SilenceUsage = true
+}
+
+// Execute runs a command, and exits with status 1 if it fails.
+func Execute(cmd *cobra.Command) {
+	cmd.SilenceErrors = true
+	err := cmd.Execute()
+	if err != nil {
+		if !errors.Is(err, errReported) {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpm"
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+	"github.com/spf13/cobra"
+)
+
+// cpmCommand runs a CP/M program directly, with the BDOS emulated on the
+// host, instead of booting CP/M on a machine.
+func cpmCommand(cmd *cobra.Command, args []string) error {
+	sim := cpusim.NewCPUSim()
+	sim.SetDebug(debug)
+	sim.SetMemDebug(memDebug)
+	if err := setSpeed(sim); err != nil {
+		return err
+	}
+
+	var serialIO cpusim.SerialIO
+	if inFilename != "" {
+		fs, err := cpusim.NewFileSerial(inFilename, !noExitEof)
+		if err != nil {
+			return fmt.Errorf("failed to open input file '%s': %w", inFilename, err)
+		}
+		serialIO = fs
+	} else {
+		serialIO = cpusim.NewStdioSerial(true)
+	}
+
+	machine := cpm.New(sim, serialIO, cpmDir)
+	if err := machine.Load(args[0], args[1:]); err != nil {
+		return fmt.Errorf("failed to load '%s': %w", args[0], err)
+	}
+
+	if len(symbols) > 0 {
+		if err := sim.LoadSymbols(symbols); err != nil {
+			return fmt.Errorf("failed to load symbols: %w", err)
+		}
+	}
+
+	if profileFile != "" || callgrind != "" {
+		profiler = cpusim.NewProfiler(sim)
+		if err := profiler.Start(); err != nil {
+			return err
+		}
+	}
+
+	if trace.Filename != "" {
+		tracer, err := sim.StartTrace(trace)
+		if err != nil {
+			return fmt.Errorf("failed to start trace to '%s': %w", trace.Filename, err)
+		}
+		defer tracer.Close() // nolint:errcheck
+	}
+
+	var wg sync.WaitGroup
+	machine.Console.Start(&wg)
+	err := machine.Run()
+	machine.Console.RestoreTerminal()
+	if err != nil {
+		err = fmt.Errorf("%w (PC at %s)", err, sim.DescribeAddress(machine.CPU.GetPC()))
+	}
+
+	if profiler != nil {
+		if perr := profiler.WriteFiles(profileFile, callgrind); perr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to write profile: %w", perr))
+		}
+	}
+	return err
+}
@@ -0,0 +1,136 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"os"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+	"github.com/scottmbaker/gocpusim/pkg/machine"
+	"github.com/spf13/cobra"
+)
+
+// readImage reads a binary image loaded at base, or an Intel HEX or
+// S-record file, and returns a reader for it and the addresses it covers.
+func readImage(filename string, base cpusim.Address) (cpusim.ReadFunc, cpusim.Address, cpusim.Address, error) {
+	if cpusim.IsHexFile(filename) {
+		img, err := cpusim.ReadHexFile(filename)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		low, high, ok := img.Bounds()
+		if !ok {
+			return nil, 0, 0, fmt.Errorf("%s has no data", filename)
+		}
+		return img.Read, low, high, nil
+	}
+	image, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if len(image) == 0 {
+		return nil, 0, 0, fmt.Errorf("%s is empty", filename)
+	}
+	return cpusim.ImageReader(image, base), base, base + cpusim.Address(len(image)) - 1, nil
+}
+
+func disasmCommand(cmd *cobra.Command, args []string) error {
+	cpuType := disasmCPU
+	if cpuType == "" {
+		if machineName == "" {
+			return fmt.Errorf("--machine or --cpu is required")
+		}
+		cfg, err := machine.Lookup(machineName)
+		if err != nil {
+			return err
+		}
+		cpuType = cfg.CPU.Type
+	}
+	if _, err := machine.NewDisassembler(cpuType, nil); err != nil {
+		return err
+	}
+
+	read, start, end, err := readImage(args[0], cpusim.Address(disasmBase))
+	if err != nil {
+		return fmt.Errorf("failed to load '%s': %w", args[0], err)
+	}
+	if cmd.Flags().Changed("start") {
+		start = cpusim.Address(disasmStart)
+	}
+	if cmd.Flags().Changed("end") {
+		end = cpusim.Address(disasmEnd)
+	}
+
+	newDisassembler := func(symbols cpusim.SymbolLookup) cpusim.Disassembler {
+		d, _ := machine.NewDisassembler(cpuType, symbols)
+		return d
+	}
+	// Symbols are looked up without a machine, so banks are ignored
+	var names cpusim.SymbolLookup
+	if len(symbols) > 0 {
+		sim := cpusim.NewCPUSim()
+		if err := sim.LoadSymbols(symbols); err != nil {
+			return fmt.Errorf("failed to load symbols: %w", err)
+		}
+		names = sim.Symbols
+	}
+	return cpusim.WriteListing(os.Stdout, newDisassembler, read, start, end, names)
+}
+
+// inspectImageCommand inspects each image, carrying on past those that
+// can't be read.
+func inspectImageCommand(cmd *cobra.Command, args []string) error {
+	failed := false
+	for _, filename := range args {
+		if err := inspectImage(filename); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			failed = true
+		}
+	}
+	if failed {
+		return errReported
+	}
+	return nil
+}
+
+// inspectImage prints what is in an image: for a hex file, the address
+// ranges it fills and its start address; for a binary, its size, checksum
+// and how much of the end is erased.
+func inspectImage(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	if !cpusim.IsHexFile(filename) {
+		erased := len(data) - len(bytes.TrimRight(data, "\xFF"))
+		fmt.Printf("%s: binary, %d bytes, CRC-32 %08X\n", filename, len(data), crc32.ChecksumIEEE(data))
+		if erased > 0 {
+			fmt.Printf("  the last %d bytes are FF\n", erased)
+		}
+		return nil
+	}
+
+	img, err := cpusim.ReadHexFile(filename)
+	if err != nil {
+		return err
+	}
+	format := "Intel HEX"
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("S")) {
+		format = "S-record"
+	}
+	total := 0
+	for _, seg := range img.Segments {
+		total += len(seg.Data)
+	}
+	fmt.Printf("%s: %s, %d bytes in %d segments", filename, format, total, len(img.Segments))
+	if img.Start != nil {
+		fmt.Printf(", start %04X", *img.Start)
+	}
+	fmt.Println()
+	for _, seg := range img.Segments {
+		fmt.Printf("  %04X-%04X  %d bytes\n", seg.Address, seg.Address+cpusim.Address(len(seg.Data))-1, len(seg.Data))
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+	"github.com/scottmbaker/gocpusim/pkg/machine"
+	"github.com/spf13/cobra"
+)
+
+func listMachinesCommand(cmd *cobra.Command, args []string) error {
+	for _, name := range machine.Profiles() {
+		cfg, err := machine.LoadProfile(name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%-16s %-5s %s\n", name, cfg.CPU.Type, cfg.Description)
+	}
+	return nil
+}
+
+// printDevice prints a line of list-devices. The wiring notes that are
+// empty are left out.
+func printDevice(name, kind, addresses string, wiring ...string) {
+	var notes []string
+	for _, w := range wiring {
+		if w != "" {
+			notes = append(notes, w)
+		}
+	}
+	line := fmt.Sprintf("%-20s %-10s %-36s %s", name, kind, addresses, strings.Join(notes, ", "))
+	fmt.Println(strings.TrimRight(line, " "))
+}
+
+// prefixed returns s with a prefix, or nothing if s is empty.
+func prefixed(prefix, s string) string {
+	if s == "" {
+		return ""
+	}
+	return prefix + s
+}
+
+// outputs describes the enable bits that a register drives.
+func outputs(bits map[int]string) string {
+	var s []string
+	for bit, name := range bits {
+		s = append(s, fmt.Sprintf("D%d drives %s", bit, name))
+	}
+	sort.Strings(s)
+	return strings.Join(s, ", ")
+}
+
+func listDevicesCommand(cmd *cobra.Command, args []string) error {
+	if listKinds {
+		for _, kind := range cpusim.DeviceKinds() {
+			fmt.Println(kind)
+		}
+		return nil
+	}
+
+	cfg, err := lookupMachine(args)
+	if err != nil {
+		return err
+	}
+	printDevices(cfg)
+	for i, c := range cfg.Coprocessors {
+		interleave := cfg.Interleave
+		if interleave == "" {
+			interleave = "free"
+		}
+		fmt.Printf("\ncoprocessor %d, interleave %s\n", i+1, interleave)
+		printDevices(&c)
+		if len(c.Shared) > 0 {
+			fmt.Printf("shared with the main cpu: %s\n", strings.Join(c.Shared, ", "))
+		}
+	}
+	return nil
+}
+
+// printDevices prints the CPU and devices of a description.
+func printDevices(cfg *machine.Config) {
+	cpu := cfg.CPU.Type
+	if cfg.CPU.PortMask != 0 {
+		cpu += fmt.Sprintf(", port mask %02X", cfg.CPU.PortMask)
+	}
+	if cfg.CPU.Clock != "" {
+		cpu += ", clock " + cfg.CPU.Clock
+	}
+	fmt.Printf("cpu %s\n", cpu)
+
+	for _, c := range cfg.Memory {
+		addresses := ""
+		if c.Kind != "bus8" {
+			addresses = fmt.Sprintf("%05X-%05X", c.Start, c.End)
+		}
+		printDevice(c.Name, c.Kind, addresses, prefixed("enable ", c.Enable), prefixed("on ", c.Bus))
+	}
+	for _, c := range cfg.Ports {
+		addresses := ""
+		if c.Type != "romport" {
+			addresses = fmt.Sprintf("%02X", c.Address)
+		}
+		printDevice(c.Name, c.Type, addresses, outputs(c.Outputs), prefixed("enable ", c.Enable), prefixed("on ", c.Bus))
+	}
+	for _, c := range cfg.Mappers {
+		registers := c.Registers
+		if registers == "" {
+			registers = "ports"
+		}
+		printDevice(c.Name, c.Type, fmt.Sprintf("%02X %s", c.Address, strings.Join(c.DestBits, ",")),
+			outputs(c.Outputs), prefixed("enable ", c.Enable), prefixed("map enable ", c.MapEnable),
+			prefixed("maps ", c.Filter), prefixed("maps memory on ", c.Bus), "registers on "+registers)
+	}
+	for _, c := range cfg.Serial {
+		var addresses string
+		switch c.Type {
+		case "asci":
+			addresses = fmt.Sprintf("%02X", c.Address)
+		case "sio", "scc":
+			addresses = fmt.Sprintf("data %02X,%02X control %02X,%02X", c.Data, c.DataB, c.Control, c.ControlB)
+		default:
+			addresses = fmt.Sprintf("data %02X control %02X", c.Data, c.Control)
+		}
+		transport := c.Transport
+		if transport == "" {
+			transport = "console"
+		}
+		interrupt := ""
+		if c.Interrupt {
+			interrupt = "interrupt"
+		}
+		printDevice(c.Name, c.Type, addresses, transport, interrupt, prefixed("enable ", c.Enable), prefixed("on ", c.Bus))
+	}
+	for _, c := range cfg.Disks {
+		addresses := fmt.Sprintf("%02X", c.Address)
+		if c.Type == "fdc" {
+			addresses = fmt.Sprintf("msr %02X data %02X dor %02X dcr %02X", c.MSR, c.Data, c.DOR, c.DCR)
+		}
+		printDevice(c.Name, c.Type, addresses, prefixed("image ", c.Image), prefixed("enable ", c.Enable), prefixed("on ", c.Bus))
+	}
+}
@@ -0,0 +1,282 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+	"github.com/scottmbaker/gocpusim/pkg/gdbstub"
+	"github.com/scottmbaker/gocpusim/pkg/machine"
+	"github.com/scottmbaker/gocpusim/pkg/monitor"
+	"github.com/spf13/cobra"
+)
+
+// rewindCheckpoints limits how far back --rewind can go.
+const rewindCheckpoints = 64
+
+var console *monitor.Monitor // set by --monitor
+
+// lookupMachine returns the description of the machine that --machine or the
+// argument names.
+func lookupMachine(args []string) (*machine.Config, error) {
+	name := machineName
+	if len(args) > 0 {
+		if name != "" {
+			return nil, fmt.Errorf("give the machine with --machine or as an argument, not both")
+		}
+		name = args[0]
+	}
+	if name == "" {
+		return nil, fmt.Errorf("--machine is required; try list-machines")
+	}
+	return machine.Lookup(name)
+}
+
+// setSpeed sets the throttle from --clock or --ips, and the IO poll delay.
+func setSpeed(sim *cpusim.CpuSim) error {
+	if clock != "" {
+		if ips > 0 {
+			return fmt.Errorf("--ips and --clock cannot be used together")
+		}
+		hz, err := cpusim.ParseFrequency(clock)
+		if err != nil {
+			return err
+		}
+		sim.SetClock(hz)
+	} else if ips > 0 {
+		sim.SetIPS(ips)
+	}
+	sim.IOPollDelay = ioPollDelay
+	return nil
+}
+
+func newMachine(cfg *machine.Config) (*machine.Machine, error) {
+	sim := cpusim.NewCPUSim()
+	sim.SetDebug(debug)
+	sim.SetMemDebug(memDebug)
+
+	var serialIO cpusim.SerialIO
+	if deterministic {
+		// The console is only written to; its input comes from the
+		// input script.
+		serialIO = cpusim.NewStdioSerial(false)
+	} else if inFilename != "" {
+		fs, err := cpusim.NewFileSerial(inFilename, !noExitEof)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open input file '%s': %w", inFilename, err)
+		}
+		serialIO = fs
+	} else {
+		serialIO = cpusim.NewStdioSerial(true)
+	}
+	if monitorOn {
+		m, err := monitor.New(sim, serialIO)
+		if err != nil {
+			return nil, err
+		}
+		console = m
+		serialIO = m
+	}
+
+	opts := machine.Options{
+		Console:  serialIO,
+		ROM:      romFilename,
+		Load:     loadFiles,
+		EraseROM: erasedROM,
+		Disks:    map[string]machine.DiskImage{},
+	}
+	for _, arg := range disks {
+		name, disk, err := machine.ParseDiskImage(arg)
+		if err != nil {
+			return nil, err
+		}
+		opts.Disks[name] = disk
+	}
+	return machine.New(sim, cfg, opts)
+}
+
+// newScheduler returns the scheduler for --deterministic, with --in-file
+// delivered at cycle 0 followed by the --input-script.
+func newScheduler(m *machine.Machine) (*cpusim.Scheduler, error) {
+	var script []cpusim.InputEvent
+	if inFilename != "" {
+		data, err := os.ReadFile(inFilename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read input file '%s': %w", inFilename, err)
+		}
+		script = append(script, cpusim.InputEvent{Cycle: 0, Data: data})
+	}
+	if inputScript != "" {
+		events, err := cpusim.LoadInputScript(inputScript)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load input script: %w", err)
+		}
+		script = append(script, events...)
+	}
+	scheduler, err := m.Scheduler(script)
+	if err != nil {
+		return nil, err
+	}
+	scheduler.ExitAtEnd = len(script) > 0 && !noExitEof
+	return scheduler, nil
+}
+
+// newGDBServer listens for a GDB remote debugger on --gdb, sharing the
+// monitor's debugger if there is one.
+func newGDBServer(sim *cpusim.CpuSim) (*gdbstub.Server, error) {
+	var debugger *cpusim.Debugger
+	if console != nil {
+		debugger = console.Debugger
+	} else {
+		var err error
+		if debugger, err = cpusim.NewDebugger(sim); err != nil {
+			return nil, err
+		}
+	}
+	server, err := gdbstub.New(sim, debugger)
+	if err != nil {
+		return nil, err
+	}
+	if err := server.Listen(gdbAddress); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "GDB server listening on %s\n", server.Addr())
+	return server, nil
+}
+
+func runCommand(cmd *cobra.Command, args []string) error {
+	cfg, err := lookupMachine(args)
+	if err != nil {
+		return err
+	}
+	if cfg.ROM == "" && len(cfg.Load) == 0 && romFilename == "" && len(loadFiles) == 0 {
+		return fmt.Errorf("--rom-file or --load is required")
+	}
+
+	if inputScript != "" && !deterministic {
+		return fmt.Errorf("--input-script needs --deterministic")
+	}
+	if deterministic && monitorOn {
+		return fmt.Errorf("--monitor can't be used with --deterministic")
+	}
+
+	m, err := newMachine(cfg)
+	if err != nil {
+		return err
+	}
+	sim := m.Sim
+
+	if board != nil && board.Setup != nil {
+		if err := board.Setup(m); err != nil {
+			return err
+		}
+	}
+
+	if err := setSpeed(sim); err != nil {
+		return err
+	}
+
+	if len(symbols) > 0 {
+		if err := sim.LoadSymbols(symbols); err != nil {
+			return fmt.Errorf("failed to load symbols: %w", err)
+		}
+	}
+
+	if loadState != "" {
+		if err := sim.LoadStateFile(loadState); err != nil {
+			return fmt.Errorf("failed to load state from '%s': %w", loadState, err)
+		}
+	}
+
+	if rewind > 0 {
+		if console == nil {
+			return fmt.Errorf("--rewind needs --monitor")
+		}
+		r, err := cpusim.NewRewinder(sim, rewind, rewindCheckpoints)
+		if err != nil {
+			return err
+		}
+		console.Rewinder = r
+	}
+
+	var gdbServer *gdbstub.Server
+	if gdbAddress != "" {
+		var err error
+		if gdbServer, err = newGDBServer(sim); err != nil {
+			return fmt.Errorf("failed to start GDB server on '%s': %w", gdbAddress, err)
+		}
+		defer gdbServer.Close() // nolint:errcheck
+	}
+
+	if profileFile != "" || callgrind != "" {
+		profiler = cpusim.NewProfiler(sim)
+		if err := profiler.Start(); err != nil {
+			return err
+		}
+	}
+
+	if trace.Filename != "" {
+		tracer, err := sim.StartTrace(trace)
+		if err != nil {
+			return fmt.Errorf("failed to start trace to '%s': %w", trace.Filename, err)
+		}
+		defer tracer.Close() // nolint:errcheck
+	}
+
+	var scheduler *cpusim.Scheduler
+	if deterministic {
+		var err error
+		if scheduler, err = newScheduler(m); err != nil {
+			return err
+		}
+	}
+
+	if gdbServer != nil {
+		go gdbServer.Serve() // nolint:errcheck
+	}
+	// SIGINT only arrives when the console isn't in raw mode; in raw mode,
+	// Ctrl-C goes to the machine.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var result cpusim.RunResult
+	if scheduler != nil {
+		result = scheduler.Run(ctx)
+	} else {
+		result = m.Run(ctx)
+	}
+	m.RestoreTerminal()
+	sim.PrintResult(result)
+	if board != nil && board.Finish != nil {
+		board.Finish(m)
+	}
+
+	var errs []error
+	if err := m.Close(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if profiler != nil {
+		if err := profiler.WriteFiles(profileFile, callgrind); err != nil {
+			errs = append(errs, fmt.Errorf("failed to write profile: %w", err))
+		}
+	}
+
+	if saveState != "" {
+		if err := sim.SaveStateFile(saveState); err != nil {
+			errs = append(errs, fmt.Errorf("failed to save state to '%s': %w", saveState, err))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	if result.Reason == cpusim.ExitError {
+		// PrintResult has said why
+		return errReported
+	}
+	return nil
+}
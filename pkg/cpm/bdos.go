@@ -0,0 +1,252 @@
+package cpm
+
+import "fmt"
+
+// BDOS function numbers, passed in C.
+const (
+	fnReset        = 0
+	fnConIn        = 1
+	fnConOut       = 2
+	fnReaderIn     = 3
+	fnPunchOut     = 4
+	fnListOut      = 5
+	fnDirectIO     = 6
+	fnGetIOByte    = 7
+	fnSetIOByte    = 8
+	fnPrintString  = 9
+	fnReadBuffer   = 10
+	fnConStatus    = 11
+	fnVersion      = 12
+	fnResetDisks   = 13
+	fnSelectDisk   = 14
+	fnOpen         = 15
+	fnClose        = 16
+	fnSearchFirst  = 17
+	fnSearchNext   = 18
+	fnDelete       = 19
+	fnReadSeq      = 20
+	fnWriteSeq     = 21
+	fnMake         = 22
+	fnRename       = 23
+	fnLoginVector  = 24
+	fnCurrentDisk  = 25
+	fnSetDMA       = 26
+	fnAllocVector  = 27
+	fnWriteProtect = 28
+	fnROVector     = 29
+	fnSetAttrs     = 30
+	fnGetDPB       = 31
+	fnUserCode     = 32
+	fnReadRandom   = 33
+	fnWriteRandom  = 34
+	fnFileSize     = 35
+	fnSetRandom    = 36
+	fnResetDrive   = 37
+	fnWriteZero    = 40
+)
+
+// bdos carries out the BDOS call in C with the parameter in DE.
+func (m *Machine) bdos() error {
+	fn, e, de := m.CPU.C, m.CPU.E, m.de()
+	if m.Sim.Debug {
+		fmt.Printf("BDOS %d DE=%04X\n", fn, de)
+	}
+
+	switch fn {
+	case fnReset:
+		return errExit
+	case fnConIn:
+		b, ok := m.Console.Read()
+		if !ok {
+			return errExit
+		}
+		m.echo(b)
+		m.result(uint16(b))
+	case fnConOut:
+		m.Console.Write(e)
+		m.result(0)
+	case fnReaderIn:
+		m.result(0x1A)
+	case fnPunchOut, fnListOut:
+		m.result(0)
+	case fnDirectIO:
+		switch e {
+		case 0xFF: // input, or 0 if there is none
+			if !m.Console.Status() {
+				m.result(0)
+				break
+			}
+			fallthrough
+		case 0xFD: // input
+			b, ok := m.Console.Read()
+			if !ok {
+				return errExit
+			}
+			m.result(uint16(b))
+		case 0xFE: // status
+			m.result(m.conStatus())
+		default:
+			m.Console.Write(e)
+			m.result(0)
+		}
+	case fnGetIOByte:
+		m.result(uint16(m.peek(0x0003)))
+	case fnSetIOByte:
+		m.poke(0x0003, e)
+		m.result(0)
+	case fnPrintString:
+		for addr := de; m.peek(addr) != '$'; addr++ {
+			m.Console.Write(m.peek(addr))
+		}
+		m.result(0)
+	case fnReadBuffer:
+		if !m.readBuffer(de) {
+			return errExit
+		}
+		m.result(0)
+	case fnConStatus:
+		m.result(m.conStatus())
+	case fnVersion:
+		m.result(0x0022)
+	case fnResetDisks:
+		m.dma = defaultDMA
+		m.drive = 0
+		m.result(0)
+	case fnSelectDisk:
+		m.drive = e & 0x0F
+		m.result(0)
+	case fnLoginVector:
+		m.result(1 << m.drive)
+	case fnCurrentDisk:
+		m.result(uint16(m.drive))
+	case fnSetDMA:
+		m.dma = de
+		m.result(0)
+	case fnAllocVector:
+		m.result(alvAddr)
+	case fnWriteProtect, fnROVector, fnSetAttrs, fnResetDrive:
+		m.result(0)
+	case fnGetDPB:
+		m.result(dpbAddr)
+	case fnUserCode:
+		if e == 0xFF {
+			m.result(uint16(m.user))
+		} else {
+			m.user = e & 0x0F
+			m.result(0)
+		}
+	case fnOpen, fnClose, fnSearchFirst, fnSearchNext, fnDelete, fnReadSeq, fnWriteSeq, fnMake, fnRename,
+		fnReadRandom, fnWriteRandom, fnFileSize, fnSetRandom, fnWriteZero:
+		m.result(uint16(m.fileCall(fn, de)))
+	default:
+		if m.Sim.Debug {
+			fmt.Printf("BDOS %d is not supported\n", fn)
+		}
+		m.result(0xFF)
+	}
+	return nil
+}
+
+func (m *Machine) conStatus() uint16 {
+	if m.Console.Status() {
+		return 0xFF
+	}
+	return 0
+}
+
+// echo echoes a character read by the console input functions, as the BDOS
+// does for printable characters and the usual controls.
+func (m *Machine) echo(b byte) {
+	if b >= 0x20 || b == '\r' || b == '\n' || b == '\t' || b == 0x08 {
+		m.Console.Write(b)
+	}
+}
+
+// readBuffer reads an edited line into the buffer at address: its size
+// first, then the count of characters read, then the characters. It returns
+// false if the input ended first.
+func (m *Machine) readBuffer(address uint16) bool {
+	size := int(m.peek(address))
+	var line []byte
+	for {
+		b, ok := m.Console.Read()
+		if !ok {
+			return false
+		}
+		switch {
+		case b == '\r' || b == '\n':
+			m.Console.Write('\r')
+			m.poke(address+1, byte(len(line)))
+			for i, c := range line {
+				m.poke(address+2+uint16(i), c)
+			}
+			return true
+		case b == 0x08 || b == 0x7F:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				for _, c := range []byte{0x08, ' ', 0x08} {
+					m.Console.Write(c)
+				}
+			}
+		case b == 0x15 || b == 0x18: // ^U, ^X
+			for range line {
+				for _, c := range []byte{0x08, ' ', 0x08} {
+					m.Console.Write(c)
+				}
+			}
+			line = line[:0]
+		case len(line) < size:
+			line = append(line, b)
+			m.echo(b)
+		}
+	}
+}
+
+// BIOS entry points, by their index in the jump table.
+const (
+	biosBoot    = 0
+	biosWBoot   = 1
+	biosConSt   = 2
+	biosConIn   = 3
+	biosConOut  = 4
+	biosList    = 5
+	biosPunch   = 6
+	biosReader  = 7
+	biosSelDsk  = 9
+	biosRead    = 13
+	biosWrite   = 14
+	biosListSt  = 15
+	biosSecTran = 16
+)
+
+// bios carries out a call to a BIOS jump table entry. There is no disk
+// underneath the BDOS, so the disk entries fail.
+func (m *Machine) bios(entry int) error {
+	switch entry {
+	case biosBoot, biosWBoot:
+		return errExit
+	case biosConSt:
+		m.CPU.A = byte(m.conStatus())
+	case biosConIn:
+		b, ok := m.Console.Read()
+		if !ok {
+			return errExit
+		}
+		m.CPU.A = b
+	case biosConOut:
+		m.Console.Write(m.CPU.C)
+	case biosList, biosPunch:
+		// discarded
+	case biosReader:
+		m.CPU.A = 0x1A
+	case biosSelDsk:
+		m.CPU.H, m.CPU.L = 0, 0
+	case biosRead, biosWrite:
+		m.CPU.A = 1
+	case biosListSt:
+		m.CPU.A = 0
+	case biosSecTran:
+		m.CPU.H, m.CPU.L = m.CPU.B, m.CPU.C
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package cpm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+)
+
+// Console connects the BDOS and BIOS console functions to a SerialIO. Input
+// is read by a goroutine into a buffer, as the serial devices do, so that
+// the console status functions don't block.
+type Console struct {
+	Sim    *cpusim.CpuSim
+	Serial cpusim.SerialIO
+	Name   string
+
+	mu     sync.Mutex
+	buffer []byte
+	eof    bool
+	ready  chan struct{} // signaled when input arrives or ends
+}
+
+func NewConsole(sim *cpusim.CpuSim, serial cpusim.SerialIO, name string) *Console {
+	return &Console{
+		Sim:    sim,
+		Serial: serial,
+		Name:   name,
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+func (c *Console) GetName() string {
+	return c.Name
+}
+
+// ReceiveInput appends a byte to the input buffer.
+func (c *Console) ReceiveInput(b byte) {
+	c.mu.Lock()
+	c.buffer = append(c.buffer, b)
+	c.mu.Unlock()
+	c.signal()
+}
+
+func (c *Console) signal() {
+	select {
+	case c.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Console) run() error {
+	for {
+		b, err := c.Serial.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b == 0x03 {
+			c.Sim.CtrlC.Store(true)
+		}
+		c.Sim.DeliverInput(c, b)
+	}
+}
+
+func (c *Console) Start(wg *sync.WaitGroup) {
+	go func() {
+		c.Serial.Start()
+		err := c.run()
+		if err != io.EOF {
+			fmt.Fprintf(os.Stderr, "Console error: %v\n", err)
+		}
+		c.mu.Lock()
+		c.eof = true
+		c.mu.Unlock()
+		c.signal()
+	}()
+}
+
+func (c *Console) RestoreTerminal() {
+	c.Serial.RestoreTerminal()
+}
+
+// Status reports whether a character is waiting.
+func (c *Console) Status() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.buffer) > 0 {
+		c.Sim.IOActivity()
+		return true
+	}
+	c.mu.Unlock()
+	c.Sim.IOPoll()
+	c.mu.Lock()
+	return false
+}
+
+// Read waits for a character. It returns false once the input has ended, or
+// when Ctrl-C stops the machine. Line feeds from a file are read as returns.
+func (c *Console) Read() (byte, bool) {
+	for {
+		c.mu.Lock()
+		if len(c.buffer) > 0 {
+			b := c.buffer[0]
+			c.buffer = c.buffer[1:]
+			c.mu.Unlock()
+			if b == 0x0A {
+				b = 0x0D
+			}
+			return b, true
+		}
+		eof := c.eof
+		c.mu.Unlock()
+		if eof || c.Sim.CtrlC.Load() {
+			return 0, false
+		}
+		<-c.ready
+	}
+}
+
+// Write sends a character to the console.
+func (c *Console) Write(b byte) {
+	if err := c.Sim.WriteSerial(c.Serial, b); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing to serial: %v\n", err)
+	}
+}
@@ -0,0 +1,201 @@
+// Package cpm runs CP/M 2.2 programs directly on a simulated Z80, without
+// booting CP/M.
+//
+// The machine is 64K of RAM holding the program at 0100h, a zero page set up
+// as the CCP would leave it, and stubs for the BDOS and BIOS at the top of
+// memory. Each stub is a RET; when the CPU reaches one, a step hook carries
+// out the call on the host before the RET executes. Console I/O goes through
+// a SerialIO, and files are kept in a host directory, which every drive
+// letter refers to.
+//
+// The program ends when it jumps to 0000h, returns to the stack's initial
+// return address, calls BDOS function 0 or BIOS WBOOT, or waits for console
+// input after the input has ended.
+package cpm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+	"github.com/scottmbaker/gocpusim/pkg/cpusim/cpuz80"
+)
+
+// The memory map. The BDOS entry is also the top of the TPA, as programs
+// learn from the address at 0006h.
+const (
+	TPA       = 0x0100
+	BDOSEntry = 0xFE06
+	dpbAddr   = 0xFE10
+	alvAddr   = 0xFE80
+	BIOSBase  = 0xFF00
+	biosCalls = 17
+
+	defaultDMA = 0x0080
+	fcb1Addr   = 0x005C
+	fcb2Addr   = 0x006C
+)
+
+// A disk parameter block for a 2MB drive with 2K blocks, for the programs
+// that ask how much space there is.
+var dpb = []byte{
+	0x40, 0x00, // SPT
+	0x04,       // BSH
+	0x0F,       // BLM
+	0x00,       // EXM
+	0xFF, 0x03, // DSM
+	0xFF, 0x00, // DRM
+	0xF0, 0x00, // AL0, AL1
+	0x00, 0x00, // CKS
+	0x00, 0x00, // OFF
+}
+
+// errExit stops the CPU when the program ends.
+var errExit = errors.New("program exited")
+
+// Machine is a Z80 with 64K of RAM and an emulated BDOS.
+type Machine struct {
+	Sim     *cpusim.CpuSim
+	CPU     *cpuz80.CPUZ80
+	RAM     *cpusim.Memory
+	Console *Console
+	Dir     string // host directory for the drives
+
+	dma    uint16
+	drive  byte
+	user   byte
+	files  map[string]*os.File // open files, by host path
+	paths  map[string]string   // host paths of files, by lower case name
+	search []dirEntry          // remaining matches for search next
+}
+
+// New creates a machine whose console is serial and whose drives are the
+// host directory dir.
+func New(sim *cpusim.CpuSim, serial cpusim.SerialIO, dir string) *Machine {
+	cpu := cpuz80.NewZ80(sim, "z80")
+	sim.AddCPU(cpu)
+
+	ram := cpusim.NewMemory(sim, "ram", cpusim.KIND_RAM, 0x0000, 0xFFFF, 16, false, &cpusim.AlwaysEnabled)
+	sim.AddMemory(ram)
+
+	m := &Machine{
+		Sim:     sim,
+		CPU:     cpu,
+		RAM:     ram,
+		Console: NewConsole(sim, serial, "console"),
+		Dir:     dir,
+		dma:     defaultDMA,
+		files:   map[string]*os.File{},
+		paths:   map[string]string{},
+	}
+	sim.AddStepHook(m.trap)
+	return m
+}
+
+// Load loads a .COM file at 0100h and sets up the zero page for it, with
+// the command tail and default FCBs made from args.
+func (m *Machine) Load(filename string, args []string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if TPA+len(data) > BDOSEntry {
+		return fmt.Errorf("%s is %d bytes, but the TPA only has %d", filename, len(data), BDOSEntry-TPA)
+	}
+	mem := m.RAM.Contents
+	copy(mem[TPA:], data)
+
+	// JP WBOOT and JP BDOS
+	copy(mem[0x0000:], []byte{0xC3, 0x03, BIOSBase >> 8})
+	copy(mem[0x0005:], []byte{0xC3, BDOSEntry & 0xFF, BDOSEntry >> 8})
+	mem[0x0003] = 0x00 // IOBYTE
+	mem[0x0004] = 0x00 // drive A, user 0
+
+	mem[BDOSEntry] = 0xC9
+	for i := 0; i < biosCalls; i++ {
+		mem[BIOSBase+3*i] = 0xC9
+	}
+	copy(mem[dpbAddr:], dpb)
+
+	tail := strings.ToUpper(strings.Join(args, " "))
+	if tail != "" {
+		tail = " " + tail
+	}
+	if len(tail) > 127 {
+		return fmt.Errorf("command tail is %d characters, but CP/M allows 127", len(tail))
+	}
+	mem[defaultDMA] = byte(len(tail))
+	copy(mem[defaultDMA+1:], tail)
+	if len(tail) < 127 {
+		// A full tail reaches the end of the buffer, and a terminator
+		// would overwrite the program.
+		mem[defaultDMA+1+len(tail)] = 0
+	}
+
+	setFCB(mem[fcb1Addr:fcb1Addr+16], "")
+	setFCB(mem[fcb2Addr:fcb2Addr+16], "")
+	if len(args) > 0 {
+		setFCB(mem[fcb1Addr:fcb1Addr+16], args[0])
+	}
+	if len(args) > 1 {
+		setFCB(mem[fcb2Addr:fcb2Addr+16], args[1])
+	}
+	mem[fcb1Addr+32] = 0
+
+	// A RET from the program goes to 0000h
+	m.CPU.SP = BDOSEntry &^ 0xFF
+	m.CPU.SP -= 2
+	mem[m.CPU.SP], mem[m.CPU.SP+1] = 0x00, 0x00
+	m.CPU.PC = TPA
+	return nil
+}
+
+// Run runs the program until it exits.
+func (m *Machine) Run() error {
+	defer m.closeFiles()
+	err := m.CPU.Run()
+	if err == errExit {
+		return nil
+	}
+	return err
+}
+
+// trap carries out a BDOS or BIOS call when the CPU reaches a stub.
+func (m *Machine) trap() error {
+	pc := m.CPU.PC
+	switch {
+	case pc == BDOSEntry:
+		return m.bdos()
+	case pc >= BIOSBase && pc < BIOSBase+3*biosCalls && (pc-BIOSBase)%3 == 0:
+		return m.bios(int(pc-BIOSBase) / 3)
+	}
+	return nil
+}
+
+func (m *Machine) closeFiles() {
+	for path, f := range m.files {
+		f.Close() // nolint:errcheck
+		delete(m.files, path)
+	}
+}
+
+func (m *Machine) peek(address uint16) byte {
+	return m.RAM.Contents[address]
+}
+
+func (m *Machine) poke(address uint16, value byte) {
+	m.RAM.Contents[address] = value
+}
+
+func (m *Machine) de() uint16 {
+	return uint16(m.CPU.D)<<8 | uint16(m.CPU.E)
+}
+
+// result returns a value from a call the way the BDOS does, in both HL and
+// BA.
+func (m *Machine) result(value uint16) {
+	m.CPU.L, m.CPU.H = byte(value), byte(value>>8)
+	m.CPU.A, m.CPU.B = m.CPU.L, m.CPU.H
+}
@@ -0,0 +1,147 @@
+package cpm
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMachine(t *testing.T, program []byte, args ...string) (*Machine, *cpusim.ChannelSerial) {
+	t.Helper()
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "test.com")
+	require.NoError(t, os.WriteFile(filename, program, 0644))
+
+	serial := cpusim.NewChannelSerial()
+	m := New(cpusim.NewCPUSim(), serial, dir)
+	require.NoError(t, m.Load(filename, args))
+	return m, serial
+}
+
+func output(serial *cpusim.ChannelSerial) string {
+	var out bytes.Buffer
+	for len(serial.Out) > 0 {
+		out.WriteByte(<-serial.Out)
+	}
+	return out.String()
+}
+
+func TestRun(t *testing.T) {
+	program := make([]byte, 0x30)
+	copy(program, []byte{
+		0x11, 0x00, 0x02, // LD DE,0200H
+		0x3E, 0x10, // LD A,16
+		0x12,       // LD (DE),A
+		0x0E, 0x0A, // LD C,10
+		0xCD, 0x05, 0x00, // CALL BDOS
+		0x11, 0x20, 0x01, // LD DE,MSG
+		0x0E, 0x09, // LD C,9
+		0xCD, 0x05, 0x00, // CALL BDOS
+		0xC9, // RET
+	})
+	copy(program[0x20:], "ok$")
+
+	m, serial := newTestMachine(t, program, "foo.txt", "b:*.asm")
+	mem := m.RAM.Contents
+	assert.Equal(t, " FOO.TXT B:*.ASM", string(mem[0x81:0x81+mem[0x80]]))
+	assert.Equal(t, "\x00FOO     TXT", string(mem[fcb1Addr:fcb1Addr+12]))
+	assert.Equal(t, "\x02????????ASM", string(mem[fcb2Addr:fcb2Addr+12]))
+
+	for _, b := range []byte("abX\x08c\n") {
+		serial.In <- b
+	}
+	close(serial.In)
+	var wg sync.WaitGroup
+	m.Console.Start(&wg)
+	require.NoError(t, m.Run())
+
+	assert.Equal(t, "abX\x08 \x08c\rok", output(serial))
+	assert.Equal(t, byte(3), mem[0x201])
+	assert.Equal(t, "abc", string(mem[0x202:0x205]))
+}
+
+func TestLongTail(t *testing.T) {
+	program := []byte{0xC9} // RET
+	arg := strings.Repeat("X", 126)
+
+	m, _ := newTestMachine(t, program, arg)
+	mem := m.RAM.Contents
+	assert.Equal(t, byte(127), mem[0x80])
+	assert.Equal(t, " "+arg, string(mem[0x81:0x100]))
+	assert.Equal(t, byte(0xC9), mem[TPA])
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "test.com")
+	require.NoError(t, os.WriteFile(filename, program, 0644))
+	m = New(cpusim.NewCPUSim(), cpusim.NewChannelSerial(), dir)
+	assert.Error(t, m.Load(filename, []string{arg + "X"}))
+}
+
+// call makes a BDOS call and returns A.
+func call(t *testing.T, m *Machine, fn byte, de uint16) byte {
+	t.Helper()
+	m.CPU.C, m.CPU.D, m.CPU.E = fn, byte(de>>8), byte(de)
+	require.NoError(t, m.bdos())
+	return m.CPU.A
+}
+
+func TestFiles(t *testing.T) {
+	m, _ := newTestMachine(t, []byte{0xC9})
+	mem := m.RAM.Contents
+	require.NoError(t, os.WriteFile(filepath.Join(m.Dir, "HELLO.TXT"), bytes.Repeat([]byte{'x'}, 200), 0644))
+
+	// Read a file a record at a time
+	setFCB(mem[fcb1Addr:], "hello.txt")
+	require.Equal(t, byte(0), call(t, m, fnOpen, fcb1Addr))
+	assert.Equal(t, byte(2), mem[fcb1Addr+fcbRC])
+	assert.Equal(t, byte(0), call(t, m, fnReadSeq, fcb1Addr))
+	assert.Equal(t, bytes.Repeat([]byte{'x'}, 128), mem[0x80:0x100])
+	assert.Equal(t, byte(0), call(t, m, fnReadSeq, fcb1Addr))
+	assert.Equal(t, append(bytes.Repeat([]byte{'x'}, 72), bytes.Repeat([]byte{0x1A}, 56)...), mem[0x80:0x100])
+	assert.Equal(t, byte(1), call(t, m, fnReadSeq, fcb1Addr))
+	assert.Equal(t, byte(0), call(t, m, fnFileSize, fcb1Addr))
+	assert.Equal(t, byte(2), mem[fcb1Addr+fcbR0])
+	assert.Equal(t, byte(0), call(t, m, fnClose, fcb1Addr))
+
+	// Write one, sequentially and at random
+	const fcb = 0x0300
+	setFCB(mem[fcb:], "out.dat")
+	require.Equal(t, byte(0), call(t, m, fnMake, fcb))
+	call(t, m, fnSetDMA, 0x0400)
+	copy(mem[0x400:0x480], bytes.Repeat([]byte{'A'}, 128))
+	assert.Equal(t, byte(0), call(t, m, fnWriteSeq, fcb))
+	assert.Equal(t, byte(0), call(t, m, fnWriteSeq, fcb))
+	mem[fcb+fcbR0] = 5
+	assert.Equal(t, byte(0), call(t, m, fnWriteRandom, fcb))
+	assert.Equal(t, byte(0), call(t, m, fnSetRandom, fcb))
+	assert.Equal(t, byte(5), mem[fcb+fcbR0])
+	assert.Equal(t, byte(0), call(t, m, fnClose, fcb))
+	info, err := os.Stat(filepath.Join(m.Dir, "out.dat"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(6*128), info.Size())
+
+	// List the directory
+	setFCB(mem[fcb:], "*.*")
+	var names []string
+	for fn := byte(fnSearchFirst); call(t, m, fn, fcb) == 0; fn = fnSearchNext {
+		names = append(names, string(mem[0x401:0x40C]))
+	}
+	assert.Equal(t, []string{"HELLO   TXT", "OUT     DAT", "TEST    COM"}, names)
+
+	// Rename and delete
+	setFCB(mem[fcb:], "out.dat")
+	setFCB(mem[fcb+16:], "new.dat")
+	assert.Equal(t, byte(0), call(t, m, fnRename, fcb))
+	setFCB(mem[fcb:], "out.dat")
+	assert.Equal(t, byte(0xFF), call(t, m, fnOpen, fcb))
+	setFCB(mem[fcb:], "new.*")
+	assert.Equal(t, byte(0), call(t, m, fnDelete, fcb))
+	assert.Equal(t, byte(0xFF), call(t, m, fnSearchFirst, fcb))
+}
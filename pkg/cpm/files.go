@@ -0,0 +1,423 @@
+package cpm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const recordSize = 128
+
+// FCB field offsets.
+const (
+	fcbDrive = 0
+	fcbName  = 1
+	fcbEX    = 12
+	fcbS2    = 14
+	fcbRC    = 15
+	fcbNew   = 17 // the new name, for rename
+	fcbCR    = 32
+	fcbR0    = 33
+	fcbSize  = 36
+)
+
+// setFCB fills in the drive and name of an FCB from a command line argument,
+// as the CCP does for the default FCBs.
+func setFCB(fcb []byte, arg string) {
+	fcb[fcbDrive] = 0
+	arg = strings.ToUpper(arg)
+	if len(arg) >= 2 && arg[1] == ':' && arg[0] >= 'A' && arg[0] <= 'P' {
+		fcb[fcbDrive] = arg[0] - 'A' + 1
+		arg = arg[2:]
+	}
+	name, ext, _ := strings.Cut(arg, ".")
+	putName(fcb[fcbName:fcbName+8], name)
+	putName(fcb[fcbName+8:fcbName+11], ext)
+	for i := fcbEX; i < 16; i++ {
+		fcb[i] = 0
+	}
+}
+
+// putName fills a blank-padded name field, expanding * into ?s.
+func putName(field []byte, s string) {
+	for i := range field {
+		switch {
+		case i < len(s) && s[i] == '*':
+			for ; i < len(field); i++ {
+				field[i] = '?'
+			}
+			return
+		case i < len(s):
+			field[i] = s[i]
+		default:
+			field[i] = ' '
+		}
+	}
+}
+
+// dirEntry is a host file that has a CP/M name.
+type dirEntry struct {
+	name [11]byte // blank padded, as in an FCB
+	path string
+	size int64
+}
+
+// cpmName returns a host file name as an FCB name, if it is a valid 8.3 name.
+func cpmName(host string) ([11]byte, bool) {
+	var name [11]byte
+	base, ext, _ := strings.Cut(strings.ToUpper(host), ".")
+	if base == "" || len(base) > 8 || len(ext) > 3 || strings.ContainsAny(base+ext, ". *?<>,;:=[]") {
+		return name, false
+	}
+	for _, c := range base + ext {
+		if c <= ' ' || c > '~' {
+			return name, false
+		}
+	}
+	putName(name[0:8], base)
+	putName(name[8:11], ext)
+	return name, true
+}
+
+// hostName turns an FCB name into a host file name.
+func hostName(name []byte) string {
+	var base, ext []byte
+	for _, c := range name[0:8] {
+		base = append(base, c&0x7F)
+	}
+	for _, c := range name[8:11] {
+		ext = append(ext, c&0x7F)
+	}
+	s := strings.TrimRight(string(base), " ")
+	if e := strings.TrimRight(string(ext), " "); e != "" {
+		s += "." + e
+	}
+	return strings.ToLower(s)
+}
+
+// matchName matches an FCB name against a pattern in which ? matches any
+// character.
+func matchName(pattern []byte, name [11]byte) bool {
+	for i, c := range pattern[:11] {
+		if c&0x7F != '?' && c&0x7F != name[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// list returns the host files whose names match an FCB name.
+func (m *Machine) list(pattern []byte) []dirEntry {
+	files, err := os.ReadDir(m.Dir)
+	if err != nil {
+		return nil
+	}
+	var entries []dirEntry
+	for _, f := range files {
+		name, ok := cpmName(f.Name())
+		if !ok || !f.Type().IsRegular() || !matchName(pattern, name) {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, dirEntry{name: name, path: filepath.Join(m.Dir, f.Name()), size: info.Size()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return string(entries[i].name[:]) < string(entries[j].name[:])
+	})
+	return entries
+}
+
+// lookup returns the host path of the file an FCB names, and whether it
+// exists. Host names are matched without regard to case. Files that are
+// found are remembered, so that reading a file a record at a time doesn't
+// list the directory for every record.
+func (m *Machine) lookup(fcb []byte) (string, bool) {
+	name := fcb[fcbName : fcbName+11]
+	key := hostName(name)
+	if path, ok := m.paths[key]; ok {
+		return path, true
+	}
+	if entries := m.list(name); len(entries) > 0 {
+		if !strings.Contains(key, "?") {
+			m.paths[key] = entries[0].path
+		}
+		return entries[0].path, true
+	}
+	return filepath.Join(m.Dir, key), false
+}
+
+// file returns an open handle on a host file, creating the file if asked
+// to. Files that can't be written are opened read-only.
+func (m *Machine) file(path string, create bool) (*os.File, error) {
+	if f, ok := m.files[path]; ok {
+		return f, nil
+	}
+	flags := os.O_RDWR
+	if create {
+		flags |= os.O_CREATE
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		if f, err = os.Open(path); err != nil {
+			return nil, err
+		}
+	}
+	m.files[path] = f
+	return f, nil
+}
+
+func (m *Machine) closeFile(path string) {
+	if f, ok := m.files[path]; ok {
+		f.Close() // nolint:errcheck
+		delete(m.files, path)
+	}
+}
+
+// The current record of sequential access is CR in extent EX of module S2.
+func seqRecord(fcb []byte) int64 {
+	return (int64(fcb[fcbS2])*32+int64(fcb[fcbEX]&0x1F))*recordSize + int64(fcb[fcbCR])
+}
+
+func setSeqRecord(fcb []byte, record int64) {
+	fcb[fcbCR] = byte(record % recordSize)
+	fcb[fcbEX] = byte(record / recordSize % 32)
+	fcb[fcbS2] = byte(record / (recordSize * 32))
+}
+
+func randomRecord(fcb []byte) int64 {
+	return int64(fcb[fcbR0]) | int64(fcb[fcbR0+1])<<8 | int64(fcb[fcbR0+2])<<16
+}
+
+func setRandomRecord(fcb []byte, record int64) {
+	fcb[fcbR0] = byte(record)
+	fcb[fcbR0+1] = byte(record >> 8)
+	fcb[fcbR0+2] = byte(record >> 16)
+}
+
+// setRC sets the record count of the FCB's extent from the file size.
+func setRC(fcb []byte, size int64) {
+	records := (size+recordSize-1)/recordSize - (int64(fcb[fcbS2])*32+int64(fcb[fcbEX]&0x1F))*recordSize
+	fcb[fcbRC] = byte(min(max(records, 0), recordSize))
+}
+
+// fileCall carries out a BDOS file function on the FCB at address and
+// returns the BDOS's result code.
+func (m *Machine) fileCall(fn byte, address uint16) byte {
+	var buf [fcbSize]byte
+	for i := range buf {
+		buf[i] = m.peek(address + uint16(i))
+	}
+	fcb := buf[:]
+	result := m.fcbCall(fn, fcb)
+	for i, b := range buf {
+		m.poke(address+uint16(i), b)
+	}
+	return result
+}
+
+func (m *Machine) fcbCall(fn byte, fcb []byte) byte {
+	switch fn {
+	case fnOpen:
+		path, ok := m.lookup(fcb)
+		if !ok {
+			return 0xFF
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return 0xFF
+		}
+		setRC(fcb, info.Size())
+		return 0
+
+	case fnClose:
+		path, ok := m.lookup(fcb)
+		if !ok {
+			return 0xFF
+		}
+		m.closeFile(path)
+		return 0
+
+	case fnSearchFirst:
+		pattern := fcb[fcbName : fcbName+11]
+		if fcb[fcbDrive] == '?' {
+			pattern = []byte("???????????")
+		}
+		m.search = m.list(pattern)
+		return m.searchNext()
+
+	case fnSearchNext:
+		return m.searchNext()
+
+	case fnDelete:
+		entries := m.list(fcb[fcbName : fcbName+11])
+		if len(entries) == 0 {
+			return 0xFF
+		}
+		m.paths = map[string]string{}
+		for _, e := range entries {
+			m.closeFile(e.path)
+			if err := os.Remove(e.path); err != nil {
+				return 0xFF
+			}
+		}
+		return 0
+
+	case fnReadSeq:
+		path, _ := m.lookup(fcb)
+		record := seqRecord(fcb)
+		if !m.readRecord(path, record) {
+			return 1
+		}
+		setSeqRecord(fcb, record+1)
+		return 0
+
+	case fnWriteSeq:
+		path, _ := m.lookup(fcb)
+		record := seqRecord(fcb)
+		if !m.writeRecord(path, record) {
+			return 2
+		}
+		setSeqRecord(fcb, record+1)
+		return 0
+
+	case fnMake:
+		path, ok := m.lookup(fcb)
+		if ok && (fcb[fcbEX] != 0 || fcb[fcbS2] != 0) {
+			return 0 // a new extent of a file that exists
+		}
+		m.closeFile(path)
+		f, err := os.Create(path)
+		if err != nil {
+			return 0xFF
+		}
+		m.files[path] = f
+		m.paths[hostName(fcb[fcbName:fcbName+11])] = path
+		fcb[fcbRC] = 0
+		return 0
+
+	case fnRename:
+		path, ok := m.lookup(fcb)
+		if !ok {
+			return 0xFF
+		}
+		newName := fcb[fcbNew : fcbNew+11]
+		if entries := m.list(newName); len(entries) > 0 {
+			return 0xFF
+		}
+		m.closeFile(path)
+		m.paths = map[string]string{}
+		if err := os.Rename(path, filepath.Join(m.Dir, hostName(newName))); err != nil {
+			return 0xFF
+		}
+		return 0
+
+	case fnReadRandom:
+		path, _ := m.lookup(fcb)
+		record := randomRecord(fcb)
+		if !m.readRecord(path, record) {
+			return 1
+		}
+		setSeqRecord(fcb, record)
+		return 0
+
+	case fnWriteRandom, fnWriteZero:
+		path, _ := m.lookup(fcb)
+		record := randomRecord(fcb)
+		if !m.writeRecord(path, record) {
+			return 2
+		}
+		setSeqRecord(fcb, record)
+		return 0
+
+	case fnFileSize:
+		path, ok := m.lookup(fcb)
+		if !ok {
+			return 0xFF
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return 0xFF
+		}
+		setRandomRecord(fcb, (info.Size()+recordSize-1)/recordSize)
+		return 0
+
+	case fnSetRandom:
+		setRandomRecord(fcb, seqRecord(fcb))
+		return 0
+	}
+	return 0xFF
+}
+
+// searchNext writes the next directory entry found by search first to the
+// DMA buffer.
+func (m *Machine) searchNext() byte {
+	if len(m.search) == 0 {
+		return 0xFF
+	}
+	e := m.search[0]
+	m.search = m.search[1:]
+
+	var dir [32]byte
+	dir[0] = m.user
+	copy(dir[1:12], e.name[:])
+	records := (e.size + recordSize - 1) / recordSize
+	if records > 0 {
+		extent := (records - 1) / recordSize
+		dir[fcbEX] = byte(extent % 32)
+		dir[fcbS2] = byte(extent / 32)
+		dir[fcbRC] = byte(records - extent*recordSize)
+	}
+	for i, b := range dir {
+		m.poke(m.dma+uint16(i), b)
+	}
+	return 0
+}
+
+// readRecord reads a record into the DMA buffer, padding a short last
+// record with ^Zs. It returns false at the end of the file.
+func (m *Machine) readRecord(path string, record int64) bool {
+	f, err := m.file(path, false)
+	if err != nil {
+		return false
+	}
+	var buf [recordSize]byte
+	n, err := f.ReadAt(buf[:], record*recordSize)
+	if n == 0 {
+		if err != nil && err != io.EOF && m.Sim.Debug {
+			fmt.Printf("read %s: %v\n", path, err)
+		}
+		return false
+	}
+	for i := n; i < recordSize; i++ {
+		buf[i] = 0x1A
+	}
+	for i, b := range buf {
+		m.poke(m.dma+uint16(i), b)
+	}
+	return true
+}
+
+// writeRecord writes the DMA buffer to a record.
+func (m *Machine) writeRecord(path string, record int64) bool {
+	f, err := m.file(path, true)
+	if err != nil {
+		return false
+	}
+	var buf [recordSize]byte
+	for i := range buf {
+		buf[i] = m.peek(m.dma + uint16(i))
+	}
+	if _, err := f.WriteAt(buf[:], record*recordSize); err != nil {
+		if m.Sim.Debug {
+			fmt.Printf("write %s: %v\n", path, err)
+		}
+		return false
+	}
+	return true
+}
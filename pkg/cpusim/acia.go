@@ -1,6 +1,7 @@
 package cpusim
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -28,6 +29,9 @@ import (
 //   - Bits 2-4: Word Select (data bits, parity, stop bits)
 //   - Bits 5-6: Transmit Control (RTS, TX interrupt enable)
 //   - Bit 7: Receive Interrupt Enable
+//
+// If an interrupt line is connected, the ACIA asserts it while IRQ is set in
+// the status register.
 type ACIA struct {
 	Sim            *CpuSim
 	Serial         SerialIO
@@ -40,6 +44,7 @@ type ACIA struct {
 	lastCharOut    byte
 	inputEOF       bool
 	controlReg     byte
+	Interrupt      *SignalLine
 }
 
 func (a *ACIA) GetName() string {
@@ -53,6 +58,10 @@ func (a *ACIA) HasAddress(address Address) bool {
 	return address == a.DataAddress || address == a.ControlAddress
 }
 
+func (a *ACIA) DecodeEnabler() EnablerInterface {
+	return a.Enabler
+}
+
 func (a *ACIA) Read(address Address) (byte, error) {
 	if !a.HasAddress(address) {
 		return 0, &ErrInvalidAddress{Address: address}
@@ -62,7 +71,7 @@ func (a *ACIA) Read(address Address) (byte, error) {
 	defer a.mu.Unlock()
 
 	if a.inputEOF && len(a.Keybuffer) == 0 {
-		a.Sim.Halt()
+		a.Sim.Stop(ExitEndOfInput)
 	}
 
 	if address == a.DataAddress {
@@ -72,6 +81,7 @@ func (a *ACIA) Read(address Address) (byte, error) {
 			if value == 0x0A {
 				value = 0x0D
 			}
+			a.updateInterrupt()
 			return value, nil
 		}
 		return 0, nil
@@ -89,7 +99,10 @@ func (a *ACIA) Read(address Address) (byte, error) {
 			a.Sim.IOPoll()
 			a.mu.Lock()
 		}
-		// DCD=0 (asserted/active), CTS=0 (asserted/active), no errors, no IRQ
+		if a.interruptPending() {
+			status |= 0x80 // IRQ
+		}
+		// DCD=0 (asserted/active), CTS=0 (asserted/active), no errors
 		return status, nil
 	}
 
@@ -102,7 +115,7 @@ func (a *ACIA) Write(address Address, value byte) error {
 	}
 
 	if address == a.DataAddress {
-		err := a.Serial.WriteByte(value)
+		err := a.Sim.WriteSerial(a.Serial, value)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error writing to serial: %v\n", err)
 		}
@@ -111,13 +124,40 @@ func (a *ACIA) Write(address Address, value byte) error {
 	}
 
 	if address == a.ControlAddress {
+		a.mu.Lock()
 		a.controlReg = value
-		// Bits 0-1 == 11 means master reset; we accept but ignore it
+		a.updateInterrupt()
+		a.mu.Unlock()
 	}
 
 	return nil
 }
 
+// ConnectInterrupt connects the ACIA's IRQ output to a signal line.
+func (a *ACIA) ConnectInterrupt(line *SignalLine) {
+	a.Interrupt = line
+}
+
+// interruptPending returns true if the receiver has data and receive
+// interrupts are enabled, or transmit interrupts are enabled (the transmit
+// register is always empty). Master reset disables both. Caller holds a.mu.
+func (a *ACIA) interruptPending() bool {
+	if a.controlReg&0x03 == 0x03 {
+		return false
+	}
+	if a.controlReg&0x80 != 0 && len(a.Keybuffer) > 0 {
+		return true
+	}
+	return a.controlReg&0x60 == 0x20
+}
+
+// updateInterrupt drives the interrupt line from the current state. Caller holds a.mu.
+func (a *ACIA) updateInterrupt() {
+	if a.Interrupt != nil {
+		a.Interrupt.Set(a, a.interruptPending())
+	}
+}
+
 func (a *ACIA) WriteStatus(address Address, statusAddr Address, value byte) error {
 	return &ErrNotImplemented{Device: a}
 }
@@ -135,21 +175,33 @@ func (a *ACIA) Run() error {
 		if b == 0x03 {
 			a.Sim.CtrlC.Store(true)
 		}
-		a.mu.Lock()
-		a.Keybuffer = append(a.Keybuffer, b)
-		a.mu.Unlock()
+		a.Sim.DeliverInput(a, b)
 	}
 }
 
+// ReceiveInput appends a byte to the receive buffer.
+func (a *ACIA) ReceiveInput(b byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Keybuffer = append(a.Keybuffer, b)
+	a.updateInterrupt()
+}
+
+// EndInput marks the end of the input. Once the receive buffer has been read
+// empty, the machine stops with ExitEndOfInput.
+func (a *ACIA) EndInput() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.inputEOF = true
+}
+
 func (a *ACIA) Start(wg *sync.WaitGroup) {
 	go func() {
 		a.Serial.Start()
 		err := a.Run()
 		if err != nil {
 			if err == io.EOF {
-				a.mu.Lock()
-				a.inputEOF = true
-				a.mu.Unlock()
+				a.EndInput()
 			} else {
 				fmt.Fprintf(os.Stderr, "ACIA error: %v\n", err)
 			}
@@ -175,3 +227,60 @@ func NewACIA(sim *CpuSim, serial SerialIO, name string, dataAddress, controlAddr
 		Enabler:        enabler,
 	}
 }
+
+// ACIAOptions are the options for a KIND_ACIA, a 6850.
+type ACIAOptions struct {
+	Name           string           `yaml:"name"`
+	DataAddress    Address          `yaml:"data"`
+	ControlAddress Address          `yaml:"control"`
+	Serial         SerialIO         `yaml:"-"`
+	Enabler        EnablerInterface `yaml:"-"`
+}
+
+func init() {
+	RegisterDevice(KIND_ACIA, func(sim *CpuSim, o ACIAOptions) (DeviceInterface, error) {
+		if o.Serial == nil {
+			return nil, fmt.Errorf("no serial transport")
+		}
+		return NewACIA(sim, o.Serial, o.Name, o.DataAddress, o.ControlAddress, enablerOrAlways(o.Enabler)), nil
+	})
+}
+
+// ResetDevice resets the control register. Input that has been typed but not
+// read is kept.
+func (a *ACIA) ResetDevice(kind ResetKind) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.controlReg = 0
+	a.updateInterrupt()
+}
+
+type aciaState struct {
+	Keybuffer   []byte `json:"keybuffer"`
+	LastCharOut byte   `json:"last_char_out"`
+	ControlReg  byte   `json:"control"`
+}
+
+func (a *ACIA) SaveState() (any, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return &aciaState{
+		Keybuffer:   append([]byte{}, a.Keybuffer...),
+		LastCharOut: a.lastCharOut,
+		ControlReg:  a.controlReg,
+	}, nil
+}
+
+func (a *ACIA) RestoreState(data json.RawMessage) error {
+	var state aciaState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Keybuffer = state.Keybuffer
+	a.lastCharOut = state.LastCharOut
+	a.controlReg = state.ControlReg
+	a.updateInterrupt()
+	return nil
+}
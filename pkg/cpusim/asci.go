@@ -1,6 +1,7 @@
 package cpusim
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -46,6 +47,9 @@ import (
 //
 // Channel 0 is the primary channel with keyboard input. Channel 1
 // accepts output but has no input source.
+//
+// If an interrupt line is connected, the ASCI asserts it whenever RDRF and
+// RIE, or TDRE and TIE, are both set on either channel.
 type ASCI struct {
 	Sim       *CpuSim
 	Serial    SerialIO
@@ -59,6 +63,7 @@ type ASCI struct {
 	cntlA     [2]byte // CNTLA0, CNTLA1
 	cntlB     [2]byte // CNTLB0, CNTLB1
 	stat      [2]byte // STAT0, STAT1
+	Interrupt *SignalLine
 }
 
 func (a *ASCI) GetName() string {
@@ -73,6 +78,10 @@ func (a *ASCI) HasAddress(address Address) bool {
 	return offset <= 0x09
 }
 
+func (a *ASCI) DecodeEnabler() EnablerInterface {
+	return a.Enabler
+}
+
 func (a *ASCI) Read(address Address) (byte, error) {
 	if !a.HasAddress(address) {
 		return 0, &ErrInvalidAddress{Address: address}
@@ -82,7 +91,7 @@ func (a *ASCI) Read(address Address) (byte, error) {
 	defer a.mu.Unlock()
 
 	if a.inputEOF && len(a.Keybuffer) == 0 {
-		a.Sim.Halt()
+		a.Sim.Stop(ExitEndOfInput)
 	}
 
 	offset := address - a.BaseAddr
@@ -122,6 +131,7 @@ func (a *ASCI) Read(address Address) (byte, error) {
 			if value == 0x0A {
 				value = 0x0D
 			}
+			a.updateInterrupt()
 			return value, nil
 		}
 		return 0, nil
@@ -152,10 +162,13 @@ func (a *ASCI) Write(address Address, value byte) error {
 	case 0x04, 0x05: // STAT0, STAT1
 		ch := offset - 0x04
 		// Only RIE (bit 3) and TIE (bit 0) are writable
+		a.mu.Lock()
 		a.stat[ch] = (a.stat[ch] & 0xF6) | (value & 0x09)
+		a.updateInterrupt()
+		a.mu.Unlock()
 
 	case 0x06, 0x07: // TDR0, TDR1
-		err := a.Serial.WriteByte(value)
+		err := a.Sim.WriteSerial(a.Serial, value)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error writing to serial: %v\n", err)
 		}
@@ -168,6 +181,27 @@ func (a *ASCI) Write(address Address, value byte) error {
 	return nil
 }
 
+// ConnectInterrupt connects the ASCI's interrupt output to a signal line.
+func (a *ASCI) ConnectInterrupt(line *SignalLine) {
+	a.Interrupt = line
+}
+
+// interruptPending returns true if either channel has an enabled interrupt
+// condition. The transmitters are always empty. Caller holds a.mu.
+func (a *ASCI) interruptPending() bool {
+	if a.stat[0]&0x08 != 0 && len(a.Keybuffer) > 0 {
+		return true
+	}
+	return a.stat[0]&0x01 != 0 || a.stat[1]&0x01 != 0
+}
+
+// updateInterrupt drives the interrupt line from the current state. Caller holds a.mu.
+func (a *ASCI) updateInterrupt() {
+	if a.Interrupt != nil {
+		a.Interrupt.Set(a, a.interruptPending())
+	}
+}
+
 func (a *ASCI) WriteStatus(address Address, statusAddr Address, value byte) error {
 	return &ErrNotImplemented{Device: a}
 }
@@ -185,21 +219,33 @@ func (a *ASCI) Run() error {
 		if b == 0x03 {
 			a.Sim.CtrlC.Store(true)
 		}
-		a.mu.Lock()
-		a.Keybuffer = append(a.Keybuffer, b)
-		a.mu.Unlock()
+		a.Sim.DeliverInput(a, b)
 	}
 }
 
+// ReceiveInput appends a byte to the receive buffer.
+func (a *ASCI) ReceiveInput(b byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Keybuffer = append(a.Keybuffer, b)
+	a.updateInterrupt()
+}
+
+// EndInput marks the end of the input. Once the receive buffer has been read
+// empty, the machine stops with ExitEndOfInput.
+func (a *ASCI) EndInput() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.inputEOF = true
+}
+
 func (a *ASCI) Start(wg *sync.WaitGroup) {
 	go func() {
 		a.Serial.Start()
 		err := a.Run()
 		if err != nil {
 			if err == io.EOF {
-				a.mu.Lock()
-				a.inputEOF = true
-				a.mu.Unlock()
+				a.EndInput()
 			} else {
 				fmt.Fprintf(os.Stderr, "ASCI error: %v\n", err)
 			}
@@ -224,3 +270,67 @@ func NewASCI(sim *CpuSim, serial SerialIO, name string, baseAddr Address, enable
 		Enabler:  enabler,
 	}
 }
+
+// ASCIOptions are the options for a KIND_ASCI, the Z180's serial ports.
+type ASCIOptions struct {
+	Name        string           `yaml:"name"`
+	BaseAddress Address          `yaml:"address"`
+	Serial      SerialIO         `yaml:"-"`
+	Enabler     EnablerInterface `yaml:"-"`
+}
+
+func init() {
+	RegisterDevice(KIND_ASCI, func(sim *CpuSim, o ASCIOptions) (DeviceInterface, error) {
+		if o.Serial == nil {
+			return nil, fmt.Errorf("no serial transport")
+		}
+		return NewASCI(sim, o.Serial, o.Name, o.BaseAddress, enablerOrAlways(o.Enabler)), nil
+	})
+}
+
+// ResetDevice resets the registers of both channels. Input that has been
+// typed but not read is kept.
+func (a *ASCI) ResetDevice(kind ResetKind) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cntlA = [2]byte{}
+	a.cntlB = [2]byte{}
+	a.stat = [2]byte{}
+	a.updateInterrupt()
+}
+
+type asciState struct {
+	Keybuffer   []byte  `json:"keybuffer"`
+	LastCharOut byte    `json:"last_char_out"`
+	CntlA       [2]byte `json:"cntla"`
+	CntlB       [2]byte `json:"cntlb"`
+	Stat        [2]byte `json:"stat"`
+}
+
+func (a *ASCI) SaveState() (any, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return &asciState{
+		Keybuffer:   append([]byte{}, a.Keybuffer...),
+		LastCharOut: a.lastCharOut,
+		CntlA:       a.cntlA,
+		CntlB:       a.cntlB,
+		Stat:        a.stat,
+	}, nil
+}
+
+func (a *ASCI) RestoreState(data json.RawMessage) error {
+	var state asciState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Keybuffer = state.Keybuffer
+	a.lastCharOut = state.LastCharOut
+	a.cntlA = state.CntlA
+	a.cntlB = state.CntlB
+	a.stat = state.Stat
+	a.updateInterrupt()
+	return nil
+}
@@ -2,6 +2,7 @@ package cpusim
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"os"
 )
@@ -69,13 +70,19 @@ const (
 //	2: Sector Count    7: Status / Command
 //	3: LBA Low         8: Alt Status / Device Control
 //	4: LBA Mid         9: Data Latch (high byte)
+//
+// If an interrupt line is connected, INTRQ is asserted when a command
+// completes or a sector is ready for transfer, and cleared by reading the
+// Status register or writing a command. Setting nIEN in Device Control masks it.
 type CompactFlash struct {
 	Sim         *CpuSim
 	Name        string
 	BaseAddress Address
 	Enabler     EnablerInterface
+	Interrupt   *SignalLine
 
 	file      *os.File
+	imagePath string
 	imageOff  int64 // byte offset to sector 0 in the image file
 	identify  [512]byte
 	data      [512]byte
@@ -95,6 +102,7 @@ type CompactFlash struct {
 	lba4    byte // device/head
 	status  byte
 	devctrl byte
+	intrq   bool
 
 	// Drive geometry (from identify block)
 	cylinders uint16
@@ -114,6 +122,20 @@ func NewCompactFlash(sim *CpuSim, name string, baseAddress Address, enabler Enab
 	}
 }
 
+// CompactFlashOptions are the options for a KIND_CF. The image is attached
+// afterwards, with AttachImage.
+type CompactFlashOptions struct {
+	Name        string           `yaml:"name"`
+	BaseAddress Address          `yaml:"address"`
+	Enabler     EnablerInterface `yaml:"-"`
+}
+
+func init() {
+	RegisterDevice(KIND_CF, func(sim *CpuSim, o CompactFlashOptions) (DeviceInterface, error) {
+		return NewCompactFlash(sim, o.Name, o.BaseAddress, enablerOrAlways(o.Enabler)), nil
+	})
+}
+
 // AttachImage opens a disk image file. imageOffset is the byte offset where
 // sector 0 begins (use 1024 for emulatorkit images that have a 1KB header,
 // or 0 for raw images).
@@ -123,6 +145,7 @@ func (cf *CompactFlash) AttachImage(filename string, imageOffset int64) error {
 		return fmt.Errorf("cf: open %s: %w", filename, err)
 	}
 	cf.file = f
+	cf.imagePath = filename
 	cf.imageOff = imageOffset
 	return nil
 }
@@ -181,8 +204,13 @@ func (cf *CompactFlash) HasAddress(address Address) bool {
 	return reg >= 0 && reg < cfNumRegs
 }
 
+func (cf *CompactFlash) DecodeEnabler() EnablerInterface {
+	return cf.Enabler
+}
+
 func (cf *CompactFlash) Read(address Address) (byte, error) {
 	reg := int(address) - int(cf.BaseAddress)
+	defer cf.updateInterrupt()
 
 	switch reg {
 	case cfRegData:
@@ -200,6 +228,7 @@ func (cf *CompactFlash) Read(address Address) (byte, error) {
 	case cfRegDevHead:
 		return cf.lba4, nil
 	case cfRegStatus:
+		cf.intrq = false
 		return cf.status, nil
 	case cfRegAltStatus:
 		return cf.status, nil
@@ -211,6 +240,7 @@ func (cf *CompactFlash) Read(address Address) (byte, error) {
 
 func (cf *CompactFlash) Write(address Address, value byte) error {
 	reg := int(address) - int(cf.BaseAddress)
+	defer cf.updateInterrupt()
 
 	switch reg {
 	case cfRegData:
@@ -252,6 +282,18 @@ func (cf *CompactFlash) WriteStatus(address Address, statusAddr Address, value b
 	return &ErrNotImplemented{Device: cf}
 }
 
+// ConnectInterrupt connects the INTRQ output to a signal line.
+func (cf *CompactFlash) ConnectInterrupt(line *SignalLine) {
+	cf.Interrupt = line
+}
+
+// updateInterrupt drives the interrupt line from INTRQ and nIEN.
+func (cf *CompactFlash) updateInterrupt() {
+	if cf.Interrupt != nil {
+		cf.Interrupt.Set(cf, cf.intrq && cf.devctrl&0x02 == 0)
+	}
+}
+
 func (cf *CompactFlash) reset() {
 	cf.status = cfStDRDY | cfStDSC
 	cf.error = 0x01
@@ -262,6 +304,7 @@ func (cf *CompactFlash) reset() {
 	cf.count = 0x01
 	cf.state = cfStateIdle
 	cf.eightbit = false
+	cf.intrq = false
 }
 
 func (cf *CompactFlash) xlateBlock() int64 {
@@ -381,6 +424,8 @@ func (cf *CompactFlash) dataIn() byte {
 		cf.advanceLBA()
 		if cf.length == 0 {
 			cf.completed()
+		} else {
+			cf.intrq = true // next sector is ready
 		}
 	}
 	return v
@@ -420,12 +465,14 @@ func (cf *CompactFlash) completed() {
 	cf.status &= ^byte(cfStBSY | cfStDRQ)
 	cf.status |= cfStDRDY
 	cf.state = cfStateIdle
+	cf.intrq = true
 }
 
 func (cf *CompactFlash) issueCommand(cmd byte) {
 	cf.status &= ^byte(cfStERR | cfStDRDY)
 	cf.status |= cfStBSY
 	cf.error = 0
+	cf.intrq = false
 
 	switch {
 	case cmd == cfCmdIdentify:
@@ -435,6 +482,7 @@ func (cf *CompactFlash) issueCommand(cmd byte) {
 		cf.state = cfStateDataIn
 		cf.status &= ^byte(cfStBSY)
 		cf.status |= cfStDRQ | cfStDRDY
+		cf.intrq = true
 
 	case cmd == cfCmdRead || cmd == cfCmdReadNR:
 		cf.length = int(cf.count)
@@ -445,6 +493,7 @@ func (cf *CompactFlash) issueCommand(cmd byte) {
 		cf.state = cfStateDataIn
 		cf.status &= ^byte(cfStBSY)
 		cf.status |= cfStDRQ | cfStDSC | cfStDRDY
+		cf.intrq = true
 
 	case cmd == cfCmdWrite || cmd == cfCmdWriteNR:
 		cf.length = int(cf.count)
@@ -515,9 +564,105 @@ func (cf *CompactFlash) issueCommand(cmd byte) {
 	}
 }
 
-func (cf *CompactFlash) Close() {
-	if cf.file != nil {
-		cf.file.Close()
-		cf.file = nil
+// ResetDevice resets the drive as its RESET pin does. The image stays
+// attached.
+func (cf *CompactFlash) ResetDevice(kind ResetKind) {
+	cf.reset()
+	cf.devctrl = 0
+	cf.updateInterrupt()
+}
+
+// Close flushes the image to disk and closes it.
+func (cf *CompactFlash) Close() error {
+	if cf.file == nil {
+		return nil
+	}
+	err := cf.file.Sync()
+	if cerr := cf.file.Close(); err == nil {
+		err = cerr
+	}
+	cf.file = nil
+	return err
+}
+
+type cfState struct {
+	Registers [10]byte `json:"registers"` // error, feature, count, lba1-4, status, devctrl, latch
+	Data      []byte   `json:"data"`
+	Identify  []byte   `json:"identify"`
+	DataPtr   int      `json:"dptr"`
+	State     int      `json:"state"`
+	Length    int      `json:"length"`
+	IntRQ     bool     `json:"intrq"`
+	Cylinders uint16   `json:"cylinders"`
+	Heads     byte     `json:"heads"`
+	Sectors   byte     `json:"sectors"`
+	LBA       bool     `json:"lba"`
+	EightBit  bool     `json:"eightbit"`
+	ImagePath string   `json:"image_path,omitempty"`
+	ImageOff  int64    `json:"image_offset"`
+}
+
+func (cf *CompactFlash) SaveState() (any, error) {
+	return &cfState{
+		Registers: [10]byte{cf.error, cf.feature, cf.count, cf.lba1, cf.lba2, cf.lba3, cf.lba4, cf.status, cf.devctrl, cf.dataLatch},
+		Data:      cf.data[:],
+		Identify:  cf.identify[:],
+		DataPtr:   cf.dptr,
+		State:     cf.state,
+		Length:    cf.length,
+		IntRQ:     cf.intrq,
+		Cylinders: cf.cylinders,
+		Heads:     cf.heads,
+		Sectors:   cf.sectors,
+		LBA:       cf.lba,
+		EightBit:  cf.eightbit,
+		ImagePath: cf.imagePath,
+		ImageOff:  cf.imageOff,
+	}, nil
+}
+
+// RestoreState restores the controller. If the snapshot was taken with a
+// different image attached, that image is reopened.
+func (cf *CompactFlash) RestoreState(data json.RawMessage) error {
+	var state cfState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
 	}
+	if len(state.Data) != len(cf.data) || len(state.Identify) != len(cf.identify) {
+		return fmt.Errorf("cf: bad sector buffer size in snapshot")
+	}
+	if state.DataPtr < 0 || state.DataPtr > len(cf.data) {
+		return fmt.Errorf("cf: bad data pointer %d in snapshot", state.DataPtr)
+	}
+
+	if state.ImagePath != cf.imagePath {
+		if err := cf.Close(); err != nil {
+			return err
+		}
+		cf.imagePath = ""
+		if state.ImagePath != "" {
+			if err := cf.AttachImage(state.ImagePath, state.ImageOff); err != nil {
+				return err
+			}
+		}
+	}
+	cf.imageOff = state.ImageOff
+
+	r := state.Registers
+	cf.error, cf.feature, cf.count = r[0], r[1], r[2]
+	cf.lba1, cf.lba2, cf.lba3, cf.lba4 = r[3], r[4], r[5], r[6]
+	cf.status, cf.devctrl, cf.dataLatch = r[7], r[8], r[9]
+	copy(cf.data[:], state.Data)
+	copy(cf.identify[:], state.Identify)
+	cf.dptr = state.DataPtr
+	cf.state = state.State
+	cf.length = state.Length
+	cf.intrq = state.IntRQ
+	cf.cylinders = state.Cylinders
+	cf.heads = state.Heads
+	cf.sectors = state.Sectors
+	cf.lba = state.LBA
+	cf.eightbit = state.EightBit
+	cf.updateInterrupt()
+	return nil
 }
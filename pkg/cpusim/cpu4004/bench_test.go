@@ -0,0 +1,68 @@
+package cpu4004
+
+import (
+	"testing"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+)
+
+// newSBC4004 builds the ROM, RAM and ROM page mapper of the 4004 single
+// board computer, as cpusim4004 does, with the ROM filled by program.
+func newSBC4004(b *testing.B, program []byte) *CPU4004 {
+	b.Helper()
+
+	sim := cpusim.NewCPUSim()
+	sim.SetDebug(false)
+
+	cpu := New4004(sim, "cpu")
+	sim.AddCPU(cpu)
+
+	mapper := cpusim.New74670(sim, "mapper", 0x00, cpusim.A10, cpusim.D0, cpusim.A10, cpusim.A11, cpusim.A12, cpusim.A13, &cpusim.AlwaysEnabled, &cpusim.AlwaysEnabled)
+	mapper.FilterMemoryKind(cpusim.KIND_ROM)
+	sim.AddMapper(mapper)
+
+	rom := cpusim.NewMemory(sim, "rom", cpusim.KIND_ROM, 0x0000, 0x3FFF, 12, true, &cpusim.TrueEnabler{})
+	sim.AddMemory(rom)
+	ram := cpusim.NewMemory(sim, "ram", cpusim.KIND_RAM, 0x0000, 0x7F, 7, false, cpu.DCLEnabler(0))
+	sim.AddMemory(ram)
+
+	romPort := NewRomPort(sim, "romport_4289", &cpusim.TrueEnabler{})
+	romPort.AddPort(mapper)
+	sim.AddPort(romPort)
+
+	copy(rom.Contents, program)
+	return cpu
+}
+
+// BenchmarkNOPLoop measures the time per instruction of a loop of NOPs.
+func BenchmarkNOPLoop(b *testing.B) {
+	program := make([]byte, 256)
+	program[254], program[255] = 0x40, 0x00 // JUN 000
+	cpu := newSBC4004(b, program)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := cpu.Execute(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkRAMLoop measures the time per instruction of a loop that reads
+// and writes RAM, switching the memory filter between ROM and RAM.
+func BenchmarkRAMLoop(b *testing.B) {
+	program := []byte{
+		0x20, 0x00, // FIM P0,00
+		0x21,       // SRC P0
+		0xE9,       // RDM
+		0xF2,       // IAC
+		0xE0,       // WRM
+		0x40, 0x02, // JUN 002
+	}
+	cpu := newSBC4004(b, program)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := cpu.Execute(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
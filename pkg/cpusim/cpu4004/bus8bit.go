@@ -26,6 +26,14 @@ func (b *Bus8Bit) HasAddress(address cpusim.Address) bool {
 	return (address >= 0) && (address <= 255)
 }
 
+func (b *Bus8Bit) DecodeEnabler() cpusim.EnablerInterface {
+	return b.Enabler
+}
+
+func (b *Bus8Bit) AddressRange() (cpusim.Address, cpusim.Address) {
+	return 0, 255
+}
+
 func (b *Bus8Bit) _writePort(address cpusim.Address, value byte) error {
 	for _, mem := range b.Ports {
 		if mem.HasAddress(address) {
@@ -185,3 +193,30 @@ func NewBus8Bit(sim *cpusim.CpuSim, name string, enabler cpusim.EnablerInterface
 	}
 	return mem
 }
+
+// KIND_BUS8 is the kind that the 8-bit bus registers as. It reads as
+// KIND_RAM from GetKind, as the CPU treats it as RAM.
+const KIND_BUS8 = "BUS8"
+
+// DeviceOptions are the options for the 4004's own devices, the 8-bit bus
+// and the ROM port.
+type DeviceOptions struct {
+	Name    string                  `yaml:"name"`
+	Enabler cpusim.EnablerInterface `yaml:"-"`
+}
+
+func init() {
+	cpusim.RegisterDevice(KIND_BUS8, func(sim *cpusim.CpuSim, o DeviceOptions) (cpusim.DeviceInterface, error) {
+		return NewBus8Bit(sim, o.Name, enablerOrAlways(o.Enabler)), nil
+	})
+	cpusim.RegisterDevice(cpusim.KIND_ROMPORT, func(sim *cpusim.CpuSim, o DeviceOptions) (cpusim.DeviceInterface, error) {
+		return NewRomPort(sim, o.Name, enablerOrAlways(o.Enabler)), nil
+	})
+}
+
+func enablerOrAlways(enabler cpusim.EnablerInterface) cpusim.EnablerInterface {
+	if enabler == nil {
+		return &cpusim.AlwaysEnabled
+	}
+	return enabler
+}
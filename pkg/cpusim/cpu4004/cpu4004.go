@@ -7,6 +7,10 @@ import (
 	"github.com/scottmbaker/gocpusim/pkg/cpusim"
 )
 
+// ClocksPerCycle is the number of clock periods in one 4004 instruction cycle.
+// At the standard 740kHz clock an instruction cycle takes 10.8us.
+const ClocksPerCycle = 8
+
 type CPU4004 struct {
 	Sim        *cpusim.CpuSim // Reference to the CPU simulation
 	Name       string         // Name of the CPU
@@ -17,11 +21,15 @@ type CPU4004 struct {
 	PC         uint16         // Program Counter
 	Halted     atomic.Bool    // Flag to indicate if the CPU is halted
 	NewStyle   bool           // Flag to indicate if the new style debugging is used
-	Cycles     int            // Cycle counter
+	Cycles     int            // Instruction cycle counter (ClocksPerCycle clocks each)
 	DebugLine  func(*cpusim.CpuSim)
 	DebugTwo   func(*cpusim.CpuSim)
 	DebugThree func(*cpusim.CpuSim)
 	DebugFour  func(*cpusim.CpuSim)
+
+	resetPending atomic.Bool
+	resetLine    *cpusim.SignalLine
+	testLine     *cpusim.SignalLine
 }
 
 const (
@@ -191,6 +199,23 @@ func (cpu *CPU4004) GetPairName(pair int) string {
 	}
 }
 
+func (cpu *CPU4004) GetPC() cpusim.Address {
+	return cpusim.Address(cpu.PC)
+}
+
+func (cpu *CPU4004) SetPC(pc cpusim.Address) {
+	cpu.PC = uint16(pc)
+}
+
+// RegisterNames names the registers accepted by GetReg and SetReg.
+func (cpu *CPU4004) RegisterNames() []string {
+	names := make([]string, len(cpu.Registers))
+	for i := range names {
+		names[i] = cpu.GetRegName(i)
+	}
+	return names
+}
+
 func (cpu *CPU4004) GetReg(register int) (byte, error) {
 	if register < 0 || register >= len(cpu.Registers) {
 		return 0, &cpusim.ErrInvalidRegister{Device: cpu, Register: register}
@@ -261,6 +286,8 @@ func (cpu *CPU4004) ExecuteFIN(destPair int) error {
 	srcAddr := (cpu.PC & 0xFF00) | uint16(srcRelAddr)
 	// Note: This already handles the wrap issue when FIN was the instruction at 0xxxFF.
 	// Since PC was already incremented, it already points to the next page.
+	// FIN is a one-word instruction that takes a second cycle to read the ROM
+	cpu.Cycles += 1
 	cpu.Sim.FilterMemoryKind(cpusim.KIND_ROM)
 	srcVal, err := cpu.Sim.ReadMemory(cpusim.Address(srcAddr))
 	if err != nil {
@@ -326,7 +353,10 @@ func (cpu *CPU4004) ExecuteFetchImmediate(opCode byte) error {
 	return cpu.SetPair(destPair, value)
 }
 
+// FetchOpcode fetches the next word from ROM. Each word fetched takes one
+// instruction cycle, so two-word instructions take two.
 func (cpu *CPU4004) FetchOpcode() (byte, error) {
+	cpu.Cycles += 1
 	cpu.Sim.FilterMemoryKind(cpusim.KIND_ROM)
 	opCode, err := cpu.Sim.ReadMemory(cpusim.Address(cpu.PC))
 	if err != nil {
@@ -690,13 +720,64 @@ func (cpu *CPU4004) ExecuteBBL(value byte) error {
 	return cpu.SetReg(REG_ACCUM, value)
 }
 
+// Reset clears the registers, stack and program counter, as the 4004 RESET
+// input does.
+func (cpu *CPU4004) Reset() {
+	for i := range cpu.Registers {
+		if i != FLAG_TEST {
+			cpu.Registers[i] = 0
+		}
+	}
+	for i := range cpu.Stack {
+		cpu.Stack[i] = 0
+	}
+	cpu.RC = 0
+	cpu.SP = 0
+	cpu.PC = 0
+}
+
+// ResetDevice resets the CPU for CpuSim.Reset, as the RESET line does.
+func (cpu *CPU4004) ResetDevice(kind cpusim.ResetKind) {
+	cpu.resetPending.Store(false)
+	cpu.Reset()
+}
+
+// ConnectSignal connects a control line to the CPU. RESET holds the CPU in
+// reset while asserted. TEST drives the TEST input tested by JCN; asserted
+// corresponds to the pin being high.
+func (cpu *CPU4004) ConnectSignal(name string, line *cpusim.SignalLine) error {
+	switch name {
+	case cpusim.SIGNAL_RESET:
+		cpu.resetLine = line
+		line.OnChange(func(asserted bool) {
+			if asserted {
+				cpu.resetPending.Store(true)
+			}
+		})
+	case cpusim.SIGNAL_TEST:
+		cpu.testLine = line
+	default:
+		return &cpusim.ErrNotImplemented{Device: cpu, What: "signal " + name}
+	}
+	return nil
+}
+
 func (cpu *CPU4004) Execute() error {
+	if cpu.resetPending.Load() || (cpu.resetLine != nil && cpu.resetLine.Asserted()) {
+		cpu.resetPending.Store(false)
+		cpu.Reset()
+		return nil
+	}
+
+	if cpu.testLine != nil {
+		cpu.Registers[FLAG_TEST] = toBit(cpu.testLine.Asserted())
+	}
+
 	if cpu.Sim.Debug {
+		cpu.Sim.DebugLabel(cpusim.Address(cpu.PC))
 		fmt.Printf("%04X: ", cpu.PC)
 	}
 
-	cpu.Cycles += 1
-
 	opCode, err := cpu.FetchOpcode()
 	if err != nil {
 		return err
@@ -961,6 +1042,19 @@ func (cpu *CPU4004) Halt() {
 	cpu.Halted.Store(true)
 }
 
+func (cpu *CPU4004) IsHalted() bool {
+	return cpu.Halted.Load()
+}
+
+func (cpu *CPU4004) ClearHalt() {
+	cpu.Halted.Store(false)
+}
+
+// ClockCycles returns the number of clock cycles executed.
+func (cpu *CPU4004) ClockCycles() uint64 {
+	return uint64(cpu.Cycles) * ClocksPerCycle
+}
+
 func (cpu *CPU4004) Run() error {
 	cpu.Halted.Store(false)
 	for {
@@ -972,10 +1066,14 @@ func (cpu *CPU4004) Run() error {
 			fmt.Println("CPU halted")
 			return nil
 		}
+		start := cpu.Cycles
 		if err := cpu.Execute(); err != nil {
 			return err
 		}
-		cpu.Sim.Throttle.Tick()
+		cpu.Sim.Throttle.Tick(uint64(cpu.Cycles-start) * ClocksPerCycle)
+		if err := cpu.Sim.AfterStep(); err != nil {
+			return err
+		}
 	}
 	// never reached
 }
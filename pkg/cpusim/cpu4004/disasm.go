@@ -0,0 +1,148 @@
+package cpu4004
+
+import (
+	"fmt"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+)
+
+// Disassembler decodes 4004 and 4040 instructions without executing them.
+// JCN, FIM, JUN, JMS and ISZ take two bytes. JCN and ISZ jump within the
+// page holding their second byte, so one at the end of a page jumps into the
+// next. JUN and JMS stay within the current 4K bank.
+//
+// With Is4040 set, the 4040 instructions in 01-0E are decoded; otherwise
+// they are shown as DB, like any other undefined opcode. Numbers are in hex,
+// in the "0FH" style. If Symbols is set, jump targets are replaced by their
+// names.
+type Disassembler struct {
+	Is4040  bool
+	Symbols cpusim.SymbolLookup
+}
+
+func NewDisassembler(is4040 bool, symbols cpusim.SymbolLookup) *Disassembler {
+	return &Disassembler{Is4040: is4040, Symbols: symbols}
+}
+
+// Disassemble decodes the instruction at address in the CPU's ROM. The CPU
+// executes the 4040 HLT, so 4040 mnemonics are used.
+func (cpu *CPU4004) Disassemble(address cpusim.Address) cpusim.Instruction {
+	filter := cpu.Sim.MemoryFilter
+	defer cpu.Sim.FilterMemoryKind(filter)
+	cpu.Sim.FilterMemoryKind(cpusim.KIND_ROM)
+	return NewDisassembler(true, cpu.Sim.Symbols).Disassemble(cpu.Sim.PeekMemory, address)
+}
+
+var (
+	dis4040   = [15]string{"NOP", "HLT", "BBS", "LCR", "OR4", "OR5", "AN6", "AN7", "DB0", "DB1", "SB0", "SB1", "EIN", "DIN", "RPM"}
+	disRegOps = [16]string{6: "INC", 8: "ADD", 9: "SUB", 10: "LD", 11: "XCH"}
+	disIO     = [16]string{"WRM", "WMP", "WRR", "WPM", "WR0", "WR1", "WR2", "WR3", "SBM", "RDM", "RDR", "ADM", "RD0", "RD1", "RD2", "RD3"}
+	disAcc    = [14]string{"CLB", "CLC", "IAC", "CMC", "CMA", "RAL", "RAR", "TCC", "DAC", "TCS", "STC", "DAA", "KBP", "DCL"}
+
+	// JCN conditions with a name in the assembler: test, carry, zero and
+	// their inversions
+	disCond = map[byte]string{1: "T", 2: "C", 4: "Z", 9: "TN", 10: "CN", 12: "ZN"}
+)
+
+// Disassemble decodes the instruction at address.
+func (d *Disassembler) Disassemble(read cpusim.ReadFunc, address cpusim.Address) cpusim.Instruction {
+	ins := cpusim.Instruction{Address: address}
+	pc := address
+	fetch := func() byte {
+		b := read(pc)
+		ins.Bytes = append(ins.Bytes, b)
+		pc++
+		return b
+	}
+	set := func(mnemonic, operands string) {
+		ins.Mnemonic = mnemonic
+		ins.Operands = operands
+	}
+	target := func(a cpusim.Address) string {
+		ins.Target = a
+		ins.HasTarget = true
+		if d.Symbols != nil {
+			if name, ok := d.Symbols.LookupSymbol(a); ok {
+				return name
+			}
+		}
+		return hexNumber(int(a), 3)
+	}
+	// inPage is a jump within the page of the instruction's second byte
+	inPage := func() string {
+		low := fetch()
+		return target(pc&^0xFF | cpusim.Address(low))
+	}
+
+	op := fetch()
+	low := op & 0x0F
+	reg := fmt.Sprintf("R%d", low)
+	pair := fmt.Sprintf("P%d", low>>1)
+
+	switch op >> 4 {
+	case 0x0:
+		switch {
+		case op == 0x00:
+			set("NOP", "")
+		case d.Is4040 && int(op) < len(dis4040):
+			set(dis4040[op], "")
+		default:
+			set("DB", hexNumber(int(op), 2))
+		}
+	case 0x1:
+		cond, ok := disCond[low]
+		if !ok {
+			cond = fmt.Sprintf("%d", low)
+		}
+		set("JCN", cond+","+inPage())
+	case 0x2:
+		if op&1 == 0 {
+			set("FIM", pair+","+hexNumber(int(fetch()), 2))
+		} else {
+			set("SRC", pair)
+		}
+	case 0x3:
+		if op&1 == 0 {
+			set("FIN", pair)
+		} else {
+			set("JIN", pair)
+		}
+	case 0x4, 0x5:
+		high := cpusim.Address(low) << 8
+		a := address&^0xFFF | high | cpusim.Address(fetch())
+		if op>>4 == 0x4 {
+			set("JUN", target(a))
+		} else {
+			set("JMS", target(a))
+			ins.Flow = cpusim.FlowCall
+		}
+	case 0x6, 0x8, 0x9, 0xA, 0xB:
+		set(disRegOps[op>>4], reg)
+	case 0x7:
+		set("ISZ", reg+","+inPage())
+	case 0xC:
+		set("BBL", hexNumber(int(low), 1))
+		ins.Flow = cpusim.FlowReturn
+	case 0xD:
+		set("LDM", hexNumber(int(low), 1))
+	case 0xE:
+		set(disIO[low], "")
+	case 0xF:
+		if int(low) < len(disAcc) {
+			set(disAcc[low], "")
+		} else {
+			set("DB", hexNumber(int(op), 2))
+		}
+	}
+	return ins
+}
+
+// hexNumber formats a number in assembler hex, with a leading zero when
+// the first digit is a letter.
+func hexNumber(value int, digits int) string {
+	text := fmt.Sprintf("%0*XH", digits, value)
+	if text[0] > '9' {
+		text = "0" + text
+	}
+	return text
+}
@@ -0,0 +1,78 @@
+package cpu4004
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisassemble(t *testing.T) {
+	tests := []struct {
+		address cpusim.Address
+		code    []byte
+		text    string
+	}{
+		{0x100, []byte{0x00}, "NOP"},
+		{0x100, []byte{0x01}, "HLT"},
+		{0x100, []byte{0x0F}, "DB 0FH"},
+		{0x100, []byte{0x12, 0x34}, "JCN C,134H"},
+		{0x100, []byte{0x1A, 0x34}, "JCN CN,134H"},
+		{0x100, []byte{0x16, 0x34}, "JCN 6,134H"},
+		{0x1FE, []byte{0x14, 0x05}, "JCN Z,205H"},
+		{0x1FF, []byte{0x74, 0x05}, "ISZ R4,205H"},
+		{0x100, []byte{0x2A, 0xAB}, "FIM P5,0ABH"},
+		{0x100, []byte{0x2B}, "SRC P5"},
+		{0x100, []byte{0x3C}, "FIN P6"},
+		{0x100, []byte{0x3F}, "JIN P7"},
+		{0x100, []byte{0x4A, 0xBC}, "JUN 0ABCH"},
+		{0x1100, []byte{0x50, 0x10}, "JMS 1010H"},
+		{0x100, []byte{0x6F}, "INC R15"},
+		{0x100, []byte{0xB3}, "XCH R3"},
+		{0x100, []byte{0xCF}, "BBL 0FH"},
+		{0x100, []byte{0xD7}, "LDM 7H"},
+		{0x100, []byte{0xE8}, "SBM"},
+		{0x100, []byte{0xFD}, "DCL"},
+		{0x100, []byte{0xFE}, "DB 0FEH"},
+	}
+
+	dis := NewDisassembler(true, nil)
+	for _, tt := range tests {
+		ins := dis.Disassemble(cpusim.ImageReader(tt.code, tt.address), tt.address)
+		assert.Equal(t, tt.text, ins.Text(), "% X", tt.code)
+		assert.Equal(t, tt.code, ins.Bytes, tt.text)
+	}
+
+	assert.Equal(t, "DB 01H", NewDisassembler(false, nil).Disassemble(cpusim.ImageReader([]byte{0x01}, 0), 0).Text())
+}
+
+// TestDisassembleExecution checks the disassembler against the CPU: every
+// instruction that executes without transferring control must advance the
+// PC by its length.
+func TestDisassembleExecution(t *testing.T) {
+	flow := map[string]bool{"JCN": true, "JUN": true, "JMS": true, "ISZ": true, "JIN": true, "BBL": true, "HLT": true}
+	for op := 0; op < 256; op++ {
+		sim := cpusim.NewCPUSim()
+		cpu := New4004(sim, "cpu")
+		sim.AddCPU(cpu)
+		rom := cpusim.NewMemory(sim, "rom", cpusim.KIND_ROM, 0x000, 0xFFF, 12, true, &cpusim.AlwaysEnabled)
+		sim.AddMemory(rom)
+		ram := cpusim.NewMemory(sim, "ram", cpusim.KIND_RAM, 0x00, 0x7F, 7, false, &cpusim.AlwaysEnabled)
+		ram.CreateStatusBytes(0x08, 0x04)
+		sim.AddMemory(ram)
+
+		copy(rom.Contents[0x100:], []byte{byte(op), 0x10})
+		cpu.PC = 0x100
+		ins := cpu.Disassemble(0x100)
+		if flow[ins.Mnemonic] {
+			continue
+		}
+		if err := cpu.Execute(); err != nil {
+			continue // not implemented by the simulator
+		}
+		require.False(t, strings.HasPrefix(ins.Text(), "DB"), "%02X executed", op)
+		assert.Equal(t, 0x100+ins.Len(), int(cpu.PC), "%02X: %s", op, ins.Text())
+	}
+}
@@ -0,0 +1,42 @@
+package cpu4004
+
+import "github.com/scottmbaker/gocpusim/pkg/cpusim"
+
+// RemoteArchitecture is empty, as GDB has no support for the 4004.
+func (cpu *CPU4004) RemoteArchitecture() string {
+	return ""
+}
+
+// RemoteRegisters returns R0 to R15, the accumulator, CL, the carry and test
+// flags, PC, the stack pointer and RC.
+func (cpu *CPU4004) RemoteRegisters() []cpusim.RemoteRegister {
+	var regs []cpusim.RemoteRegister
+	for i := range cpu.Registers {
+		regs = append(regs, cpusim.RemoteRegister{
+			Name: cpu.GetRegName(i),
+			Bits: 8,
+			Get:  func() uint16 { return uint16(cpu.Registers[i]) },
+			Set:  func(value uint16) { cpu.Registers[i] = byte(value) },
+		})
+	}
+	return append(regs,
+		cpusim.RemoteRegister{
+			Name: "PC",
+			Bits: 16,
+			Get:  func() uint16 { return cpu.PC },
+			Set:  func(value uint16) { cpu.PC = value },
+		},
+		cpusim.RemoteRegister{
+			Name: "SP",
+			Bits: 8,
+			Get:  func() uint16 { return uint16(cpu.SP) },
+			Set:  func(value uint16) { cpu.SP = byte(value) },
+		},
+		cpusim.RemoteRegister{
+			Name: "RC",
+			Bits: 8,
+			Get:  func() uint16 { return uint16(cpu.RC) },
+			Set:  func(value uint16) { cpu.RC = byte(value) },
+		},
+	)
+}
@@ -22,6 +22,14 @@ func (b *RomPort) HasAddress(address cpusim.Address) bool {
 	return (address >= 0) && (address <= 255)
 }
 
+func (b *RomPort) DecodeEnabler() cpusim.EnablerInterface {
+	return b.Enabler
+}
+
+func (b *RomPort) AddressRange() (cpusim.Address, cpusim.Address) {
+	return 0, 255
+}
+
 func (b *RomPort) _writePort(address cpusim.Address, value byte) error {
 	address = (address >> 4) & 0x0F
 	for _, mem := range b.Ports {
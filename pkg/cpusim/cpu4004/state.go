@@ -0,0 +1,82 @@
+package cpu4004
+
+import (
+	"encoding/json"
+)
+
+type cpu4004State struct {
+	Registers [20]byte
+	Stack     [3]uint16
+	RC        byte
+	SP        byte
+	PC        uint16
+	Cycles    int
+}
+
+func (cpu *CPU4004) SaveState() (any, error) {
+	return &cpu4004State{
+		Registers: cpu.Registers,
+		Stack:     cpu.Stack,
+		RC:        cpu.RC,
+		SP:        cpu.SP,
+		PC:        cpu.PC,
+		Cycles:    cpu.Cycles,
+	}, nil
+}
+
+func (cpu *CPU4004) RestoreState(data json.RawMessage) error {
+	var s cpu4004State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	cpu.Registers = s.Registers
+	cpu.Stack = s.Stack
+	cpu.RC = s.RC
+	cpu.SP = s.SP
+	cpu.PC = s.PC
+	cpu.Cycles = s.Cycles
+	return nil
+}
+
+// Children lets machine snapshots reach the devices behind the bus adapter.
+func (b *Bus8Bit) Children() []any {
+	children := []any{}
+	for _, mem := range b.Memory {
+		children = append(children, mem)
+	}
+	for _, port := range b.Ports {
+		children = append(children, port)
+	}
+	for _, mapper := range b.Mappers {
+		children = append(children, mapper)
+	}
+	return children
+}
+
+type bus8BitState struct {
+	LastReadValue  byte
+	LastWriteValue byte
+}
+
+func (b *Bus8Bit) SaveState() (any, error) {
+	return &bus8BitState{LastReadValue: b.LastReadValue, LastWriteValue: b.LastWriteValue}, nil
+}
+
+func (b *Bus8Bit) RestoreState(data json.RawMessage) error {
+	var s bus8BitState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	b.LastReadValue = s.LastReadValue
+	b.LastWriteValue = s.LastWriteValue
+	return nil
+}
+
+// Children lets machine snapshots reach the devices behind the ROM port.
+func (b *RomPort) Children() []any {
+	children := []any{}
+	for _, port := range b.Ports {
+		children = append(children, port)
+	}
+	return children
+}
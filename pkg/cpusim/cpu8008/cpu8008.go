@@ -16,6 +16,16 @@ type CPU8008 struct {
 	PC        uint16 // Program Counter
 	Halted    atomic.Bool // Flag to indicate if the CPU is halted
 	NewStyle  bool   // Flag to indicate if the new style debugging is used
+	Stopped   bool   // HALT executed with an interrupt line connected; waiting for INTERRUPT
+	Cycles    uint64 // T-states executed (ClocksPerState clocks each)
+
+	// IntAck returns the instruction jammed onto the data bus when an
+	// interrupt is acknowledged. If nil, RST 0 is used, which is how most
+	// 8008 boards start the processor.
+	IntAck func() byte
+
+	intConnected bool
+	intPending   atomic.Bool
 }
 
 const (
@@ -138,6 +148,26 @@ func (cpu *CPU8008) GetRegName(reg int) string {
 	}
 }
 
+func (cpu *CPU8008) GetPC() cpusim.Address {
+	return cpusim.Address(cpu.PC)
+}
+
+func (cpu *CPU8008) SetPC(pc cpusim.Address) {
+	cpu.PC = uint16(pc)
+}
+
+// RegisterNames names the registers accepted by GetReg and SetReg. M is
+// memory rather than a register, so it is left unnamed.
+func (cpu *CPU8008) RegisterNames() []string {
+	names := make([]string, len(cpu.Registers))
+	for i := range names {
+		if i != REG_M {
+			names[i] = cpu.GetRegName(i)
+		}
+	}
+	return names
+}
+
 func (cpu *CPU8008) GetReg(register int) (byte, error) {
 	if register < 0 || register >= len(cpu.Registers) {
 		return 0, &cpusim.ErrInvalidRegister{Device: cpu, Register: register}
@@ -396,6 +426,7 @@ func (cpu *CPU8008) ExecuteJump(addr uint16, conditional bool, flag int, istrue
 			cpu.DebugJump(conditional, flag, istrue, iscall, addr)
 			return nil // Jump not taken
 		}
+		cpu.Cycles += statesTaken
 	}
 
 	if iscall {
@@ -419,6 +450,7 @@ func (cpu *CPU8008) ExecuteRet(conditional bool, flag int, istrue bool) error {
 			cpu.DebugRet(conditional, flag, istrue)
 			return nil // Return not taken
 		}
+		cpu.Cycles += statesTaken
 	}
 
 	if cpu.SP == 0 {
@@ -474,8 +506,74 @@ func (cpu *CPU8008) Halt() {
 	cpu.Halted.Store(true)
 }
 
+func (cpu *CPU8008) IsHalted() bool {
+	return cpu.Halted.Load()
+}
+
+func (cpu *CPU8008) ClearHalt() {
+	cpu.Halted.Store(false)
+}
+
+// ResetDevice resets the CPU for CpuSim.Reset. The 8008 has no reset
+// input, so this is what a board's power-on circuit does: the CPU starts
+// again at 0 with an empty stack. A cold reset also clears the registers.
+func (cpu *CPU8008) ResetDevice(kind cpusim.ResetKind) {
+	cpu.PC = 0
+	cpu.SP = 0
+	cpu.Stack = [8]uint16{}
+	cpu.Stopped = false
+	cpu.intPending.Store(false)
+	if kind == cpusim.ResetCold {
+		cpu.Registers = [12]byte{}
+	}
+}
+
+// ConnectSignal connects a control line to the CPU. The 8008 has a single
+// INTERRUPT input, which is edge triggered: each assertion causes one
+// instruction to be jammed from the data bus. It also restarts a stopped CPU.
+func (cpu *CPU8008) ConnectSignal(name string, line *cpusim.SignalLine) error {
+	if name != cpusim.SIGNAL_INT {
+		return &cpusim.ErrNotImplemented{Device: cpu, What: "signal " + name}
+	}
+	cpu.intConnected = true
+	line.OnChange(func(asserted bool) {
+		if asserted {
+			cpu.Interrupt()
+		}
+	})
+	return nil
+}
+
+// Interrupt latches an interrupt request, which is acknowledged at the next
+// instruction boundary.
+func (cpu *CPU8008) Interrupt() {
+	cpu.intPending.Store(true)
+}
+
 func (cpu *CPU8008) Execute() error {
+	if cpu.intPending.Load() {
+		cpu.intPending.Store(false)
+		cpu.Stopped = false
+		opCode := byte(0x05) // RST 0
+		if cpu.IntAck != nil {
+			opCode = cpu.IntAck()
+		}
+		if cpu.Sim.Debug {
+			fmt.Printf("%04X: <INT> [%02X %08b] %s ", cpu.PC, opCode, opCode, cpu.String())
+		}
+		// The PC is not advanced during the interrupt cycle, so an RST pushes
+		// the address of the instruction that was interrupted.
+		return cpu.ExecuteOpcode(opCode)
+	}
+
+	if cpu.Stopped {
+		// The clock keeps running while the CPU is stopped
+		cpu.Cycles++
+		return nil
+	}
+
 	if cpu.Sim.Debug {
+		cpu.Sim.DebugLabel(cpusim.Address(cpu.PC))
 		fmt.Printf("%04X: ", cpu.PC)
 	}
 
@@ -489,10 +587,22 @@ func (cpu *CPU8008) Execute() error {
 		fmt.Printf("%s ", cpu.String())
 	}
 
+	return cpu.ExecuteOpcode(opCode)
+}
+
+// ExecuteOpcode executes an opcode that has already been fetched. Operands, if
+// any, are fetched from memory at the PC.
+func (cpu *CPU8008) ExecuteOpcode(opCode byte) error {
+	cpu.Cycles += opcodeStates(opCode)
+
 	if opCode == 0xFF || opCode == 0x00 || opCode == 0x01 {
 		// make sure to check HALT before other operations because
 		// it overlaps some other opcodes
-		cpu.Halted.Store(true)
+		if cpu.intConnected {
+			cpu.Stopped = true
+		} else {
+			cpu.Halted.Store(true)
+		}
 		cpu.DebugInstr("HALT")
 		return nil
 	}
@@ -568,6 +678,11 @@ func (cpu *CPU8008) Execute() error {
 	return &cpusim.ErrInvalidOpcode{Device: cpu, Opcode: opCode}
 }
 
+// ClockCycles returns the number of clock cycles executed.
+func (cpu *CPU8008) ClockCycles() uint64 {
+	return cpu.Cycles * ClocksPerState
+}
+
 func (cpu *CPU8008) Run() error {
 	cpu.Halted.Store(false)
 	for {
@@ -579,10 +694,14 @@ func (cpu *CPU8008) Run() error {
 			fmt.Println("CPU halted")
 			return nil
 		}
+		start := cpu.Cycles
 		if err := cpu.Execute(); err != nil {
 			return err
 		}
-		cpu.Sim.Throttle.Tick()
+		cpu.Sim.Throttle.Tick((cpu.Cycles - start) * ClocksPerState)
+		if err := cpu.Sim.AfterStep(); err != nil {
+			return err
+		}
 	}
 	// never reached
 }
@@ -0,0 +1,185 @@
+package cpu8008
+
+import (
+	"fmt"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+)
+
+// Disassembler decodes 8008 instructions without executing them, in either
+// the new (8080-like) mnemonics or the original Intel ones. Opcodes the CPU
+// rejects are shown as DB.
+//
+// Numbers are in hex, in the "0FFh" style. If Symbols is set, jump and call
+// targets are replaced by their names.
+type Disassembler struct {
+	NewStyle bool
+	Symbols  cpusim.SymbolLookup
+}
+
+func NewDisassembler(newStyle bool, symbols cpusim.SymbolLookup) *Disassembler {
+	return &Disassembler{NewStyle: newStyle, Symbols: symbols}
+}
+
+// Disassemble decodes the instruction at address in the CPU's memory, in the
+// CPU's mnemonic style.
+func (cpu *CPU8008) Disassemble(address cpusim.Address) cpusim.Instruction {
+	return NewDisassembler(cpu.NewStyle, cpu.Sim.Symbols).Disassemble(cpu.Sim.PeekMemory, address)
+}
+
+var (
+	disRegs      = "ABCDEHLM"
+	disALUNew    = [8]string{"ADD", "ADC", "SUB", "SBB", "ANA", "XRA", "ORA", "CMP"}
+	disALUOld    = [8]string{"AD", "AC", "SU", "SB", "ND", "XR", "OR", "CP"}
+	disALUIntNew = [8]string{"ADI", "ACI", "SUI", "SBI", "ANI", "XRI", "ORI", "CPI"}
+	disALUIntOld = [8]string{"ADI", "ACI", "SUI", "SBI", "NDI", "XRI", "ORI", "CPI"}
+	disRotate    = [4]string{"RLC", "RRC", "RAL", "RAR"}
+	disCondFalse = [4]string{"NC", "NZ", "P", "PO"} // new style, condition false
+	disCondTrue  = [4]string{"C", "Z", "M", "PE"}   // new style, condition true
+)
+
+// Disassemble decodes the instruction at address. The decoding follows
+// ExecuteOpcode, including the order in which overlapping opcodes are
+// matched.
+func (d *Disassembler) Disassemble(read cpusim.ReadFunc, address cpusim.Address) cpusim.Instruction {
+	ins := cpusim.Instruction{Address: address}
+	pc := address
+	fetch := func() byte {
+		b := read(pc)
+		ins.Bytes = append(ins.Bytes, b)
+		pc++
+		return b
+	}
+	set := func(mnemonic, operands string) {
+		ins.Mnemonic = mnemonic
+		ins.Operands = operands
+	}
+	target := func() string {
+		lo := fetch()
+		hi := fetch()
+		a := (cpusim.Address(hi)<<8 | cpusim.Address(lo)) & 0x3FFF
+		ins.Target = a
+		ins.HasTarget = true
+		if d.Symbols != nil {
+			if name, ok := d.Symbols.LookupSymbol(a); ok {
+				return name
+			}
+		}
+		return hexNumber(int(a), 4)
+	}
+
+	op := fetch()
+	dst := int(op>>3) & 7
+	src := int(op) & 7
+	flag := dst & 3
+	isTrue := op&0x20 != 0
+
+	switch {
+	case op == 0xFF || op == 0x00 || op == 0x01:
+		set("HLT", "")
+	case op&0xC0 == 0xC0:
+		if d.NewStyle {
+			set("MOV", fmt.Sprintf("%c,%c", disRegs[dst], disRegs[src]))
+		} else {
+			set(fmt.Sprintf("L%c%c", disRegs[dst], disRegs[src]), "")
+		}
+	case op&0xC7 == 0x06:
+		value := hexNumber(int(fetch()), 2)
+		if d.NewStyle {
+			set("MVI", fmt.Sprintf("%c,%s", disRegs[dst], value))
+		} else {
+			set(fmt.Sprintf("L%cI", disRegs[dst]), value)
+		}
+	case op&0xC6 == 0:
+		switch {
+		case dst == REG_A || dst == REG_M:
+			// the CPU rejects INR and DCR of A and M
+			set("DB", hexNumber(int(op), 2))
+		case d.NewStyle && op&1 == 0:
+			set("INR", string(disRegs[dst]))
+		case d.NewStyle:
+			set("DCR", string(disRegs[dst]))
+		case op&1 == 0:
+			set(fmt.Sprintf("IN%c", disRegs[dst]), "")
+		default:
+			set(fmt.Sprintf("DC%c", disRegs[dst]), "")
+		}
+	case op&0xC0 == 0x80:
+		if d.NewStyle {
+			set(disALUNew[dst], string(disRegs[src]))
+		} else {
+			set(fmt.Sprintf("%s%c", disALUOld[dst], disRegs[src]), "")
+		}
+	case op&0xC7 == 0x04:
+		value := hexNumber(int(fetch()), 2)
+		if d.NewStyle {
+			set(disALUIntNew[dst], value)
+		} else {
+			set(disALUIntOld[dst], value)
+		}
+	case op&0xE7 == 0x02:
+		set(disRotate[dst], "")
+	case op&0xC7 == 0x44:
+		set("JMP", target())
+	case op&0xC7 == 0x40:
+		set(d.conditional("J", flag, isTrue), target())
+	case op&0xC7 == 0x46:
+		if d.NewStyle {
+			set("CALL", target())
+		} else {
+			set("CAL", target())
+		}
+		ins.Flow = cpusim.FlowCall
+	case op&0xC7 == 0x42:
+		set(d.conditional("C", flag, isTrue), target())
+		ins.Flow = cpusim.FlowCall
+	case op&0xC7 == 0x07:
+		set("RET", "")
+		ins.Flow = cpusim.FlowReturn
+	case op&0xC7 == 0x03:
+		set(d.conditional("R", flag, isTrue), "")
+		ins.Flow = cpusim.FlowReturn
+	case op&0xC7 == 0x05:
+		set("RST", fmt.Sprintf("%d", dst))
+		ins.Target = cpusim.Address(dst) << 3
+		ins.HasTarget = true
+		ins.Flow = cpusim.FlowCall
+	case op&0xC1 == 0x41:
+		port := int(op>>1) & 0x1F
+		switch {
+		case port&0x18 != 0:
+			set("OUT", hexNumber(port, 2))
+		case d.NewStyle:
+			set("IN", hexNumber(port, 2))
+		default:
+			set("INP", hexNumber(port, 2))
+		}
+	default:
+		set("DB", hexNumber(int(op), 2))
+	}
+	return ins
+}
+
+// conditional names a conditional jump, call or return, e.g. JNZ or JFZ.
+func (d *Disassembler) conditional(prefix string, flag int, isTrue bool) string {
+	switch {
+	case !d.NewStyle && isTrue:
+		return prefix + "T" + FlagStr(FLAG_CARRY+flag)
+	case !d.NewStyle:
+		return prefix + "F" + FlagStr(FLAG_CARRY+flag)
+	case isTrue:
+		return prefix + disCondTrue[flag]
+	default:
+		return prefix + disCondFalse[flag]
+	}
+}
+
+// hexNumber formats a number in assembler hex, with a leading zero when
+// the first digit is a letter.
+func hexNumber(value int, digits int) string {
+	text := fmt.Sprintf("%0*Xh", digits, value)
+	if text[0] > '9' {
+		text = "0" + text
+	}
+	return text
+}
@@ -0,0 +1,114 @@
+package cpu8008
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+)
+
+var listingLine = regexp.MustCompile(`^\s*\d+/\s*([0-9A-F]+) : ((?:[0-9A-F]{2} )+)\s+(\w+)`)
+
+// TestDisassembleListings disassembles the code in the assembler listings
+// of the test programs and checks it against the source.
+func (s *Cpu8008Suite) TestDisassembleListings() {
+	listings, err := filepath.Glob(filepath.Join(s.testBinDir, "*.lst"))
+	s.Require().NoError(err)
+	s.Require().NotEmpty(listings)
+
+	dis := NewDisassembler(true, nil)
+	for _, listing := range listings {
+		f, err := os.Open(listing)
+		s.Require().NoError(err)
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			m := listingLine.FindStringSubmatch(scanner.Text())
+			if m == nil {
+				continue
+			}
+			address, err := strconv.ParseUint(m[1], 16, 16)
+			s.Require().NoError(err)
+			var code []byte
+			for _, field := range strings.Fields(m[2]) {
+				b, _ := strconv.ParseUint(field, 16, 8)
+				code = append(code, byte(b))
+			}
+
+			ins := dis.Disassemble(cpusim.ImageReader(code, cpusim.Address(address)), cpusim.Address(address))
+			s.Equal(strings.ToUpper(m[3]), ins.Mnemonic, "%s: %s", listing, scanner.Text())
+			s.Equal(code, ins.Bytes, "%s: %s", listing, scanner.Text())
+		}
+		s.Require().NoError(f.Close())
+	}
+}
+
+func (s *Cpu8008Suite) TestDisassembleStyles() {
+	tests := []struct {
+		code     []byte
+		new, old string
+	}{
+		{[]byte{0xC8}, "MOV B,A", "LBA"},
+		{[]byte{0x3E, 0xFF}, "MVI M,0FFh", "LMI 0FFh"},
+		{[]byte{0x10}, "INR C", "INC"},
+		{[]byte{0x21}, "DCR E", "DCE"},
+		{[]byte{0xA7}, "ANA M", "NDM"},
+		{[]byte{0x24, 0x0F}, "ANI 0Fh", "NDI 0Fh"},
+		{[]byte{0x68, 0x34, 0x12}, "JZ 1234h", "JTZ 1234h"},
+		{[]byte{0x52, 0x00, 0x20}, "CP 2000h", "CFS 2000h"},
+		{[]byte{0x46, 0x00, 0x20}, "CALL 2000h", "CAL 2000h"},
+		{[]byte{0x1B}, "RPO", "RFP"},
+		{[]byte{0x0D}, "RST 1", "RST 1"},
+		{[]byte{0x41}, "IN 00h", "INP 00h"},
+		{[]byte{0x51}, "OUT 08h", "OUT 08h"},
+		{[]byte{0x38}, "DB 38h", "DB 38h"},
+	}
+	for _, tt := range tests {
+		read := func(a cpusim.Address) byte {
+			return tt.code[a-0x100]
+		}
+		s.Equal(tt.new, NewDisassembler(true, nil).Disassemble(read, 0x100).Text())
+		s.Equal(tt.old, NewDisassembler(false, nil).Disassemble(read, 0x100).Text())
+	}
+}
+
+// TestDisassembleExecution checks the disassembler against the CPU: each
+// opcode shown as DB must be rejected, and every other instruction that
+// doesn't transfer control must advance the PC by its length.
+func (s *Cpu8008Suite) TestDisassembleExecution() {
+	flow := map[string]bool{"HLT": true, "JMP": true, "CALL": true, "RET": true, "RST": true}
+	for op := 0; op < 256; op++ {
+		copy(s.ram.Contents[0x100:], []byte{byte(op), 0x10, 0x00})
+		s.cpu.PC = 0x100
+		ins := s.cpu.Disassemble(0x100)
+		if flow[ins.Mnemonic] || ins.HasTarget || op&0xC7 == 0x03 {
+			continue
+		}
+		err := s.cpu.Execute()
+		if ins.Mnemonic == "DB" {
+			s.Error(err, "%02X", op)
+			continue
+		}
+		s.NoError(err, "%02X: %s", op, ins.Text())
+		s.Equal(0x100+ins.Len(), int(s.cpu.PC), "%02X: %s", op, ins.Text())
+	}
+}
+
+// TestListingSymbols loads the labels from a test program's listing and
+// checks that the disassembler uses them.
+func (s *Cpu8008Suite) TestListingSymbols() {
+	table := cpusim.NewSymbolTable(nil)
+	s.Require().NoError(table.Load(filepath.Join(s.testBinDir, "TestCall.lst"), cpusim.NoBank))
+	s.Equal(7, table.Len(), "only the labels L1-L7 are addresses")
+
+	address, ok := table.Resolve("L1")
+	s.Require().True(ok)
+	s.Equal(cpusim.Address(0x0008), address)
+
+	read := cpusim.ImageReader([]byte{0x46, 0x08, 0x00}, 4)
+	s.Equal("CALL L1", NewDisassembler(true, table).Disassemble(read, 4).Text())
+	s.Equal("L1+2", table.Describe(0x000A))
+}
@@ -0,0 +1,39 @@
+package cpu8008
+
+import "github.com/scottmbaker/gocpusim/pkg/cpusim"
+
+// RemoteArchitecture is empty, as GDB has no support for the 8008.
+func (cpu *CPU8008) RemoteArchitecture() string {
+	return ""
+}
+
+// RemoteRegisters returns the registers A to L, the four flags, PC and the
+// stack pointer.
+func (cpu *CPU8008) RemoteRegisters() []cpusim.RemoteRegister {
+	var regs []cpusim.RemoteRegister
+	for i := range cpu.Registers {
+		if i == REG_M {
+			continue
+		}
+		regs = append(regs, cpusim.RemoteRegister{
+			Name: cpu.GetRegName(i),
+			Bits: 8,
+			Get:  func() uint16 { return uint16(cpu.Registers[i]) },
+			Set:  func(value uint16) { cpu.Registers[i] = byte(value) },
+		})
+	}
+	return append(regs,
+		cpusim.RemoteRegister{
+			Name: "PC",
+			Bits: 16,
+			Get:  func() uint16 { return cpu.PC },
+			Set:  func(value uint16) { cpu.PC = value },
+		},
+		cpusim.RemoteRegister{
+			Name: "SP",
+			Bits: 8,
+			Get:  func() uint16 { return uint16(cpu.SP) },
+			Set:  func(value uint16) { cpu.SP = byte(value) },
+		},
+	)
+}
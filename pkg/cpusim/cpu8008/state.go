@@ -0,0 +1,42 @@
+package cpu8008
+
+import (
+	"encoding/json"
+)
+
+type cpu8008State struct {
+	Registers  [12]byte
+	Stack      [8]uint16
+	SP         byte
+	PC         uint16
+	Stopped    bool
+	Cycles     uint64
+	IntPending bool
+}
+
+func (cpu *CPU8008) SaveState() (any, error) {
+	return &cpu8008State{
+		Registers:  cpu.Registers,
+		Stack:      cpu.Stack,
+		SP:         cpu.SP,
+		PC:         cpu.PC,
+		Stopped:    cpu.Stopped,
+		Cycles:     cpu.Cycles,
+		IntPending: cpu.intPending.Load(),
+	}, nil
+}
+
+func (cpu *CPU8008) RestoreState(data json.RawMessage) error {
+	var s cpu8008State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	cpu.Registers = s.Registers
+	cpu.Stack = s.Stack
+	cpu.SP = s.SP
+	cpu.PC = s.PC
+	cpu.Stopped = s.Stopped
+	cpu.Cycles = s.Cycles
+	cpu.intPending.Store(s.IntPending)
+	return nil
+}
@@ -0,0 +1,59 @@
+package cpu8008
+
+// ClocksPerState is the number of clock periods in one 8008 T-state. The
+// two-phase clock runs at 500kHz, so a state takes 4us.
+const ClocksPerState = 2
+
+// statesTaken is the extra time taken by a conditional jump, call or return
+// when the condition is true.
+const statesTaken = 2
+
+// opcodeStates returns the number of T-states taken by an opcode, assuming
+// that a conditional jump, call or return is not taken. The decoding follows
+// the same order as ExecuteOpcode, since some of the patterns overlap.
+func opcodeStates(opCode byte) uint64 {
+	switch {
+	case opCode == 0xFF || opCode == 0x00 || opCode == 0x01: // HLT
+		return 4
+	case opCode&0xC0 == 0xC0: // Lr1r2, LrM, LMr
+		if opCode&0x07 == REG_M {
+			return 8
+		}
+		if opCode&0x38 == REG_M<<3 {
+			return 7
+		}
+		return 5
+	case opCode&0xC7 == 0x06: // LrI, LMI
+		if opCode&0x38 == REG_M<<3 {
+			return 9
+		}
+		return 8
+	case opCode&0xC7 == 0x00, opCode&0xC7 == 0x01: // INr, DCr
+		return 5
+	case opCode&0xC0 == 0x80: // ALU r, ALU M
+		if opCode&0x07 == REG_M {
+			return 8
+		}
+		return 5
+	case opCode&0xC7 == 0x04: // ALU I
+		return 8
+	case opCode&0xE7 == 0x02: // RLC, RRC, RAL, RAR
+		return 5
+	case opCode&0xC7 == 0x44, opCode&0xC7 == 0x46: // JMP, CAL
+		return 11
+	case opCode&0xC7 == 0x40, opCode&0xC7 == 0x42: // JFc, JTc, CFc, CTc
+		return 9
+	case opCode&0xC7 == 0x07: // RET
+		return 5
+	case opCode&0xC7 == 0x03: // RFc, RTc
+		return 3
+	case opCode&0xC7 == 0x05: // RST
+		return 5
+	case opCode&0xC1 == 0x41: // INP, OUT
+		if opCode&0x30 == 0 {
+			return 8
+		}
+		return 6
+	}
+	return 0
+}
@@ -0,0 +1,58 @@
+package cpuz80
+
+import (
+	"testing"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+)
+
+const nostosROM = "../../../roms/z80/nostos512k.rom"
+
+// newRC2014 builds the 512K RC2014 configuration used by cpusim-z80-rc2014,
+// with the ACIA console.
+func newRC2014(b *testing.B) *CPUZ80 {
+	b.Helper()
+
+	sim := cpusim.NewCPUSim()
+	sim.SetDebug(false)
+
+	cpu := NewZ80(sim, "cpu")
+	cpu.PortAddressMask = 0xFF
+	sim.AddCPU(cpu)
+
+	mapEnable := cpusim.NewEnableBit()
+	ramRomEnable := cpusim.NewEnableBit()
+	mapper := cpusim.NewDual74670(sim, "mapper-lo", 0x78, cpusim.A14, cpusim.D0, cpusim.A14, cpusim.A15, cpusim.A16, cpusim.A17, cpusim.A18, -1, -1, -1, &cpusim.AlwaysEnabled, &mapEnable.HiEnable)
+	mapper.ConnectEnableBit(5, ramRomEnable)
+	sim.AddMapper(mapper)
+	sim.AddPort(mapper)
+
+	latch := cpusim.NewGenericOutputPort(sim, "mapper-enable-latch", 0x7C, 0, &cpusim.AlwaysEnabled)
+	latch.ConnectEnableBit(0, mapEnable)
+	sim.AddPort(latch)
+
+	ram := cpusim.NewMemory(sim, "ram", cpusim.KIND_RAM, 0x0000, 0x7FFFF, 19, false, &ramRomEnable.HiEnable)
+	sim.AddMemory(ram)
+	rom := cpusim.NewMemory(sim, "rom", cpusim.KIND_ROM, 0x0000, 0x7FFFF, 19, true, &ramRomEnable.LoEnable)
+	sim.AddMemory(rom)
+
+	sim.AddPort(cpusim.NewSp0SpeechDevice(sim, "sp0256", 0x20, &cpusim.AlwaysEnabled))
+	sim.AddPort(cpusim.NewACIA(sim, cpusim.NewChannelSerial(), "uart", 0x81, 0x80, &cpusim.AlwaysEnabled))
+
+	if err := rom.Load(nostosROM); err != nil {
+		b.Skipf("nostos ROM not available: %v", err)
+	}
+	return cpu
+}
+
+// BenchmarkNostosBoot measures the time per instruction while booting the
+// nostos ROM on the RC2014 configuration.
+func BenchmarkNostosBoot(b *testing.B) {
+	cpu := newRC2014(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := cpu.Execute(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
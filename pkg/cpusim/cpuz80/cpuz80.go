@@ -88,11 +88,35 @@ type CPUZ80 struct {
 	IFF1, IFF2 bool
 	IM         byte
 	EIPending  bool // EI delays one instruction
+	InHalt     bool // HALT executed, idling until an interrupt
+
+	// IntAck is called during the interrupt acknowledge cycle to read the byte
+	// that the interrupting device places on the data bus. If nil, the bus
+	// floats high and reads as 0xFF (RST 38h in IM 0).
+	IntAck func() byte
+
+	// RETIHook is called when RETI (ED 4D) is executed, so that daisy-chained
+	// peripherals snooping the bus can clear their in-service state.
+	RETIHook func()
+
+	intLine      atomic.Bool // level of the /INT input
+	nmiPending   atomic.Bool // latched falling edge of the /NMI input
+	nmiConnected bool        // a signal line drives /NMI, so DI/HALT can be woken
+	resetPending atomic.Bool // latched /RESET pulse
+	resetLine    *cpusim.SignalLine
+	waitLine     *cpusim.SignalLine
+	busrqLine    *cpusim.SignalLine
+	busakLine    *cpusim.SignalLine
+	busGranted   bool
+	ackFetch     bool // executing an IM 0 instruction, whose bytes come from acknowledge cycles
 
 	// Internal
 	Halted atomic.Bool
 	WZ     uint16 // Internal MEMPTR register
 
+	// Cycles is the running count of T-states executed
+	Cycles uint64
+
 	// Q flag tracking for undocumented SCF/CCF behavior
 	Q     byte
 	PrevQ byte
@@ -131,6 +155,46 @@ func (cpu *CPUZ80) Halt() {
 	cpu.Halted.Store(true)
 }
 
+func (cpu *CPUZ80) IsHalted() bool {
+	return cpu.Halted.Load()
+}
+
+func (cpu *CPUZ80) ClearHalt() {
+	cpu.Halted.Store(false)
+}
+
+// Reset puts the CPU into its power-on state. Only the registers that the
+// Z80 actually initializes are changed; the rest keep their values, as on
+// real hardware, except AF and SP which read back as FFFF after reset.
+func (cpu *CPUZ80) Reset() {
+	cpu.PC = 0
+	cpu.I = 0
+	cpu.R = 0
+	cpu.IFF1 = false
+	cpu.IFF2 = false
+	cpu.IM = 0
+	cpu.EIPending = false
+	cpu.InHalt = false
+	cpu.setAF(0xFFFF)
+	cpu.SP = 0xFFFF
+	cpu.WZ = 0
+	cpu.Q = 0
+	cpu.PrevQ = 0
+}
+
+// ResetDevice resets the CPU for CpuSim.Reset. A cold reset also clears the
+// registers that Reset leaves alone.
+func (cpu *CPUZ80) ResetDevice(kind cpusim.ResetKind) {
+	cpu.Reset()
+	cpu.resetPending.Store(false)
+	cpu.nmiPending.Store(false)
+	if kind == cpusim.ResetCold {
+		cpu.B, cpu.C, cpu.D, cpu.E, cpu.H, cpu.L = 0, 0, 0, 0, 0, 0
+		cpu.AF_, cpu.BC_, cpu.DE_, cpu.HL_ = 0, 0, 0, 0
+		cpu.IX, cpu.IY = 0, 0
+	}
+}
+
 func (cpu *CPUZ80) SetReg(register int, value byte) error {
 	switch register {
 	case RegA:
@@ -149,6 +213,22 @@ func (cpu *CPUZ80) SetReg(register int, value byte) error {
 		cpu.H = value
 	case RegL:
 		cpu.L = value
+	case RegIXH:
+		cpu.IX = cpu.IX&0x00FF | uint16(value)<<8
+	case RegIXL:
+		cpu.IX = cpu.IX&0xFF00 | uint16(value)
+	case RegIYH:
+		cpu.IY = cpu.IY&0x00FF | uint16(value)<<8
+	case RegIYL:
+		cpu.IY = cpu.IY&0xFF00 | uint16(value)
+	case RegSPH:
+		cpu.SP = cpu.SP&0x00FF | uint16(value)<<8
+	case RegSPL:
+		cpu.SP = cpu.SP&0xFF00 | uint16(value)
+	case RegPCH:
+		cpu.PC = cpu.PC&0x00FF | uint16(value)<<8
+	case RegPCL:
+		cpu.PC = cpu.PC&0xFF00 | uint16(value)
 	case RegI:
 		cpu.I = value
 	case RegR:
@@ -183,6 +263,22 @@ func (cpu *CPUZ80) GetReg(register int) (byte, error) {
 		return cpu.H, nil
 	case RegL:
 		return cpu.L, nil
+	case RegIXH:
+		return byte(cpu.IX >> 8), nil
+	case RegIXL:
+		return byte(cpu.IX), nil
+	case RegIYH:
+		return byte(cpu.IY >> 8), nil
+	case RegIYL:
+		return byte(cpu.IY), nil
+	case RegSPH:
+		return byte(cpu.SP >> 8), nil
+	case RegSPL:
+		return byte(cpu.SP), nil
+	case RegPCH:
+		return byte(cpu.PC >> 8), nil
+	case RegPCL:
+		return byte(cpu.PC), nil
 	case RegI:
 		return cpu.I, nil
 	case RegR:
@@ -204,11 +300,35 @@ func (cpu *CPUZ80) GetReg(register int) (byte, error) {
 	}
 }
 
+func (cpu *CPUZ80) GetPC() cpusim.Address {
+	return cpusim.Address(cpu.PC)
+}
+
+func (cpu *CPUZ80) SetPC(pc cpusim.Address) {
+	cpu.PC = uint16(pc)
+}
+
+// RegisterNames names the registers accepted by GetReg and SetReg. The
+// alternate register set is not byte addressable and is left unnamed.
+func (cpu *CPUZ80) RegisterNames() []string {
+	return []string{
+		RegA: "A", RegF: "F", RegB: "B", RegC: "C", RegD: "D", RegE: "E", RegH: "H", RegL: "L",
+		RegIXH: "IXH", RegIXL: "IXL", RegIYH: "IYH", RegIYL: "IYL", RegSPH: "SPH", RegSPL: "SPL",
+		RegI: "I", RegR: "R", RegIM: "IM", RegIFF1: "IFF1", RegIFF2: "IFF2", RegPCH: "PCH", RegPCL: "PCL",
+	}
+}
+
 func (cpu *CPUZ80) String() string {
 	return fmt.Sprintf("A=%02X F=%02X B=%02X C=%02X D=%02X E=%02X H=%02X L=%02X SP=%04X PC=%04X IX=%04X IY=%04X I=%02X R=%02X",
 		cpu.A, cpu.F, cpu.B, cpu.C, cpu.D, cpu.E, cpu.H, cpu.L, cpu.SP, cpu.PC, cpu.IX, cpu.IY, cpu.I, cpu.R)
 }
 
+// ClockCycles returns the number of clock cycles executed. On the Z80 each
+// T-state is one clock cycle.
+func (cpu *CPUZ80) ClockCycles() uint64 {
+	return cpu.Cycles
+}
+
 func (cpu *CPUZ80) Run() error {
 	cpu.Halted.Store(false)
 	for {
@@ -220,10 +340,14 @@ func (cpu *CPUZ80) Run() error {
 			fmt.Println("CPU halted")
 			return nil
 		}
+		start := cpu.Cycles
 		if err := cpu.Execute(); err != nil {
 			return err
 		}
-		cpu.Sim.Throttle.Tick()
+		cpu.Sim.Throttle.Tick(cpu.Cycles - start)
+		if err := cpu.Sim.AfterStep(); err != nil {
+			return err
+		}
 	}
 }
 
@@ -257,6 +381,9 @@ func (cpu *CPUZ80) writePort(addr uint16, val byte) {
 }
 
 func (cpu *CPUZ80) fetchByte() byte {
+	if cpu.ackFetch {
+		return cpu.interruptAck()
+	}
 	val := cpu.readByte(cpu.PC)
 	cpu.PC++
 	return val
@@ -477,15 +604,40 @@ func (cpu *CPUZ80) incR() {
 }
 
 func (cpu *CPUZ80) Execute() error {
+	if cpu.resetPending.Load() || (cpu.resetLine != nil && cpu.resetLine.Asserted()) {
+		cpu.resetPending.Store(false)
+		cpu.Reset()
+		return nil
+	}
+
+	if cpu.busHeld() {
+		// The clock keeps running while the CPU is stalled
+		cpu.Cycles++
+		return nil
+	}
+
 	cpu.PrevQ = cpu.Q
 	cpu.Q = 0
 
+	if accepted, err := cpu.acceptInterrupt(); accepted || err != nil {
+		return err
+	}
+
+	if cpu.InHalt {
+		// HALT keeps executing NOPs until an interrupt is accepted
+		cpu.incR()
+		cpu.Cycles += cyclesHaltNOP
+		return nil
+	}
+
 	cpu.incR()
 
 	opcode := cpu.fetchByte()
 
 	if cpu.Sim.Debug {
-		fmt.Printf("%04X: [%02X] %s\n", cpu.PC-1, opcode, cpu.String())
+		cpu.Sim.DebugLabel(cpusim.Address(cpu.PC - 1))
+		ins := cpu.Disassemble(cpusim.Address(cpu.PC - 1))
+		fmt.Printf("%04X: %-11s  %-18s %s\n", cpu.PC-1, ins.Hex(), ins.Text(), cpu.String())
 	}
 
 	// Clear EI pending before execution; the EI instruction sets it fresh
@@ -493,5 +645,6 @@ func (cpu *CPUZ80) Execute() error {
 		cpu.EIPending = false
 	}
 
+	cpu.Cycles += uint64(cyclesMain[opcode])
 	return cpu.executeUnprefixed(opcode)
 }
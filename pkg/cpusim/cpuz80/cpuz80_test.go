@@ -111,10 +111,11 @@ func (p *PortOp) UnmarshalJSON(data []byte) error {
 }
 
 type TestCase struct {
-	Name    string    `json:"name"`
-	Initial TestState `json:"initial"`
-	Final   TestState `json:"final"`
-	Ports   []PortOp  `json:"ports"`
+	Name    string            `json:"name"`
+	Initial TestState         `json:"initial"`
+	Final   TestState         `json:"final"`
+	Ports   []PortOp          `json:"ports"`
+	Cycles  []json.RawMessage `json:"cycles"` // one entry per T-state
 }
 
 func setupCPU(tc *TestCase) (*CPUZ80, *cpusim.CpuSim, *cpusim.Memory, *TestPort) {
@@ -280,6 +281,7 @@ func runTestFile(t *testing.T, filename string) {
 				t.Fatalf("Execute error: %v", err)
 			}
 			compareCPU(t, &tc, cpu, ram, port)
+			assert.Equal(t, uint64(len(tc.Cycles)), cpu.Cycles, "T-states")
 		}) {
 			if i > 5 {
 				t.Fatalf("Too many failures, stopping after %d", i)
@@ -0,0 +1,453 @@
+package cpuz80
+
+import (
+	"fmt"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+)
+
+// Disassembler decodes Z80 instructions without executing them. It covers
+// the CB, DD, ED, FD, DDCB and FDCB prefixes and the undocumented forms that
+// the CPU executes: IXH/IXL/IYH/IYL operands, SLL, the DDCB/FDCB forms that
+// also store their result in a register, and the ED aliases of NEG, RETN,
+// RETI and IM. A DD or FD prefix in front of an instruction that doesn't use
+// HL is ignored, as it is by the CPU, and the instruction is decoded as if
+// it were unprefixed. Invalid ED opcodes, which execute as two-byte NOPs, are
+// shown as DB.
+//
+// Numbers are in hex, in the "0FFH" style. If Symbols is set, jump and call
+// targets and (nn) memory operands are replaced by their names.
+type Disassembler struct {
+	Symbols cpusim.SymbolLookup
+}
+
+func NewDisassembler(symbols cpusim.SymbolLookup) *Disassembler {
+	return &Disassembler{Symbols: symbols}
+}
+
+// Disassemble decodes the instruction at address.
+func (d *Disassembler) Disassemble(read cpusim.ReadFunc, address cpusim.Address) cpusim.Instruction {
+	s := &decoder{dis: d, read: read, pc: uint16(address)}
+	s.ins.Address = address
+	s.decode()
+	s.ins.Bytes = s.bytes
+	return s.ins
+}
+
+// Disassemble decodes the instruction at address in the CPU's memory, as
+// mapped at the moment.
+func (cpu *CPUZ80) Disassemble(address cpusim.Address) cpusim.Instruction {
+	return NewDisassembler(cpu.Sim.Symbols).Disassemble(cpu.Sim.PeekMemory, address)
+}
+
+var (
+	disReg    = [8]string{"B", "C", "D", "E", "H", "L", "(HL)", "A"}
+	disPair   = [4]string{"BC", "DE", "HL", "SP"}
+	disPair2  = [4]string{"BC", "DE", "HL", "AF"}
+	disCond   = [8]string{"NZ", "Z", "NC", "C", "PO", "PE", "P", "M"}
+	disALU    = [8]string{"ADD", "ADC", "SUB", "SBC", "AND", "XOR", "OR", "CP"}
+	disRotate = [8]string{"RLC", "RRC", "RL", "RR", "SLA", "SRA", "SLL", "SRL"}
+	disAccum  = [8]string{"RLCA", "RRCA", "RLA", "RRA", "DAA", "CPL", "SCF", "CCF"}
+	disIM     = [8]string{"0", "0/1", "1", "2", "0", "0/1", "1", "2"}
+	disBlock  = [4][4]string{{"LDI", "CPI", "INI", "OUTI"}, {"LDD", "CPD", "IND", "OUTD"}, {"LDIR", "CPIR", "INIR", "OTIR"}, {"LDDR", "CPDR", "INDR", "OTDR"}}
+	disEDMisc = [6][2]string{{"LD", "I,A"}, {"LD", "R,A"}, {"LD", "A,I"}, {"LD", "A,R"}, {"RRD", ""}, {"RLD", ""}}
+	disALUAcc = [8]bool{true, true, false, true, false, false, false, false} // ALU ops written with "A,"
+)
+
+// decoder holds the state of one instruction being decoded.
+type decoder struct {
+	dis   *Disassembler
+	read  cpusim.ReadFunc
+	pc    uint16
+	bytes []byte
+	ins   cpusim.Instruction
+
+	index   string // "IX" or "IY" while decoding a DD or FD instruction
+	indexed bool   // the instruction used the index register
+}
+
+func (s *decoder) fetch() byte {
+	b := s.read(cpusim.Address(s.pc))
+	s.bytes = append(s.bytes, b)
+	s.pc++
+	return b
+}
+
+func (s *decoder) fetchWord() uint16 {
+	lo := s.fetch()
+	hi := s.fetch()
+	return uint16(hi)<<8 | uint16(lo)
+}
+
+func (s *decoder) set(mnemonic string, operands string) {
+	s.ins.Mnemonic = mnemonic
+	s.ins.Operands = operands
+}
+
+// hexNumber formats a number in assembler hex, with a leading zero when
+// the first digit is a letter.
+func hexNumber(value uint16, digits int) string {
+	text := fmt.Sprintf("%0*XH", digits, value)
+	if text[0] > '9' {
+		text = "0" + text
+	}
+	return text
+}
+
+func (s *decoder) imm8() string {
+	return hexNumber(uint16(s.fetch()), 2)
+}
+
+func (s *decoder) imm16() string {
+	return hexNumber(s.fetchWord(), 4)
+}
+
+// address formats a 16-bit address, by name if there is a symbol for it.
+func (s *decoder) address(a uint16) string {
+	if s.dis.Symbols != nil {
+		if name, ok := s.dis.Symbols.LookupSymbol(cpusim.Address(a)); ok {
+			return name
+		}
+	}
+	return hexNumber(a, 4)
+}
+
+// target records the destination of a jump or call and formats it.
+func (s *decoder) target(a uint16) string {
+	s.ins.Target = cpusim.Address(a)
+	s.ins.HasTarget = true
+	return s.address(a)
+}
+
+func (s *decoder) relative() string {
+	d := int8(s.fetch())
+	return s.target(s.pc + uint16(d))
+}
+
+// reg names register r of an instruction. With an index prefix, H and L
+// are the halves of the index register and (HL) becomes (IX+d), reading the
+// displacement byte. plain leaves H and L alone, for instructions that also
+// use (IX+d).
+func (s *decoder) reg(r byte, plain bool) string {
+	if s.index == "" {
+		return disReg[r]
+	}
+	switch {
+	case r == 6:
+		s.indexed = true
+		return s.displacement()
+	case (r == 4 || r == 5) && !plain:
+		s.indexed = true
+		return s.index + disReg[r][:1]
+	}
+	return disReg[r]
+}
+
+func (s *decoder) displacement() string {
+	d := int8(s.fetch())
+	if d < 0 {
+		return fmt.Sprintf("(%s-%s)", s.index, hexNumber(uint16(-int(d)), 2))
+	}
+	return fmt.Sprintf("(%s+%s)", s.index, hexNumber(uint16(d), 2))
+}
+
+// hl names HL, or the index register with an index prefix.
+func (s *decoder) hl() string {
+	if s.index == "" {
+		return "HL"
+	}
+	s.indexed = true
+	return s.index
+}
+
+func (s *decoder) pair(p byte, table [4]string) string {
+	if p == 2 {
+		return s.hl()
+	}
+	return table[p]
+}
+
+func (s *decoder) decode() {
+	opcode := s.fetch()
+	switch opcode {
+	case 0xCB:
+		s.decodeCB()
+	case 0xED:
+		s.decodeED()
+	case 0xDD, 0xFD:
+		s.decodeIndexed(opcode)
+	default:
+		s.decodeUnprefixed(opcode)
+	}
+}
+
+// decodeIndexed decodes an instruction after a DD or FD prefix.
+func (s *decoder) decodeIndexed(prefix byte) {
+	s.index = "IX"
+	if prefix == 0xFD {
+		s.index = "IY"
+	}
+	start, n := s.pc, len(s.bytes)
+
+	opcode := s.fetch()
+	if opcode == 0xCB {
+		s.decodeIndexedCB()
+		return
+	}
+	if opcode != 0xDD && opcode != 0xFD && opcode != 0xED {
+		s.decodeUnprefixed(opcode)
+		if s.indexed {
+			return
+		}
+	}
+
+	// The prefix has no effect on this instruction; the CPU executes the
+	// rest as if it were unprefixed.
+	s.pc = start
+	s.bytes = s.bytes[:n]
+	s.index = ""
+	s.ins = cpusim.Instruction{Address: s.ins.Address}
+	s.decode()
+}
+
+func (s *decoder) decodeUnprefixed(opcode byte) {
+	x, y, z := opcode>>6, (opcode>>3)&7, opcode&7
+	p, q := y>>1, y&1
+
+	switch x {
+	case 0:
+		switch z {
+		case 0:
+			switch y {
+			case 0:
+				s.set("NOP", "")
+			case 1:
+				s.set("EX", "AF,AF'")
+			case 2:
+				s.set("DJNZ", s.relative())
+			case 3:
+				s.set("JR", s.relative())
+			default:
+				s.set("JR", disCond[y-4]+","+s.relative())
+			}
+		case 1:
+			if q == 0 {
+				dst := s.pair(p, disPair)
+				s.set("LD", dst+","+s.imm16())
+			} else {
+				s.set("ADD", s.hl()+","+s.pair(p, disPair))
+			}
+		case 2:
+			switch y {
+			case 0:
+				s.set("LD", "(BC),A")
+			case 1:
+				s.set("LD", "A,(BC)")
+			case 2:
+				s.set("LD", "(DE),A")
+			case 3:
+				s.set("LD", "A,(DE)")
+			case 4:
+				s.set("LD", "("+s.address(s.fetchWord())+"),"+s.hl())
+			case 5:
+				s.set("LD", s.hl()+",("+s.address(s.fetchWord())+")")
+			case 6:
+				s.set("LD", "("+s.address(s.fetchWord())+"),A")
+			case 7:
+				s.set("LD", "A,("+s.address(s.fetchWord())+")")
+			}
+		case 3:
+			if q == 0 {
+				s.set("INC", s.pair(p, disPair))
+			} else {
+				s.set("DEC", s.pair(p, disPair))
+			}
+		case 4:
+			s.set("INC", s.reg(y, false))
+		case 5:
+			s.set("DEC", s.reg(y, false))
+		case 6:
+			dst := s.reg(y, false)
+			s.set("LD", dst+","+s.imm8())
+		case 7:
+			s.set(disAccum[y], "")
+		}
+
+	case 1:
+		if y == 6 && z == 6 {
+			s.set("HALT", "")
+			return
+		}
+		// LD H,(IX+d) and LD (IX+d),L use the real H and L
+		memory := y == 6 || z == 6
+		dst := s.reg(y, memory)
+		s.set("LD", dst+","+s.reg(z, memory))
+
+	case 2:
+		s.alu(y, s.reg(z, false))
+
+	case 3:
+		switch z {
+		case 0:
+			s.set("RET", disCond[y])
+			s.ins.Flow = cpusim.FlowReturn
+		case 1:
+			if q == 0 {
+				s.set("POP", s.pair(p, disPair2))
+				return
+			}
+			switch p {
+			case 0:
+				s.set("RET", "")
+				s.ins.Flow = cpusim.FlowReturn
+			case 1:
+				s.set("EXX", "")
+			case 2:
+				s.set("JP", "("+s.hl()+")")
+			case 3:
+				s.set("LD", "SP,"+s.hl())
+			}
+		case 2:
+			s.set("JP", disCond[y]+","+s.target(s.fetchWord()))
+		case 3:
+			switch y {
+			case 0:
+				s.set("JP", s.target(s.fetchWord()))
+			case 1:
+				s.decodeCB()
+			case 2:
+				s.set("OUT", "("+s.imm8()+"),A")
+			case 3:
+				s.set("IN", "A,("+s.imm8()+")")
+			case 4:
+				s.set("EX", "(SP),"+s.hl())
+			case 5:
+				s.set("EX", "DE,HL")
+			case 6:
+				s.set("DI", "")
+			case 7:
+				s.set("EI", "")
+			}
+		case 4:
+			s.set("CALL", disCond[y]+","+s.target(s.fetchWord()))
+			s.ins.Flow = cpusim.FlowCall
+		case 5:
+			if q == 0 {
+				s.set("PUSH", s.pair(p, disPair2))
+			} else {
+				// p = 0; the prefixes are handled by decode
+				s.set("CALL", s.target(s.fetchWord()))
+				s.ins.Flow = cpusim.FlowCall
+			}
+		case 6:
+			s.alu(y, s.imm8())
+		case 7:
+			s.set("RST", hexNumber(uint16(y)*8, 2))
+			s.target(uint16(y) * 8)
+			s.ins.Flow = cpusim.FlowCall
+		}
+	}
+}
+
+func (s *decoder) alu(op byte, operand string) {
+	if disALUAcc[op] {
+		s.set(disALU[op], "A,"+operand)
+	} else {
+		s.set(disALU[op], operand)
+	}
+}
+
+func (s *decoder) decodeCB() {
+	opcode := s.fetch()
+	x, y, z := opcode>>6, (opcode>>3)&7, opcode&7
+	s.bitOp(x, y, disReg[z])
+}
+
+// bitOp sets a rotate, shift or bit instruction from a CB opcode.
+func (s *decoder) bitOp(x, y byte, operand string) {
+	switch x {
+	case 0:
+		s.set(disRotate[y], operand)
+	case 1:
+		s.set("BIT", fmt.Sprintf("%d,%s", y, operand))
+	case 2:
+		s.set("RES", fmt.Sprintf("%d,%s", y, operand))
+	case 3:
+		s.set("SET", fmt.Sprintf("%d,%s", y, operand))
+	}
+}
+
+// decodeIndexedCB decodes DD CB d op and FD CB d op. Apart from BIT, the
+// forms with a register other than (HL) also copy the result into that
+// register.
+func (s *decoder) decodeIndexedCB() {
+	s.indexed = true
+	operand := s.displacement()
+	opcode := s.fetch()
+	x, y, z := opcode>>6, (opcode>>3)&7, opcode&7
+	if x != 1 && z != 6 {
+		operand += "," + disReg[z]
+	}
+	s.bitOp(x, y, operand)
+}
+
+func (s *decoder) decodeED() {
+	opcode := s.fetch()
+	x, y, z := opcode>>6, (opcode>>3)&7, opcode&7
+	p, q := y>>1, y&1
+
+	switch {
+	case x == 1:
+		switch z {
+		case 0:
+			if y == 6 {
+				s.set("IN", "(C)")
+			} else {
+				s.set("IN", disReg[y]+",(C)")
+			}
+		case 1:
+			if y == 6 {
+				s.set("OUT", "(C),0")
+			} else {
+				s.set("OUT", "(C),"+disReg[y])
+			}
+		case 2:
+			if q == 0 {
+				s.set("SBC", "HL,"+disPair[p])
+			} else {
+				s.set("ADC", "HL,"+disPair[p])
+			}
+		case 3:
+			if q == 0 {
+				s.set("LD", "("+s.address(s.fetchWord())+"),"+disPair[p])
+			} else {
+				s.set("LD", disPair[p]+",("+s.address(s.fetchWord())+")")
+			}
+		case 4:
+			s.set("NEG", "")
+		case 5:
+			if q == 0 {
+				s.set("RETN", "")
+			} else {
+				s.set("RETI", "")
+			}
+			s.ins.Flow = cpusim.FlowReturn
+		case 6:
+			s.set("IM", disIM[y])
+		case 7:
+			if y < 6 {
+				s.set(disEDMisc[y][0], disEDMisc[y][1])
+			} else {
+				s.invalidED(opcode)
+			}
+		}
+	case x == 2 && z <= 3 && y >= 4:
+		s.set(disBlock[y-4][z], "")
+	default:
+		s.invalidED(opcode)
+	}
+}
+
+func (s *decoder) invalidED(opcode byte) {
+	s.set("DB", hexNumber(0xED, 2)+","+hexNumber(uint16(opcode), 2))
+}
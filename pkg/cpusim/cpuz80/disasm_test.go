@@ -0,0 +1,101 @@
+package cpuz80
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSymbols map[cpusim.Address]string
+
+func (s testSymbols) LookupSymbol(address cpusim.Address) (string, bool) {
+	name, ok := s[address]
+	return name, ok
+}
+
+func TestDisassemble(t *testing.T) {
+	symbols := testSymbols{0x1234: "START", 0x0108: "LOOP"}
+	tests := []struct {
+		bytes []byte
+		text  string
+	}{
+		{[]byte{0x00}, "NOP"},
+		{[]byte{0x08}, "EX AF,AF'"},
+		{[]byte{0x01, 0x34, 0x12}, "LD BC,1234H"},
+		{[]byte{0x3E, 0xFF}, "LD A,0FFH"},
+		{[]byte{0x2A, 0x34, 0x12}, "LD HL,(START)"},
+		{[]byte{0xC3, 0x34, 0x12}, "JP START"},
+		{[]byte{0xCA, 0x00, 0xC0}, "JP Z,0C000H"},
+		{[]byte{0x18, 0x06}, "JR LOOP"},
+		{[]byte{0x10, 0xFE}, "DJNZ 0100H"},
+		{[]byte{0xCD, 0x34, 0x12}, "CALL START"},
+		{[]byte{0xFF}, "RST 38H"},
+		{[]byte{0xD3, 0x80}, "OUT (80H),A"},
+		{[]byte{0x96}, "SUB (HL)"},
+		{[]byte{0x8F}, "ADC A,A"},
+		{[]byte{0x76}, "HALT"},
+		{[]byte{0xE9}, "JP (HL)"},
+		{[]byte{0xCB, 0x37}, "SLL A"},
+		{[]byte{0xCB, 0x7E}, "BIT 7,(HL)"},
+		{[]byte{0xDD, 0x21, 0x00, 0x80}, "LD IX,8000H"},
+		{[]byte{0xDD, 0x7E, 0x05}, "LD A,(IX+05H)"},
+		{[]byte{0xFD, 0x74, 0xFD}, "LD (IY-03H),H"},
+		{[]byte{0xDD, 0x66, 0x01}, "LD H,(IX+01H)"},
+		{[]byte{0xDD, 0x36, 0x02, 0x99}, "LD (IX+02H),99H"},
+		{[]byte{0xDD, 0x65}, "LD IXH,IXL"},
+		{[]byte{0xFD, 0x84}, "ADD A,IYH"},
+		{[]byte{0xDD, 0xE9}, "JP (IX)"},
+		{[]byte{0xDD, 0xCB, 0x03, 0x06}, "RLC (IX+03H)"},
+		{[]byte{0xDD, 0xCB, 0x03, 0x00}, "RLC (IX+03H),B"},
+		{[]byte{0xFD, 0xCB, 0x80, 0x4F}, "BIT 1,(IY-80H)"},
+		{[]byte{0xFD, 0xCB, 0x00, 0xFF}, "SET 7,(IY+00H),A"},
+		{[]byte{0xDD, 0x00}, "NOP"},
+		{[]byte{0xDD, 0xFD, 0x23}, "INC IY"},
+		{[]byte{0xED, 0xB0}, "LDIR"},
+		{[]byte{0xED, 0x70}, "IN (C)"},
+		{[]byte{0xED, 0x71}, "OUT (C),0"},
+		{[]byte{0xED, 0x4C}, "NEG"},
+		{[]byte{0xED, 0x5D}, "RETI"},
+		{[]byte{0xED, 0x4E}, "IM 0/1"},
+		{[]byte{0xED, 0x43, 0x34, 0x12}, "LD (START),BC"},
+		{[]byte{0xED, 0x00}, "DB 0EDH,00H"},
+	}
+
+	dis := NewDisassembler(symbols)
+	for _, tt := range tests {
+		read := func(address cpusim.Address) byte {
+			return tt.bytes[address-0x100]
+		}
+		ins := dis.Disassemble(read, 0x100)
+		assert.Equal(t, tt.text, ins.Text(), "% X", tt.bytes)
+		assert.Equal(t, tt.bytes, ins.Bytes, tt.text)
+	}
+}
+
+// TestDisassembleLengths checks the disassembler against the CPU: every
+// instruction that doesn't transfer control must advance the PC by its
+// disassembled length.
+func TestDisassembleLengths(t *testing.T) {
+	prefixes := [][]byte{{}, {0xCB}, {0xED}, {0xDD}, {0xFD}, {0xDD, 0xCB, 0x05}, {0xFD, 0xCB, 0xFB}}
+	flow := map[string]bool{
+		"JP": true, "JR": true, "DJNZ": true, "CALL": true, "RET": true, "RETI": true, "RETN": true,
+		"RST": true, "HALT": true, "LDIR": true, "LDDR": true, "CPIR": true, "CPDR": true,
+		"INIR": true, "INDR": true, "OTIR": true, "OTDR": true,
+	}
+
+	for _, prefix := range prefixes {
+		for op := 0; op < 256; op++ {
+			program := append(append([]byte{}, prefix...), byte(op), 0x12, 0x34, 0x56)
+			cpu, _ := setupProgram(t, program)
+			ins := cpu.Disassemble(0x100)
+			if flow[ins.Mnemonic] {
+				continue
+			}
+			require.NoError(t, cpu.Execute())
+			assert.Equal(t, 0x100+ins.Len(), int(cpu.PC), fmt.Sprintf("% X: %s", program[:ins.Len()], ins.Text()))
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package cpuz80
+
+import "github.com/scottmbaker/gocpusim/pkg/cpusim"
+
+// RemoteArchitecture is GDB's name for the Z80.
+func (cpu *CPUZ80) RemoteArchitecture() string {
+	return "z80"
+}
+
+// RemoteRegisters returns the registers in GDB's z80 order.
+func (cpu *CPUZ80) RemoteRegisters() []cpusim.RemoteRegister {
+	return []cpusim.RemoteRegister{
+		bytePair("af", &cpu.A, &cpu.F),
+		bytePair("bc", &cpu.B, &cpu.C),
+		bytePair("de", &cpu.D, &cpu.E),
+		bytePair("hl", &cpu.H, &cpu.L),
+		word("sp", &cpu.SP),
+		word("pc", &cpu.PC),
+		word("ix", &cpu.IX),
+		word("iy", &cpu.IY),
+		word("af'", &cpu.AF_),
+		word("bc'", &cpu.BC_),
+		word("de'", &cpu.DE_),
+		word("hl'", &cpu.HL_),
+		bytePair("ir", &cpu.I, &cpu.R),
+	}
+}
+
+func bytePair(name string, hi, lo *byte) cpusim.RemoteRegister {
+	return cpusim.RemoteRegister{
+		Name: name,
+		Bits: 16,
+		Get:  func() uint16 { return uint16(*hi)<<8 | uint16(*lo) },
+		Set:  func(value uint16) { *hi, *lo = byte(value>>8), byte(value) },
+	}
+}
+
+func word(name string, w *uint16) cpusim.RemoteRegister {
+	return cpusim.RemoteRegister{
+		Name: name,
+		Bits: 16,
+		Get:  func() uint16 { return *w },
+		Set:  func(value uint16) { *w = value },
+	}
+}
@@ -0,0 +1,90 @@
+package cpuz80
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+	"github.com/scottmbaker/gocpusim/pkg/gdbstub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGDBStub(t *testing.T) {
+	// LD HL,2000h; loop: IN A,(81h); LD (HL),A; INC HL; JR loop
+	cpu, _ := setupProgram(t, []byte{0x21, 0x00, 0x20, 0xDB, 0x81, 0x77, 0x23, 0x18, 0xFA})
+	cpu.PortAddressMask = 0xFF
+	sim := cpu.Sim
+
+	debugger, err := cpusim.NewDebugger(sim)
+	require.NoError(t, err)
+	server, err := gdbstub.New(sim, debugger)
+	require.NoError(t, err)
+	require.NoError(t, server.Listen("127.0.0.1:0"))
+	defer server.Close() // nolint:errcheck
+
+	var wg sync.WaitGroup
+	sim.Start(&wg)
+	go server.Serve() // nolint:errcheck
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close() // nolint:errcheck
+	r := bufio.NewReader(conn)
+
+	exchange := func(packet string) string {
+		t.Helper()
+		var sum byte
+		for i := 0; i < len(packet); i++ {
+			sum += packet[i]
+		}
+		_, err := fmt.Fprintf(conn, "$%s#%02x", packet, sum)
+		require.NoError(t, err)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		ack, err := r.ReadByte()
+		require.NoError(t, err)
+		require.Equal(t, byte('+'), ack)
+		_, err = r.ReadString('$')
+		require.NoError(t, err)
+		reply, err := r.ReadString('#')
+		require.NoError(t, err)
+		_, err = r.Discard(2)
+		require.NoError(t, err)
+		_, err = conn.Write([]byte("+"))
+		require.NoError(t, err)
+		return strings.TrimSuffix(reply, "#")
+	}
+
+	assert.Equal(t, "S05", exchange("?"))
+	assert.Len(t, exchange("g"), 13*4)
+	assert.Contains(t, exchange("qXfer:features:read:target.xml:0,1000"), "<architecture>z80</architecture>")
+
+	// Restart from the top and run to the store
+	assert.Equal(t, "OK", exchange("P5=0001"))
+	assert.Equal(t, "21002", exchange("m100,3")[:5])
+	assert.Equal(t, "OK", exchange("Z0,105,1"))
+	assert.Equal(t, "S05", exchange("c"))
+	assert.Equal(t, "0501", exchange("p5"))
+	assert.Equal(t, "0020", exchange("p3"))
+	assert.Equal(t, "OK", exchange("z0,105,1"))
+
+	assert.Equal(t, "S05", exchange("s"))
+	assert.Equal(t, "0601", exchange("p5"))
+
+	// The store to 2002h is on the third pass through the loop
+	assert.Equal(t, "OK", exchange("Z2,2002,1"))
+	assert.Equal(t, "T05watch:2002;", exchange("c"))
+	assert.Equal(t, "0601", exchange("p5"))
+
+	assert.Equal(t, "OK", exchange("M3000,2:abcd"))
+	assert.Equal(t, "abcd", exchange("m3000,2"))
+
+	assert.Equal(t, "OK", exchange("D"))
+	sim.CtrlC.Store(true)
+	wg.Wait()
+}
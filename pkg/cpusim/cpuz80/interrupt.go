@@ -0,0 +1,183 @@
+package cpuz80
+
+import (
+	"fmt"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+)
+
+// SetINT drives the maskable interrupt input. The line is level sensitive, so
+// the interrupting device must hold it asserted until it has been serviced.
+func (cpu *CPUZ80) SetINT(asserted bool) {
+	cpu.intLine.Store(asserted)
+}
+
+// INTAsserted returns the current level of the maskable interrupt input.
+func (cpu *CPUZ80) INTAsserted() bool {
+	return cpu.intLine.Load()
+}
+
+// NMI latches a non-maskable interrupt. It is accepted at the next
+// instruction boundary regardless of IFF1.
+func (cpu *CPUZ80) NMI() {
+	cpu.nmiPending.Store(true)
+}
+
+// interruptAck runs an interrupt acknowledge cycle and returns the data bus.
+func (cpu *CPUZ80) interruptAck() byte {
+	if cpu.IntAck == nil {
+		return 0xFF
+	}
+	return cpu.IntAck()
+}
+
+// acceptInterrupt samples the NMI and INT inputs at an instruction boundary.
+// It returns true if an interrupt was accepted, in which case the acceptance
+// takes the place of the instruction that would otherwise have executed.
+func (cpu *CPUZ80) acceptInterrupt() (bool, error) {
+	if cpu.nmiPending.Load() {
+		cpu.nmiPending.Store(false)
+		cpu.InHalt = false
+		cpu.incR()
+
+		if cpu.Sim.Debug {
+			fmt.Printf("%04X: <NMI> %s\n", cpu.PC, cpu.String())
+		}
+
+		// IFF2 keeps the pre-NMI state so that RETN can restore it
+		cpu.IFF1 = false
+		cpu.Cycles += cyclesNMI
+		cpu.push(cpu.PC)
+		cpu.PC = 0x0066
+		cpu.WZ = cpu.PC
+		return true, nil
+	}
+
+	// Maskable interrupts are not accepted in the instruction following EI
+	if !cpu.IFF1 || cpu.EIPending || !cpu.intLine.Load() {
+		return false, nil
+	}
+
+	cpu.InHalt = false
+	cpu.incR()
+	cpu.IFF1 = false
+	cpu.IFF2 = false
+
+	data := cpu.interruptAck()
+
+	if cpu.Sim.Debug {
+		fmt.Printf("%04X: <INT IM%d [%02X]> %s\n", cpu.PC, cpu.IM, data, cpu.String())
+	}
+
+	switch cpu.IM {
+	case 0:
+		return true, cpu.executeIM0(data)
+	case 1:
+		cpu.Cycles += cyclesIM1
+		cpu.push(cpu.PC)
+		cpu.PC = 0x0038
+	default:
+		cpu.Cycles += cyclesIM2
+		cpu.push(cpu.PC)
+		cpu.PC = cpu.readWord(uint16(cpu.I)<<8 | uint16(data))
+	}
+	cpu.WZ = cpu.PC
+	return true, nil
+}
+
+// executeIM0 executes the instruction supplied on the data bus during an IM 0
+// acknowledge. This is almost always an RST, but CALL nn is supported by
+// running two further acknowledge cycles for the operand, as an 8080-style
+// interrupt controller would. Any other instruction reads the rest of its
+// bytes, including the opcode after a prefix, through further acknowledge
+// cycles too, so that PC stays where the interrupt left it.
+func (cpu *CPUZ80) executeIM0(opcode byte) error {
+	// The acknowledge cycle takes the place of the opcode fetch, plus two
+	// wait states
+	cpu.Cycles += cyclesIntAck + uint64(cyclesMain[opcode])
+
+	switch {
+	case opcode&0xC7 == 0xC7: // RST p
+		cpu.push(cpu.PC)
+		cpu.PC = uint16(opcode & 0x38)
+		cpu.WZ = cpu.PC
+		return nil
+	case opcode == 0xCD: // CALL nn
+		lo := cpu.interruptAck()
+		hi := cpu.interruptAck()
+		cpu.push(cpu.PC)
+		cpu.PC = uint16(hi)<<8 | uint16(lo)
+		cpu.WZ = cpu.PC
+		return nil
+	default:
+		cpu.ackFetch = true
+		defer func() { cpu.ackFetch = false }()
+		return cpu.executeUnprefixed(opcode)
+	}
+}
+
+// ConnectSignal connects a control line to the CPU. INT is level sensitive,
+// NMI and RESET are edge sensitive (RESET also holds the CPU in reset for as
+// long as it is asserted), WAIT and BUSRQ stall the CPU while asserted, and
+// BUSAK is driven by the CPU while it has granted the bus.
+func (cpu *CPUZ80) ConnectSignal(name string, line *cpusim.SignalLine) error {
+	switch name {
+	case cpusim.SIGNAL_INT:
+		line.OnChange(cpu.SetINT)
+		cpu.SetINT(line.Asserted())
+	case cpusim.SIGNAL_NMI:
+		cpu.nmiConnected = true
+		line.OnChange(func(asserted bool) {
+			if asserted {
+				cpu.NMI()
+			}
+		})
+	case cpusim.SIGNAL_RESET:
+		cpu.resetLine = line
+		line.OnChange(func(asserted bool) {
+			if asserted {
+				cpu.resetPending.Store(true)
+			}
+		})
+	case cpusim.SIGNAL_WAIT:
+		cpu.waitLine = line
+	case cpusim.SIGNAL_BUSRQ:
+		cpu.busrqLine = line
+	case cpusim.SIGNAL_BUSAK:
+		cpu.busakLine = line
+	default:
+		return &cpusim.ErrNotImplemented{Device: cpu, What: "signal " + name}
+	}
+	return nil
+}
+
+// busHeld returns true if WAIT or BUSRQ is stalling the CPU. When BUSRQ is
+// asserted the CPU grants the bus by asserting BUSAK until it is released.
+func (cpu *CPUZ80) busHeld() bool {
+	if cpu.waitLine != nil && cpu.waitLine.Asserted() {
+		return true
+	}
+	if cpu.busrqLine == nil {
+		return false
+	}
+	requested := cpu.busrqLine.Asserted()
+	if requested != cpu.busGranted {
+		cpu.busGranted = requested
+		if cpu.busakLine != nil {
+			cpu.busakLine.Set(cpu, requested)
+		}
+	}
+	return requested
+}
+
+// ConnectDaisyChain connects a mode 2 interrupt daisy chain to the CPU. The
+// chain drives INT, supplies the vector during the acknowledge cycle, and is
+// told when RETI is executed.
+func (cpu *CPUZ80) ConnectDaisyChain(chain *cpusim.DaisyChain) error {
+	cpu.IntAck = chain.Acknowledge
+	cpu.RETIHook = chain.RETI
+	if chain.Output == nil {
+		return nil
+	}
+	return cpu.ConnectSignal(cpusim.SIGNAL_INT, chain.Output)
+}
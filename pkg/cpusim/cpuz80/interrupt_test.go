@@ -0,0 +1,350 @@
+package cpuz80
+
+import (
+	"testing"
+	"time"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterruptIM1(t *testing.T) {
+	// IM 1; EI; NOP; NOP
+	cpu, ram := setupProgram(t, []byte{0xED, 0x56, 0xFB, 0x00, 0x00})
+	cpu.SetINT(true)
+
+	step(t, cpu, 2)
+	assert.True(t, cpu.IFF1)
+	assert.Equal(t, uint16(0x0103), cpu.PC)
+
+	// The instruction following EI always executes before the interrupt
+	step(t, cpu, 1)
+	assert.Equal(t, uint16(0x0104), cpu.PC)
+
+	step(t, cpu, 1)
+	assert.Equal(t, uint16(0x0038), cpu.PC)
+	assert.False(t, cpu.IFF1)
+	assert.False(t, cpu.IFF2)
+	assert.Equal(t, uint16(0xEFFE), cpu.SP)
+	assert.Equal(t, byte(0x04), ram.Contents[0xEFFE])
+	assert.Equal(t, byte(0x01), ram.Contents[0xEFFF])
+}
+
+func TestInterruptIM2(t *testing.T) {
+	// LD A,0x20; LD I,A; IM 2; EI; NOP
+	cpu, ram := setupProgram(t, []byte{0x3E, 0x20, 0xED, 0x47, 0xED, 0x5E, 0xFB, 0x00})
+	ram.Contents[0x2010] = 0x34
+	ram.Contents[0x2011] = 0x12
+	cpu.IntAck = func() byte { return 0x10 }
+	cpu.SetINT(true)
+
+	step(t, cpu, 6)
+	assert.Equal(t, uint16(0x1234), cpu.PC)
+	assert.Equal(t, uint16(0x1234), cpu.WZ)
+	assert.Equal(t, byte(0x08), ram.Contents[0xEFFE])
+}
+
+func TestInterruptIM0(t *testing.T) {
+	// IM 0; EI; NOP
+	cpu, _ := setupProgram(t, []byte{0xED, 0x46, 0xFB, 0x00})
+	cpu.IntAck = func() byte { return 0xD7 } // RST 10h
+	cpu.SetINT(true)
+
+	step(t, cpu, 4)
+	assert.Equal(t, uint16(0x0010), cpu.PC)
+}
+
+func TestInterruptIM0Prefixed(t *testing.T) {
+	// IM 0; EI; NOP; NOP
+	cpu, _ := setupProgram(t, []byte{0xED, 0x46, 0xFB, 0x00, 0x00})
+	bus := []byte{0xDD, 0x21, 0x34, 0x12} // LD IX,1234h
+	acks := 0
+	cpu.IntAck = func() byte {
+		acks++
+		if acks > len(bus) {
+			return 0xFF
+		}
+		return bus[acks-1]
+	}
+	cpu.SetINT(true)
+
+	// All four bytes come from acknowledge cycles, and PC doesn't move
+	step(t, cpu, 4)
+	assert.Equal(t, 4, acks)
+	assert.Equal(t, uint16(0x1234), cpu.IX)
+	assert.Equal(t, uint16(0x0104), cpu.PC)
+
+	cpu.SetINT(false)
+	step(t, cpu, 1)
+	assert.Equal(t, uint16(0x0105), cpu.PC)
+}
+
+func TestInterruptMasked(t *testing.T) {
+	// DI; NOP; NOP
+	cpu, _ := setupProgram(t, []byte{0xF3, 0x00, 0x00})
+	cpu.SetINT(true)
+
+	step(t, cpu, 3)
+	assert.Equal(t, uint16(0x0103), cpu.PC)
+}
+
+func TestNMI(t *testing.T) {
+	// EI; NOP; NOP
+	cpu, _ := setupProgram(t, []byte{0xFB, 0x00, 0x00})
+	step(t, cpu, 2)
+	require.True(t, cpu.IFF1)
+
+	cpu.NMI()
+	step(t, cpu, 1)
+	assert.Equal(t, uint16(0x0066), cpu.PC)
+	assert.False(t, cpu.IFF1)
+	assert.True(t, cpu.IFF2)
+
+	// RETN restores IFF1 from IFF2
+	cpu.Sim.Memory[0].(*cpusim.Memory).Contents[0x0066] = 0xED
+	cpu.Sim.Memory[0].(*cpusim.Memory).Contents[0x0067] = 0x45
+	step(t, cpu, 1)
+	assert.Equal(t, uint16(0x0102), cpu.PC)
+	assert.True(t, cpu.IFF1)
+}
+
+func TestHaltWakeup(t *testing.T) {
+	// IM 1; EI; HALT; NOP
+	cpu, _ := setupProgram(t, []byte{0xED, 0x56, 0xFB, 0x76, 0x00})
+
+	step(t, cpu, 3)
+	assert.True(t, cpu.InHalt)
+	assert.False(t, cpu.Halted.Load())
+
+	// HALT idles without advancing PC
+	step(t, cpu, 10)
+	assert.Equal(t, uint16(0x0104), cpu.PC)
+
+	cpu.SetINT(true)
+	step(t, cpu, 1)
+	assert.False(t, cpu.InHalt)
+	assert.Equal(t, uint16(0x0038), cpu.PC)
+}
+
+func TestHaltWithInterruptsDisabled(t *testing.T) {
+	// DI; HALT
+	cpu, _ := setupProgram(t, []byte{0xF3, 0x76})
+
+	step(t, cpu, 2)
+	assert.True(t, cpu.Halted.Load())
+}
+
+func TestHaltWokenByNMI(t *testing.T) {
+	// DI; HALT; with the NMI handler at 0066h: RETN
+	cpu, ram := setupProgram(t, []byte{0xF3, 0x76, 0x00})
+	copy(ram.Contents[0x0066:], []byte{0xED, 0x45})
+	nmiLine := cpu.Sim.Signal(cpusim.SIGNAL_NMI)
+	require.NoError(t, cpu.ConnectSignal(cpusim.SIGNAL_NMI, nmiLine))
+
+	// Something could still send an NMI, so the CPU idles in HALT
+	step(t, cpu, 10)
+	assert.False(t, cpu.Halted.Load())
+	assert.True(t, cpu.InHalt)
+	assert.Equal(t, uint16(0x0102), cpu.PC)
+
+	nmiLine.Pulse(&testDevice{name: "button"})
+	step(t, cpu, 1)
+	assert.False(t, cpu.InHalt)
+	assert.Equal(t, uint16(0x0066), cpu.PC)
+
+	// RETN goes back to the instruction after HALT
+	step(t, cpu, 1)
+	assert.Equal(t, uint16(0x0102), cpu.PC)
+	assert.False(t, cpu.IFF1)
+}
+
+// testDevice is a minimal signal source for driving lines in tests.
+type testDevice struct {
+	name string
+}
+
+func (d *testDevice) GetName() string {
+	return d.name
+}
+
+func TestSignalLineWiredOR(t *testing.T) {
+	// IM 1; EI; NOP; NOP
+	cpu, _ := setupProgram(t, []byte{0xED, 0x56, 0xFB, 0x00, 0x00})
+	intLine := cpu.Sim.Signal(cpusim.SIGNAL_INT)
+	require.NoError(t, cpu.ConnectSignal(cpusim.SIGNAL_INT, intLine))
+
+	dev1 := &testDevice{name: "dev1"}
+	dev2 := &testDevice{name: "dev2"}
+	intLine.Assert(dev1)
+	intLine.Assert(dev2)
+	intLine.Release(dev1)
+	assert.True(t, cpu.INTAsserted())
+
+	intLine.Release(dev2)
+	assert.False(t, cpu.INTAsserted())
+	step(t, cpu, 4)
+	assert.Equal(t, uint16(0x0105), cpu.PC)
+}
+
+func TestResetSignal(t *testing.T) {
+	// EI; NOP
+	cpu, _ := setupProgram(t, []byte{0xFB, 0x00})
+	resetLine := cpu.Sim.Signal(cpusim.SIGNAL_RESET)
+	require.NoError(t, cpu.ConnectSignal(cpusim.SIGNAL_RESET, resetLine))
+
+	step(t, cpu, 2)
+	resetLine.Pulse(&testDevice{name: "button"})
+	step(t, cpu, 1)
+	assert.Equal(t, uint16(0x0000), cpu.PC)
+	assert.False(t, cpu.IFF1)
+}
+
+func TestACIAInterrupt(t *testing.T) {
+	// IM 1; LD A,0x96; OUT (0x80),A; EI; JR $
+	cpu, _ := setupProgram(t, []byte{0xED, 0x56, 0x3E, 0x96, 0xD3, 0x80, 0xFB, 0x18, 0xFE})
+	cpu.PortAddressMask = 0xFF
+
+	serial := cpusim.NewChannelSerial()
+	acia := cpusim.NewACIA(cpu.Sim, serial, "acia", 0x81, 0x80, &cpusim.AlwaysEnabled)
+	cpu.Sim.AddPort(acia)
+
+	intLine := cpu.Sim.Signal(cpusim.SIGNAL_INT)
+	require.NoError(t, cpu.ConnectSignal(cpusim.SIGNAL_INT, intLine))
+	acia.ConnectInterrupt(intLine)
+
+	step(t, cpu, 10)
+	assert.Equal(t, uint16(0x0107), cpu.PC)
+	assert.False(t, intLine.Asserted())
+
+	acia.Start(nil)
+	serial.In <- 'A'
+	require.Eventually(t, intLine.Asserted, time.Second, time.Millisecond)
+
+	step(t, cpu, 1)
+	assert.Equal(t, uint16(0x0038), cpu.PC)
+}
+
+func TestDaisyChainSIO(t *testing.T) {
+	// LD A,0x20; LD I,A; IM 2; EI; JR $
+	cpu, ram := setupProgram(t, []byte{0x3E, 0x20, 0xED, 0x47, 0xED, 0x5E, 0xFB, 0x18, 0xFE})
+	cpu.PortAddressMask = 0xFF
+
+	// Handler: LD A,0x28 (reset Tx int pending); OUT (0x80),A; EI; RETI
+	copy(ram.Contents[0x0300:], []byte{0x3E, 0x28, 0xD3, 0x80, 0xFB, 0xED, 0x4D})
+	ram.Contents[0x2048] = 0x00
+	ram.Contents[0x2049] = 0x03
+
+	sio1 := cpusim.NewSIO(cpu.Sim, cpusim.NewChannelSerial(), "sio1", 0x81, 0x83, 0x80, 0x82, &cpusim.AlwaysEnabled)
+	sio2 := cpusim.NewSIO(cpu.Sim, cpusim.NewChannelSerial(), "sio2", 0x85, 0x87, 0x84, 0x86, &cpusim.AlwaysEnabled)
+	cpu.Sim.AddPort(sio1)
+	cpu.Sim.AddPort(sio2)
+
+	chain := cpusim.NewDaisyChain(cpu.Sim, "daisy", cpu.Sim.Signal(cpusim.SIGNAL_INT))
+	require.NoError(t, cpu.ConnectDaisyChain(chain))
+	chain.Add(sio1)
+	chain.Add(sio2)
+
+	// sio1: vector 0x40 with status affects vector, channel A Tx interrupt
+	for _, w := range [][2]byte{{0x82, 0x02}, {0x82, 0x40}, {0x82, 0x01}, {0x82, 0x04}, {0x80, 0x01}, {0x80, 0x02}} {
+		require.NoError(t, sio1.Write(cpusim.Address(w[0]), w[1]))
+	}
+	// sio2: channel A Tx interrupt
+	require.NoError(t, sio2.Write(0x84, 0x01))
+	require.NoError(t, sio2.Write(0x84, 0x02))
+
+	step(t, cpu, 5)
+	assert.Equal(t, uint16(0x0107), cpu.PC)
+
+	// Both devices request; the first in the chain wins with a modified vector
+	require.NoError(t, sio2.Write(0x85, 'B'))
+	require.NoError(t, sio1.Write(0x81, 'A'))
+	require.NoError(t, sio1.Write(0x82, 0x02))
+	value, err := sio1.Read(0x82)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0x48), value)
+
+	step(t, cpu, 1)
+	assert.Equal(t, uint16(0x0300), cpu.PC)
+
+	// sio1 is in service, which holds off sio2
+	assert.False(t, cpu.INTAsserted())
+
+	// RETI ends sio1's service and lets sio2 through
+	step(t, cpu, 4)
+	assert.Equal(t, uint16(0x0107), cpu.PC)
+	assert.True(t, cpu.INTAsserted())
+}
+
+func TestDaisyChainCTC(t *testing.T) {
+	cpu, ram := setupProgram(t, []byte{
+		0x3E, 0x20, 0xED, 0x47, 0xED, 0x5E, // LD A,0x20; LD I,A; IM 2
+		0x3E, 0x40, 0xD3, 0x88, // LD A,0x40; OUT (0x88),A - vector
+		0x3E, 0xA7, 0xD3, 0x8A, // LD A,0xA7; OUT (0x8A),A - channel 2 timer, /256, interrupts on
+		0x3E, 0x02, 0xD3, 0x8A, // LD A,2; OUT (0x8A),A - time constant
+		0xFB, 0x18, 0xFE, // EI; JR $
+	})
+	cpu.PortAddressMask = 0xFF
+
+	// Handler: LD HL,0x8000; INC (HL); EI; RETI
+	copy(ram.Contents[0x0300:], []byte{0x21, 0x00, 0x80, 0x34, 0xFB, 0xED, 0x4D})
+	ram.Contents[0x2044] = 0x00
+	ram.Contents[0x2045] = 0x03
+
+	ctc := cpusim.NewCTC(cpu.Sim, "ctc", 0x88, &cpusim.AlwaysEnabled)
+	cpu.Sim.AddPort(ctc)
+	chain := cpusim.NewDaisyChain(cpu.Sim, "daisy", cpu.Sim.Signal(cpusim.SIGNAL_INT))
+	require.NoError(t, cpu.ConnectDaisyChain(chain))
+	chain.Add(ctc)
+
+	for cpu.Cycles < 512*10 {
+		require.NoError(t, cpu.Sim.Step(cpu))
+	}
+	// The timer counts down every 256 cycles from 2, and interrupts at zero
+	assert.InDelta(t, 10, int(ram.Contents[0x8000]), 1)
+
+	// Reading a channel gives its down counter
+	value, err := ctc.Read(0x8A)
+	require.NoError(t, err)
+	assert.Contains(t, []byte{1, 2}, value)
+
+	// A reset channel stops
+	require.NoError(t, ctc.Write(0x8A, 0x03))
+	count := ram.Contents[0x8000]
+	for end := cpu.Cycles + 2048; cpu.Cycles < end; {
+		require.NoError(t, cpu.Sim.Step(cpu))
+	}
+	assert.Equal(t, count, ram.Contents[0x8000])
+}
+
+func TestDaisyChainCTCNesting(t *testing.T) {
+	sim := cpusim.NewCPUSim()
+	ctc := cpusim.NewCTC(sim, "ctc", 0x88, &cpusim.AlwaysEnabled)
+	chain := cpusim.NewDaisyChain(sim, "daisy", cpusim.NewSignalLine("INT"))
+	chain.Add(ctc)
+
+	// Channels 0 and 1 count one trigger each, with interrupts on
+	require.NoError(t, ctc.Write(0x88, 0x40))
+	for _, port := range []cpusim.Address{0x88, 0x89} {
+		require.NoError(t, ctc.Write(port, 0xC5))
+		require.NoError(t, ctc.Write(port, 0x01))
+	}
+
+	ctc.Trigger(1)
+	assert.True(t, chain.Output.Asserted())
+	assert.Equal(t, byte(0x42), chain.Acknowledge())
+
+	// Channel 1 is held off by its own service, but channel 0 nests
+	ctc.Trigger(1)
+	assert.False(t, chain.Output.Asserted())
+	ctc.Trigger(0)
+	assert.True(t, chain.Output.Asserted())
+	assert.Equal(t, byte(0x40), chain.Acknowledge())
+
+	// RETI ends channel 0 first, then channel 1, which can then interrupt
+	chain.RETI()
+	assert.False(t, chain.Output.Asserted())
+	chain.RETI()
+	assert.True(t, chain.Output.Asserted())
+	assert.Equal(t, byte(0x42), chain.Acknowledge())
+}
@@ -0,0 +1,93 @@
+package cpuz80
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupLoadMachine builds a 16K ROM with 4K mapper pages, and 64K of RAM.
+func setupLoadMachine(t *testing.T) (*CPUZ80, *cpusim.Memory, *cpusim.Memory) {
+	t.Helper()
+	cpu, ram := setupProgram(t, nil)
+	sim := cpu.Sim
+	rom := cpusim.NewMemory(sim, "rom", cpusim.KIND_ROM, 0x0000, 0x3FFF, 14, true, &cpusim.AlwaysDisabled)
+	sim.AddMemory(rom)
+	mapper := cpusim.New74670(sim, "mapper", 0x00, cpusim.A12, cpusim.D0, cpusim.A12, cpusim.A13, cpusim.A14, cpusim.A15, &cpusim.AlwaysEnabled, &cpusim.AlwaysEnabled)
+	sim.AddMapper(mapper)
+	return cpu, rom, ram
+}
+
+func writeFiles(t *testing.T, files map[string][]byte) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0644))
+	}
+	return dir
+}
+
+func TestLoadImages(t *testing.T) {
+	cpu, rom, ram := setupLoadMachine(t)
+	dir := writeFiles(t, map[string][]byte{
+		"boot.bin":  {0xC3, 0x00, 0x10},
+		"bank1.bin": {0x11, 0x22},
+		"bank3.bin": {0x33},
+		"data.bin":  {0x44, 0x55},
+		"prog.s19":  []byte("S107020006033E426D\nS9030200FA\n"),
+	})
+
+	err := cpu.Sim.LoadImages(cpusim.LoadOptions{
+		ROM: filepath.Join(dir, "boot.bin"),
+		Images: []string{
+			filepath.Join(dir, "bank1.bin") + ":1",
+			filepath.Join(dir, "bank3.bin") + "@3000",
+			"ram=" + filepath.Join(dir, "data.bin") + "@8000",
+			filepath.Join(dir, "prog.s19"),
+		},
+		EraseROM: true,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []byte{0xC3, 0x00, 0x10, 0xFF}, rom.Contents[0x0000:0x0004])
+	assert.Equal(t, []byte{0x11, 0x22, 0xFF}, rom.Contents[0x1000:0x1003])
+	assert.Equal(t, byte(0x33), rom.Contents[0x3000])
+	assert.Equal(t, byte(0xFF), rom.Contents[0x3FFF])
+	assert.Equal(t, []byte{0x44, 0x55}, ram.Contents[0x8000:0x8002])
+
+	// The RAM is the enabled device at 0200
+	assert.Equal(t, []byte{0x06, 0x03, 0x3E, 0x42}, ram.Contents[0x200:0x204])
+	assert.Equal(t, cpusim.Address(0x200), cpu.GetPC())
+}
+
+func TestLoadImageErrors(t *testing.T) {
+	cpu, _, _ := setupLoadMachine(t)
+	dir := writeFiles(t, map[string][]byte{
+		"a.bin":   make([]byte, 0x1000),
+		"b.bin":   {0x01},
+		"big.hex": []byte(":020000040010EA\n:0100000000FF\n:00000001FF\n"),
+	})
+	a, b := filepath.Join(dir, "a.bin"), filepath.Join(dir, "b.bin")
+
+	err := cpu.Sim.LoadImages(cpusim.LoadOptions{Images: []string{a, b + "@FFF"}})
+	assert.ErrorContains(t, err, b+"@FFF overlaps "+a+" in rom at offset FFF")
+
+	err = cpu.Sim.LoadImages(cpusim.LoadOptions{Images: []string{a + ":4"}})
+	assert.ErrorContains(t, err, a+":4: 4096 bytes at offset 4000 overflow rom, which has 16384 bytes")
+
+	err = cpu.Sim.LoadImages(cpusim.LoadOptions{Images: []string{"eeprom=" + b}})
+	assert.ErrorContains(t, err, `no memory device named "eeprom"`)
+
+	err = cpu.Sim.LoadImages(cpusim.LoadOptions{Images: []string{filepath.Join(dir, "big.hex")}})
+	assert.ErrorContains(t, err, "no memory at address 100000")
+
+	rom := cpusim.NewMemory(cpu.Sim, "small", cpusim.KIND_ROM, 0, 0xFFF, 12, true, &cpusim.AlwaysEnabled)
+	assert.NoError(t, rom.Load(a))
+	assert.ErrorContains(t, rom.Load(filepath.Join(dir, "c.bin")), "no such file")
+	small := cpusim.NewMemory(cpu.Sim, "tiny", cpusim.KIND_ROM, 0, 0xFF, 8, true, &cpusim.AlwaysEnabled)
+	assert.ErrorContains(t, small.Load(a), "is 4096 bytes, but tiny only has 256")
+}
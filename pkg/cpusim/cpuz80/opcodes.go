@@ -242,6 +242,7 @@ func (cpu *CPUZ80) executeUnprefixed(opcode byte) error {
 			offset := int8(d)
 			cpu.PC = uint16(int32(cpu.PC) + int32(offset))
 			cpu.WZ = cpu.PC
+			cpu.Cycles += cyclesJRTaken
 		}
 		return nil
 
@@ -258,6 +259,7 @@ func (cpu *CPUZ80) executeUnprefixed(opcode byte) error {
 			offset := int8(d)
 			cpu.PC = uint16(int32(cpu.PC) + int32(offset))
 			cpu.WZ = cpu.PC
+			cpu.Cycles += cyclesJRTaken
 		}
 		return nil
 
@@ -267,6 +269,7 @@ func (cpu *CPUZ80) executeUnprefixed(opcode byte) error {
 			offset := int8(d)
 			cpu.PC = uint16(int32(cpu.PC) + int32(offset))
 			cpu.WZ = cpu.PC
+			cpu.Cycles += cyclesJRTaken
 		}
 		return nil
 
@@ -276,6 +279,7 @@ func (cpu *CPUZ80) executeUnprefixed(opcode byte) error {
 			offset := int8(d)
 			cpu.PC = uint16(int32(cpu.PC) + int32(offset))
 			cpu.WZ = cpu.PC
+			cpu.Cycles += cyclesJRTaken
 		}
 		return nil
 
@@ -285,6 +289,7 @@ func (cpu *CPUZ80) executeUnprefixed(opcode byte) error {
 			offset := int8(d)
 			cpu.PC = uint16(int32(cpu.PC) + int32(offset))
 			cpu.WZ = cpu.PC
+			cpu.Cycles += cyclesJRTaken
 		}
 		return nil
 
@@ -322,7 +327,12 @@ func (cpu *CPUZ80) executeUnprefixed(opcode byte) error {
 
 	// LD r,r' block: 0x40-0x7F (except 0x76 which is HALT)
 	case 0x76: // HALT
-		cpu.Halted.Store(true)
+		// With interrupts disabled only an NMI can wake the CPU, so treat
+		// DI/HALT as the end of the program unless something drives NMI.
+		cpu.InHalt = true
+		if !cpu.IFF1 && !cpu.nmiConnected {
+			cpu.Halted.Store(true)
+		}
 		return nil
 
 	case 0x40: // LD B,B
@@ -600,6 +610,7 @@ func (cpu *CPUZ80) executeUnprefixed(opcode byte) error {
 		if cpu.condition(cc) {
 			cpu.push(cpu.PC)
 			cpu.PC = addr
+			cpu.Cycles += cyclesCallTaken
 		}
 		cpu.WZ = addr
 		return nil
@@ -616,6 +627,7 @@ func (cpu *CPUZ80) executeUnprefixed(opcode byte) error {
 		if cpu.condition(cc) {
 			cpu.PC = cpu.pop()
 			cpu.WZ = cpu.PC
+			cpu.Cycles += cyclesRetTaken
 		}
 		return nil
 
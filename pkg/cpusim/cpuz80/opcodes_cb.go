@@ -8,6 +8,15 @@ func (cpu *CPUZ80) executeCB() error {
 	r := opcode & 0x07
 	op := opcode >> 3
 
+	switch {
+	case r != 6:
+		cpu.Cycles += cyclesCBReg
+	case op >= 8 && op < 16:
+		cpu.Cycles += cyclesCBBitMem
+	default:
+		cpu.Cycles += cyclesCBMem
+	}
+
 	if op < 8 {
 		// Rotate/shift operations
 		val := cpu.getReg8(r)
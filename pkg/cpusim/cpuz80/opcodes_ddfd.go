@@ -14,6 +14,7 @@ func (cpu *CPUZ80) executeFD() error {
 func (cpu *CPUZ80) executeIndexed(idx *uint16) error {
 	cpu.incR()
 	opcode := cpu.fetchByte()
+	cpu.Cycles += cyclesPrefix + uint64(cyclesMain[opcode]) + uint64(cyclesIndexedDisp[opcode])
 
 	switch opcode {
 	case 0x09: // ADD IX/IY,BC
@@ -340,7 +341,8 @@ func (cpu *CPUZ80) executeIndexed(idx *uint16) error {
 		return cpu.executeIndexedCB(idx)
 
 	default:
-		// Unrecognized DD/FD opcodes fall through to the unprefixed handler
+		// Unrecognized DD/FD opcodes fall through to the unprefixed handler,
+		// which has already been charged for above
 		return cpu.executeUnprefixed(opcode)
 	}
 }
@@ -357,6 +359,12 @@ func (cpu *CPUZ80) executeIndexedCB(idx *uint16) error {
 	r := opcode & 0x07
 	op := opcode >> 3
 
+	if op >= 8 && op < 16 {
+		cpu.Cycles += cyclesIndexedBit
+	} else {
+		cpu.Cycles += cyclesIndexedCB
+	}
+
 	if op < 8 {
 		// Rotate/shift on (IX/IY+d), result also stored in register (undocumented)
 		val := cpu.readByte(addr)
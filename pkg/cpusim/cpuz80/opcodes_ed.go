@@ -4,6 +4,7 @@ package cpuz80
 func (cpu *CPUZ80) executeED() error {
 	cpu.incR()
 	opcode := cpu.fetchByte()
+	cpu.Cycles += uint64(cyclesED[opcode])
 
 	switch opcode {
 	// IN r,(C) - 0x40,0x48,0x50,0x58,0x60,0x68,0x70,0x78
@@ -149,6 +150,10 @@ func (cpu *CPUZ80) executeED() error {
 		cpu.IFF1 = cpu.IFF2
 		cpu.PC = cpu.pop()
 		cpu.WZ = cpu.PC
+		// Peripherals only decode the documented ED 4D encoding
+		if opcode == 0x4D && cpu.RETIHook != nil {
+			cpu.RETIHook()
+		}
 		return nil
 
 	// IM 0
@@ -256,6 +261,7 @@ func (cpu *CPUZ80) executeED() error {
 		cpu.ldi()
 		if cpu.getBC() != 0 {
 			cpu.PC -= 2
+			cpu.Cycles += cyclesBlockRepeat
 			cpu.WZ = cpu.PC + 1
 			cpu.blockRepeatFlags()
 		}
@@ -267,6 +273,7 @@ func (cpu *CPUZ80) executeED() error {
 		cpu.ldd()
 		if cpu.getBC() != 0 {
 			cpu.PC -= 2
+			cpu.Cycles += cyclesBlockRepeat
 			cpu.WZ = cpu.PC + 1
 			cpu.blockRepeatFlags()
 		}
@@ -280,6 +287,7 @@ func (cpu *CPUZ80) executeED() error {
 		cpu.cpi()
 		if cpu.getBC() != 0 && cpu.F&MaskZ == 0 {
 			cpu.PC -= 2
+			cpu.Cycles += cyclesBlockRepeat
 			cpu.WZ = cpu.PC + 1
 			cpu.blockRepeatFlags()
 		}
@@ -291,6 +299,7 @@ func (cpu *CPUZ80) executeED() error {
 		cpu.cpd()
 		if cpu.getBC() != 0 && cpu.F&MaskZ == 0 {
 			cpu.PC -= 2
+			cpu.Cycles += cyclesBlockRepeat
 			cpu.WZ = cpu.PC + 1
 			cpu.blockRepeatFlags()
 		}
@@ -304,6 +313,7 @@ func (cpu *CPUZ80) executeED() error {
 		cpu.ini()
 		if cpu.B != 0 {
 			cpu.PC -= 2
+			cpu.Cycles += cyclesBlockRepeat
 			cpu.WZ = cpu.PC + 1
 			cpu.blockRepeatIOFlags()
 		}
@@ -315,6 +325,7 @@ func (cpu *CPUZ80) executeED() error {
 		cpu.ind()
 		if cpu.B != 0 {
 			cpu.PC -= 2
+			cpu.Cycles += cyclesBlockRepeat
 			cpu.WZ = cpu.PC + 1
 			cpu.blockRepeatIOFlags()
 		}
@@ -326,6 +337,7 @@ func (cpu *CPUZ80) executeED() error {
 		cpu.outi()
 		if cpu.B != 0 {
 			cpu.PC -= 2
+			cpu.Cycles += cyclesBlockRepeat
 			cpu.WZ = cpu.PC + 1
 			cpu.blockRepeatIOFlags()
 		}
@@ -337,6 +349,7 @@ func (cpu *CPUZ80) executeED() error {
 		cpu.outd()
 		if cpu.B != 0 {
 			cpu.PC -= 2
+			cpu.Cycles += cyclesBlockRepeat
 			cpu.WZ = cpu.PC + 1
 			cpu.blockRepeatIOFlags()
 		}
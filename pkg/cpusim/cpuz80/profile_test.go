@@ -0,0 +1,71 @@
+package cpuz80
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfiler(t *testing.T) {
+	// 0100: LD B,3; loop: CALL sub; DJNZ loop; HALT
+	// 0110: sub: NOP; RET
+	cpu, ram := setupProgram(t, []byte{0x06, 0x03, 0xCD, 0x10, 0x01, 0x10, 0xFB, 0x76})
+	copy(ram.Contents[0x110:], []byte{0x00, 0xC9})
+	sim := cpu.Sim
+	table := cpusim.NewSymbolTable(sim)
+	table.Add(cpusim.Symbol{Name: "SUB", Address: 0x110, Bank: cpusim.NoBank})
+	sim.Symbols = table
+
+	p := cpusim.NewProfiler(sim)
+	require.NoError(t, p.Start())
+	for i := 0; i < 14; i++ {
+		require.NoError(t, cpu.Execute())
+		require.NoError(t, sim.AfterStep())
+	}
+	p.Stop()
+
+	assert.Equal(t, cpusim.Cost{Count: 14, Cycles: 138}, p.Total())
+	assert.Equal(t, cpusim.Cost{Count: 3, Cycles: 51}, p.AddressCost(0x102))
+
+	funcs := p.Functions()
+	require.Len(t, funcs, 2)
+	main, sub := funcs[0], funcs[1]
+	assert.Equal(t, cpusim.Address(0x100), main.Entry)
+	assert.Equal(t, cpusim.Cost{Count: 8, Cycles: 96}, main.Exclusive)
+	assert.Equal(t, p.Total(), main.Inclusive)
+	assert.Equal(t, cpusim.Address(0x110), sub.Entry)
+	assert.Equal(t, uint64(3), sub.Calls)
+	assert.Equal(t, cpusim.Cost{Count: 6, Cycles: 42}, sub.Exclusive)
+	assert.Equal(t, sub.Exclusive, sub.Inclusive)
+
+	var report strings.Builder
+	require.NoError(t, p.WriteReport(&report))
+	assert.Contains(t, report.String(), "Profile: 14 instructions, 138 cycles")
+	assert.Regexp(t, `0102-0105\s+2\s+`, report.String())
+	assert.Regexp(t, `SUB\s+3\s+42\s+`, report.String())
+
+	var callgrind strings.Builder
+	require.NoError(t, p.WriteCallgrind(&callgrind))
+	assert.Contains(t, callgrind.String(), "fn=(2)\n0x0110 12 3\n0x0111 30 3\n")
+	assert.Contains(t, callgrind.String(), "cfn=(2) SUB\ncalls=3 0x0110\n0x0102 42 6\n")
+}
+
+func TestProfilerSections(t *testing.T) {
+	cpu, _ := setupProgram(t, []byte{0x00, 0x00, 0x00})
+	p := cpusim.NewProfiler(cpu.Sim)
+
+	p.BeginSection("a")
+	step(t, cpu, 1)
+	p.BeginSection("b")
+	step(t, cpu, 2)
+	p.BeginSection("a")
+	p.BeginSection("")
+
+	sections := p.Sections()
+	require.Len(t, sections, 2)
+	assert.Equal(t, cpusim.SectionProfile{Name: "b", Count: 1, Cycles: 8, MaxCycles: 8}, *sections[0])
+	assert.Equal(t, cpusim.SectionProfile{Name: "a", Count: 2, Cycles: 4, MaxCycles: 4}, *sections[1])
+}
@@ -0,0 +1,107 @@
+package cpuz80
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewind(t *testing.T) {
+	// LD HL,2000h; loop: IN A,(81h); LD (HL),A; INC HL; JR loop
+	cpu, ram := setupProgram(t, []byte{0x21, 0x00, 0x20, 0xDB, 0x81, 0x77, 0x23, 0x18, 0xFA})
+	cpu.PortAddressMask = 0xFF
+	sim := cpu.Sim
+	acia := cpusim.NewACIA(sim, cpusim.NewChannelSerial(), "acia", 0x81, 0x80, &cpusim.AlwaysEnabled)
+	sim.AddPort(acia)
+
+	rewinder, err := cpusim.NewRewinder(sim, 16, 0)
+	require.NoError(t, err)
+
+	run := func(n int) {
+		for i := 0; i < n; i++ {
+			require.NoError(t, cpu.Execute())
+			require.NoError(t, sim.AfterStep())
+		}
+	}
+
+	run(10)
+	sim.DeliverInput(acia, 'x')
+	run(30)
+	require.Equal(t, uint64(40), rewinder.Count())
+	pc, hl := cpu.PC, cpu.getHL()
+	written := append([]byte{}, ram.Contents[0x2000:0x2010]...)
+	assert.Contains(t, written, byte('x'))
+
+	// Step back and run forward again; the input is replayed at the same point
+	require.NoError(t, rewinder.StepBack(25))
+	assert.Equal(t, uint64(15), rewinder.Count())
+	run(25)
+	assert.Equal(t, pc, cpu.PC)
+	assert.Equal(t, hl, cpu.getHL())
+	assert.Equal(t, written, ram.Contents[0x2000:0x2010])
+
+	// The fourth pass through the loop stores to 2003h
+	found, err := rewinder.RunBackToWrite(0x2003)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, uint64(14), rewinder.Count())
+	assert.Equal(t, uint16(0x0105), cpu.PC)
+	assert.Equal(t, uint16(0x2003), cpu.getHL())
+
+	found, err = rewinder.RunBackToWrite(0x3000)
+	require.NoError(t, err)
+	assert.False(t, found)
+	assert.Equal(t, uint64(14), rewinder.Count())
+}
+
+func TestRewindCTC(t *testing.T) {
+	cpu, ram := setupProgram(t, []byte{
+		0x3E, 0x20, 0xED, 0x47, 0xED, 0x5E, // LD A,20h; LD I,A; IM 2
+		0x3E, 0x40, 0xD3, 0x88, // LD A,40h; OUT (88h),A - vector
+		0x3E, 0x85, 0xD3, 0x88, // LD A,85h; OUT (88h),A - channel 0 timer, /16, interrupts on
+		0x3E, 0x04, 0xD3, 0x88, // LD A,4; OUT (88h),A - time constant
+		0xFB, 0x18, 0xFE, // EI; JR $
+	})
+	cpu.PortAddressMask = 0xFF
+	sim := cpu.Sim
+
+	// Handler: LD HL,8000h; INC (HL); EI; RETI
+	copy(ram.Contents[0x0300:], []byte{0x21, 0x00, 0x80, 0x34, 0xFB, 0xED, 0x4D})
+	ram.Contents[0x2040] = 0x00
+	ram.Contents[0x2041] = 0x03
+
+	ctc := cpusim.NewCTC(sim, "ctc", 0x88, &cpusim.AlwaysEnabled)
+	sim.AddPort(ctc)
+	chain := cpusim.NewDaisyChain(sim, "daisy", sim.Signal(cpusim.SIGNAL_INT))
+	require.NoError(t, cpu.ConnectDaisyChain(chain))
+	chain.Add(ctc)
+
+	rewinder, err := cpusim.NewRewinder(sim, 64, 0)
+	require.NoError(t, err)
+
+	save := func() []byte {
+		var buf bytes.Buffer
+		require.NoError(t, sim.SaveState(&buf))
+		return buf.Bytes()
+	}
+
+	for rewinder.Count() < 100 {
+		require.NoError(t, sim.Step(cpu))
+	}
+	at100, ticks := save(), ram.Contents[0x8000]
+	for rewinder.Count() < 300 {
+		require.NoError(t, sim.Step(cpu))
+	}
+	at300 := save()
+	require.Greater(t, ram.Contents[0x8000], ticks, "the timer interrupted between 100 and 300")
+
+	// Rewinding replays from the checkpoint at 64, across timer interrupts
+	require.NoError(t, rewinder.RewindTo(100))
+	assert.Equal(t, at100, save())
+
+	require.NoError(t, rewinder.RewindTo(300))
+	assert.Equal(t, at300, save())
+}
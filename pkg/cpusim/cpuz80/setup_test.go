@@ -0,0 +1,35 @@
+package cpuz80
+
+import (
+	"testing"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+	"github.com/stretchr/testify/require"
+)
+
+// setupProgram returns a Z80 with 64K of RAM, about to run program at 0100h.
+func setupProgram(t *testing.T, program []byte) (*CPUZ80, *cpusim.Memory) {
+	t.Helper()
+
+	sim := cpusim.NewCPUSim()
+	sim.SetDebug(false)
+
+	cpu := NewZ80(sim, "test-cpu")
+	sim.AddCPU(cpu)
+
+	ram := cpusim.NewMemory(sim, "ram", cpusim.KIND_RAM, 0x0000, 0xFFFF, 16, false, &cpusim.AlwaysEnabled)
+	sim.AddMemory(ram)
+
+	copy(ram.Contents[0x0100:], program)
+	cpu.PC = 0x0100
+	cpu.SP = 0xF000
+	return cpu, ram
+}
+
+// step executes n instructions, without the step hooks.
+func step(t *testing.T, cpu *CPUZ80, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		require.NoError(t, cpu.Execute())
+	}
+}
@@ -0,0 +1,56 @@
+package cpuz80
+
+import (
+	"encoding/json"
+)
+
+type z80State struct {
+	A, F, B, C, D, E, H, L byte
+	AF_, BC_, DE_, HL_     uint16
+	IX, IY, SP, PC         uint16
+	I, R                   byte
+	IFF1, IFF2             bool
+	IM                     byte
+	EIPending              bool
+	InHalt                 bool
+	WZ                     uint16
+	Q, PrevQ               byte
+	Cycles                 uint64
+	NMIPending             bool
+}
+
+func (cpu *CPUZ80) SaveState() (any, error) {
+	return &z80State{
+		A: cpu.A, F: cpu.F, B: cpu.B, C: cpu.C, D: cpu.D, E: cpu.E, H: cpu.H, L: cpu.L,
+		AF_: cpu.AF_, BC_: cpu.BC_, DE_: cpu.DE_, HL_: cpu.HL_,
+		IX: cpu.IX, IY: cpu.IY, SP: cpu.SP, PC: cpu.PC,
+		I: cpu.I, R: cpu.R,
+		IFF1: cpu.IFF1, IFF2: cpu.IFF2, IM: cpu.IM,
+		EIPending:  cpu.EIPending,
+		InHalt:     cpu.InHalt,
+		WZ:         cpu.WZ,
+		Q:          cpu.Q,
+		PrevQ:      cpu.PrevQ,
+		Cycles:     cpu.Cycles,
+		NMIPending: cpu.nmiPending.Load(),
+	}, nil
+}
+
+func (cpu *CPUZ80) RestoreState(data json.RawMessage) error {
+	var s z80State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	cpu.A, cpu.F, cpu.B, cpu.C, cpu.D, cpu.E, cpu.H, cpu.L = s.A, s.F, s.B, s.C, s.D, s.E, s.H, s.L
+	cpu.AF_, cpu.BC_, cpu.DE_, cpu.HL_ = s.AF_, s.BC_, s.DE_, s.HL_
+	cpu.IX, cpu.IY, cpu.SP, cpu.PC = s.IX, s.IY, s.SP, s.PC
+	cpu.I, cpu.R = s.I, s.R
+	cpu.IFF1, cpu.IFF2, cpu.IM = s.IFF1, s.IFF2, s.IM
+	cpu.EIPending = s.EIPending
+	cpu.InHalt = s.InHalt
+	cpu.WZ = s.WZ
+	cpu.Q, cpu.PrevQ = s.Q, s.PrevQ
+	cpu.Cycles = s.Cycles
+	cpu.nmiPending.Store(s.NMIPending)
+	return nil
+}
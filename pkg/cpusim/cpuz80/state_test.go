@@ -0,0 +1,43 @@
+package cpuz80
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveRestoreState(t *testing.T) {
+	// LD HL,0x2000; loop: INC (HL); INC A; EXX; INC BC; EXX; JR loop
+	program := []byte{0x21, 0x00, 0x20, 0x34, 0x3C, 0xD9, 0x03, 0xD9, 0x18, 0xF9}
+
+	cpu, ram := setupProgram(t, program)
+	step(t, cpu, 100)
+
+	var snapshot bytes.Buffer
+	require.NoError(t, cpu.Sim.SaveState(&snapshot))
+
+	step(t, cpu, 50)
+
+	restored, restoredRAM := setupProgram(t, nil)
+	require.NoError(t, restored.Sim.RestoreState(bytes.NewReader(snapshot.Bytes())))
+	step(t, restored, 50)
+
+	assert.Equal(t, cpu.PC, restored.PC)
+	assert.Equal(t, cpu.A, restored.A)
+	assert.Equal(t, cpu.BC_, restored.BC_)
+	assert.Equal(t, cpu.Cycles, restored.Cycles)
+	assert.Equal(t, ram.Contents, restoredRAM.Contents)
+}
+
+func TestRestoreStateMismatch(t *testing.T) {
+	cpu, _ := setupProgram(t, []byte{0x00})
+
+	var snapshot bytes.Buffer
+	require.NoError(t, cpu.Sim.SaveState(&snapshot))
+
+	other, _ := setupProgram(t, nil)
+	other.Name = "other-cpu"
+	assert.Error(t, other.Sim.RestoreState(bytes.NewReader(snapshot.Bytes())))
+}
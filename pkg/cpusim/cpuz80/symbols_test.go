@@ -0,0 +1,38 @@
+package cpuz80
+
+import (
+	"testing"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBankedSymbols(t *testing.T) {
+	// The RC2014 512K board: 16K pages selected by ports 78-7B
+	cpu, _ := setupProgram(t, nil)
+	sim := cpu.Sim
+	mapper := cpusim.NewDual74670(sim, "mapper", 0x78, cpusim.A14, cpusim.D0, cpusim.A14, cpusim.A15, cpusim.A16, cpusim.A17, cpusim.A18, -1, -1, -1, &cpusim.AlwaysEnabled, &cpusim.AlwaysEnabled)
+	sim.AddMapper(mapper)
+
+	table := cpusim.NewSymbolTable(sim)
+	table.Add(cpusim.Symbol{Name: "RESET", Address: 0x0000, Bank: cpusim.NoBank})
+	table.Add(cpusim.Symbol{Name: "BIOS", Address: 0x0100, Bank: 0x00})
+	table.Add(cpusim.Symbol{Name: "CCP", Address: 0x0100, Bank: 0x21})
+	table.Add(cpusim.Symbol{Name: "BDOS", Address: 0x3F00, Bank: 0x21})
+	sim.Symbols = table
+
+	require.NoError(t, mapper.Write(0x78, 0x00))
+	assert.Equal(t, "BIOS+3", sim.DescribeAddress(0x0103))
+
+	require.NoError(t, mapper.Write(0x78, 0x21))
+	assert.Equal(t, "CCP+3", sim.DescribeAddress(0x0103))
+	assert.Equal(t, "RESET+50", sim.DescribeAddress(0x0050))
+
+	// Bank 21 is not mapped at 4000, so the unbanked symbol is used there
+	assert.Equal(t, "RESET+4000", sim.DescribeAddress(0x4000))
+
+	name, ok := table.LookupSymbol(0x3F00)
+	assert.True(t, ok)
+	assert.Equal(t, "BDOS", name)
+}
@@ -0,0 +1,76 @@
+package cpuz80
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCycles(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []byte
+		steps   int
+		cycles  uint64
+	}{
+		{"NOP", []byte{0x00}, 1, 4},
+		{"LD BC,nn", []byte{0x01, 0x34, 0x12}, 1, 10},
+		{"LD (HL),n", []byte{0x36, 0x00}, 1, 10},
+		{"JR taken", []byte{0x18, 0x00}, 1, 12},
+		{"JR NZ not taken", []byte{0xAF, 0x20, 0x00}, 2, 4 + 7},
+		{"JR Z taken", []byte{0xAF, 0x28, 0x00}, 2, 4 + 12},
+		{"DJNZ taken", []byte{0x06, 0x02, 0x10, 0x00}, 2, 7 + 13},
+		{"CALL and RET", []byte{0xCD, 0x04, 0x01, 0x00, 0xC9}, 2, 17 + 10},
+		{"CALL NZ not taken", []byte{0xAF, 0xC4, 0x00, 0x00}, 2, 4 + 10},
+		{"RET Z taken", []byte{0xCD, 0x04, 0x01, 0x00, 0xAF, 0xC8}, 3, 17 + 4 + 11},
+		{"BIT 0,B", []byte{0xCB, 0x40}, 1, 8},
+		{"BIT 0,(HL)", []byte{0xCB, 0x46}, 1, 12},
+		{"RLC (HL)", []byte{0xCB, 0x06}, 1, 15},
+		{"LD IX,nn", []byte{0xDD, 0x21, 0x00, 0x20}, 1, 14},
+		{"LD A,(IX+d)", []byte{0xDD, 0x7E, 0x01}, 1, 19},
+		{"LD (IX+d),n", []byte{0xDD, 0x36, 0x01, 0x00}, 1, 19},
+		{"INC (IX+d)", []byte{0xDD, 0x34, 0x01}, 1, 23},
+		{"DD NOP", []byte{0xDD, 0x00}, 1, 8},
+		{"SET 0,(IX+d)", []byte{0xDD, 0xCB, 0x01, 0xC6}, 1, 23},
+		{"BIT 0,(IY+d)", []byte{0xFD, 0xCB, 0x01, 0x46}, 1, 20},
+		{"SBC HL,BC", []byte{0xED, 0x42}, 1, 15},
+		{"LDI", []byte{0x01, 0x02, 0x00, 0xED, 0xA0}, 2, 10 + 16},
+		{"LDIR", []byte{0x01, 0x02, 0x00, 0xED, 0xB0}, 3, 10 + 21 + 16},
+		{"undefined ED", []byte{0xED, 0x00}, 1, 8},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu, _ := setupProgram(t, tc.program)
+			step(t, cpu, tc.steps)
+			assert.Equal(t, tc.cycles, cpu.Cycles)
+		})
+	}
+}
+
+func TestInterruptCycles(t *testing.T) {
+	// IM 1; EI; NOP
+	cpu, _ := setupProgram(t, []byte{0xED, 0x56, 0xFB, 0x00})
+	cpu.SetINT(true)
+	step(t, cpu, 4)
+	assert.Equal(t, uint64(8+4+4+13), cpu.Cycles)
+
+	// IM 2; EI; NOP
+	cpu, _ = setupProgram(t, []byte{0xED, 0x5E, 0xFB, 0x00})
+	cpu.SetINT(true)
+	step(t, cpu, 4)
+	assert.Equal(t, uint64(8+4+4+19), cpu.Cycles)
+
+	// IM 0 with RST 38h on the bus
+	cpu, _ = setupProgram(t, []byte{0xFB, 0x00})
+	cpu.SetINT(true)
+	step(t, cpu, 3)
+	assert.Equal(t, uint64(4+4+13), cpu.Cycles)
+
+	// NMI during HALT
+	cpu, _ = setupProgram(t, []byte{0x76})
+	step(t, cpu, 3)
+	cpu.NMI()
+	step(t, cpu, 1)
+	assert.Equal(t, uint64(4+4+4+11), cpu.Cycles)
+}
@@ -0,0 +1,144 @@
+package cpuz80
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// traceProgram runs a short program under a tracer and returns the trace.
+func traceProgram(t *testing.T, format string, setup func(*cpusim.Tracer)) []byte {
+	t.Helper()
+	// LD A,42H; LD (8000H),A; OUT (10H),A; INC A; INC A; HALT
+	cpu, _ := setupProgram(t, []byte{0x3E, 0x42, 0x32, 0x00, 0x80, 0xD3, 0x10, 0x3C, 0x3C, 0x76})
+	cpu.PortAddressMask = 0xFF
+
+	var out bytes.Buffer
+	tracer, err := cpusim.NewTracer(cpu.Sim, &out, format)
+	require.NoError(t, err)
+	if setup != nil {
+		setup(tracer)
+	}
+	require.NoError(t, tracer.Start())
+	for i := 0; i < 5; i++ {
+		require.NoError(t, cpu.Execute())
+		require.NoError(t, cpu.Sim.AfterStep())
+	}
+	require.NoError(t, tracer.Close())
+	return out.Bytes()
+}
+
+func TestTraceJSON(t *testing.T) {
+	var records []cpusim.TraceRecord
+	scanner := bufio.NewScanner(bytes.NewReader(traceProgram(t, cpusim.TraceJSON, nil)))
+	for scanner.Scan() {
+		var rec cpusim.TraceRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &rec))
+		records = append(records, rec)
+	}
+	require.Len(t, records, 5)
+
+	assert.Equal(t, cpusim.Address(0x100), records[0].PC)
+	assert.Equal(t, "3E 42", records[0].Hex)
+	assert.Equal(t, "LD A,42H", records[0].Text)
+	assert.Equal(t, byte(0x00), records[0].Before["A"])
+	assert.Equal(t, byte(0x42), records[0].After["A"])
+	assert.Equal(t, cpusim.Address(0x102), records[0].Next)
+	assert.Equal(t, uint64(7), records[0].Cycles)
+	assert.Empty(t, records[0].Accesses, "fetches are not accesses")
+
+	assert.Equal(t, []cpusim.TraceAccess{{Op: cpusim.TraceWrite, Address: 0x8000, Physical: 0x8000, Value: 0x42}}, records[1].Accesses)
+	assert.Equal(t, []cpusim.TraceAccess{{Op: cpusim.TracePortOut, Address: 0x10, Physical: 0x10, Value: 0x42}}, records[2].Accesses)
+	assert.Equal(t, uint64(5), records[4].Seq)
+}
+
+func TestTraceBinary(t *testing.T) {
+	r, err := cpusim.ReadTrace(bytes.NewReader(traceProgram(t, cpusim.TraceBinary, nil)))
+	require.NoError(t, err)
+	assert.Contains(t, r.Names, "A")
+
+	var records []*cpusim.TraceRecord
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		records = append(records, rec)
+	}
+	require.Len(t, records, 5)
+	assert.Equal(t, []byte{0x32, 0x00, 0x80}, records[1].Bytes)
+	assert.Equal(t, byte(0x42), records[1].Before["A"])
+	assert.Equal(t, []cpusim.TraceAccess{{Op: cpusim.TraceWrite, Address: 0x8000, Physical: 0x8000, Value: 0x42}}, records[1].Accesses)
+	assert.Equal(t, byte(0x44), records[4].After["A"])
+}
+
+func TestTraceTriggers(t *testing.T) {
+	start, stop := cpusim.Address(0x102), cpusim.Address(0x107)
+	out := traceProgram(t, cpusim.TraceJSON, func(tr *cpusim.Tracer) {
+		tr.StartAt = &start
+		tr.StopAt = &stop
+		tr.Ranges = []cpusim.AddressRange{{Start: 0x100, End: 0x105}, {Start: 0x107, End: 0x107}}
+	})
+
+	var pcs []cpusim.Address
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		var rec cpusim.TraceRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &rec))
+		pcs = append(pcs, rec.PC)
+	}
+	assert.Equal(t, []cpusim.Address{0x102, 0x105, 0x107}, pcs)
+}
+
+func TestTraceBankSwitch(t *testing.T) {
+	sim := cpusim.NewCPUSim()
+	sim.SetDebug(false)
+	cpu := NewZ80(sim, "test-cpu")
+	cpu.PortAddressMask = 0xFF
+	sim.AddCPU(cpu)
+	ram := cpusim.NewMemory(sim, "ram", cpusim.KIND_RAM, 0x00000, 0x7FFFF, 19, false, &cpusim.AlwaysEnabled)
+	sim.AddMemory(ram)
+	mapper := cpusim.NewDual74670(sim, "mapper", 0x78, cpusim.A14, cpusim.D0, cpusim.A14, cpusim.A15, cpusim.A16, cpusim.A17, cpusim.A18, -1, -1, -1, &cpusim.AlwaysEnabled, &cpusim.AlwaysEnabled)
+	sim.AddMapper(mapper)
+	sim.AddPort(mapper)
+
+	copy(ram.Contents[0x0100:], []byte{
+		0x3E, 0x01, 0xD3, 0x79, // LD A,1; OUT (79h),A - bank 1 at 4000h
+		0x3E, 0x42, 0x32, 0x00, 0x40, // LD A,42h; LD (4000h),A
+		0x3E, 0x05, 0xD3, 0x79, // LD A,5; OUT (79h),A - bank 5 at 4000h
+		0x3E, 0x43, 0x32, 0x00, 0x40, // LD A,43h; LD (4000h),A
+	})
+	cpu.PC = 0x0100
+
+	var out bytes.Buffer
+	tracer, err := cpusim.NewTracer(sim, &out, cpusim.TraceJSON)
+	require.NoError(t, err)
+	require.NoError(t, tracer.Start())
+	for i := 0; i < 8; i++ {
+		require.NoError(t, cpu.Execute())
+		require.NoError(t, sim.AfterStep())
+	}
+	require.NoError(t, tracer.Close())
+
+	var records []cpusim.TraceRecord
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var rec cpusim.TraceRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &rec))
+		records = append(records, rec)
+	}
+	require.Len(t, records, 8)
+
+	// The same CPU address lands in the bank that was mapped at the time
+	assert.Equal(t, []cpusim.TraceAccess{{Op: cpusim.TraceWrite, Address: 0x4000, Physical: 0x04000, Value: 0x42}}, records[3].Accesses)
+	assert.Equal(t, []cpusim.TraceAccess{{Op: cpusim.TraceWrite, Address: 0x4000, Physical: 0x14000, Value: 0x43}}, records[7].Accesses)
+	assert.Equal(t, byte(0x42), ram.Contents[0x04000])
+	assert.Equal(t, byte(0x43), ram.Contents[0x14000])
+}
@@ -0,0 +1,431 @@
+package cpusim
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// CTC implements a Zilog Z80 CTC counter/timer circuit.
+//
+// The CTC has four channels, at BaseAddress to BaseAddress+3. Writing a
+// channel's port with bit 0 set loads its control word:
+//   - Bit 7: interrupt enable
+//   - Bit 6: counter mode (1) or timer mode (0)
+//   - Bit 5: timer prescaler of 256 (1) or 16 (0)
+//   - Bit 4: CLK/TRG edge (not modeled)
+//   - Bit 3: in timer mode, wait for a CLK/TRG pulse before starting
+//   - Bit 2: a time constant follows
+//   - Bit 1: software reset, which stops the channel
+//
+// The byte after a control word with bit 2 set is the time constant, where
+// 0 means 256. A stopped channel starts counting once it has one; a running
+// channel reloads it at the next zero count. Any other byte written to
+// channel 0 is the interrupt vector. Reading a channel returns its down
+// counter.
+//
+// In timer mode the counter counts down once every 16 or 256 clock cycles of
+// the CPU, so the CTC keeps time with the machine's own clock; in counter
+// mode it counts the pulses given to Trigger. When a counter reaches zero it
+// reloads, and requests an interrupt if the channel's interrupts are on.
+//
+// On a mode 2 daisy chain the CTC supplies the vector with the channel in
+// bits 2-1 during the acknowledge cycle. Channel 0 has the highest priority.
+// Once acknowledged a channel stays in service until the CTC sees RETI, and
+// holds off interrupts from itself, the channels behind it and lower-priority
+// devices; a higher-priority channel can still interrupt its handler.
+type CTC struct {
+	Sim         *CpuSim
+	Name        string
+	BaseAddress Address
+	Enabler     EnablerInterface
+	Interrupt   *SignalLine
+	InService   *SignalLine
+	mu          sync.Mutex
+	channels    [4]ctcChannel
+	vector      byte
+	lastCycles  uint64 // the CPU's clock cycles when the timers were last advanced
+}
+
+type ctcChannel struct {
+	control      byte
+	constant     int  // time constant, 1-256
+	count        int  // down counter, 1-256
+	phase        int  // clock cycles counted towards the next timer count
+	running      bool // counting
+	waitConstant bool // the next write is the time constant
+	waitTrigger  bool // a timer waiting for a CLK/TRG pulse to start
+	intPending   bool
+	ius          bool // an acknowledged interrupt is under service
+}
+
+// Control word bits
+const (
+	ctcControl     = 0x01
+	ctcReset       = 0x02
+	ctcConstant    = 0x04
+	ctcTrigger     = 0x08
+	ctcPrescale256 = 0x20
+	ctcCounter     = 0x40
+	ctcInterrupt   = 0x80
+)
+
+func (c *CTC) GetName() string {
+	return c.Name
+}
+
+func (c *CTC) HasAddress(address Address) bool {
+	if !c.Enabler.Bool() {
+		return false
+	}
+	return address >= c.BaseAddress && address < c.BaseAddress+4
+}
+
+func (c *CTC) DecodeEnabler() EnablerInterface {
+	return c.Enabler
+}
+
+func (c *CTC) Read(address Address) (byte, error) {
+	if !c.HasAddress(address) {
+		return 0, &ErrInvalidAddress{Address: address}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return byte(c.channels[address-c.BaseAddress].count), nil
+}
+
+func (c *CTC) Write(address Address, value byte) error {
+	if !c.HasAddress(address) {
+		return &ErrInvalidAddress{Address: address}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := int(address - c.BaseAddress)
+	ch := &c.channels[n]
+	switch {
+	case ch.waitConstant:
+		ch.waitConstant = false
+		ch.constant = int(value)
+		if ch.constant == 0 {
+			ch.constant = 256
+		}
+		if !ch.running && !ch.waitTrigger {
+			if ch.control&(ctcCounter|ctcTrigger) == ctcTrigger {
+				ch.waitTrigger = true
+			} else {
+				c.start(ch)
+			}
+		}
+	case value&ctcControl != 0:
+		ch.control = value
+		if value&ctcReset != 0 {
+			ch.running = false
+			ch.waitTrigger = false
+		}
+		ch.waitConstant = value&ctcConstant != 0
+		if value&ctcInterrupt == 0 {
+			ch.intPending = false
+		}
+	case n == 0:
+		c.vector = value & 0xF8
+	}
+	c.updateInterrupt()
+	return nil
+}
+
+// start starts a channel counting down from its time constant. Caller holds
+// c.mu.
+func (c *CTC) start(ch *ctcChannel) {
+	ch.running = true
+	ch.waitTrigger = false
+	ch.count = ch.constant
+	ch.phase = 0
+}
+
+// prescaler returns the clock cycles per count of a timer.
+func (ch *ctcChannel) prescaler() uint64 {
+	if ch.control&ctcPrescale256 != 0 {
+		return 256
+	}
+	return 16
+}
+
+// countDown counts a channel down n times, reloading it at each zero count.
+// Caller holds c.mu.
+func (c *CTC) countDown(ch *ctcChannel, n uint64) {
+	if n < uint64(ch.count) {
+		ch.count -= int(n)
+		return
+	}
+	n -= uint64(ch.count)
+	ch.count = ch.constant - int(n%uint64(ch.constant))
+	if ch.control&ctcInterrupt != 0 {
+		ch.intPending = true
+	}
+}
+
+// Trigger gives a pulse on a channel's CLK/TRG input. It counts a channel in
+// counter mode, and starts a timer that is waiting for it.
+func (c *CTC) Trigger(channel int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := &c.channels[channel]
+	switch {
+	case ch.waitTrigger:
+		c.start(ch)
+	case ch.running && ch.control&ctcCounter != 0:
+		c.countDown(ch, 1)
+		c.updateInterrupt()
+	}
+}
+
+// Advance runs the timers for a number of clock cycles.
+func (c *CTC) Advance(cycles uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.advance(cycles)
+}
+
+// advance runs the timers. Caller holds c.mu.
+func (c *CTC) advance(cycles uint64) {
+	for i := range c.channels {
+		ch := &c.channels[i]
+		if !ch.running || ch.control&ctcCounter != 0 {
+			continue
+		}
+		total := uint64(ch.phase) + cycles
+		ch.phase = int(total % ch.prescaler())
+		if counts := total / ch.prescaler(); counts > 0 {
+			c.countDown(ch, counts)
+		}
+	}
+	c.updateInterrupt()
+}
+
+// step is a step hook that advances the timers by the clock cycles the CPU
+// has run since the last instruction. A CPU that doesn't count its clock
+// cycles is taken to run one per instruction.
+func (c *CTC) step() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.Sim.CPU) == 0 {
+		return nil
+	}
+	counter, ok := c.Sim.CPU[0].(CycleCounter)
+	if !ok {
+		c.advance(1)
+		return nil
+	}
+	cycles := counter.ClockCycles()
+	if cycles > c.lastCycles {
+		c.advance(cycles - c.lastCycles)
+	}
+	c.lastCycles = cycles
+	return nil
+}
+
+// ConnectInterrupt connects the CTC's /INT output to a signal line.
+func (c *CTC) ConnectInterrupt(line *SignalLine) {
+	c.Interrupt = line
+}
+
+// ConnectDaisyChain connects the CTC to a mode 2 interrupt daisy chain.
+func (c *CTC) ConnectDaisyChain(request, inService *SignalLine) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Interrupt = request
+	c.InService = inService
+	c.updateInterrupt()
+}
+
+// pendingChannel returns the highest-priority channel with an interrupt
+// pending, or -1. Caller holds c.mu.
+func (c *CTC) pendingChannel() int {
+	for i, ch := range c.channels {
+		if ch.intPending {
+			return i
+		}
+	}
+	return -1
+}
+
+// inService returns the highest-priority channel in service, or 4 if none
+// is. That channel and those behind it can't interrupt. Caller holds c.mu.
+func (c *CTC) inService() int {
+	for i, ch := range c.channels {
+		if ch.ius {
+			return i
+		}
+	}
+	return len(c.channels)
+}
+
+// InterruptAcknowledge returns the vector of the highest-priority pending
+// channel and puts that channel in service.
+func (c *CTC) InterruptAcknowledge() byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := c.pendingChannel()
+	if n < 0 {
+		return 0xFF
+	}
+	c.channels[n].intPending = false
+	c.channels[n].ius = true
+	c.updateInterrupt()
+	return c.vector | byte(n)<<1
+}
+
+// ReturnFromInterrupt ends the service of the highest-priority channel in
+// service when RETI is decoded.
+func (c *CTC) ReturnFromInterrupt() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range c.channels {
+		if c.channels[i].ius {
+			c.channels[i].ius = false
+			break
+		}
+	}
+	c.updateInterrupt()
+}
+
+// updateInterrupt drives the interrupt lines from the current state. Caller holds c.mu.
+func (c *CTC) updateInterrupt() {
+	inService := c.inService()
+	if c.Interrupt != nil {
+		pending := c.pendingChannel()
+		c.Interrupt.Set(c, pending >= 0 && pending < inService)
+	}
+	if c.InService != nil {
+		c.InService.Set(c, inService < len(c.channels))
+	}
+}
+
+func (c *CTC) WriteStatus(address Address, statusAddr Address, value byte) error {
+	return &ErrNotImplemented{Device: c}
+}
+
+func (c *CTC) ReadStatus(address Address, statusAddr Address) (byte, error) {
+	return 0, &ErrNotImplemented{Device: c}
+}
+
+func (c *CTC) GetKind() string {
+	return KIND_CTC
+}
+
+// NewCTC returns a CTC whose timers run on the clock of the simulator's CPU.
+func NewCTC(sim *CpuSim, name string, baseAddress Address, enabler EnablerInterface) *CTC {
+	c := &CTC{
+		Sim:         sim,
+		Name:        name,
+		BaseAddress: baseAddress,
+		Enabler:     enabler,
+	}
+	for i := range c.channels {
+		c.channels[i] = ctcChannel{constant: 256, count: 256}
+	}
+	sim.AddStepHook(c.step)
+	return c
+}
+
+// CTCOptions are the options for a KIND_CTC.
+type CTCOptions struct {
+	Name        string           `yaml:"name"`
+	BaseAddress Address          `yaml:"address"`
+	Enabler     EnablerInterface `yaml:"-"`
+}
+
+func init() {
+	RegisterDevice(KIND_CTC, func(sim *CpuSim, o CTCOptions) (DeviceInterface, error) {
+		return NewCTC(sim, o.Name, o.BaseAddress, enablerOrAlways(o.Enabler)), nil
+	})
+}
+
+// ResetDevice stops all four channels and ends any interrupt under service.
+func (c *CTC) ResetDevice(kind ResetKind) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.channels {
+		c.channels[i] = ctcChannel{constant: 256, count: 256}
+	}
+	if kind == ResetCold {
+		c.vector = 0
+	}
+	c.updateInterrupt()
+}
+
+type ctcChannelState struct {
+	Control      byte `json:"control"`
+	Constant     int  `json:"constant"`
+	Count        int  `json:"count"`
+	Phase        int  `json:"phase"`
+	Running      bool `json:"running"`
+	WaitConstant bool `json:"wait_constant"`
+	WaitTrigger  bool `json:"wait_trigger"`
+	IntPending   bool `json:"int_pending"`
+	InService    bool `json:"in_service"`
+}
+
+type ctcState struct {
+	Channels   [4]ctcChannelState `json:"channels"`
+	Vector     byte               `json:"vector"`
+	LastCycles uint64             `json:"last_cycles"`
+}
+
+func (c *CTC) SaveState() (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state := &ctcState{Vector: c.vector, LastCycles: c.lastCycles}
+	for i, ch := range c.channels {
+		state.Channels[i] = ctcChannelState{
+			Control:      ch.control,
+			Constant:     ch.constant,
+			Count:        ch.count,
+			Phase:        ch.phase,
+			Running:      ch.running,
+			WaitConstant: ch.waitConstant,
+			WaitTrigger:  ch.waitTrigger,
+			IntPending:   ch.intPending,
+			InService:    ch.ius,
+		}
+	}
+	return state, nil
+}
+
+func (c *CTC) RestoreState(data json.RawMessage) error {
+	var state ctcState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	for i, saved := range state.Channels {
+		if saved.Constant < 1 || saved.Constant > 256 || saved.Count < 1 || saved.Count > 256 || saved.Phase < 0 || saved.Phase > 255 {
+			return fmt.Errorf("channel %d: counter out of range", i)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.vector = state.Vector
+	c.lastCycles = state.LastCycles
+	for i, saved := range state.Channels {
+		c.channels[i] = ctcChannel{
+			control:      saved.Control,
+			constant:     saved.Constant,
+			count:        saved.Count,
+			phase:        saved.Phase,
+			running:      saved.Running,
+			waitConstant: saved.WaitConstant,
+			waitTrigger:  saved.WaitTrigger,
+			intPending:   saved.IntPending,
+			ius:          saved.InService,
+		}
+	}
+	c.updateInterrupt()
+	return nil
+}
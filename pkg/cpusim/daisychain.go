@@ -0,0 +1,145 @@
+package cpusim
+
+import (
+	"sync"
+)
+
+// DaisyChainDevice is a Z80-family peripheral that supports vectored mode 2
+// interrupts through the IEI/IEO daisy chain.
+//
+// The device drives two lines given to it by the chain: request, which it
+// asserts while it wants service, and inService, which it asserts while an
+// interrupt it raised is being serviced (its IEO is held low). The chain
+// combines these into the CPU's INT input according to each device's
+// position. A device in service decides for itself whether it may request
+// again, as a CTC does for a channel ahead of the one in service.
+type DaisyChainDevice interface {
+	DeviceInterface
+	ConnectDaisyChain(request, inService *SignalLine)
+
+	// InterruptAcknowledge is called during the interrupt acknowledge cycle
+	// on the highest-priority device that is requesting service. The device
+	// returns the vector it places on the data bus and goes in service.
+	InterruptAcknowledge() byte
+
+	// ReturnFromInterrupt is called when RETI (ED 4D) is seen on the bus and
+	// this device is the highest-priority device in service.
+	ReturnFromInterrupt()
+}
+
+// DaisyChain models the IEI/IEO priority chain of Z80 peripherals. Devices
+// are added in priority order: the first device added has IEI tied high.
+//
+// A device may interrupt only while no device ahead of it is in service. The chain drives Output, which should be connected
+// to the CPU's INT input; the CPU calls Acknowledge during the interrupt
+// acknowledge cycle and RETI when it executes ED 4D.
+type DaisyChain struct {
+	Sim     *CpuSim
+	Name    string
+	Output  *SignalLine
+	mu      sync.Mutex
+	devices []*daisyLink
+}
+
+type daisyLink struct {
+	device    DaisyChainDevice
+	request   *SignalLine
+	inService *SignalLine
+}
+
+func NewDaisyChain(sim *CpuSim, name string, output *SignalLine) *DaisyChain {
+	return &DaisyChain{
+		Sim:    sim,
+		Name:   name,
+		Output: output,
+	}
+}
+
+func (c *DaisyChain) GetName() string {
+	return c.Name
+}
+
+// Add appends a device to the end (lowest priority) of the chain.
+func (c *DaisyChain) Add(device DaisyChainDevice) {
+	link := &daisyLink{
+		device:    device,
+		request:   NewSignalLine(c.Name + "." + device.GetName() + ".INT"),
+		inService: NewSignalLine(c.Name + "." + device.GetName() + ".IUS"),
+	}
+	update := func(bool) { c.update() }
+	link.request.OnChange(update)
+	link.inService.OnChange(update)
+
+	c.mu.Lock()
+	c.devices = append(c.devices, link)
+	c.mu.Unlock()
+
+	device.ConnectDaisyChain(link.request, link.inService)
+	c.update()
+}
+
+// highestRequest returns the device that would be acknowledged now, or nil.
+func (c *DaisyChain) highestRequest() *daisyLink {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.highestRequestLocked()
+}
+
+func (c *DaisyChain) highestRequestLocked() *daisyLink {
+	for _, link := range c.devices {
+		if link.request.Asserted() {
+			return link
+		}
+		if link.inService.Asserted() {
+			// IEO is low, which blocks everything behind this device
+			return nil
+		}
+	}
+	return nil
+}
+
+// highestInService returns the highest-priority device in service, or nil.
+func (c *DaisyChain) highestInService() *daisyLink {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, link := range c.devices {
+		if link.inService.Asserted() {
+			return link
+		}
+	}
+	return nil
+}
+
+// update drives the chain's output from the devices' request lines. The
+// chain's lock is held across the Set so that concurrent updates from
+// different devices cannot leave the output stale.
+func (c *DaisyChain) update() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.Output != nil {
+		c.Output.Set(c, c.highestRequestLocked() != nil)
+	}
+}
+
+// Acknowledge runs the interrupt acknowledge cycle and returns the vector
+// placed on the data bus. If no device responds the bus floats to 0xFF.
+func (c *DaisyChain) Acknowledge() byte {
+	link := c.highestRequest()
+	if link == nil {
+		return 0xFF
+	}
+	return link.device.InterruptAcknowledge()
+}
+
+// RETI notifies the chain that the CPU fetched a RETI instruction. The
+// highest-priority device in service sees its IEI high and clears its
+// in-service state.
+func (c *DaisyChain) RETI() {
+	link := c.highestInService()
+	if link == nil {
+		return
+	}
+	link.device.ReturnFromInterrupt()
+}
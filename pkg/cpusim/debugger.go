@@ -0,0 +1,379 @@
+package cpusim
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// debugPoll is how often a Debugger waiting for the CPU to stop checks that
+// the CPU is still running.
+const debugPoll = 50 * time.Millisecond
+
+var errBusy = fmt.Errorf("the CPU is being run by another debugger")
+
+// StopReason says why a Debugger stopped the CPU.
+type StopReason int
+
+const (
+	StopRequested  StopReason = iota // Stop was called
+	StopBreakpoint                   // the CPU reached a breakpoint
+	StopStep                         // Step finished
+	StopWatchpoint                   // the last instruction hit a watchpoint
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopRequested:
+		return "stopped"
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopStep:
+		return "step"
+	case StopWatchpoint:
+		return "watchpoint"
+	default:
+		return fmt.Sprintf("StopReason(%d)", int(r))
+	}
+}
+
+// WatchKind is the kind of memory access a watchpoint stops on.
+type WatchKind int
+
+const (
+	WatchWrite WatchKind = 1 << iota
+	WatchRead
+	WatchAccess = WatchWrite | WatchRead
+)
+
+type watchpoint struct {
+	address Address
+	length  Address
+	kind    WatchKind
+}
+
+// StopEvent describes where the CPU stopped. For a watchpoint, Address is
+// the address accessed and Watch the watchpoint's kind.
+type StopEvent struct {
+	Reason  StopReason
+	PC      Address
+	Address Address
+	Watch   WatchKind
+}
+
+// Debugger stops a running CPU between instructions so that it can be
+// examined and changed. A stopped CPU is held in the Debugger's step hook:
+// its goroutine is blocked, so the CPU, memory and ports may be used freely
+// until Step or Continue lets it go.
+//
+// Breakpoints stop the CPU before the instruction at the breakpoint address
+// executes. Watchpoints stop it after the instruction that accessed the
+// watched memory; read watchpoints also see instruction fetches. A Debugger supports machines with a single CPU, which must
+// implement DebugInterface.
+type Debugger struct {
+	Sim *CpuSim
+	CPU DebugInterface
+
+	// OnStop, if set, is called on the CPU's goroutine when the CPU stops at
+	// a breakpoint while no Stop or Step call is waiting for it. It must not
+	// block.
+	OnStop func(ev StopEvent)
+
+	active   atomic.Bool // the step hook has something to check
+	watching atomic.Bool // there are watchpoints
+	stopped  atomic.Bool // the CPU is held in the step hook
+	watchHit *StopEvent  // set on the CPU's goroutine by the memory watches
+
+	mu          sync.Mutex
+	breakpoints map[Address]bool
+	watchpoints []watchpoint
+	stopReq     bool   // stop at the next instruction boundary
+	stepping    uint64 // instructions left to step, 0 if not stepping
+	waiting     bool   // a Stop, Step or Resume call is waiting for the CPU
+
+	stops  chan StopEvent
+	resume chan struct{}
+}
+
+// NewDebugger attaches a debugger to the machine's CPU. The CPU keeps running
+// until it reaches a breakpoint or Stop is called.
+func NewDebugger(sim *CpuSim) (*Debugger, error) {
+	if len(sim.CPU) != 1 {
+		return nil, fmt.Errorf("debugger needs a machine with exactly one CPU, found %d", len(sim.CPU))
+	}
+	cpu, ok := sim.CPU[0].(DebugInterface)
+	if !ok {
+		return nil, &ErrNotImplemented{What: "debugging this CPU"}
+	}
+
+	d := &Debugger{
+		Sim:         sim,
+		CPU:         cpu,
+		breakpoints: make(map[Address]bool),
+		stops:       make(chan StopEvent, 1),
+		resume:      make(chan struct{}, 1),
+	}
+	sim.AddStepHook(d.step)
+	sim.WriteWatch = d.writeWatch
+	sim.ReadWatch = d.readWatch
+	return d, nil
+}
+
+// updateActive must be called with mu held whenever the breakpoints,
+// watchpoints or a stop request change.
+func (d *Debugger) updateActive() {
+	d.watching.Store(len(d.watchpoints) > 0)
+	d.active.Store(len(d.breakpoints) > 0 || len(d.watchpoints) > 0 || d.stopReq || d.stepping > 0)
+}
+
+func (d *Debugger) writeWatch(address Address, _ byte) {
+	d.watch(address, WatchWrite)
+}
+
+func (d *Debugger) readWatch(address Address) {
+	d.watch(address, WatchRead)
+}
+
+// watch records the first access to a watched address by the running CPU.
+// Accesses made while the CPU is stopped (by a debugger examining memory)
+// or while a Rewinder replays history are ignored.
+func (d *Debugger) watch(address Address, kind WatchKind) {
+	if !d.watching.Load() || d.watchHit != nil || d.stopped.Load() || d.Sim.Replaying() {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, w := range d.watchpoints {
+		if w.kind&kind != 0 && address >= w.address && address-w.address < w.length {
+			d.watchHit = &StopEvent{Reason: StopWatchpoint, Address: address, Watch: w.kind}
+			return
+		}
+	}
+}
+
+// step is called after each instruction while the machine is running.
+func (d *Debugger) step() error {
+	if !d.active.Load() || d.Sim.Replaying() {
+		return nil
+	}
+
+	d.mu.Lock()
+	reason, stop := d.checkStop()
+	if !stop {
+		d.mu.Unlock()
+		return nil
+	}
+	d.stopped.Store(true)
+	d.stopReq = false
+	d.stepping = 0
+	d.updateActive()
+	waiting := d.waiting
+	d.waiting = false
+	d.mu.Unlock()
+
+	ev := StopEvent{Reason: reason, PC: d.CPU.GetPC()}
+	if reason == StopWatchpoint {
+		ev = *d.watchHit
+		ev.PC = d.CPU.GetPC()
+	}
+	d.watchHit = nil
+	if waiting {
+		d.stops <- ev
+	} else if d.OnStop != nil {
+		d.OnStop(ev)
+	}
+	<-d.resume
+	return nil
+}
+
+func (d *Debugger) checkStop() (StopReason, bool) {
+	stepped := false
+	if d.stepping > 0 {
+		d.stepping--
+		stepped = d.stepping == 0
+	}
+	switch {
+	case d.watchHit != nil:
+		return StopWatchpoint, true
+	case d.breakpoints[d.CPU.GetPC()]:
+		return StopBreakpoint, true
+	case d.stopReq:
+		return StopRequested, true
+	case stepped:
+		return StopStep, true
+	}
+	return 0, false
+}
+
+// wait waits for the CPU to stop in the step hook.
+func (d *Debugger) wait() (StopEvent, error) {
+	ticker := time.NewTicker(debugPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev := <-d.stops:
+			return ev, nil
+		case <-ticker.C:
+			if !d.Sim.Running() {
+				d.mu.Lock()
+				d.waiting = false
+				d.stopReq = false
+				d.stepping = 0
+				d.updateActive()
+				d.mu.Unlock()
+				return StopEvent{}, &ErrNotRunning{}
+			}
+		}
+	}
+}
+
+// Stopped reports whether the CPU is held by the debugger.
+func (d *Debugger) Stopped() bool {
+	return d.stopped.Load()
+}
+
+// Stop stops the CPU at the next instruction boundary and waits for it to
+// stop. It returns ErrNotRunning if the CPU has not been started or has
+// already returned from Run.
+func (d *Debugger) Stop() (StopEvent, error) {
+	d.mu.Lock()
+	if d.stopped.Load() {
+		d.mu.Unlock()
+		return StopEvent{Reason: StopRequested, PC: d.CPU.GetPC()}, nil
+	}
+	if d.waiting {
+		d.mu.Unlock()
+		return StopEvent{}, errBusy
+	}
+	d.stopReq = true
+	d.waiting = true
+	d.updateActive()
+	d.mu.Unlock()
+	return d.wait()
+}
+
+// Interrupt asks the running CPU to stop at the next instruction boundary
+// without waiting for it. It is for stopping a CPU that a Resume call is
+// waiting on.
+func (d *Debugger) Interrupt() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.stopped.Load() {
+		d.stopReq = true
+		d.updateActive()
+	}
+}
+
+// Step lets a stopped CPU execute n instructions and waits for it to stop
+// again. It stops early at a breakpoint.
+func (d *Debugger) Step(n uint64) (StopEvent, error) {
+	if n == 0 {
+		return StopEvent{}, fmt.Errorf("step count must be greater than zero")
+	}
+	return d.release(n)
+}
+
+// Resume lets a stopped CPU run until it stops at a breakpoint, a watchpoint
+// or an Interrupt, and waits for it to stop.
+func (d *Debugger) Resume() (StopEvent, error) {
+	return d.release(0)
+}
+
+// release lets the CPU go for n instructions (0 for no limit) and waits for
+// it to stop again.
+func (d *Debugger) release(n uint64) (StopEvent, error) {
+	d.mu.Lock()
+	if !d.stopped.Load() {
+		d.mu.Unlock()
+		return StopEvent{}, fmt.Errorf("the CPU must be stopped to step or resume")
+	}
+	d.stopped.Store(false)
+	d.stepping = n
+	d.waiting = true
+	d.updateActive()
+	d.mu.Unlock()
+
+	d.resume <- struct{}{}
+	return d.wait()
+}
+
+// Continue lets a stopped CPU run on. It does nothing if the CPU is not
+// stopped.
+func (d *Debugger) Continue() {
+	d.mu.Lock()
+	if !d.stopped.Load() {
+		d.mu.Unlock()
+		return
+	}
+	d.stopped.Store(false)
+	d.mu.Unlock()
+
+	d.resume <- struct{}{}
+}
+
+// AddBreakpoint sets a breakpoint at a CPU address.
+func (d *Debugger) AddBreakpoint(address Address) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.breakpoints[address] = true
+	d.updateActive()
+}
+
+// RemoveBreakpoint clears a breakpoint, reporting whether one was set.
+func (d *Debugger) RemoveBreakpoint(address Address) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.breakpoints[address] {
+		return false
+	}
+	delete(d.breakpoints, address)
+	d.updateActive()
+	return true
+}
+
+// Breakpoints returns the breakpoint addresses in ascending order.
+func (d *Debugger) Breakpoints() []Address {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	addresses := make([]Address, 0, len(d.breakpoints))
+	for address := range d.breakpoints {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+	return addresses
+}
+
+// AddWatchpoint stops the CPU after any instruction that accesses length
+// bytes from address in the given way.
+func (d *Debugger) AddWatchpoint(address Address, length Address, kind WatchKind) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.watchpoints = append(d.watchpoints, watchpoint{address: address, length: max(length, 1), kind: kind})
+	d.updateActive()
+}
+
+// RemoveWatchpoint clears a watchpoint set with the same arguments,
+// reporting whether there was one.
+func (d *Debugger) RemoveWatchpoint(address Address, length Address, kind WatchKind) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, w := range d.watchpoints {
+		if w == (watchpoint{address: address, length: max(length, 1), kind: kind}) {
+			d.watchpoints = append(d.watchpoints[:i], d.watchpoints[i+1:]...)
+			d.updateActive()
+			return true
+		}
+	}
+	return false
+}
+
+// ClearAll removes every breakpoint and watchpoint.
+func (d *Debugger) ClearAll() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	clear(d.breakpoints)
+	d.watchpoints = nil
+	d.updateActive()
+}
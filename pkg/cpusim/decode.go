@@ -0,0 +1,305 @@
+package cpusim
+
+// Address decoding cache
+//
+// Every memory access runs the CPU address through the mappers and then looks
+// for the device that answers the mapped address; every port access looks for
+// the device that answers the port. The answer only changes when a mapper
+// register is written or an enabler changes state, so CpuSim remembers it per
+// page of CPU address space and per port.
+//
+// An entry records the enablers it was decoded under and the enable bits the
+// mappers drove for the page. On each access the driven bits are set again,
+// as the mappers would have, and the enablers are checked; if any differ, the
+// entry is decoded again. Writing a mapper register invalidates every entry.
+//
+// The answer also depends on the kind filters (FilterMemoryKind and
+// FilterPortKind), which the 4004 switches between ROM and RAM on nearly
+// every instruction. Rather than discard the cache on each switch, there is a
+// table of entries for each filter that has been used, and the one for the
+// current filter is looked up on each access.
+//
+// Only devices and mappers that declare their decode behavior (Decodable,
+// AddressRanger and PageMapper) are cached. Anything else takes the slow
+// path of walking the lists on every access, as does everything while
+// MemDebug is on.
+
+const (
+	decodePageBits = 8
+	decodePageSize = 1 << decodePageBits
+	decodePages    = 1 << (16 - decodePageBits) // pages in a 64K CPU address space
+	decodePorts    = 256
+)
+
+// Decodable is implemented by devices whose HasAddress is their enabler
+// ANDed with a fixed set of addresses.
+type Decodable interface {
+	DecodeEnabler() EnablerInterface
+}
+
+// AddressRanger is implemented by memory devices that answer one fixed,
+// contiguous range of addresses.
+type AddressRanger interface {
+	AddressRange() (start Address, end Address)
+}
+
+// PageMapper is implemented by mappers that translate aligned blocks of
+// 1<<PageBits() addresses as a unit, and whose translation depends only on
+// their registers and on the enablers returned by MapInputs. MapOutputs are
+// the enable bits that Map drives. Writing a register must call
+// CpuSim.InvalidateDecode.
+type PageMapper interface {
+	MapperInterface
+	PageBits() int
+	MapInputs() []EnablerInterface
+	MapOutputs() []*EnableBit
+}
+
+type enablerState struct {
+	enabler EnablerInterface
+	value   bool
+}
+
+type memoryDecode struct {
+	generation uint64
+	cacheable  bool
+	offset     Address         // mapped address = CPU address + offset
+	device     MemoryInterface // nil if nothing answers the page
+	memory     *Memory         // device, when it is a plain Memory
+	enablers   []enablerState
+	outputs    []*EnableBit
+	values     []bool // values of outputs after mapping
+}
+
+type portDecode struct {
+	generation uint64
+	cacheable  bool
+	device     MemoryInterface // nil if nothing answers the port
+	enablers   []enablerState
+}
+
+// memoryDecodeTable holds the entries for the pages of CPU address space
+// under one memory filter.
+type memoryDecodeTable struct {
+	filter string
+	pages  [decodePages]memoryDecode
+}
+
+// portDecodeTable holds the entries for the ports under one port filter.
+type portDecodeTable struct {
+	filter string
+	ports  [decodePorts]portDecode
+}
+
+// InvalidateDecode discards all cached address decoding. It is called when a
+// mapper register is written or the device lists change.
+func (sim *CpuSim) InvalidateDecode() {
+	sim.decodeGeneration++
+}
+
+// memoryTable returns the decode table for the current memory filter.
+func (sim *CpuSim) memoryTable() *memoryDecodeTable {
+	if t := sim.memoryDecode; t != nil && t.filter == sim.MemoryFilter {
+		return t
+	}
+	for _, t := range sim.memoryTables {
+		if t.filter == sim.MemoryFilter {
+			sim.memoryDecode = t
+			return t
+		}
+	}
+	t := &memoryDecodeTable{filter: sim.MemoryFilter}
+	sim.memoryTables = append(sim.memoryTables, t)
+	sim.memoryDecode = t
+	return t
+}
+
+// portTable returns the decode table for the current port filter.
+func (sim *CpuSim) portTable() *portDecodeTable {
+	if t := sim.portDecode; t != nil && t.filter == sim.PortFilter {
+		return t
+	}
+	for _, t := range sim.portTables {
+		if t.filter == sim.PortFilter {
+			sim.portDecode = t
+			return t
+		}
+	}
+	t := &portDecodeTable{filter: sim.PortFilter}
+	sim.portTables = append(sim.portTables, t)
+	sim.portDecode = t
+	return t
+}
+
+func (e *enablerState) changed() bool {
+	return e.enabler.Bool() != e.value
+}
+
+// memoryEntry returns the decode entry for a CPU address, or nil if the
+// address has to be decoded the slow way.
+func (sim *CpuSim) memoryEntry(address Address) *memoryDecode {
+	if address >= decodePages*decodePageSize || sim.MemDebug || len(sim.Memory) == 0 {
+		return nil
+	}
+	e := &sim.memoryTable().pages[address>>decodePageBits]
+	if e.generation != sim.decodeGeneration {
+		sim.decodeMemoryPage(e, address&^(decodePageSize-1))
+		return e.usable()
+	}
+	if !e.cacheable {
+		return nil
+	}
+
+	for i, bit := range e.outputs {
+		bit.Value = e.values[i]
+	}
+	for i := range e.enablers {
+		if e.enablers[i].changed() {
+			sim.decodeMemoryPage(e, address&^(decodePageSize-1))
+			return e.usable()
+		}
+	}
+	return e
+}
+
+func (e *memoryDecode) usable() *memoryDecode {
+	if !e.cacheable {
+		return nil
+	}
+	return e
+}
+
+// decodeMemoryPage maps a page and finds the device that answers it. As with
+// an ordinary access, the mappers drive their enable bits.
+func (sim *CpuSim) decodeMemoryPage(e *memoryDecode, base Address) {
+	e.generation = sim.decodeGeneration
+	e.cacheable = false
+	e.device = nil
+	e.memory = nil
+	e.enablers = e.enablers[:0]
+	e.outputs = e.outputs[:0]
+	e.values = e.values[:0]
+
+	address := base
+	for _, mapper := range sim.Mappers {
+		if !mapper.MatchMemory(sim.Memory[0]) {
+			continue
+		}
+		pm, ok := mapper.(PageMapper)
+		if !ok || pm.PageBits() < decodePageBits {
+			return
+		}
+		for _, en := range pm.MapInputs() {
+			e.enablers = append(e.enablers, enablerState{enabler: en, value: en.Bool()})
+		}
+		mapped, err := mapper.Map(address)
+		if err != nil {
+			return
+		}
+		address = mapped
+		for _, bit := range pm.MapOutputs() {
+			if bit != nil {
+				e.outputs = append(e.outputs, bit)
+			}
+		}
+	}
+	for _, bit := range e.outputs {
+		e.values = append(e.values, bit.Value)
+	}
+
+	end := address + decodePageSize - 1
+	for _, mem := range sim.Memory {
+		if !sim.MatchMemory(mem) {
+			continue
+		}
+		d, ok := mem.(Decodable)
+		r, ranged := mem.(AddressRanger)
+		if !ok || !ranged {
+			return
+		}
+		start, last := r.AddressRange()
+		if end < start || address > last {
+			continue
+		}
+		if address < start || end > last {
+			// the device only covers part of the page
+			return
+		}
+		en := d.DecodeEnabler()
+		enabled := en.Bool()
+		if !e.drives(en) {
+			e.enablers = append(e.enablers, enablerState{enabler: en, value: enabled})
+		}
+		if enabled {
+			e.device = mem
+			e.memory, _ = mem.(*Memory)
+			break
+		}
+	}
+
+	e.offset = address - base
+	e.cacheable = true
+}
+
+// drives reports whether an enabler follows one of the enable bits that the
+// mappers drive. Such an enabler doesn't need checking, as the bit is set
+// from the entry on every access.
+func (e *memoryDecode) drives(en EnablerInterface) bool {
+	ref, ok := en.(*ByReferenceEnabler)
+	if !ok {
+		return false
+	}
+	for _, bit := range e.outputs {
+		if ref.Value == &bit.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// portEntry returns the decode entry for a port, or nil if the port has to
+// be decoded the slow way.
+func (sim *CpuSim) portEntry(port Address) *portDecode {
+	if port >= decodePorts || sim.MemDebug {
+		return nil
+	}
+	e := &sim.portTable().ports[port]
+	if e.generation != sim.decodeGeneration {
+		sim.decodePort(e, port)
+	} else {
+		for i := range e.enablers {
+			if e.enablers[i].changed() {
+				sim.decodePort(e, port)
+				break
+			}
+		}
+	}
+	if !e.cacheable {
+		return nil
+	}
+	return e
+}
+
+func (sim *CpuSim) decodePort(e *portDecode, port Address) {
+	e.generation = sim.decodeGeneration
+	e.cacheable = false
+	e.device = nil
+	e.enablers = e.enablers[:0]
+
+	for _, p := range sim.Ports {
+		if !sim.MatchPort(p) {
+			continue
+		}
+		d, ok := p.(Decodable)
+		if !ok {
+			return
+		}
+		en := d.DecodeEnabler()
+		e.enablers = append(e.enablers, enablerState{enabler: en, value: en.Bool()})
+		if p.HasAddress(port) {
+			e.device = p
+			break
+		}
+	}
+	e.cacheable = true
+}
@@ -21,6 +21,10 @@ func (d *DipSwitch) HasAddress(address Address) bool {
 	return (address == d.DataReadAddress)
 }
 
+func (d *DipSwitch) DecodeEnabler() EnablerInterface {
+	return d.Enabler
+}
+
 func (d *DipSwitch) Read(address Address) (byte, error) {
 	if address == d.DataReadAddress {
 		return d.Value, nil
@@ -59,3 +63,9 @@ func NewDipSwitch(sim *CpuSim, name string, dataReadAddress Address, value byte,
 		Value:           value,
 	}
 }
+
+func init() {
+	RegisterDevice(KIND_INPORT, func(sim *CpuSim, o PortOptions) (DeviceInterface, error) {
+		return NewDipSwitch(sim, o.Name, o.Address, o.Value, enablerOrAlways(o.Enabler)), nil
+	})
+}
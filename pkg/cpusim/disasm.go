@@ -0,0 +1,143 @@
+package cpusim
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ReadFunc returns the byte at an address of the program being
+// disassembled. It must not have side effects.
+type ReadFunc func(address Address) byte
+
+// SymbolLookup names addresses. Disassemblers use it to print labels in
+// place of jump targets and memory operands.
+type SymbolLookup interface {
+	LookupSymbol(address Address) (name string, ok bool)
+}
+
+// Instruction is one decoded instruction.
+type Instruction struct {
+	Address   Address
+	Bytes     []byte
+	Mnemonic  string  // e.g. "LD"
+	Operands  string  // e.g. "A,(IX+05H)", with symbols substituted
+	Target    Address // destination of a jump or call, if HasTarget
+	HasTarget bool
+	Flow      Flow // calls and returns, conditional or not
+}
+
+// Flow marks the instructions that call a subroutine or return from one.
+type Flow int
+
+const (
+	FlowNone Flow = iota
+	FlowCall
+	FlowReturn
+)
+
+// Disassembler decodes instructions from memory without executing them.
+type Disassembler interface {
+	Disassemble(read ReadFunc, address Address) Instruction
+}
+
+// DisassemblerInterface is implemented by CPUs that can disassemble their
+// own memory.
+type DisassemblerInterface interface {
+	Disassemble(address Address) Instruction
+}
+
+// Len returns the length of the instruction in bytes.
+func (ins Instruction) Len() int {
+	return len(ins.Bytes)
+}
+
+// Text returns the instruction as assembler source, e.g. "LD A,(IX+05H)".
+func (ins Instruction) Text() string {
+	if ins.Operands == "" {
+		return ins.Mnemonic
+	}
+	return ins.Mnemonic + " " + ins.Operands
+}
+
+// Hex returns the bytes of the instruction in hex, separated by spaces.
+func (ins Instruction) Hex() string {
+	parts := make([]string, len(ins.Bytes))
+	for i, b := range ins.Bytes {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, " ")
+}
+
+// PeekMemory reads memory as the CPU would, but without calling ReadWatch.
+// It is meant for debuggers and disassemblers looking at memory.
+func (sim *CpuSim) PeekMemory(address Address) byte {
+	value, _, _ := sim.readMemory(address)
+	return value
+}
+
+// DisassembleRange decodes the instructions from start through end. The last
+// instruction may extend past end.
+func DisassembleRange(d Disassembler, read ReadFunc, start, end Address) []Instruction {
+	var listing []Instruction
+	for address := start; address <= end; {
+		ins := d.Disassemble(read, address)
+		listing = append(listing, ins)
+		address += Address(ins.Len())
+	}
+	return listing
+}
+
+// ImageReader returns a ReadFunc for a ROM image loaded at base. Addresses
+// outside the image read as 0xFF, as an erased EPROM would.
+func ImageReader(image []byte, base Address) ReadFunc {
+	return func(address Address) byte {
+		if address < base || address-base >= Address(len(image)) {
+			return 0xFF
+		}
+		return image[address-base]
+	}
+}
+
+// WriteListing writes an assembler-style listing of the code from start
+// through end. Jump and call targets within the range that have no symbol
+// are given labels of the form L0123. newDisassembler returns a disassembler
+// that names addresses with the symbols it is given; symbols may be nil.
+func WriteListing(w io.Writer, newDisassembler func(SymbolLookup) Disassembler, read ReadFunc, start, end Address, symbols SymbolLookup) error {
+	names := &listingSymbols{symbols: symbols, labels: map[Address]string{}}
+	for _, ins := range DisassembleRange(newDisassembler(symbols), read, start, end) {
+		if ins.HasTarget && ins.Target >= start && ins.Target <= end {
+			if _, ok := names.LookupSymbol(ins.Target); !ok {
+				names.labels[ins.Target] = fmt.Sprintf("L%04X", ins.Target)
+			}
+		}
+	}
+
+	for _, ins := range DisassembleRange(newDisassembler(names), read, start, end) {
+		if name, ok := names.LookupSymbol(ins.Address); ok {
+			if _, err := fmt.Fprintf(w, "%s:\n", name); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%04X  %-11s  %s\n", ins.Address, ins.Hex(), ins.Text()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listingSymbols adds generated labels to a symbol table.
+type listingSymbols struct {
+	symbols SymbolLookup
+	labels  map[Address]string
+}
+
+func (l *listingSymbols) LookupSymbol(address Address) (string, bool) {
+	if l.symbols != nil {
+		if name, ok := l.symbols.LookupSymbol(address); ok {
+			return name, true
+		}
+	}
+	name, ok := l.labels[address]
+	return name, ok
+}
@@ -101,3 +101,15 @@ func (e *ErrNotImplemented) Error() string {
 	}
 	return msg
 }
+
+type ErrNotRunning struct {
+	Device DeviceInterface
+}
+
+func (e *ErrNotRunning) Error() string {
+	if e.Device != nil {
+		return fmt.Sprintf("Device %s is not running", e.Device.GetName())
+	} else {
+		return "CPU is not running"
+	}
+}
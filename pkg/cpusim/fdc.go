@@ -1,6 +1,7 @@
 package cpusim
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 )
@@ -98,10 +99,17 @@ const (
 )
 
 // FDC emulates a WD37C65 / NEC uPD765 floppy disk controller.
+//
+// If an interrupt line is connected, the FDC asserts it when a read, write,
+// format or read ID command enters the result phase (until the first result
+// byte is read), and while a seek, recalibrate or reset is waiting for a
+// Sense Interrupt Status command. As on the WD37C65, the DMA bit in the DOR
+// gates the interrupt output.
 type FDC struct {
-	Sim     *CpuSim
-	Name    string
-	Enabler EnablerInterface
+	Sim       *CpuSim
+	Name      string
+	Enabler   EnablerInterface
+	Interrupt *SignalLine
 
 	// Port addresses
 	PortMSR  Address // Main Status Register (read)
@@ -117,7 +125,8 @@ type FDC struct {
 	SectorSizeCode  byte
 
 	// Disk images (up to 4 drives)
-	files [4]*os.File
+	files      [4]*os.File
+	imagePaths [4]string
 
 	// Controller state
 	phase int
@@ -177,6 +186,23 @@ func NewFDC(sim *CpuSim, name string, portMSR, portData, portDOR, portDCR Addres
 	return fdc
 }
 
+// FDCOptions are the options for a KIND_FDC. The images are attached
+// afterwards, with AttachImage.
+type FDCOptions struct {
+	Name     string           `yaml:"name"`
+	PortMSR  Address          `yaml:"msr"`
+	PortData Address          `yaml:"data"`
+	PortDOR  Address          `yaml:"dor"`
+	PortDCR  Address          `yaml:"dcr"`
+	Enabler  EnablerInterface `yaml:"-"`
+}
+
+func init() {
+	RegisterDevice(KIND_FDC, func(sim *CpuSim, o FDCOptions) (DeviceInterface, error) {
+		return NewFDC(sim, o.Name, o.PortMSR, o.PortData, o.PortDOR, o.PortDCR, enablerOrAlways(o.Enabler)), nil
+	})
+}
+
 // AttachImage opens a disk image file for the specified drive (0-3).
 func (fdc *FDC) AttachImage(drive int, filename string) error {
 	if drive < 0 || drive > 3 {
@@ -190,6 +216,7 @@ func (fdc *FDC) AttachImage(drive int, filename string) error {
 		fdc.files[drive].Close()
 	}
 	fdc.files[drive] = f
+	fdc.imagePaths[drive] = filename
 	return nil
 }
 
@@ -209,12 +236,18 @@ func (fdc *FDC) HasAddress(address Address) bool {
 		address == fdc.PortDOR || address == fdc.PortDCR
 }
 
+func (fdc *FDC) DecodeEnabler() EnablerInterface {
+	return fdc.Enabler
+}
+
 func (fdc *FDC) Read(address Address) (byte, error) {
 	switch address {
 	case fdc.PortMSR:
 		return fdc.readMSR(), nil
 	case fdc.PortData:
-		return fdc.readData(), nil
+		v := fdc.readData()
+		fdc.updateInterrupt()
+		return v, nil
 	default:
 		return 0xFF, nil
 	}
@@ -229,9 +262,39 @@ func (fdc *FDC) Write(address Address, value byte) error {
 	case fdc.PortDCR:
 		fdc.dcr = value
 	}
+	fdc.updateInterrupt()
 	return nil
 }
 
+// ConnectInterrupt connects the FDC's INT output to a signal line.
+func (fdc *FDC) ConnectInterrupt(line *SignalLine) {
+	fdc.Interrupt = line
+}
+
+// interruptPending returns true if the FDC is requesting an interrupt.
+func (fdc *FDC) interruptPending() bool {
+	if fdc.dor&fdcDorDMA == 0 {
+		return false
+	}
+	// Only the commands with an execution phase return seven result bytes
+	if fdc.phase == fdcPhaseResult && fdc.resLen == 7 && fdc.resPos == 0 {
+		return true
+	}
+	for _, st0 := range fdc.pendingSt0 {
+		if st0 != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// updateInterrupt drives the interrupt line from the current state.
+func (fdc *FDC) updateInterrupt() {
+	if fdc.Interrupt != nil {
+		fdc.Interrupt.Set(fdc, fdc.interruptPending())
+	}
+}
+
 func (fdc *FDC) ReadStatus(address Address, statusAddr Address) (byte, error) {
 	return 0, &ErrNotImplemented{Device: fdc}
 }
@@ -240,13 +303,29 @@ func (fdc *FDC) WriteStatus(address Address, statusAddr Address, value byte) err
 	return &ErrNotImplemented{Device: fdc}
 }
 
-func (fdc *FDC) Close() {
+// Close flushes the images to disk and closes them.
+func (fdc *FDC) Close() error {
+	var err error
 	for i := range fdc.files {
 		if fdc.files[i] != nil {
-			fdc.files[i].Close()
+			if serr := fdc.files[i].Sync(); err == nil {
+				err = serr
+			}
+			if cerr := fdc.files[i].Close(); err == nil {
+				err = cerr
+			}
 			fdc.files[i] = nil
 		}
+		fdc.imagePaths[i] = ""
 	}
+	return err
+}
+
+// ResetDevice resets the controller as its RESET pin does, which also
+// clears the digital output register. The images stay attached.
+func (fdc *FDC) ResetDevice(kind ResetKind) {
+	fdc.dor = fdcDorRESET
+	fdc.reset()
 }
 
 // reset reinitializes the controller to its power-on state.
@@ -860,3 +939,112 @@ func (fdc *FDC) enterIdle() {
 	fdc.msr = fdcMsrRQM
 	fdc.cmdPos = 0
 }
+
+type fdcState struct {
+	Phase       int       `json:"phase"`
+	Registers   [7]byte   `json:"registers"` // msr, dor, dcr, st0-st3
+	CmdBuf      [16]byte  `json:"cmd_buf"`
+	CmdLen      int       `json:"cmd_len"`
+	CmdPos      int       `json:"cmd_pos"`
+	ResBuf      [16]byte  `json:"res_buf"`
+	ResLen      int       `json:"res_len"`
+	ResPos      int       `json:"res_pos"`
+	DataBuf     []byte    `json:"data_buf"`
+	DataLen     int       `json:"data_len"`
+	DataPos     int       `json:"data_pos"`
+	DataDir     int       `json:"data_dir"`
+	PCN         [4]byte   `json:"pcn"`
+	PendingSt0  [4]byte   `json:"pending_st0"`
+	CmdCode     byte      `json:"cmd_code"`
+	MultiTrack  bool      `json:"multi_track"`
+	MFM         bool      `json:"mfm"`
+	SkipDeleted bool      `json:"skip_deleted"`
+	ImagePaths  [4]string `json:"image_paths"`
+}
+
+func (fdc *FDC) SaveState() (any, error) {
+	return &fdcState{
+		Phase:       fdc.phase,
+		Registers:   [7]byte{fdc.msr, fdc.dor, fdc.dcr, fdc.st0, fdc.st1, fdc.st2, fdc.st3},
+		CmdBuf:      fdc.cmdBuf,
+		CmdLen:      fdc.cmdLen,
+		CmdPos:      fdc.cmdPos,
+		ResBuf:      fdc.resBuf,
+		ResLen:      fdc.resLen,
+		ResPos:      fdc.resPos,
+		DataBuf:     fdc.dataBuf[:fdc.dataLen],
+		DataLen:     fdc.dataLen,
+		DataPos:     fdc.dataPos,
+		DataDir:     fdc.dataDir,
+		PCN:         fdc.pcn,
+		PendingSt0:  fdc.pendingSt0,
+		CmdCode:     fdc.cmdCode,
+		MultiTrack:  fdc.multiTrack,
+		MFM:         fdc.mfm,
+		SkipDeleted: fdc.skipDeleted,
+		ImagePaths:  fdc.imagePaths,
+	}, nil
+}
+
+// RestoreState restores the controller. Drives whose attached image differs
+// from the snapshot are reopened on the saved image.
+func (fdc *FDC) RestoreState(data json.RawMessage) error {
+	var state fdcState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if state.DataLen < 0 || state.DataLen > len(fdc.dataBuf) || len(state.DataBuf) != state.DataLen {
+		return fmt.Errorf("fdc: bad data buffer length %d in snapshot", state.DataLen)
+	}
+	if state.DataPos < 0 || state.DataPos > state.DataLen {
+		return fmt.Errorf("fdc: bad data position %d in snapshot", state.DataPos)
+	}
+	if state.CmdLen < 0 || state.CmdLen > len(fdc.cmdBuf) || state.CmdPos < 0 || state.CmdPos > len(fdc.cmdBuf) {
+		return fmt.Errorf("fdc: bad command length %d or position %d in snapshot", state.CmdLen, state.CmdPos)
+	}
+	if state.ResLen < 0 || state.ResLen > len(fdc.resBuf) || state.ResPos < 0 || state.ResPos > state.ResLen {
+		return fmt.Errorf("fdc: bad result length %d or position %d in snapshot", state.ResLen, state.ResPos)
+	}
+	if state.Phase < fdcPhaseIdle || state.Phase > fdcPhaseResult {
+		return fmt.Errorf("fdc: bad phase %d in snapshot", state.Phase)
+	}
+
+	for drive, path := range state.ImagePaths {
+		if path == fdc.imagePaths[drive] {
+			continue
+		}
+		if fdc.files[drive] != nil {
+			fdc.files[drive].Close()
+			fdc.files[drive] = nil
+		}
+		fdc.imagePaths[drive] = ""
+		if path != "" {
+			if err := fdc.AttachImage(drive, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	fdc.phase = state.Phase
+	r := state.Registers
+	fdc.msr, fdc.dor, fdc.dcr = r[0], r[1], r[2]
+	fdc.st0, fdc.st1, fdc.st2, fdc.st3 = r[3], r[4], r[5], r[6]
+	fdc.cmdBuf = state.CmdBuf
+	fdc.cmdLen = state.CmdLen
+	fdc.cmdPos = state.CmdPos
+	fdc.resBuf = state.ResBuf
+	fdc.resLen = state.ResLen
+	fdc.resPos = state.ResPos
+	copy(fdc.dataBuf[:], state.DataBuf)
+	fdc.dataLen = state.DataLen
+	fdc.dataPos = state.DataPos
+	fdc.dataDir = state.DataDir
+	fdc.pcn = state.PCN
+	fdc.pendingSt0 = state.PendingSt0
+	fdc.cmdCode = state.CmdCode
+	fdc.multiTrack = state.MultiTrack
+	fdc.mfm = state.MFM
+	fdc.skipDeleted = state.SkipDeleted
+	fdc.updateInterrupt()
+	return nil
+}
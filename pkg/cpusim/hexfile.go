@@ -0,0 +1,273 @@
+package cpusim
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// HexSegment is a run of contiguous bytes from a hex file.
+type HexSegment struct {
+	Address Address
+	Data    []byte
+}
+
+// HexImage is the contents of an Intel HEX or Motorola S-record file.
+type HexImage struct {
+	Segments []HexSegment
+	Start    *Address // from a start address record, if there was one
+}
+
+// add appends data at address, extending the last segment if it is
+// contiguous with it.
+func (img *HexImage) add(address Address, data []byte) {
+	if n := len(img.Segments); n > 0 {
+		last := &img.Segments[n-1]
+		if last.Address+Address(len(last.Data)) == address {
+			last.Data = append(last.Data, data...)
+			return
+		}
+	}
+	img.Segments = append(img.Segments, HexSegment{Address: address, Data: append([]byte(nil), data...)})
+}
+
+func (img *HexImage) setStart(address Address) {
+	img.Start = &address
+}
+
+// Read returns the byte at address. Addresses the image has no data for
+// read as 0xFF, as they do with ImageReader.
+func (img *HexImage) Read(address Address) byte {
+	for _, seg := range img.Segments {
+		if address >= seg.Address && address-seg.Address < Address(len(seg.Data)) {
+			return seg.Data[address-seg.Address]
+		}
+	}
+	return 0xFF
+}
+
+// Bounds returns the lowest and highest addresses that the image has data
+// for. An empty image has no bounds, and ok is false.
+func (img *HexImage) Bounds() (low, high Address, ok bool) {
+	for _, seg := range img.Segments {
+		if len(seg.Data) == 0 {
+			continue
+		}
+		end := seg.Address + Address(len(seg.Data)) - 1
+		if !ok || seg.Address < low {
+			low = seg.Address
+		}
+		if !ok || end > high {
+			high = end
+		}
+		ok = true
+	}
+	return low, high, ok
+}
+
+// hexRecords calls fn with the line number and text of each non-blank line
+// of r that starts with lead.
+func hexRecords(r io.Reader, lead byte, fn func(lineNum int, line string) (bool, error)) error {
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line[0] != lead {
+			return fmt.Errorf("line %d: record does not start with '%c'", lineNum, lead)
+		}
+		done, err := fn(lineNum, line)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		if done {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// ReadIntelHex reads an Intel HEX file. Extended segment and extended linear
+// address records are honored, and a start segment or start linear address
+// record sets the image's start address.
+func ReadIntelHex(r io.Reader) (*HexImage, error) {
+	img := &HexImage{}
+	var base Address
+	sawEOF := false
+
+	err := hexRecords(r, ':', func(lineNum int, line string) (bool, error) {
+		rec, err := hex.DecodeString(line[1:])
+		if err != nil {
+			return false, fmt.Errorf("invalid hex digits")
+		}
+		if len(rec) < 5 || len(rec) != int(rec[0])+5 {
+			return false, fmt.Errorf("record length does not match its byte count")
+		}
+		var sum byte
+		for _, b := range rec {
+			sum += b
+		}
+		if sum != 0 {
+			return false, fmt.Errorf("checksum mismatch")
+		}
+
+		offset := Address(rec[1])<<8 | Address(rec[2])
+		data := rec[4 : len(rec)-1]
+		switch rec[3] {
+		case 0x00: // data
+			img.add(base+offset, data)
+		case 0x01: // end of file
+			sawEOF = true
+			return true, nil
+		case 0x02: // extended segment address
+			if len(data) != 2 {
+				return false, fmt.Errorf("extended segment address record must have 2 bytes")
+			}
+			base = (Address(data[0])<<8 | Address(data[1])) << 4
+		case 0x03: // start segment address, CS:IP
+			if len(data) != 4 {
+				return false, fmt.Errorf("start segment address record must have 4 bytes")
+			}
+			cs := Address(data[0])<<8 | Address(data[1])
+			ip := Address(data[2])<<8 | Address(data[3])
+			img.setStart(cs<<4 + ip)
+		case 0x04: // extended linear address
+			if len(data) != 2 {
+				return false, fmt.Errorf("extended linear address record must have 2 bytes")
+			}
+			base = (Address(data[0])<<8 | Address(data[1])) << 16
+		case 0x05: // start linear address
+			if len(data) != 4 {
+				return false, fmt.Errorf("start linear address record must have 4 bytes")
+			}
+			img.setStart(Address(data[0])<<24 | Address(data[1])<<16 | Address(data[2])<<8 | Address(data[3]))
+		default:
+			return false, fmt.Errorf("unknown record type %02X", rec[3])
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !sawEOF {
+		return nil, fmt.Errorf("missing end of file record")
+	}
+	return img, nil
+}
+
+// ReadSRecord reads a Motorola S-record file. S1, S2 and S3 data records
+// carry 16, 24 and 32 bit addresses, and an S7, S8 or S9 record sets the
+// image's start address.
+func ReadSRecord(r io.Reader) (*HexImage, error) {
+	img := &HexImage{}
+	dataRecords := 0
+
+	err := hexRecords(r, 'S', func(lineNum int, line string) (bool, error) {
+		if len(line) < 4 {
+			return false, fmt.Errorf("record too short")
+		}
+		kind := line[1]
+		rec, err := hex.DecodeString(line[2:])
+		if err != nil {
+			return false, fmt.Errorf("invalid hex digits")
+		}
+		if len(rec) < 1 || len(rec) != int(rec[0])+1 {
+			return false, fmt.Errorf("record length does not match its byte count")
+		}
+		var sum byte
+		for _, b := range rec[:len(rec)-1] {
+			sum += b
+		}
+		if ^sum != rec[len(rec)-1] {
+			return false, fmt.Errorf("checksum mismatch")
+		}
+
+		var addrLen int
+		switch kind {
+		case '0', '1', '5', '9':
+			addrLen = 2
+		case '2', '6', '8':
+			addrLen = 3
+		case '3', '7':
+			addrLen = 4
+		default:
+			return false, fmt.Errorf("unknown record type S%c", kind)
+		}
+		body := rec[1 : len(rec)-1]
+		if len(body) < addrLen {
+			return false, fmt.Errorf("record too short for its address")
+		}
+		var address Address
+		for _, b := range body[:addrLen] {
+			address = address<<8 | Address(b)
+		}
+		data := body[addrLen:]
+
+		switch kind {
+		case '0': // header
+		case '1', '2', '3':
+			img.add(address, data)
+			dataRecords++
+		case '5', '6':
+			if int(address) != dataRecords {
+				return false, fmt.Errorf("record count is %d, but there were %d data records", address, dataRecords)
+			}
+		case '7', '8', '9':
+			img.setStart(address)
+			return true, nil
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// ReadHexFile reads an Intel HEX or Motorola S-record file, telling them
+// apart by their first record.
+func ReadHexFile(filename string) (*HexImage, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var img *HexImage
+	switch text := strings.TrimSpace(string(data)); {
+	case strings.HasPrefix(text, ":"):
+		img, err = ReadIntelHex(strings.NewReader(text))
+	case strings.HasPrefix(text, "S"):
+		img, err = ReadSRecord(strings.NewReader(text))
+	default:
+		return nil, fmt.Errorf("%s: not an Intel HEX or S-record file", filename)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return img, nil
+}
+
+// memoryAt returns the Memory device that holds a physical address. When
+// several devices overlap, as RAM and ROM do on the banked boards, the one
+// that is currently enabled is preferred.
+func (sim *CpuSim) memoryAt(address Address) *Memory {
+	var found *Memory
+	for _, m := range sim.Memory {
+		mem, ok := asMemory(m)
+		if !ok || address < mem.StartAddress || address > mem.EndAddress {
+			continue
+		}
+		if mem.Enabler.Bool() {
+			return mem
+		}
+		if found == nil {
+			found = mem
+		}
+	}
+	return found
+}
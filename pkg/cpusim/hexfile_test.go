@@ -0,0 +1,54 @@
+package cpusim
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntelHex(t *testing.T) {
+	img, err := ReadIntelHex(strings.NewReader(`
+:0401000006033E4272
+:02010400DDE13B
+:020000040001F9
+:01200000AA35
+:0400000500000100F6
+:00000001FF
+`))
+	require.NoError(t, err)
+	require.Len(t, img.Segments, 2)
+	assert.Equal(t, HexSegment{Address: 0x100, Data: []byte{0x06, 0x03, 0x3E, 0x42, 0xDD, 0xE1}}, img.Segments[0])
+	assert.Equal(t, HexSegment{Address: 0x12000, Data: []byte{0xAA}}, img.Segments[1])
+	require.NotNil(t, img.Start)
+	assert.Equal(t, Address(0x100), *img.Start)
+
+	_, err = ReadIntelHex(strings.NewReader(":0401000006033E4273\n:00000001FF\n"))
+	assert.ErrorContains(t, err, "line 1: checksum mismatch")
+
+	_, err = ReadIntelHex(strings.NewReader(":0401000006033E4272\n"))
+	assert.ErrorContains(t, err, "missing end of file record")
+}
+
+func TestSRecord(t *testing.T) {
+	img, err := ReadSRecord(strings.NewReader(`
+S00600004844521B
+S107010006033E426E
+S2050080007604
+S5030002FA
+S9030100FB
+`))
+	require.NoError(t, err)
+	require.Len(t, img.Segments, 2)
+	assert.Equal(t, HexSegment{Address: 0x100, Data: []byte{0x06, 0x03, 0x3E, 0x42}}, img.Segments[0])
+	assert.Equal(t, HexSegment{Address: 0x8000, Data: []byte{0x76}}, img.Segments[1])
+	require.NotNil(t, img.Start)
+	assert.Equal(t, Address(0x100), *img.Start)
+
+	_, err = ReadSRecord(strings.NewReader("S107010006033E426F\n"))
+	assert.ErrorContains(t, err, "line 1: checksum mismatch")
+
+	_, err = ReadSRecord(strings.NewReader("S107010006033E426E\nS5030002FA\n"))
+	assert.ErrorContains(t, err, "record count is 2, but there were 1 data records")
+}
@@ -7,9 +7,45 @@ type CpuInterface interface {
 	GetReg(register int) (byte, error)
 	String() string
 	Run() error
+	Execute() error // execute a single instruction
 	Halt()
 }
 
+// HaltReporter is implemented by CPUs that say when they have halted, so that
+// they can be run an instruction at a time by something other than Run.
+// ClearHalt undoes Halt before they are run again.
+type HaltReporter interface {
+	IsHalted() bool
+	ClearHalt()
+}
+
+// DebugInterface is implemented by CPUs that can be inspected and changed
+// by a debugger. RegisterNames gives the name of each register number that
+// GetReg and SetReg accept; numbers with an empty name are skipped.
+type DebugInterface interface {
+	GetPC() Address
+	SetPC(pc Address)
+	RegisterNames() []string
+}
+
+// RemoteRegister describes a register as a remote debugger sees it.
+type RemoteRegister struct {
+	Name string
+	Bits int // 8 or 16
+	Get  func() uint16
+	Set  func(value uint16)
+}
+
+// RemoteDebugInterface is implemented by CPUs that can be debugged over the
+// GDB remote protocol. RemoteRegisters lists the registers in the order of
+// GDB's register numbers. RemoteArchitecture is the name GDB knows the CPU
+// by, or "" if GDB has no support for it.
+type RemoteDebugInterface interface {
+	DebugInterface
+	RemoteRegisters() []RemoteRegister
+	RemoteArchitecture() string
+}
+
 type MemoryInterface interface {
 	GetKind() string
 	HasAddress(address Address) bool
@@ -29,6 +65,20 @@ type UartInterface interface {
 	RestoreTerminal()
 }
 
+// InputReceiver is implemented by serial devices. The device's input
+// goroutine hands each byte read from its SerialIO to CpuSim.DeliverInput,
+// which passes it on to ReceiveInput.
+type InputReceiver interface {
+	DeviceInterface
+	ReceiveInput(b byte)
+}
+
+// InputEnder is implemented by serial devices that stop the machine once
+// their input has ended and been read.
+type InputEnder interface {
+	EndInput()
+}
+
 // SerialIO abstracts the byte-level I/O transport for serial devices.
 type SerialIO interface {
 	ReadByte() (byte, error)
@@ -0,0 +1,336 @@
+package cpusim
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Lifecycle
+//
+// Run runs the CPUs until the machine stops, and returns why it stopped.
+// It can be stopped from outside by canceling its context or with Stop, and
+// from inside by the program halting, by Ctrl-C on a console or by the end
+// of a console's input file. While it runs, Pause holds the CPUs between
+// instructions, for Reset or for looking at the machine, and Resume lets
+// them go on. Close releases the disk images once the machine is done with.
+//
+// CPUs that don't implement HaltReporter are run by their own Run loops,
+// which Pause can't hold.
+
+// ExitReason is why Run returned.
+type ExitReason int
+
+const (
+	// ExitHalted means that the CPU halted, or that Halt was called.
+	ExitHalted ExitReason = iota
+	// ExitInterrupted means that Ctrl-C was typed on a console, or that the
+	// monitor or a debugger asked to quit.
+	ExitInterrupted
+	// ExitEndOfInput means that a console's input file ran out.
+	ExitEndOfInput
+	// ExitCanceled means that the context was canceled.
+	ExitCanceled
+	// ExitError means that a CPU failed; the RunResult has the error.
+	ExitError
+)
+
+func (r ExitReason) String() string {
+	switch r {
+	case ExitHalted:
+		return "halted"
+	case ExitInterrupted:
+		return "interrupted"
+	case ExitEndOfInput:
+		return "end of input"
+	case ExitCanceled:
+		return "canceled"
+	case ExitError:
+		return "error"
+	}
+	return fmt.Sprintf("ExitReason(%d)", int(r))
+}
+
+// RunResult says why Run returned.
+type RunResult struct {
+	Reason ExitReason
+	CPU    CpuInterface // the CPU that stopped first
+	Err    error        // set with ExitError
+}
+
+// ResetKind is the kind of reset that Reset does.
+type ResetKind int
+
+const (
+	// ResetWarm is the reset button: the CPUs and the devices' registers
+	// go back to their power-on state, but memory keeps its contents.
+	ResetWarm ResetKind = iota
+	// ResetCold is turning the power off and on: RAM and the registers
+	// that the reset line doesn't reach are cleared as well.
+	ResetCold
+)
+
+func (k ResetKind) String() string {
+	if k == ResetCold {
+		return "cold"
+	}
+	return "warm"
+}
+
+// Resetter is implemented by CPUs and devices that can be reset.
+type Resetter interface {
+	ResetDevice(kind ResetKind)
+}
+
+// lifecycle is the state that Run, Stop and Pause share.
+type lifecycle struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pausing atomic.Bool // checked by the CPUs before every instruction
+	loops   int         // CPUs running in Run that can be paused
+	parked  int         // CPUs held by Pause
+	stopped bool
+	reason  ExitReason
+}
+
+// wait waits on the condition, which is made when it is first needed. The
+// mutex must be held.
+func (l *lifecycle) wait() {
+	if l.cond == nil {
+		l.cond = sync.NewCond(&l.mu)
+	}
+	l.cond.Wait()
+}
+
+func (l *lifecycle) broadcast() {
+	if l.cond != nil {
+		l.cond.Broadcast()
+	}
+}
+
+// begin forgets why the last run stopped.
+func (sim *CpuSim) begin() {
+	sim.CtrlC.Store(false)
+	sim.life.mu.Lock()
+	sim.life.stopped = false
+	sim.life.mu.Unlock()
+	for _, cpu := range sim.CPU {
+		if h, ok := cpu.(HaltReporter); ok {
+			h.ClearHalt()
+		}
+	}
+}
+
+// stopReason returns the reason given to Stop, if it has been called since
+// the run began.
+func (sim *CpuSim) stopReason() (ExitReason, bool) {
+	sim.life.mu.Lock()
+	defer sim.life.mu.Unlock()
+	return sim.life.reason, sim.life.stopped
+}
+
+// Run runs the CPUs until the first of them stops, which stops the rest, or
+// until ctx is canceled. It returns why the machine stopped. The CPUs are
+// halted afterwards; calling Run again carries on from where they stopped.
+func (sim *CpuSim) Run(ctx context.Context) RunResult {
+	if len(sim.CPU) == 0 {
+		return RunResult{Reason: ExitError, Err: fmt.Errorf("no CPUs")}
+	}
+	sim.begin()
+	stop := context.AfterFunc(ctx, func() {
+		sim.Stop(ExitCanceled)
+	})
+	defer stop()
+
+	results := make(chan RunResult, len(sim.CPU))
+	for _, cpu := range sim.CPU {
+		sim.running.Add(1)
+		go func(c CpuInterface) {
+			defer sim.running.Add(-1)
+			results <- sim.runUntilStopped(c, sim.Step)
+		}(cpu)
+	}
+
+	var first RunResult
+	for i := range sim.CPU {
+		result := <-results
+		if i == 0 {
+			first = result
+			sim.Stop(result.Reason)
+		} else if result.Err != nil && first.Err == nil {
+			first = result
+		}
+	}
+	if reason, ok := sim.stopReason(); ok && first.Err == nil {
+		first.Reason = reason
+	}
+	return first
+}
+
+// runUntilStopped runs a CPU an instruction at a time with step, as its Run
+// loop would, so that it can be paused.
+func (sim *CpuSim) runUntilStopped(cpu CpuInterface, step func(CpuInterface) error) RunResult {
+	h, ok := cpu.(HaltReporter)
+	if !ok {
+		if err := cpu.Run(); err != nil {
+			return RunResult{Reason: ExitError, CPU: cpu, Err: err}
+		}
+		if sim.CtrlC.Load() {
+			return RunResult{Reason: ExitInterrupted, CPU: cpu}
+		}
+		return RunResult{Reason: ExitHalted, CPU: cpu}
+	}
+
+	sim.life.mu.Lock()
+	sim.life.loops++
+	sim.life.mu.Unlock()
+	defer func() {
+		sim.life.mu.Lock()
+		sim.life.loops--
+		sim.life.broadcast()
+		sim.life.mu.Unlock()
+	}()
+
+	for {
+		if sim.life.pausing.Load() {
+			sim.park()
+		}
+		if sim.CtrlC.Load() {
+			return RunResult{Reason: ExitInterrupted, CPU: cpu}
+		}
+		if h.IsHalted() {
+			return RunResult{Reason: ExitHalted, CPU: cpu}
+		}
+		if err := step(cpu); err != nil {
+			return RunResult{Reason: ExitError, CPU: cpu, Err: err}
+		}
+	}
+}
+
+// Step runs an instruction on one of the CPUs, with the throttle and the
+// step hooks, as its Run loop would.
+func (sim *CpuSim) Step(cpu CpuInterface) error {
+	var start uint64
+	counter, counted := cpu.(CycleCounter)
+	if counted {
+		start = counter.ClockCycles()
+	}
+	if err := cpu.Execute(); err != nil {
+		return err
+	}
+	if counted {
+		sim.Throttle.Tick(counter.ClockCycles() - start)
+	} else {
+		sim.Throttle.Tick(1)
+	}
+	return sim.AfterStep()
+}
+
+// park holds a CPU until Resume, or until the machine is stopped.
+func (sim *CpuSim) park() {
+	sim.life.mu.Lock()
+	defer sim.life.mu.Unlock()
+	sim.life.parked++
+	sim.life.broadcast()
+	for sim.life.pausing.Load() && !sim.life.stopped {
+		sim.life.wait()
+	}
+	sim.life.parked--
+}
+
+// Stop stops the machine, giving the reason that Run returns. Only the first
+// reason is kept. Devices call it when their input ends.
+func (sim *CpuSim) Stop(reason ExitReason) {
+	sim.life.mu.Lock()
+	if !sim.life.stopped {
+		sim.life.stopped = true
+		sim.life.reason = reason
+	}
+	sim.life.broadcast()
+	sim.life.mu.Unlock()
+	sim.Halt()
+}
+
+// Pause holds the CPUs between instructions, and returns once they are all
+// held. A machine paused before Run starts holds its CPUs from the first
+// instruction.
+func (sim *CpuSim) Pause() {
+	sim.life.mu.Lock()
+	defer sim.life.mu.Unlock()
+	sim.life.pausing.Store(true)
+	for sim.life.parked < sim.life.loops && !sim.life.stopped {
+		sim.life.wait()
+	}
+}
+
+// Resume lets the CPUs go on after Pause.
+func (sim *CpuSim) Resume() {
+	sim.life.mu.Lock()
+	defer sim.life.mu.Unlock()
+	sim.life.pausing.Store(false)
+	sim.life.broadcast()
+}
+
+// Paused reports whether the machine has been paused.
+func (sim *CpuSim) Paused() bool {
+	return sim.life.pausing.Load()
+}
+
+// Reset resets the CPUs and the devices. A running machine is paused for
+// the reset and then goes on from the reset address, so Reset must not be
+// called from a CPU's goroutine, such as by a step hook.
+func (sim *CpuSim) Reset(kind ResetKind) {
+	if !sim.Paused() {
+		sim.Pause()
+		defer sim.Resume()
+	}
+	for _, cpu := range sim.CPU {
+		if r, ok := cpu.(Resetter); ok {
+			r.ResetDevice(kind)
+		}
+	}
+	sim.eachDevice(func(device any) {
+		if r, ok := device.(Resetter); ok {
+			r.ResetDevice(kind)
+		}
+	})
+	sim.InvalidateDecode()
+}
+
+// Close flushes and closes the files that the devices have open, such as
+// disk images. The machine should not be run after it is closed.
+func (sim *CpuSim) Close() error {
+	var err error
+	sim.eachDevice(func(device any) {
+		if c, ok := device.(io.Closer); ok {
+			if cerr := c.Close(); cerr != nil && err == nil {
+				err = fmt.Errorf("%s: %w", deviceName(device), cerr)
+			}
+		}
+	})
+	return err
+}
+
+// PrintResult prints why the machine stopped, as the CPUs' Run loops do.
+func (sim *CpuSim) PrintResult(result RunResult) {
+	switch result.Reason {
+	case ExitInterrupted:
+		fmt.Println("CPU halted by Ctrl-C")
+	case ExitError:
+		fmt.Printf("%s", result.Err)
+		if d, ok := result.CPU.(DebugInterface); ok && sim.Symbols != nil {
+			fmt.Printf(" (PC at %s)", sim.DescribeAddress(d.GetPC()))
+		}
+	default:
+		fmt.Println("CPU halted")
+	}
+}
+
+func deviceName(device any) string {
+	if d, ok := device.(DeviceInterface); ok {
+		return d.GetName()
+	}
+	return fmt.Sprintf("%T", device)
+}
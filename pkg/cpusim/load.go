@@ -0,0 +1,256 @@
+package cpusim
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LoadSpec says where to put a file given to --load.
+//
+// The syntax is [DEVICE=]FILE[@OFFSET|:BANK]. DEVICE names a Memory device
+// and defaults to "rom". OFFSET is a hex offset into the device, and BANK
+// counts mapper pages from the start of the device. A hex file without a
+// DEVICE goes wherever its addresses say; with one, its addresses are
+// offsets into that device.
+type LoadSpec struct {
+	Filename string
+	Device   string
+	Offset   Address
+	Bank     int // or NoBank
+}
+
+// DefaultLoadDevice is the Memory device that raw images are loaded into
+// unless a LoadSpec names another.
+const DefaultLoadDevice = "rom"
+
+// ParseLoadSpec parses an argument to --load.
+func ParseLoadSpec(arg string) (LoadSpec, error) {
+	spec := LoadSpec{Filename: arg, Bank: NoBank}
+	if i := strings.Index(spec.Filename, "="); i >= 0 {
+		spec.Device, spec.Filename = spec.Filename[:i], spec.Filename[i+1:]
+	}
+	if i := strings.LastIndex(spec.Filename, "@"); i >= 0 {
+		str := strings.ToLower(spec.Filename[i+1:])
+		str = strings.TrimPrefix(str, "0x")
+		str = strings.TrimPrefix(str, "$")
+		str = strings.TrimSuffix(str, "h")
+		offset, err := strconv.ParseUint(str, 16, 32)
+		if err != nil {
+			return spec, fmt.Errorf("invalid offset in %q", arg)
+		}
+		spec.Filename, spec.Offset = spec.Filename[:i], Address(offset)
+	} else if i := strings.LastIndex(spec.Filename, ":"); i >= 0 {
+		bank, err := strconv.Atoi(spec.Filename[i+1:])
+		if err == nil {
+			if bank < 0 {
+				return spec, fmt.Errorf("invalid bank in %q", arg)
+			}
+			spec.Filename, spec.Bank = spec.Filename[:i], bank
+		}
+	}
+	if spec.Filename == "" {
+		return spec, fmt.Errorf("no file name in %q", arg)
+	}
+	return spec, nil
+}
+
+// String formats the spec the way ParseLoadSpec reads it.
+func (spec LoadSpec) String() string {
+	s := spec.Filename
+	if spec.Device != "" {
+		s = spec.Device + "=" + s
+	}
+	if spec.Bank != NoBank {
+		return fmt.Sprintf("%s:%d", s, spec.Bank)
+	}
+	if spec.Offset != 0 {
+		return fmt.Sprintf("%s@%X", s, spec.Offset)
+	}
+	return s
+}
+
+// IsHexFile says whether a file name has an Intel HEX or S-record extension.
+func IsHexFile(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".hex", ".ihx", ".ihex", ".s19", ".s28", ".s37", ".srec", ".mot":
+		return true
+	}
+	return false
+}
+
+// ImageLoader places images into a machine's Memory devices. It remembers
+// which file filled each byte, so that images that overlap are reported
+// instead of silently overwriting each other.
+type ImageLoader struct {
+	Sim *CpuSim
+
+	owners map[*Memory][]int16 // index+1 into names, per byte
+	names  []string
+}
+
+func NewImageLoader(sim *CpuSim) *ImageLoader {
+	return &ImageLoader{Sim: sim, owners: map[*Memory][]int16{}}
+}
+
+// Erase fills every ROM device with 0xFF, as an erased EPROM reads.
+func (l *ImageLoader) Erase() {
+	for _, m := range l.Sim.Memory {
+		if mem, ok := asMemory(m); ok && mem.Kind == KIND_ROM {
+			for i := range mem.Contents {
+				mem.Contents[i] = 0xFF
+			}
+		}
+	}
+}
+
+func (l *ImageLoader) device(name string) (*Memory, error) {
+	for _, m := range l.Sim.Memory {
+		if mem, ok := asMemory(m); ok && mem.Name == name {
+			return mem, nil
+		}
+	}
+	return nil, fmt.Errorf("no memory device named %q", name)
+}
+
+// offset returns where in mem a spec starts.
+func (l *ImageLoader) offset(spec LoadSpec, mem *Memory) (Address, error) {
+	if spec.Bank == NoBank {
+		return spec.Offset, nil
+	}
+	for _, mapper := range l.Sim.Mappers {
+		if pm, ok := mapper.(PageMapper); ok {
+			return Address(spec.Bank) << pm.PageBits(), nil
+		}
+	}
+	return 0, fmt.Errorf("%s: a bank needs a memory mapper, and this machine has none", spec)
+}
+
+// claim marks bytes [index, index+n) of mem as loaded from the file numbered
+// owner, failing if the range overflows the device or another file already
+// loaded any of it.
+func (l *ImageLoader) claim(mem *Memory, index Address, n int, owner int16) error {
+	if int(index)+n > len(mem.Contents) {
+		return fmt.Errorf("%s: %d bytes at offset %X overflow %s, which has %d bytes", l.names[owner-1], n, index, mem.Name, len(mem.Contents))
+	}
+	owners := l.owners[mem]
+	if owners == nil {
+		owners = make([]int16, len(mem.Contents))
+		l.owners[mem] = owners
+	}
+	for i := index; i < index+Address(n); i++ {
+		if owners[i] != 0 && owners[i] != owner {
+			return fmt.Errorf("%s overlaps %s in %s at offset %X", l.names[owner-1], l.names[owners[i]-1], mem.Name, i)
+		}
+		owners[i] = owner
+	}
+	return nil
+}
+
+// Load loads one file as a spec says.
+func (l *ImageLoader) Load(spec LoadSpec) error {
+	l.names = append(l.names, spec.String())
+	owner := int16(len(l.names))
+
+	if IsHexFile(spec.Filename) {
+		return l.loadHex(spec, owner)
+	}
+
+	name := spec.Device
+	if name == "" {
+		name = DefaultLoadDevice
+	}
+	mem, err := l.device(name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", spec, err)
+	}
+	index, err := l.offset(spec, mem)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(spec.Filename)
+	if err != nil {
+		return err
+	}
+	if err := l.claim(mem, index, len(data), owner); err != nil {
+		return err
+	}
+	copy(mem.Contents[index:], data)
+	return nil
+}
+
+func (l *ImageLoader) loadHex(spec LoadSpec, owner int16) error {
+	img, err := ReadHexFile(spec.Filename)
+	if err != nil {
+		return err
+	}
+
+	var mem *Memory
+	var base Address
+	if spec.Device != "" {
+		if mem, err = l.device(spec.Device); err != nil {
+			return fmt.Errorf("%s: %w", spec, err)
+		}
+		if base, err = l.offset(spec, mem); err != nil {
+			return err
+		}
+	}
+
+	for _, seg := range img.Segments {
+		for i, b := range seg.Data {
+			address := seg.Address + Address(i)
+			target, index := mem, base+address
+			if target == nil {
+				if target = l.Sim.memoryAt(address); target == nil {
+					return fmt.Errorf("%s: no memory at address %04X", spec, address)
+				}
+				index = address - target.StartAddress
+			}
+			if err := l.claim(target, index, 1, owner); err != nil {
+				return err
+			}
+			target.Contents[index] = b
+		}
+	}
+
+	if img.Start != nil && len(l.Sim.CPU) > 0 {
+		cpu, ok := l.Sim.CPU[0].(DebugInterface)
+		if !ok {
+			return fmt.Errorf("%s: cannot set the PC to the start address %04X", spec, *img.Start)
+		}
+		cpu.SetPC(*img.Start)
+	}
+	return nil
+}
+
+// LoadOptions are the image loading command line options.
+type LoadOptions struct {
+	ROM      string   // --rom-file, loaded whole at the start of the rom device
+	Images   []string // --load, as parsed by ParseLoadSpec
+	EraseROM bool     // fill ROM with 0xFF before loading
+}
+
+// LoadImages loads the ROM file and images given on the command line.
+func (sim *CpuSim) LoadImages(opts LoadOptions) error {
+	l := NewImageLoader(sim)
+	if opts.EraseROM {
+		l.Erase()
+	}
+	if opts.ROM != "" {
+		if err := l.Load(LoadSpec{Filename: opts.ROM, Device: DefaultLoadDevice, Bank: NoBank}); err != nil {
+			return err
+		}
+	}
+	for _, arg := range opts.Images {
+		spec, err := ParseLoadSpec(arg)
+		if err != nil {
+			return err
+		}
+		if err := l.Load(spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package cpusim
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLoadSpec(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want LoadSpec
+	}{
+		{"rom.bin", LoadSpec{Filename: "rom.bin", Bank: NoBank}},
+		{"bios.bin@0x4000", LoadSpec{Filename: "bios.bin", Offset: 0x4000, Bank: NoBank}},
+		{"ram=prog.bin@100", LoadSpec{Filename: "prog.bin", Device: "ram", Offset: 0x100, Bank: NoBank}},
+		{"cpm.bin:3", LoadSpec{Filename: "cpm.bin", Bank: 3}},
+		{"c:prog.bin", LoadSpec{Filename: "c:prog.bin", Bank: NoBank}},
+	}
+	for _, tt := range tests {
+		spec, err := ParseLoadSpec(tt.arg)
+		require.NoError(t, err, tt.arg)
+		assert.Equal(t, tt.want, spec, tt.arg)
+	}
+
+	_, err := ParseLoadSpec("rom.bin@xyz")
+	assert.ErrorContains(t, err, "invalid offset")
+}
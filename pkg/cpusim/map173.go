@@ -1,7 +1,8 @@
 package cpusim
 
 import (
-// "fmt"
+	"encoding/json"
+	// "fmt"
 )
 
 // 74LS173 style memory mapper
@@ -28,8 +29,13 @@ func (m *Map173) HasAddress(address Address) bool {
 	return address == m.MapperAddress
 }
 
+func (m *Map173) DecodeEnabler() EnablerInterface {
+	return m.Enabler
+}
+
 func (m *Map173) Write(address Address, value byte) error {
 	m.Contents = value
+	m.Sim.InvalidateDecode()
 	//fmt.Printf("MAP 173: Writing value %02X to address %04X\n", value, address)
 	return nil
 }
@@ -70,12 +76,37 @@ func (m *Map173) Map(address Address) (Address, error) {
 	return address, nil
 }
 
+// PageBits returns the lowest address bit replaced by the mapper.
+func (m *Map173) PageBits() int {
+	bits := 32
+	for _, dest := range m.DestBit {
+		if dest >= 0 && dest < bits {
+			bits = dest
+		}
+	}
+	return bits
+}
+
+// Bank returns the register value, which maps every address.
+func (m *Map173) Bank(address Address) int {
+	return int(m.Contents)
+}
+
+func (m *Map173) MapInputs() []EnablerInterface {
+	return nil
+}
+
+func (m *Map173) MapOutputs() []*EnableBit {
+	return nil
+}
+
 func (m *Map173) GetKind() string {
 	return KIND_MAPPER
 }
 
 func (m *Map173) FilterMemoryKind(kind string) {
 	m.MemoryFilter = kind
+	m.Sim.InvalidateDecode()
 }
 
 func (m *Map173) MatchMemory(mem MemoryInterface) bool {
@@ -91,3 +122,41 @@ func New74173(sim *CpuSim, name string, address Address, destBit0, destBit1, des
 		Enabler:       enabler,
 	}
 }
+
+func init() {
+	RegisterDevice(KIND_74173, func(sim *CpuSim, o MapperOptions) (DeviceInterface, error) {
+		d, err := o.destBits(KIND_74173, 4)
+		if err != nil {
+			return nil, err
+		}
+		m := New74173(sim, o.Name, o.Address, d[0], d[1], d[2], d[3], enablerOrAlways(o.Enabler))
+		if o.Filter != "" {
+			m.FilterMemoryKind(o.Filter)
+		}
+		return m, nil
+	})
+}
+
+// ResetDevice clears the register, as the 74173's CLR input does.
+func (m *Map173) ResetDevice(kind ResetKind) {
+	m.Contents = 0
+	m.Sim.InvalidateDecode()
+}
+
+type map173State struct {
+	Contents byte `json:"contents"`
+}
+
+func (m *Map173) SaveState() (any, error) {
+	return &map173State{Contents: m.Contents}, nil
+}
+
+func (m *Map173) RestoreState(data json.RawMessage) error {
+	var state map173State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	m.Contents = state.Contents
+	m.Sim.InvalidateDecode()
+	return nil
+}
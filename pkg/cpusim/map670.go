@@ -1,6 +1,7 @@
 package cpusim
 
 import (
+	"encoding/json"
 	"fmt"
 )
 
@@ -33,9 +34,14 @@ func (m *Map670) HasAddress(address Address) bool {
 	return (address >= m.MapperAddress) && (address <= (m.MapperAddress + 3))
 }
 
+func (m *Map670) DecodeEnabler() EnablerInterface {
+	return m.Enabler
+}
+
 func (m *Map670) Write(address Address, value byte) error {
 	index := (address - m.MapperAddress) & m.SourceMask
 	m.Contents[index] = value
+	m.Sim.InvalidateDecode()
 	if m.Sim.MemDebug {
 		fmt.Printf("MAP 670 %s: Writing value %02X to address %04X index %04X\n", m.Name, value, address, index)
 	}
@@ -100,12 +106,41 @@ func (m *Map670) ConnectEnableBit(bit int, enableBit *EnableBit) {
 	m.ConnectedEnableBit[bit] = enableBit
 }
 
+// PageBits returns the size of the blocks that are mapped as a unit: the
+// lowest address bit that either selects a register or is replaced by one.
+func (m *Map670) PageBits() int {
+	bits := m.SourceBit
+	for _, dest := range m.DestBit {
+		if dest >= 0 && dest < bits {
+			bits = dest
+		}
+	}
+	return bits
+}
+
+// Bank returns the register value that Map uses for address.
+func (m *Map670) Bank(address Address) int {
+	if !m.MapEnabler.Bool() {
+		return 0
+	}
+	return int(m.Contents[(address>>m.SourceBit)&m.SourceMask])
+}
+
+func (m *Map670) MapInputs() []EnablerInterface {
+	return []EnablerInterface{m.MapEnabler}
+}
+
+func (m *Map670) MapOutputs() []*EnableBit {
+	return m.ConnectedEnableBit[:]
+}
+
 func (m *Map670) GetKind() string {
 	return KIND_MAPPER
 }
 
 func (m *Map670) FilterMemoryKind(kind string) {
 	m.MemoryFilter = kind
+	m.Sim.InvalidateDecode()
 }
 
 func (m *Map670) MatchMemory(mem MemoryInterface) bool {
@@ -139,3 +174,85 @@ func NewDual74670(sim *CpuSim, name string, address Address, sourceBit, sourceDa
 		MapEnabler:    mapEnabler,
 	}
 }
+
+// MapperOptions are the options for the KIND_74670, KIND_DUAL_74670 and
+// KIND_74173 mappers. DestBits are the address bits that a register drives,
+// up to 4, or 8 for the dual 74670. The 74173 has no SourceBit, DataBit or
+// MapEnabler. Filter limits the mapper to memory of one kind.
+type MapperOptions struct {
+	Name       string           `yaml:"name"`
+	Address    Address          `yaml:"address"`
+	SourceBit  int              `yaml:"source-bit"`
+	DataBit    int              `yaml:"data-bit"`
+	DestBits   []int            `yaml:"dest-bits"`
+	Filter     string           `yaml:"filter"`
+	Enabler    EnablerInterface `yaml:"-"`
+	MapEnabler EnablerInterface `yaml:"-"`
+}
+
+// destBits returns the options' DestBits padded with NOPIN to the n outputs
+// that a kind of mapper has.
+func (o MapperOptions) destBits(kind string, n int) ([]int, error) {
+	if len(o.DestBits) > n {
+		return nil, fmt.Errorf("a %s drives at most %d address bits", kind, n)
+	}
+	bits := append([]int(nil), o.DestBits...)
+	for len(bits) < n {
+		bits = append(bits, NOPIN)
+	}
+	return bits, nil
+}
+
+func init() {
+	RegisterDevice(KIND_74670, func(sim *CpuSim, o MapperOptions) (DeviceInterface, error) {
+		d, err := o.destBits(KIND_74670, 4)
+		if err != nil {
+			return nil, err
+		}
+		m := New74670(sim, o.Name, o.Address, o.SourceBit, o.DataBit, d[0], d[1], d[2], d[3], enablerOrAlways(o.Enabler), enablerOrAlways(o.MapEnabler))
+		if o.Filter != "" {
+			m.FilterMemoryKind(o.Filter)
+		}
+		return m, nil
+	})
+	RegisterDevice(KIND_DUAL_74670, func(sim *CpuSim, o MapperOptions) (DeviceInterface, error) {
+		d, err := o.destBits(KIND_DUAL_74670, 8)
+		if err != nil {
+			return nil, err
+		}
+		m := NewDual74670(sim, o.Name, o.Address, o.SourceBit, o.DataBit, d[0], d[1], d[2], d[3], d[4], d[5], d[6], d[7], enablerOrAlways(o.Enabler), enablerOrAlways(o.MapEnabler))
+		if o.Filter != "" {
+			m.FilterMemoryKind(o.Filter)
+		}
+		return m, nil
+	})
+}
+
+// ResetDevice clears the register file on a cold reset. The 74670 has no
+// reset input, so a warm reset leaves the mapping alone.
+func (m *Map670) ResetDevice(kind ResetKind) {
+	if kind == ResetCold {
+		m.Contents = [16]byte{}
+		m.Sim.InvalidateDecode()
+	}
+}
+
+type map670State struct {
+	Contents   [16]byte `json:"contents"`
+	EnableBits []bool   `json:"enable_bits"`
+}
+
+func (m *Map670) SaveState() (any, error) {
+	return &map670State{Contents: m.Contents, EnableBits: enableBitStates(m.ConnectedEnableBit[:])}, nil
+}
+
+func (m *Map670) RestoreState(data json.RawMessage) error {
+	var state map670State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	m.Contents = state.Contents
+	restoreEnableBits(m.ConnectedEnableBit[:], state.EnableBits)
+	m.Sim.InvalidateDecode()
+	return nil
+}
@@ -1,8 +1,8 @@
 package cpusim
 
 import (
+	"encoding/json"
 	"fmt"
-	"io"
 	"os"
 )
 
@@ -30,6 +30,14 @@ func (mem *Memory) HasAddress(address Address) bool {
 	return (address >= mem.StartAddress) && (address <= mem.EndAddress)
 }
 
+func (mem *Memory) DecodeEnabler() EnablerInterface {
+	return mem.Enabler
+}
+
+func (mem *Memory) AddressRange() (Address, Address) {
+	return mem.StartAddress, mem.EndAddress
+}
+
 func (mem *Memory) Read(address Address) (byte, error) {
 	if !mem.HasAddress(address) {
 		return 0, &ErrInvalidAddress{Device: mem, Address: address}
@@ -79,17 +87,14 @@ func (mem *Memory) WriteStatus(address Address, statusAddr Address, value byte)
 }
 
 func (mem *Memory) Load(filename string) error {
-	file, err := os.Open(filename)
+	data, err := os.ReadFile(filename)
 	if err != nil {
 		return err
 	}
-	defer file.Close() // nolint:errcheck
-
-	_, err = file.Read(mem.Contents)
-	if err != nil && err != io.EOF {
-		return err
+	if len(data) > len(mem.Contents) {
+		return fmt.Errorf("%s is %d bytes, but %s only has %d", filename, len(data), mem.Name, len(mem.Contents))
 	}
-
+	copy(mem.Contents, data)
 	return nil
 }
 
@@ -118,3 +123,77 @@ func NewMemory(sim *CpuSim, name string, kind string, startAddress, endAddress A
 	}
 	return mem
 }
+
+// MemoryOptions are the options for KIND_RAM and KIND_ROM devices. A ROM is
+// read-only whatever ReadOnly says. StatusRows and StatusColumns give 4004
+// style memory its status characters.
+type MemoryOptions struct {
+	Name          string           `yaml:"name"`
+	Start         Address          `yaml:"start"`
+	End           Address          `yaml:"end"`
+	AddressBits   int              `yaml:"bits"`
+	ReadOnly      bool             `yaml:"read-only"`
+	StatusRows    int              `yaml:"status-rows"`
+	StatusColumns int              `yaml:"status-columns"`
+	Enabler       EnablerInterface `yaml:"-"`
+}
+
+func newMemoryDevice(kind string) func(sim *CpuSim, o MemoryOptions) (DeviceInterface, error) {
+	return func(sim *CpuSim, o MemoryOptions) (DeviceInterface, error) {
+		if o.End < o.Start {
+			return nil, fmt.Errorf("end %X is below start %X", o.End, o.Start)
+		}
+		if o.AddressBits <= 0 || o.AddressBits > 24 {
+			return nil, fmt.Errorf("address bits must be 1-24")
+		}
+		mem := NewMemory(sim, o.Name, kind, o.Start, o.End, o.AddressBits, o.ReadOnly || kind == KIND_ROM, enablerOrAlways(o.Enabler))
+		if o.StatusRows > 0 {
+			mem.CreateStatusBytes(o.StatusRows, o.StatusColumns)
+		}
+		return mem, nil
+	}
+}
+
+func init() {
+	RegisterDevice(KIND_RAM, newMemoryDevice(KIND_RAM))
+	RegisterDevice(KIND_ROM, newMemoryDevice(KIND_ROM))
+}
+
+// ResetDevice clears RAM on a cold reset. ROM, and RAM on a warm reset,
+// keep their contents.
+func (mem *Memory) ResetDevice(kind ResetKind) {
+	if kind != ResetCold || mem.ReadOnly {
+		return
+	}
+	clear(mem.Contents)
+	for _, row := range mem.StatusContents {
+		clear(row)
+	}
+}
+
+type memoryState struct {
+	Contents       []byte   `json:"contents"`
+	StatusContents [][]byte `json:"status,omitempty"`
+}
+
+func (mem *Memory) SaveState() (any, error) {
+	return &memoryState{Contents: mem.Contents, StatusContents: mem.StatusContents}, nil
+}
+
+func (mem *Memory) RestoreState(data json.RawMessage) error {
+	var state memoryState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if len(state.Contents) != len(mem.Contents) {
+		return fmt.Errorf("snapshot has %d bytes, memory has %d", len(state.Contents), len(mem.Contents))
+	}
+	if len(state.StatusContents) != len(mem.StatusContents) {
+		return fmt.Errorf("snapshot has %d status rows, memory has %d", len(state.StatusContents), len(mem.StatusContents))
+	}
+	copy(mem.Contents, state.Contents)
+	for i := range mem.StatusContents {
+		copy(mem.StatusContents[i], state.StatusContents[i])
+	}
+	return nil
+}
@@ -0,0 +1,472 @@
+package cpusim
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"strings"
+	"sync"
+)
+
+// Multiprocessor machines
+//
+// Each CPU of a multiprocessor machine has a CpuSim of its own, which holds
+// the memory, ports and mappers that only it can reach, along with its
+// filters, decode cache and hooks. Devices that several CPUs share are put
+// on a Bus, which wraps them so that only one CPU uses them at a time, and
+// the wrapped device is added to each CpuSim that shares it. The CPUs can be
+// of different types; a Z80 can share RAM with a 4004 coprocessor.
+//
+// A MultiSim runs the CPUs. They can run free, each on a goroutine of its
+// own as CpuSim.Start runs them, which is fastest but leaves the order of
+// their bus accesses to the Go scheduler. Or they can run in lockstep on one
+// goroutine, taking turns a few instructions at a time or in proportion to
+// their clocks, which gives the same interleaving on every run.
+//
+// Only the devices on the bus are safe to share when the CPUs run free.
+// Mappers and the enable bits that they and latches drive belong to one
+// CPU, as a mapper only clears its own CpuSim's decode cache. Nor can a
+// device that is shared by free-running CPUs be enabled by anything but a
+// TrueEnabler or FalseEnabler, as the CPU that drives its enable would
+// change it while another CPU was checking it.
+
+// Bus arbitrates between the CPUs that share devices. A CPU holds the bus
+// for each read or write of a shared device, as it would for a bus cycle;
+// the accesses of an instruction are not atomic.
+type Bus struct {
+	mu sync.Mutex
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Share wraps a device so that it is used while holding the bus. The device
+// should be added to CpuSims only in its wrapped form. Mappers can't be
+// shared.
+func (b *Bus) Share(device MemoryInterface) MemoryInterface {
+	shared := sharedDevice{bus: b, device: device}
+	d, decodable := device.(Decodable)
+	r, ranged := device.(AddressRanger)
+	switch {
+	case decodable && ranged:
+		return &sharedRangedDevice{sharedDecodableDevice{shared, d}, r}
+	case decodable:
+		return &sharedDecodableDevice{shared, d}
+	}
+	return &shared
+}
+
+// SharedDevice is implemented by devices that a Bus has wrapped.
+type SharedDevice interface {
+	MemoryInterface
+	Unwrap() MemoryInterface
+}
+
+type sharedDevice struct {
+	bus    *Bus
+	device MemoryInterface
+}
+
+func (s *sharedDevice) Unwrap() MemoryInterface {
+	return s.device
+}
+
+// Children makes the shared device's state part of the snapshot of each
+// CpuSim it is in.
+func (s *sharedDevice) Children() []any {
+	return []any{s.device}
+}
+
+func (s *sharedDevice) GetName() string {
+	if d, ok := s.device.(DeviceInterface); ok {
+		return d.GetName()
+	}
+	return ""
+}
+
+func (s *sharedDevice) GetKind() string {
+	return s.device.GetKind()
+}
+
+func (s *sharedDevice) HasAddress(address Address) bool {
+	return s.device.HasAddress(address)
+}
+
+func (s *sharedDevice) Read(address Address) (byte, error) {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	return s.device.Read(address)
+}
+
+func (s *sharedDevice) Write(address Address, value byte) error {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	return s.device.Write(address, value)
+}
+
+func (s *sharedDevice) ReadStatus(address Address, statusAddr Address) (byte, error) {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	return s.device.ReadStatus(address, statusAddr)
+}
+
+func (s *sharedDevice) WriteStatus(address Address, statusAddr Address, value byte) error {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	return s.device.WriteStatus(address, statusAddr, value)
+}
+
+// The wrappers keep the decode behavior of the device, so that a CpuSim with
+// shared devices still caches its decoding. A shared Memory is reached
+// through the wrapper, not directly.
+type sharedDecodableDevice struct {
+	sharedDevice
+	decodable Decodable
+}
+
+func (s *sharedDecodableDevice) DecodeEnabler() EnablerInterface {
+	return s.decodable.DecodeEnabler()
+}
+
+type sharedRangedDevice struct {
+	sharedDecodableDevice
+	ranger AddressRanger
+}
+
+func (s *sharedRangedDevice) AddressRange() (Address, Address) {
+	return s.ranger.AddressRange()
+}
+
+// asMemory returns the Memory that a device is, or that it wraps if it is
+// shared, for loading images and the like outside of the CPU's accesses.
+func asMemory(device MemoryInterface) (*Memory, bool) {
+	if s, ok := device.(SharedDevice); ok {
+		device = s.Unwrap()
+	}
+	mem, ok := device.(*Memory)
+	return mem, ok
+}
+
+// ReplaceDevice replaces a device in the memory and port lists, as when a
+// device that has been added is then put on a bus. It reports whether the
+// device was found.
+func (sim *CpuSim) ReplaceDevice(old MemoryInterface, device MemoryInterface) bool {
+	found := false
+	for _, list := range [][]MemoryInterface{sim.Memory, sim.Ports} {
+		for i, mem := range list {
+			if mem == old {
+				list[i] = device
+				found = true
+			}
+		}
+	}
+	if found {
+		sim.InvalidateDecode()
+	}
+	return found
+}
+
+// Interleave is how a MultiSim takes turns between its CPUs.
+type Interleave int
+
+const (
+	// InterleaveFree runs each CPU on a goroutine of its own.
+	InterleaveFree Interleave = iota
+	// InterleaveInstructions runs the CPUs in turn, Quantum instructions
+	// at a time.
+	InterleaveInstructions
+	// InterleaveCycles runs whichever CPU is furthest behind in time, by
+	// its clock cycles and Processor.Clock, so that the CPUs keep their
+	// relative speeds.
+	InterleaveCycles
+)
+
+func (i Interleave) String() string {
+	switch i {
+	case InterleaveFree:
+		return "free"
+	case InterleaveInstructions:
+		return "instructions"
+	case InterleaveCycles:
+		return "cycles"
+	}
+	return fmt.Sprintf("Interleave(%d)", int(i))
+}
+
+// ParseInterleave parses free, instructions or cycles.
+func ParseInterleave(s string) (Interleave, error) {
+	for _, i := range []Interleave{InterleaveFree, InterleaveInstructions, InterleaveCycles} {
+		if strings.EqualFold(s, i.String()) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown interleave %q; expected free, instructions or cycles", s)
+}
+
+// Processor is one of the CPUs of a MultiSim, with the CpuSim that holds
+// its devices.
+type Processor struct {
+	Sim   *CpuSim
+	Clock int64 // Hz, for InterleaveCycles
+
+	stopped bool
+}
+
+// MultiSim runs several CPUs that share a bus.
+type MultiSim struct {
+	Processors []*Processor
+	Bus        *Bus
+	Interleave Interleave
+	Quantum    int // instructions per turn with InterleaveInstructions
+
+	shared []MemoryInterface
+}
+
+func NewMultiSim() *MultiSim {
+	return &MultiSim{
+		Bus:        NewBus(),
+		Interleave: InterleaveFree,
+		Quantum:    1,
+	}
+}
+
+// AddProcessor adds a CPU, which must be the only CPU in sim. The clock is
+// needed for InterleaveCycles.
+func (ms *MultiSim) AddProcessor(sim *CpuSim, clock int64) *Processor {
+	p := &Processor{Sim: sim, Clock: clock}
+	ms.Processors = append(ms.Processors, p)
+	return p
+}
+
+// Share puts a device on the bus, replacing it in the CpuSims that it has
+// already been added to, and returns the shared device for adding to the
+// others.
+func (ms *MultiSim) Share(device MemoryInterface) MemoryInterface {
+	shared := ms.Bus.Share(device)
+	for _, p := range ms.Processors {
+		p.Sim.ReplaceDevice(device, shared)
+	}
+	ms.shared = append(ms.shared, shared)
+	return shared
+}
+
+// fixedEnabler reports whether an enabler never changes.
+func fixedEnabler(en EnablerInterface) bool {
+	switch en.(type) {
+	case *TrueEnabler, *FalseEnabler:
+		return true
+	}
+	return false
+}
+
+// check returns an error if the processors can't be run as configured.
+func (ms *MultiSim) check() error {
+	if len(ms.Processors) == 0 {
+		return fmt.Errorf("no processors")
+	}
+	for i, p := range ms.Processors {
+		if len(p.Sim.CPU) != 1 {
+			return fmt.Errorf("processor %d has %d CPUs; each needs a CpuSim of its own", i, len(p.Sim.CPU))
+		}
+		if ms.Interleave == InterleaveFree {
+			continue
+		}
+		if _, ok := p.Sim.CPU[0].(HaltReporter); !ok {
+			return fmt.Errorf("processor %d can't run in lockstep, as it doesn't report when it halts", i)
+		}
+		if ms.Interleave == InterleaveCycles {
+			if _, ok := p.Sim.CPU[0].(CycleCounter); !ok || p.Clock <= 0 {
+				return fmt.Errorf("processor %d needs a clock and a cycle count to interleave by cycles", i)
+			}
+		}
+	}
+	if ms.Interleave == InterleaveFree {
+		for _, device := range ms.shared {
+			d, ok := device.(Decodable)
+			if !ok {
+				return fmt.Errorf("%s can't be shared by CPUs that run free, as it doesn't say what enables it", deviceName(device))
+			}
+			if !fixedEnabler(d.DecodeEnabler()) {
+				return fmt.Errorf("%s can't be shared by CPUs that run free, as its enable can change; interleave them by instructions or cycles", deviceName(device))
+			}
+		}
+	}
+	if ms.Interleave == InterleaveInstructions && ms.Quantum <= 0 {
+		return fmt.Errorf("the quantum must be at least one instruction")
+	}
+	return nil
+}
+
+// Start runs the CPUs on a goroutine of its own until the first of them
+// halts, which halts the rest, or one of them fails, and prints why they
+// stopped.
+func (ms *MultiSim) Start(wg *sync.WaitGroup) {
+	if err := ms.check(); err != nil {
+		fmt.Printf("%s\n", err)
+		return
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ms.Processors[0].Sim.PrintResult(ms.Run(context.Background()))
+	}()
+}
+
+// Run runs the CPUs until the first of them halts, which halts the rest, or
+// one of them fails, or ctx is canceled. It returns why the first CPU
+// stopped, or the error that one of them failed with. Lockstep CPUs run on
+// the calling goroutine, and can't be paused.
+func (ms *MultiSim) Run(ctx context.Context) RunResult {
+	if err := ms.check(); err != nil {
+		return RunResult{Reason: ExitError, Err: err}
+	}
+	if ms.Interleave == InterleaveFree {
+		return ms.runFree(ctx)
+	}
+
+	for _, p := range ms.Processors {
+		p.Sim.begin()
+		p.stopped = false
+		p.Sim.running.Add(1)
+	}
+	defer func() {
+		for _, p := range ms.Processors {
+			if !p.stopped {
+				p.Sim.running.Add(-1)
+			}
+		}
+	}()
+	stop := context.AfterFunc(ctx, func() {
+		ms.Stop(ExitCanceled)
+	})
+	defer stop()
+
+	for {
+		if ms.Interleave == InterleaveCycles {
+			p := ms.behind()
+			if p == nil {
+				break
+			}
+			if err := ms.step(p); err != nil {
+				return RunResult{Reason: ExitError, CPU: p.Sim.CPU[0], Err: err}
+			}
+			continue
+		}
+
+		active := false
+		for _, p := range ms.Processors {
+			for i := 0; i < ms.Quantum && !p.stopped; i++ {
+				if err := ms.step(p); err != nil {
+					return RunResult{Reason: ExitError, CPU: p.Sim.CPU[0], Err: err}
+				}
+			}
+			active = active || !p.stopped
+		}
+		if !active {
+			break
+		}
+	}
+	first := ms.Processors[0].Sim
+	reason, _ := first.stopReason()
+	return RunResult{Reason: reason, CPU: first.CPU[0]}
+}
+
+// runFree runs each CPU on a goroutine of its own, as CpuSim.Run does.
+func (ms *MultiSim) runFree(ctx context.Context) RunResult {
+	results := make([]RunResult, len(ms.Processors))
+	var wg sync.WaitGroup
+	for i, p := range ms.Processors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = p.Sim.Run(ctx)
+			if i == 0 {
+				ms.Stop(results[i].Reason)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, result := range results {
+		if result.Err != nil {
+			return result
+		}
+	}
+	return results[0]
+}
+
+// behind returns the running processor that is furthest behind in time, or
+// nil if they have all stopped. The times, cycles/clock, are compared as
+// cycles1*clock2 < cycles2*clock1, in 128 bits so that they can't overflow.
+func (ms *MultiSim) behind() *Processor {
+	var found *Processor
+	var foundCycles uint64
+	for _, p := range ms.Processors {
+		if p.stopped {
+			continue
+		}
+		cycles := p.Sim.CPU[0].(CycleCounter).ClockCycles()
+		if found != nil {
+			hi1, lo1 := bits.Mul64(cycles, uint64(found.Clock))
+			hi2, lo2 := bits.Mul64(foundCycles, uint64(p.Clock))
+			if hi1 > hi2 || (hi1 == hi2 && lo1 >= lo2) {
+				continue
+			}
+		}
+		found, foundCycles = p, cycles
+	}
+	return found
+}
+
+// step runs an instruction on a processor, as CpuSim.Run would.
+func (ms *MultiSim) step(p *Processor) error {
+	sim := p.Sim
+	cpu := sim.CPU[0]
+	if sim.CtrlC.Load() {
+		ms.Stop(ExitInterrupted)
+	}
+	if cpu.(HaltReporter).IsHalted() {
+		p.stopped = true
+		sim.running.Add(-1)
+		if p == ms.Processors[0] {
+			ms.Stop(ExitHalted)
+		}
+		return nil
+	}
+	return sim.Step(cpu)
+}
+
+// Stop stops all of the CPUs, as CpuSim.Stop does.
+func (ms *MultiSim) Stop(reason ExitReason) {
+	for _, p := range ms.Processors {
+		p.Sim.Stop(reason)
+	}
+}
+
+// Close closes the files that the devices of all of the CPUs have open.
+func (ms *MultiSim) Close() error {
+	var err error
+	for _, p := range ms.Processors {
+		if cerr := p.Sim.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Halt halts all of the CPUs.
+func (ms *MultiSim) Halt() {
+	for _, p := range ms.Processors {
+		p.Sim.Halt()
+	}
+}
+
+// Running reports whether any of the CPUs is still running.
+func (ms *MultiSim) Running() bool {
+	for _, p := range ms.Processors {
+		if p.Sim.Running() {
+			return true
+		}
+	}
+	return false
+}
@@ -1,5 +1,7 @@
 package cpusim
 
+import "encoding/json"
+
 type GenericOutputPort struct {
 	Sim                *CpuSim
 	Name               string
@@ -7,6 +9,7 @@ type GenericOutputPort struct {
 	ConnectedEnableBit [8]*EnableBit
 	Enabler            EnablerInterface
 	Value              byte
+	initial            byte // the value after reset
 }
 
 func (d *GenericOutputPort) GetName() string {
@@ -20,6 +23,10 @@ func (d *GenericOutputPort) HasAddress(address Address) bool {
 	return (address == d.dataWriteAddress)
 }
 
+func (d *GenericOutputPort) DecodeEnabler() EnablerInterface {
+	return d.Enabler
+}
+
 func (d *GenericOutputPort) Read(address Address) (byte, error) {
 	return 0, &ErrWriteOnly{Device: d}
 }
@@ -70,5 +77,46 @@ func NewGenericOutputPort(sim *CpuSim, name string, dataWriteAddress Address, va
 		dataWriteAddress: dataWriteAddress,
 		Enabler:          enabler,
 		Value:            value,
+		initial:          value,
+	}
+}
+
+// PortOptions are the options for the simple ports: KIND_GENERIC_OUTPORT,
+// KIND_INPORT (a DIP switch) and KIND_SP0256_SPEECH_DEVICE. Value is the
+// latch's initial value or the switch setting.
+type PortOptions struct {
+	Name    string           `yaml:"name"`
+	Address Address          `yaml:"address"`
+	Value   byte             `yaml:"value"`
+	Enabler EnablerInterface `yaml:"-"`
+}
+
+func init() {
+	RegisterDevice(KIND_GENERIC_OUTPORT, func(sim *CpuSim, o PortOptions) (DeviceInterface, error) {
+		return NewGenericOutputPort(sim, o.Name, o.Address, o.Value, enablerOrAlways(o.Enabler)), nil
+	})
+}
+
+// ResetDevice puts the latch back to the value it was made with.
+func (d *GenericOutputPort) ResetDevice(kind ResetKind) {
+	d.Value = d.initial
+	d.UpdateEnableOut()
+}
+
+type outputPortState struct {
+	Value byte `json:"value"`
+}
+
+func (d *GenericOutputPort) SaveState() (any, error) {
+	return &outputPortState{Value: d.Value}, nil
+}
+
+func (d *GenericOutputPort) RestoreState(data json.RawMessage) error {
+	var state outputPortState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
 	}
+	d.Value = state.Value
+	d.UpdateEnableOut()
+	return nil
 }
@@ -0,0 +1,533 @@
+package cpusim
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Profiler measures where the guest program spends its time. For every
+// instruction it counts executions and clock cycles by address. Calls and
+// returns are followed on a shadow stack, so that each function's time can
+// be given both with and without the functions it calls. Taken backward
+// jumps are counted as loops.
+//
+// A function is named by its entry address, the target of the call. Code
+// that was not called, such as the reset path, belongs to the function
+// where the profile started. Returns are matched to calls by their return
+// address, so a return that doesn't match a call, as when an interrupt
+// handler returns, doesn't unwind the stack.
+//
+// Sections measure the cycles between calls to BeginSection, for programs
+// such as interpreters that can say what they are doing better than their
+// PC can.
+type Profiler struct {
+	Sim *CpuSim
+	Top int // number of lines in each table of the report
+
+	cpu    DebugInterface
+	dis    DisassemblerInterface
+	clock  CycleCounter
+	costs  map[lineKey]*Cost
+	funcs  map[Address]*FunctionProfile
+	calls  map[callKey]*callCost
+	loops  map[loopKey]uint64
+	stack  []frame
+	total  Cost
+	root   Address
+	active bool
+
+	// The instruction about to execute
+	ins         Instruction
+	startCycles uint64
+
+	sections     map[string]*SectionProfile
+	section      string
+	sectionStart uint64
+}
+
+// Cost is the count and clock cycles of instructions.
+type Cost struct {
+	Count  uint64 // instructions executed
+	Cycles uint64
+}
+
+// FunctionProfile is the time spent in a function.
+type FunctionProfile struct {
+	Entry     Address
+	Calls     uint64
+	Inclusive Cost // including the functions it called
+	Exclusive Cost
+}
+
+// SectionProfile is the time spent in a section named by BeginSection.
+type SectionProfile struct {
+	Name      string
+	Count     uint64
+	Cycles    uint64
+	MaxCycles uint64
+}
+
+type lineKey struct {
+	fn      Address
+	address Address
+}
+
+type callKey struct {
+	caller Address
+	site   Address
+	callee Address
+}
+
+type callCost struct {
+	calls     uint64
+	inclusive Cost
+}
+
+type loopKey struct {
+	head Address
+	tail Address
+}
+
+type frame struct {
+	fn     Address
+	site   Address // the call instruction
+	ret    Address // where the callee returns to
+	start  Cost    // totals when the function was entered
+	nested bool    // the function is already on the stack further down
+}
+
+// NewProfiler creates a profiler for the machine's first CPU. It does
+// nothing until Start is called, except for sections.
+func NewProfiler(sim *CpuSim) *Profiler {
+	p := &Profiler{
+		Sim:      sim,
+		Top:      20,
+		costs:    map[lineKey]*Cost{},
+		funcs:    map[Address]*FunctionProfile{},
+		calls:    map[callKey]*callCost{},
+		loops:    map[loopKey]uint64{},
+		sections: map[string]*SectionProfile{},
+	}
+	if len(sim.CPU) > 0 {
+		p.cpu, _ = sim.CPU[0].(DebugInterface)
+		p.dis, _ = sim.CPU[0].(DisassemblerInterface)
+		p.clock, _ = sim.CPU[0].(CycleCounter)
+	}
+	return p
+}
+
+// Start begins profiling with the next instruction.
+func (p *Profiler) Start() error {
+	if p.cpu == nil {
+		return fmt.Errorf("CPU does not support profiling")
+	}
+	p.root = p.cpu.GetPC()
+	p.stack = []frame{{fn: p.root}}
+	p.function(p.root).Calls = 1
+	p.active = true
+	p.prepare()
+	p.Sim.AddStepHook(p.step)
+	return nil
+}
+
+func (p *Profiler) cycles() uint64 {
+	if p.clock == nil {
+		return 0
+	}
+	return p.clock.ClockCycles()
+}
+
+func (p *Profiler) function(entry Address) *FunctionProfile {
+	fn, ok := p.funcs[entry]
+	if !ok {
+		fn = &FunctionProfile{Entry: entry}
+		p.funcs[entry] = fn
+	}
+	return fn
+}
+
+// prepare decodes the instruction about to execute.
+func (p *Profiler) prepare() {
+	pc := p.cpu.GetPC()
+	if p.dis != nil {
+		p.ins = p.dis.Disassemble(pc)
+	} else {
+		p.ins = Instruction{Address: pc}
+	}
+	p.startCycles = p.cycles()
+}
+
+// step is the profiler's step hook. It charges the instruction that has
+// executed to its address and function, and follows calls and returns.
+func (p *Profiler) step() error {
+	if !p.active || p.Sim.Replaying() {
+		p.prepare()
+		return nil
+	}
+	ins := &p.ins
+	next := p.cpu.GetPC()
+	cost := Cost{Count: 1, Cycles: p.cycles() - p.startCycles}
+	fallThrough := ins.Address + Address(ins.Len())
+
+	top := &p.stack[len(p.stack)-1]
+	line := p.costs[lineKey{top.fn, ins.Address}]
+	if line == nil {
+		line = &Cost{}
+		p.costs[lineKey{top.fn, ins.Address}] = line
+	}
+	line.add(cost)
+	p.funcs[top.fn].Exclusive.add(cost)
+	p.total.add(cost)
+
+	switch {
+	case ins.Flow == FlowCall && next != fallThrough:
+		nested := false
+		for _, f := range p.stack {
+			nested = nested || f.fn == next
+		}
+		p.stack = append(p.stack, frame{fn: next, site: ins.Address, ret: fallThrough, start: p.total, nested: nested})
+		p.function(next).Calls++
+
+	case ins.Flow == FlowReturn && next != fallThrough:
+		for i := len(p.stack) - 1; i > 0; i-- {
+			if p.stack[i].ret == next {
+				for len(p.stack) > i {
+					p.leave()
+				}
+				break
+			}
+		}
+
+	case ins.HasTarget && next == ins.Target && next <= ins.Address:
+		p.loops[loopKey{next, ins.Address}]++
+	}
+
+	p.prepare()
+	return nil
+}
+
+// leave pops the top frame, charging the time since the call to the
+// function and to the call.
+func (p *Profiler) leave() {
+	f := p.stack[len(p.stack)-1]
+	p.stack = p.stack[:len(p.stack)-1]
+	spent := Cost{Count: p.total.Count - f.start.Count, Cycles: p.total.Cycles - f.start.Cycles}
+	if !f.nested {
+		p.funcs[f.fn].Inclusive.add(spent)
+	}
+	key := callKey{p.stack[len(p.stack)-1].fn, f.site, f.fn}
+	call := p.calls[key]
+	if call == nil {
+		call = &callCost{}
+		p.calls[key] = call
+	}
+	call.calls++
+	call.inclusive.add(spent)
+}
+
+func (c *Cost) add(o Cost) {
+	c.Count += o.Count
+	c.Cycles += o.Cycles
+}
+
+// Stop ends profiling. The functions still on the stack are charged with
+// their time so far.
+func (p *Profiler) Stop() {
+	if !p.active {
+		return
+	}
+	p.active = false
+	for len(p.stack) > 1 {
+		p.leave()
+	}
+	p.funcs[p.root].Inclusive = p.total
+}
+
+// BeginSection ends the current section, if any, and begins another. The
+// cycles between the two calls are charged to the section that ends.
+func (p *Profiler) BeginSection(name string) {
+	now := p.cycles()
+	if p.section != "" {
+		s, ok := p.sections[p.section]
+		if !ok {
+			s = &SectionProfile{Name: p.section}
+			p.sections[p.section] = s
+		}
+		cycles := now - p.sectionStart
+		s.Count++
+		s.Cycles += cycles
+		s.MaxCycles = max(s.MaxCycles, cycles)
+	}
+	p.section = name
+	p.sectionStart = now
+}
+
+// Sections returns the sections, in order of their average cycles, the
+// slowest first.
+func (p *Profiler) Sections() []*SectionProfile {
+	sections := make([]*SectionProfile, 0, len(p.sections))
+	for _, s := range p.sections {
+		sections = append(sections, s)
+	}
+	sort.Slice(sections, func(i, j int) bool {
+		ai, aj := sections[i].Cycles/sections[i].Count, sections[j].Cycles/sections[j].Count
+		if ai != aj {
+			return ai > aj
+		}
+		return sections[i].Name < sections[j].Name
+	})
+	return sections
+}
+
+// WriteSections writes the table of sections.
+func (p *Profiler) WriteSections(w io.Writer) error {
+	pw := &profileWriter{w: w}
+	pw.printf("Section         Count       Cycles      Avg Cycles  Max Cycles\n")
+	pw.printf("---------------------------------------------------------------\n")
+	for _, s := range p.Sections() {
+		pw.printf("%-15s %-11d %-11d %-11d %-11d\n", s.Name, s.Count, s.Cycles, s.Cycles/s.Count, s.MaxCycles)
+	}
+	return pw.err
+}
+
+// Functions returns the functions called, the most exclusive cycles first.
+func (p *Profiler) Functions() []*FunctionProfile {
+	funcs := make([]*FunctionProfile, 0, len(p.funcs))
+	for _, fn := range p.funcs {
+		funcs = append(funcs, fn)
+	}
+	sort.Slice(funcs, func(i, j int) bool {
+		if funcs[i].Exclusive.Cycles != funcs[j].Exclusive.Cycles {
+			return funcs[i].Exclusive.Cycles > funcs[j].Exclusive.Cycles
+		}
+		return funcs[i].Entry < funcs[j].Entry
+	})
+	return funcs
+}
+
+// AddressCost returns the executions and cycles of the instruction at an
+// address, over all of the functions it ran in.
+func (p *Profiler) AddressCost(address Address) Cost {
+	var total Cost
+	for key, cost := range p.costs {
+		if key.address == address {
+			total.add(*cost)
+		}
+	}
+	return total
+}
+
+// Total returns the instructions and cycles profiled.
+func (p *Profiler) Total() Cost {
+	return p.total
+}
+
+func (p *Profiler) name(address Address) string {
+	if p.Sim.Symbols != nil {
+		return p.Sim.DescribeAddress(address)
+	}
+	return fmt.Sprintf("%04X", address)
+}
+
+// location names an address by symbol, if there are symbols.
+func (p *Profiler) location(address Address) string {
+	if p.Sim.Symbols == nil {
+		return ""
+	}
+	return p.Sim.DescribeAddress(address)
+}
+
+func percent(part, whole uint64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(whole)
+}
+
+// profileWriter keeps the first error of a series of writes.
+type profileWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (pw *profileWriter) printf(format string, args ...any) {
+	if pw.err == nil {
+		_, pw.err = fmt.Fprintf(pw.w, format, args...)
+	}
+}
+
+// WriteReport writes a text report of the hottest instructions, functions
+// and loops, and the sections if there are any.
+func (p *Profiler) WriteReport(w io.Writer) error {
+	pw := &profileWriter{w: w}
+	pw.printf("Profile: %d instructions, %d cycles\n", p.total.Count, p.total.Cycles)
+
+	byAddress := map[Address]*Cost{}
+	for key, cost := range p.costs {
+		c := byAddress[key.address]
+		if c == nil {
+			c = &Cost{}
+			byAddress[key.address] = c
+		}
+		c.add(*cost)
+	}
+	addresses := make([]Address, 0, len(byAddress))
+	for address := range byAddress {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		ci, cj := byAddress[addresses[i]].Cycles, byAddress[addresses[j]].Cycles
+		if ci != cj {
+			return ci > cj
+		}
+		return addresses[i] < addresses[j]
+	})
+
+	pw.printf("\nInstructions:\n")
+	pw.printf("Address  Count       Cycles      %%Cycles  Location              Instruction\n")
+	for _, address := range addresses[:min(p.Top, len(addresses))] {
+		c := byAddress[address]
+		text := ""
+		if p.dis != nil {
+			text = p.dis.Disassemble(address).Text()
+		}
+		pw.printf("%04X     %-11d %-11d %6.2f%%  %-20s  %s\n", address, c.Count, c.Cycles, percent(c.Cycles, p.total.Cycles), p.location(address), text)
+	}
+
+	if p.dis != nil {
+		funcs := p.Functions()
+		pw.printf("\nFunctions:\n")
+		pw.printf("Function              Calls       Inclusive   %%Incl    Exclusive   %%Excl\n")
+		for _, fn := range funcs[:min(p.Top, len(funcs))] {
+			pw.printf("%-20s  %-11d %-11d %6.2f%%  %-11d %6.2f%%\n", p.name(fn.Entry), fn.Calls,
+				fn.Inclusive.Cycles, percent(fn.Inclusive.Cycles, p.total.Cycles),
+				fn.Exclusive.Cycles, percent(fn.Exclusive.Cycles, p.total.Cycles))
+		}
+
+		type loop struct {
+			loopKey
+			iterations uint64
+			cycles     uint64
+		}
+		var loops []loop
+		for key, iterations := range p.loops {
+			l := loop{loopKey: key, iterations: iterations}
+			for address, c := range byAddress {
+				if address >= key.head && address <= key.tail {
+					l.cycles += c.Cycles
+				}
+			}
+			loops = append(loops, l)
+		}
+		sort.Slice(loops, func(i, j int) bool {
+			if loops[i].cycles != loops[j].cycles {
+				return loops[i].cycles > loops[j].cycles
+			}
+			return loops[i].head < loops[j].head
+		})
+		pw.printf("\nLoops:\n")
+		pw.printf("Loop        Iterations  Cycles      %%Cycles  Location\n")
+		for _, l := range loops[:min(p.Top, len(loops))] {
+			pw.printf("%04X-%04X   %-11d %-11d %6.2f%%  %s\n", l.head, l.tail, l.iterations, l.cycles, percent(l.cycles, p.total.Cycles), p.location(l.head))
+		}
+	}
+
+	if len(p.sections) > 0 {
+		pw.printf("\n")
+		if pw.err == nil {
+			pw.err = p.WriteSections(w)
+		}
+	}
+	return pw.err
+}
+
+// WriteCallgrind writes the profile in the format of valgrind's callgrind
+// tool, for KCachegrind and similar viewers. The events are clock cycles
+// and instructions, and the positions are instruction addresses.
+func (p *Profiler) WriteCallgrind(w io.Writer) error {
+	pw := &profileWriter{w: w}
+	pw.printf("# callgrind format\n")
+	pw.printf("version: 1\n")
+	pw.printf("creator: gocpusim\n")
+	pw.printf("positions: instr\n")
+	pw.printf("events: Cycles Instructions\n")
+	pw.printf("summary: %d %d\n", p.total.Cycles, p.total.Count)
+
+	ids := map[Address]int{}
+	fnName := func(entry Address) string {
+		if id, ok := ids[entry]; ok {
+			return fmt.Sprintf("(%d)", id)
+		}
+		ids[entry] = len(ids) + 1
+		return fmt.Sprintf("(%d) %s", ids[entry], p.name(entry))
+	}
+
+	entries := make([]Address, 0, len(p.funcs))
+	for entry := range p.funcs {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i] < entries[j] })
+
+	lines := map[Address][]Address{}
+	for key := range p.costs {
+		lines[key.fn] = append(lines[key.fn], key.address)
+	}
+	calls := map[Address][]callKey{}
+	for key := range p.calls {
+		calls[key.caller] = append(calls[key.caller], key)
+	}
+
+	for _, entry := range entries {
+		pw.printf("\nfn=%s\n", fnName(entry))
+		addresses := lines[entry]
+		sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+		for _, address := range addresses {
+			c := p.costs[lineKey{entry, address}]
+			pw.printf("0x%04X %d %d\n", address, c.Cycles, c.Count)
+		}
+		keys := calls[entry]
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].site != keys[j].site {
+				return keys[i].site < keys[j].site
+			}
+			return keys[i].callee < keys[j].callee
+		})
+		for _, key := range keys {
+			c := p.calls[key]
+			pw.printf("cfn=%s\n", fnName(key.callee))
+			pw.printf("calls=%d 0x%04X\n", c.calls, key.callee)
+			pw.printf("0x%04X %d %d\n", key.site, c.inclusive.Cycles, c.inclusive.Count)
+		}
+	}
+	return pw.err
+}
+
+// WriteFiles stops the profiler and writes the report and the callgrind
+// file, each if its filename is not empty. A report filename of "-" writes
+// to stdout.
+func (p *Profiler) WriteFiles(report, callgrind string) error {
+	p.Stop()
+	write := func(filename string, writeTo func(io.Writer) error) error {
+		if filename == "" {
+			return nil
+		}
+		if filename == "-" {
+			return writeTo(os.Stdout)
+		}
+		f, err := os.Create(filename)
+		if err != nil {
+			return err
+		}
+		if err := writeTo(f); err != nil {
+			_ = f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	if err := write(report, p.WriteReport); err != nil {
+		return err
+	}
+	return write(callgrind, p.WriteCallgrind)
+}
@@ -0,0 +1,99 @@
+package cpusim
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// A device factory makes a device of one kind from an options struct of its
+// own type. The devices in this package register themselves under their
+// KIND_ names; other packages can register their own peripherals from an
+// init function, and then machines built from configuration, the command
+// line or tests can make them by name with NewDevice.
+type deviceFactory struct {
+	options reflect.Type // the options struct
+	create  func(sim *CpuSim, options any) (DeviceInterface, error)
+}
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]deviceFactory{}
+)
+
+// RegisterDevice registers the factory for a kind of device. It panics if
+// the kind is already registered, as the kinds are fixed when the program
+// starts.
+func RegisterDevice[T any](kind string, factory func(sim *CpuSim, options T) (DeviceInterface, error)) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if _, ok := factories[kind]; ok {
+		panic(fmt.Sprintf("cpusim: device kind %q is registered twice", kind))
+	}
+	factories[kind] = deviceFactory{
+		options: reflect.TypeFor[T](),
+		create: func(sim *CpuSim, options any) (DeviceInterface, error) {
+			return factory(sim, options.(T))
+		},
+	}
+}
+
+func lookupDevice(kind string) (deviceFactory, error) {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	factory, ok := factories[kind]
+	if !ok {
+		return factory, fmt.Errorf("no device kind %q", kind)
+	}
+	return factory, nil
+}
+
+// NewDevice makes a device of a registered kind. The options are the struct
+// that the kind was registered with, or a pointer to one. The device is not
+// added to the simulator.
+func NewDevice(sim *CpuSim, kind string, options any) (DeviceInterface, error) {
+	factory, err := lookupDevice(kind)
+	if err != nil {
+		return nil, err
+	}
+	value := reflect.ValueOf(options)
+	if value.Kind() == reflect.Pointer && value.Type().Elem() == factory.options && !value.IsNil() {
+		value = value.Elem()
+	}
+	if !value.IsValid() || value.Type() != factory.options {
+		return nil, fmt.Errorf("%s options are %v, not %T", kind, factory.options, options)
+	}
+	return factory.create(sim, value.Interface())
+}
+
+// NewDeviceOptions returns a pointer to a new options struct for a kind of
+// device, for filling in from configuration.
+func NewDeviceOptions(kind string) (any, error) {
+	factory, err := lookupDevice(kind)
+	if err != nil {
+		return nil, err
+	}
+	return reflect.New(factory.options).Interface(), nil
+}
+
+// DeviceKinds returns the registered kinds of device, sorted.
+func DeviceKinds() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	kinds := make([]string, 0, len(factories))
+	for kind := range factories {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// enablerOrAlways returns the enabler a device's options give, which is
+// always enabled if they give none.
+func enablerOrAlways(enabler EnablerInterface) EnablerInterface {
+	if enabler == nil {
+		return &AlwaysEnabled
+	}
+	return enabler
+}
@@ -0,0 +1,259 @@
+package cpusim
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Rewinder records a running machine so that it can be stepped backwards.
+//
+// It takes an in-memory checkpoint every Interval instructions and records
+// each byte of serial input along with the instruction count at which it was
+// delivered. Rewinding restores the nearest earlier checkpoint and
+// re-executes forward to the target, replaying the recorded input and
+// running the other step hooks (a CTC's timers, say) after each instruction,
+// so that the machine ends up exactly as it was at that point. Running
+// forward again after a rewind replays the recorded input too, until new
+// input arrives.
+//
+// While recording, serial input is held until the next instruction boundary
+// so that its timing can be reproduced. Device output is suppressed while
+// re-executing. Disk images attached to a CF or FDC are not rewound.
+//
+// A Rewinder supports machines with a single CPU. The CPU must be stopped
+// while rewinding.
+type Rewinder struct {
+	Sim            *CpuSim
+	Interval       uint64 // instructions between checkpoints
+	MaxCheckpoints int    // older checkpoints are discarded beyond this (0 = unlimited)
+
+	cpu         CpuInterface
+	count       uint64 // instructions executed since recording began
+	head        uint64 // furthest count in the recorded history
+	checkpoints []checkpoint
+	inputs      []inputEvent // delivered and future input, in instruction order
+	nextInput   int          // index in inputs of the next byte to deliver
+
+	mu      sync.Mutex
+	pending []inputEvent // live input waiting for the next instruction boundary
+}
+
+type checkpoint struct {
+	count    uint64
+	snapshot *Snapshot
+}
+
+type inputEvent struct {
+	count  uint64 // delivered after this many instructions
+	device InputReceiver
+	b      byte
+}
+
+// NewRewinder starts recording the machine, taking the first checkpoint at
+// the current state.
+func NewRewinder(sim *CpuSim, interval uint64, maxCheckpoints int) (*Rewinder, error) {
+	if len(sim.CPU) != 1 {
+		return nil, fmt.Errorf("rewind needs a machine with exactly one CPU, found %d", len(sim.CPU))
+	}
+	if interval == 0 {
+		return nil, fmt.Errorf("rewind checkpoint interval must be greater than zero")
+	}
+
+	r := &Rewinder{
+		Sim:            sim,
+		Interval:       interval,
+		MaxCheckpoints: maxCheckpoints,
+		cpu:            sim.CPU[0],
+	}
+	if err := r.checkpoint(); err != nil {
+		return nil, err
+	}
+	sim.InputHook = r.queueInput
+	// Count each instruction before any other hook (a debugger stopping the
+	// CPU, say) runs for it.
+	sim.StepHooks = append([]func() error{r.step}, sim.StepHooks...)
+	return r, nil
+}
+
+// Count returns the number of instructions executed since recording began,
+// which is the machine's current position in its history.
+func (r *Rewinder) Count() uint64 {
+	return r.count
+}
+
+// Oldest returns the earliest instruction count that can be rewound to.
+func (r *Rewinder) Oldest() uint64 {
+	return r.checkpoints[0].count
+}
+
+// queueInput is the machine's InputHook. It runs on the serial device's
+// goroutine and holds the byte for the CPU's next instruction boundary.
+func (r *Rewinder) queueInput(dev InputReceiver, b byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = append(r.pending, inputEvent{device: dev, b: b})
+}
+
+// step is called after each instruction while the machine is running.
+func (r *Rewinder) step() error {
+	if r.Sim.Replaying() {
+		return nil // replay keeps the count itself
+	}
+	r.count++
+	r.head = max(r.head, r.count)
+	r.deliverRecorded()
+
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = nil
+	r.mu.Unlock()
+
+	if len(pending) > 0 {
+		// New input changes history from here on, so anything recorded
+		// beyond this point no longer applies.
+		r.inputs = r.inputs[:r.nextInput]
+		r.discardCheckpointsAfter(r.count)
+		r.head = r.count
+		for _, ev := range pending {
+			ev.count = r.count
+			r.inputs = append(r.inputs, ev)
+			r.nextInput++
+			ev.device.ReceiveInput(ev.b)
+		}
+	}
+
+	if r.count%r.Interval == 0 {
+		return r.checkpoint()
+	}
+	return nil
+}
+
+// deliverRecorded delivers recorded input that is due at the current count.
+func (r *Rewinder) deliverRecorded() {
+	for r.nextInput < len(r.inputs) && r.inputs[r.nextInput].count == r.count {
+		ev := r.inputs[r.nextInput]
+		ev.device.ReceiveInput(ev.b)
+		r.nextInput++
+	}
+}
+
+// checkpoint saves the current state, unless there already is a checkpoint
+// for this instruction count.
+func (r *Rewinder) checkpoint() error {
+	if n := len(r.checkpoints); n > 0 && r.checkpoints[n-1].count >= r.count {
+		return nil
+	}
+
+	snapshot, err := r.Sim.snapshot()
+	if err != nil {
+		return fmt.Errorf("rewind checkpoint: %w", err)
+	}
+	r.checkpoints = append(r.checkpoints, checkpoint{count: r.count, snapshot: snapshot})
+
+	if r.MaxCheckpoints > 0 && len(r.checkpoints) > r.MaxCheckpoints {
+		r.checkpoints = r.checkpoints[len(r.checkpoints)-r.MaxCheckpoints:]
+
+		// Input delivered before the oldest checkpoint is no longer needed
+		oldest := r.checkpoints[0].count
+		drop := sort.Search(len(r.inputs), func(i int) bool { return r.inputs[i].count > oldest })
+		r.inputs = r.inputs[drop:]
+		r.nextInput -= drop
+	}
+	return nil
+}
+
+func (r *Rewinder) discardCheckpointsAfter(count uint64) {
+	keep := sort.Search(len(r.checkpoints), func(i int) bool { return r.checkpoints[i].count > count })
+	r.checkpoints = r.checkpoints[:keep]
+}
+
+// checkpointBefore returns the index of the latest checkpoint at or before
+// count, or -1 if that part of history has been discarded.
+func (r *Rewinder) checkpointBefore(count uint64) int {
+	return sort.Search(len(r.checkpoints), func(i int) bool { return r.checkpoints[i].count > count }) - 1
+}
+
+// replay restores a checkpoint and re-executes up to target instructions.
+func (r *Rewinder) replay(cp checkpoint, target uint64) error {
+	if err := r.Sim.restoreSnapshot(cp.snapshot); err != nil {
+		return fmt.Errorf("rewind: %w", err)
+	}
+	r.count = cp.count
+	r.nextInput = sort.Search(len(r.inputs), func(i int) bool { return r.inputs[i].count > cp.count })
+
+	r.Sim.replaying.Store(true)
+	defer r.Sim.replaying.Store(false)
+
+	for r.count < target {
+		if err := r.cpu.Execute(); err != nil {
+			return fmt.Errorf("rewind: replay at instruction %d: %w", r.count, err)
+		}
+		r.count++
+		r.deliverRecorded()
+		if err := r.Sim.AfterStep(); err != nil {
+			return fmt.Errorf("rewind: replay at instruction %d: %w", r.count, err)
+		}
+	}
+	return nil
+}
+
+// RewindTo puts the machine in the state it was in after target
+// instructions. The target may be ahead of the current count if the machine
+// was rewound earlier, as long as it is within the recorded history.
+func (r *Rewinder) RewindTo(target uint64) error {
+	if target > r.head {
+		return fmt.Errorf("cannot rewind to instruction %d, history ends at %d", target, r.head)
+	}
+	i := r.checkpointBefore(target)
+	if i < 0 {
+		return fmt.Errorf("cannot rewind to instruction %d, history starts at %d", target, r.Oldest())
+	}
+	return r.replay(r.checkpoints[i], target)
+}
+
+// StepBack rewinds the machine by n instructions, or as far as history goes.
+func (r *Rewinder) StepBack(n uint64) error {
+	target := r.Oldest()
+	if r.count-target > n {
+		target = r.count - n
+	}
+	return r.RewindTo(target)
+}
+
+// RunBackToWrite rewinds to the most recent instruction that wrote address,
+// leaving the machine just before that instruction executes. It reports
+// false, and leaves the machine where it was, if no recorded instruction
+// wrote the address.
+func (r *Rewinder) RunBackToWrite(address Address) (bool, error) {
+	start := r.count
+	found := false
+	var at uint64
+
+	watch := r.Sim.WriteWatch
+	r.Sim.WriteWatch = func(a Address, _ byte) {
+		if a == address {
+			found = true
+			at = r.count
+		}
+	}
+
+	// Search one checkpoint interval at a time, newest first
+	end := start
+	for i := r.checkpointBefore(end); i >= 0 && !found; i-- {
+		if r.checkpoints[i].count == end {
+			continue
+		}
+		if err := r.replay(r.checkpoints[i], end); err != nil {
+			r.Sim.WriteWatch = watch
+			return false, err
+		}
+		end = r.checkpoints[i].count
+	}
+	r.Sim.WriteWatch = watch
+
+	if !found {
+		return false, r.RewindTo(start)
+	}
+	return true, r.RewindTo(at)
+}
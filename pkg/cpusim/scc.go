@@ -1,6 +1,7 @@
 package cpusim
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -39,6 +40,17 @@ import (
 //
 // Channel A is the primary channel with keyboard input. Channel B is a
 // secondary channel that accepts output but has no input source.
+//
+// Interrupts are enabled per channel in WR1 (bit 1 Tx, bits 3-4 Rx mode) and
+// globally by the MIE bit in WR9. If an interrupt line is connected, the SCC
+// asserts it while any enabled condition is pending.
+//
+// On a mode 2 daisy chain the SCC supplies the WR2 vector during the
+// acknowledge cycle (unless WR9 NV is set). With WR9 VIS set, the status of
+// the highest-priority condition is encoded in bits 3-1 of the vector, or in
+// bits 6-4 if WR9 Status High is set. RR2 on channel B always returns the
+// modified vector. Unlike the SIO, the SCC does not decode RETI; software
+// ends service with the "reset highest IUS" command in WR0.
 type SCC struct {
 	Sim          *CpuSim
 	Serial       SerialIO
@@ -54,6 +66,9 @@ type SCC struct {
 	inputEOF     bool
 	chanA        sccChannel
 	chanB        sccChannel
+	Interrupt    *SignalLine
+	InService    *SignalLine
+	ius          bool // an acknowledged interrupt is under service
 }
 
 // sccChannel holds per-channel state for the SCC.
@@ -61,6 +76,7 @@ type sccChannel struct {
 	writeRegs [16]byte // WR0-WR15
 	readRegs  [16]byte // RR0-RR15
 	regPtr    byte     // Next register to read/write (bits 0-2 of WR0, +8 if Point High)
+	txIP      bool     // Tx buffer empty interrupt pending
 }
 
 func (s *SCC) GetName() string {
@@ -75,6 +91,10 @@ func (s *SCC) HasAddress(address Address) bool {
 		address == s.ControlAddrA || address == s.ControlAddrB
 }
 
+func (s *SCC) DecodeEnabler() EnablerInterface {
+	return s.Enabler
+}
+
 func (s *SCC) Read(address Address) (byte, error) {
 	if !s.HasAddress(address) {
 		return 0, &ErrInvalidAddress{Address: address}
@@ -84,7 +104,7 @@ func (s *SCC) Read(address Address) (byte, error) {
 	defer s.mu.Unlock()
 
 	if s.inputEOF && len(s.Keybuffer) == 0 {
-		s.Sim.Halt()
+		s.Sim.Stop(ExitEndOfInput)
 	}
 
 	// Data port reads
@@ -95,6 +115,7 @@ func (s *SCC) Read(address Address) (byte, error) {
 			if value == 0x0A {
 				value = 0x0D
 			}
+			s.updateInterrupt()
 			return value, nil
 		}
 		return 0, nil
@@ -141,10 +162,16 @@ func (s *SCC) readControl(ch *sccChannel, isChannelA bool) byte {
 		return 0x01
 	case 2:
 		// RR2: Interrupt vector (channel B returns modified vector)
-		return ch.readRegs[2]
+		if isChannelA {
+			return ch.writeRegs[2]
+		}
+		return s.modifiedVector()
 	case 3:
 		// RR3: Interrupt pending (channel A only)
-		return ch.readRegs[3]
+		if isChannelA {
+			return s.pendingBits()
+		}
+		return 0
 	default:
 		return ch.readRegs[reg]
 	}
@@ -155,24 +182,38 @@ func (s *SCC) Write(address Address, value byte) error {
 		return &ErrInvalidAddress{Address: address}
 	}
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	// Data port writes
 	if address == s.DataAddrA || address == s.DataAddrB {
-		err := s.Serial.WriteByte(value)
+		err := s.Sim.WriteSerial(s.Serial, value)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error writing to serial: %v\n", err)
 		}
 		s.lastCharOut = value
 		s.Sim.IOActivity()
+
+		// The character is sent immediately, so the transmitter is empty
+		// again and a Tx interrupt is pending if enabled.
+		ch := &s.chanA
+		if address == s.DataAddrB {
+			ch = &s.chanB
+		}
+		ch.txIP = ch.writeRegs[1]&0x02 != 0
+		s.updateInterrupt()
 		return nil
 	}
 
 	// Control port writes
 	if address == s.ControlAddrA {
 		s.writeControl(&s.chanA, value)
+		s.updateInterrupt()
 		return nil
 	}
 	if address == s.ControlAddrB {
 		s.writeControl(&s.chanB, value)
+		s.updateInterrupt()
 		return nil
 	}
 
@@ -198,20 +239,138 @@ func (s *SCC) writeControl(ch *sccChannel, value byte) {
 		case 2:
 			// Reset external/status interrupts
 		case 3:
-			// Channel reset
+			// Channel reset (the shared WR2 and WR9 are unaffected)
 			ch.regPtr = 0
 			for i := range ch.writeRegs {
-				ch.writeRegs[i] = 0
+				if i != 2 && i != 9 {
+					ch.writeRegs[i] = 0
+				}
 			}
+			ch.txIP = false
 		case 5:
 			// Reset Tx interrupt pending
+			ch.txIP = false
 		case 6:
 			// Error reset
 		case 7:
 			// Reset highest IUS (channel A only)
+			s.ius = false
 		}
 	} else {
 		ch.writeRegs[reg] = value
+		if reg == 2 || reg == 9 {
+			// WR2 and WR9 are shared by both channels
+			s.chanA.writeRegs[reg] = value
+			s.chanB.writeRegs[reg] = value
+		}
+	}
+}
+
+// ConnectInterrupt connects the SCC's /INT output to a signal line.
+func (s *SCC) ConnectInterrupt(line *SignalLine) {
+	s.Interrupt = line
+}
+
+// pendingBits returns the interrupt pending bits in RR3 format. Caller holds s.mu.
+//   - Bit 1: Channel B Tx IP
+//   - Bit 4: Channel A Tx IP
+//   - Bit 5: Channel A Rx IP
+func (s *SCC) pendingBits() byte {
+	var ip byte
+	if s.chanB.txIP {
+		ip |= 0x02
+	}
+	if s.chanA.txIP {
+		ip |= 0x10
+	}
+	if s.chanA.writeRegs[1]&0x18 != 0 && len(s.Keybuffer) > 0 {
+		ip |= 0x20
+	}
+	return ip
+}
+
+// interruptPending returns true if an enabled interrupt condition is pending
+// and the master interrupt enable (WR9 bit 3) is set. Caller holds s.mu.
+func (s *SCC) interruptPending() bool {
+	if s.chanA.writeRegs[9]&0x08 == 0 {
+		return false
+	}
+	return s.pendingBits() != 0
+}
+
+// ConnectDaisyChain connects the SCC to a mode 2 interrupt daisy chain.
+func (s *SCC) ConnectDaisyChain(request, inService *SignalLine) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Interrupt = request
+	s.InService = inService
+	s.updateInterrupt()
+}
+
+// pendingSource returns the vector status code of the highest-priority
+// pending interrupt, or zilogVectorPending if nothing is pending. Caller holds s.mu.
+func (s *SCC) pendingSource() int {
+	ip := s.pendingBits()
+	switch {
+	case ip&0x20 != 0:
+		return zilogVectorRxA
+	case ip&0x10 != 0:
+		return zilogVectorTxA
+	case ip&0x02 != 0:
+		return zilogVectorTxB
+	}
+	return zilogVectorPending
+}
+
+// modifiedVector returns WR2 with the status of the highest-priority pending
+// condition, in the position selected by WR9 Status High. Caller holds s.mu.
+func (s *SCC) modifiedVector() byte {
+	vector := s.chanA.writeRegs[2]
+	source := s.pendingSource()
+	if source == zilogVectorPending {
+		source = zilogVectorNone
+	}
+	if s.chanA.writeRegs[9]&0x10 != 0 {
+		// Status High: V4-V6 hold the status bits in reverse order
+		status := byte(source&1)<<2 | byte(source&2) | byte(source&4)>>2
+		return vector&0x8F | status<<4
+	}
+	return vector&0xF1 | byte(source)<<1
+}
+
+// InterruptAcknowledge returns the vector and puts the SCC in service.
+func (s *SCC) InterruptAcknowledge() byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wr9 := s.chanA.writeRegs[9]
+	vector := s.chanA.writeRegs[2]
+	if wr9&0x01 != 0 {
+		vector = s.modifiedVector()
+	}
+	if wr9&0x02 != 0 {
+		// No Vector: the SCC does not drive the bus
+		vector = 0xFF
+	}
+	s.ius = true
+	s.updateInterrupt()
+	return vector
+}
+
+// ReturnFromInterrupt does nothing: the SCC does not snoop RETI, so software
+// must issue "reset highest IUS" before returning.
+func (s *SCC) ReturnFromInterrupt() {
+}
+
+// updateInterrupt drives the interrupt lines from the current state. The
+// in-service line is also held while WR9 DLC (disable lower chain) is set.
+// Caller holds s.mu.
+func (s *SCC) updateInterrupt() {
+	if s.Interrupt != nil {
+		s.Interrupt.Set(s, !s.ius && s.interruptPending())
+	}
+	if s.InService != nil {
+		s.InService.Set(s, s.ius || s.chanA.writeRegs[9]&0x04 != 0)
 	}
 }
 
@@ -232,21 +391,33 @@ func (s *SCC) Run() error {
 		if b == 0x03 {
 			s.Sim.CtrlC.Store(true)
 		}
-		s.mu.Lock()
-		s.Keybuffer = append(s.Keybuffer, b)
-		s.mu.Unlock()
+		s.Sim.DeliverInput(s, b)
 	}
 }
 
+// ReceiveInput appends a byte to the receive buffer.
+func (s *SCC) ReceiveInput(b byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Keybuffer = append(s.Keybuffer, b)
+	s.updateInterrupt()
+}
+
+// EndInput marks the end of the input. Once the receive buffer has been read
+// empty, the machine stops with ExitEndOfInput.
+func (s *SCC) EndInput() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inputEOF = true
+}
+
 func (s *SCC) Start(wg *sync.WaitGroup) {
 	go func() {
 		s.Serial.Start()
 		err := s.Run()
 		if err != nil {
 			if err == io.EOF {
-				s.mu.Lock()
-				s.inputEOF = true
-				s.mu.Unlock()
+				s.EndInput()
 			} else {
 				fmt.Fprintf(os.Stderr, "SCC error: %v\n", err)
 			}
@@ -274,3 +445,72 @@ func NewSCC(sim *CpuSim, serial SerialIO, name string, dataAddrA, dataAddrB, con
 		Enabler:      enabler,
 	}
 }
+
+func init() {
+	RegisterDevice(KIND_SCC, func(sim *CpuSim, o SIOOptions) (DeviceInterface, error) {
+		if o.Serial == nil {
+			return nil, fmt.Errorf("no serial transport")
+		}
+		return NewSCC(sim, o.Serial, o.Name, o.DataAddressA, o.DataAddressB, o.ControlAddressA, o.ControlAddressB, enablerOrAlways(o.Enabler)), nil
+	})
+}
+
+// ResetDevice resets both channels and ends any interrupt under service.
+// Input that has been typed but not read is kept.
+func (s *SCC) ResetDevice(kind ResetKind) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chanA = sccChannel{}
+	s.chanB = sccChannel{}
+	s.ius = false
+	s.updateInterrupt()
+}
+
+type sccChannelState struct {
+	WriteRegs [16]byte `json:"write_regs"`
+	ReadRegs  [16]byte `json:"read_regs"`
+	RegPtr    byte     `json:"reg_ptr"`
+	TxIP      bool     `json:"tx_ip"`
+}
+
+type sccState struct {
+	Keybuffer   []byte             `json:"keybuffer"`
+	LastCharOut byte               `json:"last_char_out"`
+	Channels    [2]sccChannelState `json:"channels"`
+	InService   bool               `json:"in_service"`
+}
+
+func (s *SCC) SaveState() (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := &sccState{
+		Keybuffer:   append([]byte{}, s.Keybuffer...),
+		LastCharOut: s.lastCharOut,
+		InService:   s.ius,
+	}
+	for i, ch := range []*sccChannel{&s.chanA, &s.chanB} {
+		state.Channels[i] = sccChannelState{WriteRegs: ch.writeRegs, ReadRegs: ch.readRegs, RegPtr: ch.regPtr, TxIP: ch.txIP}
+	}
+	return state, nil
+}
+
+func (s *SCC) RestoreState(data json.RawMessage) error {
+	var state sccState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Keybuffer = state.Keybuffer
+	s.lastCharOut = state.LastCharOut
+	s.ius = state.InService
+	for i, ch := range []*sccChannel{&s.chanA, &s.chanB} {
+		saved := state.Channels[i]
+		ch.writeRegs = saved.WriteRegs
+		ch.readRegs = saved.ReadRegs
+		ch.regPtr = saved.RegPtr
+		ch.txIP = saved.TxIP
+	}
+	s.updateInterrupt()
+	return nil
+}
@@ -0,0 +1,199 @@
+package cpusim
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Deterministic runs
+//
+// Normally serial input is read on each device's own goroutine and lands in
+// its receive buffer at whatever point the CPU has reached, and IOPoll and
+// the throttle sleep on the wall clock, so no two runs are quite alike. A
+// Scheduler instead runs the machine on the calling goroutine against a
+// virtual clock, the CPU's clock cycles or, for a CPU that doesn't count
+// them, its instructions. Input comes from a script that says at which
+// cycle each piece of it arrives, and nothing sleeps. Two runs of the same
+// program with the same script execute exactly the same instructions, so
+// their traces are identical.
+//
+// The serial devices must not be started, as their input goroutines would
+// add input of their own.
+
+// InputEvent is input that arrives when the virtual clock reaches Cycle.
+type InputEvent struct {
+	Cycle uint64
+	Data  []byte
+}
+
+// ParseInputScript reads an input script. Each line gives a cycle and the
+// input that arrives then, as a Go string literal:
+//
+//	# boot, then list the directory
+//	150000  "help\r"
+//	+200000 "dir\r"
+//
+// A cycle starting with + is counted from the line before. Blank lines and
+// lines starting with # are ignored. The events are returned in cycle order.
+func ParseInputScript(r io.Reader) ([]InputEvent, error) {
+	var script []InputEvent
+	var last uint64
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		space := strings.IndexAny(line, " \t")
+		if space < 0 {
+			return nil, fmt.Errorf("line %d: expected a cycle and a quoted string", lineNum)
+		}
+		cycleStr, text := line[:space], strings.TrimSpace(line[space:])
+		relative := strings.HasPrefix(cycleStr, "+")
+		cycle, err := strconv.ParseUint(strings.TrimPrefix(cycleStr, "+"), 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid cycle %q", lineNum, cycleStr)
+		}
+		if relative {
+			cycle += last
+		}
+		data, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid string %s", lineNum, text)
+		}
+		script = append(script, InputEvent{Cycle: cycle, Data: []byte(data)})
+		last = cycle
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(script, func(i, j int) bool {
+		return script[i].Cycle < script[j].Cycle
+	})
+	return script, nil
+}
+
+// LoadInputScript reads an input script from a file.
+func LoadInputScript(filename string) ([]InputEvent, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // nolint:errcheck
+	script, err := ParseInputScript(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return script, nil
+}
+
+// Scheduler runs a machine with a single CPU deterministically.
+type Scheduler struct {
+	Sim    *CpuSim
+	Input  InputReceiver // the device that the script's input goes to
+	Script []InputEvent  // in cycle order
+
+	// ExitAtEnd ends the device's input after the last event, so that the
+	// machine stops with ExitEndOfInput once the program has read it all.
+	ExitAtEnd bool
+
+	cpu   CpuInterface
+	clock uint64
+	next  int // index in Script of the next event
+	ended bool
+}
+
+// NewScheduler returns a scheduler that delivers the script's input to a
+// serial device. The device may be nil if the script is empty.
+func NewScheduler(sim *CpuSim, input InputReceiver, script []InputEvent) (*Scheduler, error) {
+	if len(sim.CPU) != 1 {
+		return nil, fmt.Errorf("a deterministic run needs a machine with exactly one CPU, found %d", len(sim.CPU))
+	}
+	if input == nil && len(script) > 0 {
+		return nil, fmt.Errorf("there is no serial device for the input script")
+	}
+	return &Scheduler{
+		Sim:    sim,
+		Input:  input,
+		Script: script,
+		cpu:    sim.CPU[0],
+	}, nil
+}
+
+// Clock returns the virtual clock: the cycles, or instructions, that the CPU
+// has executed under the scheduler.
+func (s *Scheduler) Clock() uint64 {
+	return s.clock
+}
+
+// Run runs the CPU on the calling goroutine until the machine stops, as
+// CpuSim.Run does, delivering the script's input as the clock reaches it.
+// The throttle and the IO poll delay are ignored. Calling Run again carries
+// on from where it stopped, clock and script included.
+func (s *Scheduler) Run(ctx context.Context) RunResult {
+	sim := s.Sim
+	sim.begin()
+	stop := context.AfterFunc(ctx, func() {
+		sim.Stop(ExitCanceled)
+	})
+	defer stop()
+
+	sim.virtualTime.Store(true)
+	defer sim.virtualTime.Store(false)
+
+	s.deliver()
+	sim.running.Add(1)
+	result := sim.runUntilStopped(s.cpu, s.step)
+	sim.running.Add(-1)
+	sim.Stop(result.Reason)
+	if reason, ok := sim.stopReason(); ok && result.Err == nil {
+		result.Reason = reason
+	}
+	return result
+}
+
+// step runs an instruction, advances the clock and delivers the input that
+// has come due, before the step hooks see the instruction.
+func (s *Scheduler) step(cpu CpuInterface) error {
+	var start uint64
+	counter, counted := cpu.(CycleCounter)
+	if counted {
+		start = counter.ClockCycles()
+	}
+	if err := cpu.Execute(); err != nil {
+		return err
+	}
+	if counted {
+		s.clock += counter.ClockCycles() - start
+	} else {
+		s.clock++
+	}
+	s.deliver()
+	return s.Sim.AfterStep()
+}
+
+// deliver passes on the input that is due, as a device's input goroutine
+// would.
+func (s *Scheduler) deliver() {
+	for s.next < len(s.Script) && s.Script[s.next].Cycle <= s.clock {
+		for _, b := range s.Script[s.next].Data {
+			if b == 0x03 {
+				s.Sim.CtrlC.Store(true)
+			}
+			s.Sim.DeliverInput(s.Input, b)
+		}
+		s.next++
+	}
+	if s.ExitAtEnd && !s.ended && s.next == len(s.Script) {
+		s.ended = true
+		if e, ok := s.Input.(InputEnder); ok {
+			e.EndInput()
+		}
+	}
+}
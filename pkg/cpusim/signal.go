@@ -0,0 +1,137 @@
+package cpusim
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Names of the standard control lines. Machines may create additional lines
+// with any name they like.
+const (
+	SIGNAL_INT   = "INT"   // maskable interrupt request
+	SIGNAL_NMI   = "NMI"   // non-maskable interrupt request
+	SIGNAL_RESET = "RESET" // system reset
+	SIGNAL_WAIT  = "WAIT"  // stretch the current bus cycle
+	SIGNAL_BUSRQ = "BUSRQ" // bus request from a DMA device
+	SIGNAL_BUSAK = "BUSAK" // bus acknowledge from the CPU
+	SIGNAL_TEST  = "TEST"  // 4004 TEST input
+)
+
+// SignalLine is a named wired-OR control line. Any number of devices may drive
+// the line, and it is asserted as long as at least one of them asserts it.
+// Logic levels are abstracted away: "asserted" is the active state of the
+// line regardless of whether the real signal is active high or active low.
+//
+// Consumers either sample the line with Asserted, or register a callback with
+// OnChange to be told about transitions. Callbacks are invoked with the line's
+// lock held, so they must not drive the same line.
+type SignalLine struct {
+	Name      string
+	mu        sync.Mutex
+	drivers   map[DeviceInterface]struct{}
+	asserted  atomic.Bool
+	listeners []func(asserted bool)
+}
+
+func NewSignalLine(name string) *SignalLine {
+	return &SignalLine{
+		Name:    name,
+		drivers: make(map[DeviceInterface]struct{}),
+	}
+}
+
+func (l *SignalLine) GetName() string {
+	return l.Name
+}
+
+// Asserted returns true if any device is asserting the line.
+func (l *SignalLine) Asserted() bool {
+	return l.asserted.Load()
+}
+
+// Assert adds source to the set of devices driving the line.
+func (l *SignalLine) Assert(source DeviceInterface) {
+	l.Set(source, true)
+}
+
+// Release removes source from the set of devices driving the line.
+func (l *SignalLine) Release(source DeviceInterface) {
+	l.Set(source, false)
+}
+
+// Set asserts or releases the line on behalf of source.
+func (l *SignalLine) Set(source DeviceInterface, asserted bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if asserted {
+		l.drivers[source] = struct{}{}
+	} else {
+		delete(l.drivers, source)
+	}
+
+	level := len(l.drivers) > 0
+	if level == l.asserted.Load() {
+		return
+	}
+	l.asserted.Store(level)
+	for _, fn := range l.listeners {
+		fn(level)
+	}
+}
+
+// Pulse asserts and immediately releases the line, as a push button would.
+// Edge-sensitive consumers such as NMI and RESET see one complete pulse.
+func (l *SignalLine) Pulse(source DeviceInterface) {
+	l.Assert(source)
+	l.Release(source)
+}
+
+// IsDrivenBy returns true if source is currently asserting the line.
+func (l *SignalLine) IsDrivenBy(source DeviceInterface) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.drivers[source]
+	return ok
+}
+
+// OnChange registers fn to be called whenever the line changes state.
+func (l *SignalLine) OnChange(fn func(asserted bool)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.listeners = append(l.listeners, fn)
+}
+
+// SignalReceiver is implemented by CPUs (and anything else) that can have
+// control lines connected to them.
+type SignalReceiver interface {
+	ConnectSignal(name string, line *SignalLine) error
+}
+
+// Signal returns the named signal line, creating it if it does not yet exist.
+func (sim *CpuSim) Signal(name string) *SignalLine {
+	sim.signalMu.Lock()
+	defer sim.signalMu.Unlock()
+
+	line, ok := sim.Signals[name]
+	if !ok {
+		line = NewSignalLine(name)
+		sim.Signals[name] = line
+	}
+	return line
+}
+
+// ConnectSignal connects the named signal line to every CPU that accepts it.
+func (sim *CpuSim) ConnectSignal(name string) (*SignalLine, error) {
+	line := sim.Signal(name)
+	for _, cpu := range sim.CPU {
+		receiver, ok := cpu.(SignalReceiver)
+		if !ok {
+			continue
+		}
+		if err := receiver.ConnectSignal(name, line); err != nil {
+			return nil, err
+		}
+	}
+	return line, nil
+}
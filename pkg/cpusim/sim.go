@@ -1,7 +1,7 @@
 package cpusim
 
 import (
-	"fmt"
+	"context"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -48,6 +48,7 @@ const (
 	KIND_SIO                  = "SIO"
 	KIND_ASCI                 = "ASCI"
 	KIND_SCC                  = "SCC"
+	KIND_CTC                  = "CTC"
 	KIND_INPORT               = "INPORT"
 	KIND_ROMPORT              = "ROMPORT"
 	KIND_RAMPORT              = "RAMPORT"
@@ -55,6 +56,11 @@ const (
 	KIND_FDC                  = "FDC"
 	KIND_GENERIC_OUTPORT      = "GENERIC_OUTPORT"
 	KIND_SP0256_SPEECH_DEVICE = "SP0256A-AL2"
+
+	// The mappers all have KIND_MAPPER, so they are made by part number
+	KIND_74670      = "74670"
+	KIND_DUAL_74670 = "DUAL_74670"
+	KIND_74173      = "74173"
 )
 
 type CpuSim struct {
@@ -65,11 +71,45 @@ type CpuSim struct {
 	Throttle     *Throttle
 	IOPollDelay  time.Duration // sleep this long when a UART status poll finds no data; 0 = disabled
 	emptyPolls   atomic.Int32
+	virtualTime  atomic.Bool // a Scheduler is running the machine, see scheduler.go
 	CtrlC        atomic.Bool
 	Debug        bool
 	MemDebug     bool
 	MemoryFilter string
 	PortFilter   string
+	Signals      map[string]*SignalLine
+	signalMu     sync.Mutex
+
+	// StepHooks are called by the running CPU after every instruction.
+	StepHooks []func() error
+
+	// InputHook, if set, takes over delivery of serial input bytes from
+	// DeliverInput. It is used to hold input until an instruction boundary.
+	InputHook func(dev InputReceiver, b byte)
+
+	// WriteWatch, if set, is called on every CPU memory write with the
+	// address as seen by the CPU.
+	WriteWatch func(address Address, value byte)
+
+	// ReadWatch, if set, is called on every CPU memory read, including
+	// instruction fetches, with the address as seen by the CPU.
+	ReadWatch func(address Address)
+
+	// Symbols, if set, names addresses in disassembly and trace output.
+	Symbols SymbolLookup
+
+	tracer *Tracer // see trace.go
+
+	replaying atomic.Bool
+	running   atomic.Int32 // CPUs started and not yet returned from Run
+	life      lifecycle    // see lifecycle.go
+
+	// Address decoding cache, see decode.go
+	decodeGeneration uint64
+	memoryDecode     *memoryDecodeTable // the table for MemoryFilter
+	portDecode       *portDecodeTable   // the table for PortFilter
+	memoryTables     []*memoryDecodeTable
+	portTables       []*portDecodeTable
 }
 
 func NewCPUSim() *CpuSim {
@@ -79,6 +119,9 @@ func NewCPUSim() *CpuSim {
 		Ports:    make([]MemoryInterface, 0),
 		Throttle: NewThrottle(0), // no throttling by default
 		Debug:    true,
+		Signals:  make(map[string]*SignalLine),
+
+		decodeGeneration: 1,
 	}
 }
 
@@ -86,6 +129,12 @@ func (sim *CpuSim) SetIPS(ips int64) {
 	sim.Throttle = NewThrottle(ips)
 }
 
+// SetClock paces execution to a clock frequency in Hz. The CPUs must
+// implement CycleCounter.
+func (sim *CpuSim) SetClock(hz int64) {
+	sim.Throttle = NewClockThrottle(hz)
+}
+
 // IOActivity resets the empty-poll counter. Any UART that has data available
 // or is transmitting should call this so that activity on one UART prevents
 // idle UARTs from triggering poll delays.
@@ -97,9 +146,10 @@ func (sim *CpuSim) IOActivity() {
 // empty poll it sleeps for IOPollDelay, reducing host CPU usage when the
 // emulated program is spin-waiting for input. The first empty poll is free
 // so that a single status check during a TX loop doesn't stall output.
+// A deterministic run never sleeps.
 // Callers must release their mutex before calling this, as it may sleep.
 func (sim *CpuSim) IOPoll() {
-	if sim.IOPollDelay <= 0 {
+	if sim.IOPollDelay <= 0 || sim.virtualTime.Load() {
 		return
 	}
 	if sim.emptyPolls.Add(1) > 1 {
@@ -107,6 +157,46 @@ func (sim *CpuSim) IOPoll() {
 	}
 }
 
+// AddStepHook adds a function that the running CPU calls after every
+// instruction. An error from a hook stops the CPU.
+func (sim *CpuSim) AddStepHook(hook func() error) {
+	sim.StepHooks = append(sim.StepHooks, hook)
+}
+
+// AfterStep runs the step hooks. It is called by the CPUs' Run loops.
+func (sim *CpuSim) AfterStep() error {
+	for _, hook := range sim.StepHooks {
+		if err := hook(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeliverInput passes a byte of serial input to a device.
+func (sim *CpuSim) DeliverInput(dev InputReceiver, b byte) {
+	if sim.InputHook != nil {
+		sim.InputHook(dev, b)
+		return
+	}
+	dev.ReceiveInput(b)
+}
+
+// Replaying reports whether the machine is re-executing history. Devices
+// suppress output that the user has already seen.
+func (sim *CpuSim) Replaying() bool {
+	return sim.replaying.Load()
+}
+
+// WriteSerial sends a byte of device output to a serial transport, unless
+// the machine is replaying.
+func (sim *CpuSim) WriteSerial(serial SerialIO, b byte) error {
+	if sim.Replaying() {
+		return nil
+	}
+	return serial.WriteByte(b)
+}
+
 func (sim *CpuSim) SetDebug(debug bool) {
 	sim.Debug = debug
 }
@@ -121,14 +211,17 @@ func (sim *CpuSim) AddCPU(cpu CpuInterface) {
 
 func (sim *CpuSim) AddMemory(memory MemoryInterface) {
 	sim.Memory = append(sim.Memory, memory)
+	sim.InvalidateDecode()
 }
 
 func (sim *CpuSim) AddPort(port MemoryInterface) {
 	sim.Ports = append(sim.Ports, port)
+	sim.InvalidateDecode()
 }
 
 func (sim *CpuSim) AddMapper(mapper MapperInterface) {
 	sim.Mappers = append(sim.Mappers, mapper)
+	sim.InvalidateDecode()
 }
 
 func (sim *CpuSim) Halt() {
@@ -137,17 +230,19 @@ func (sim *CpuSim) Halt() {
 	}
 }
 
+// Start runs the CPUs on a goroutine of its own, as Run does, and prints
+// why they stopped.
 func (sim *CpuSim) Start(wg *sync.WaitGroup) {
-	for _, cpu := range sim.CPU {
-		wg.Add(1)
-		go func(c CpuInterface) {
-			defer wg.Done()
-			err := c.Run()
-			if err != nil {
-				fmt.Printf("%s", err)
-			}
-		}(cpu)
-	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sim.PrintResult(sim.Run(context.Background()))
+	}()
+}
+
+// Running reports whether any CPU started by Run is still running.
+func (sim *CpuSim) Running() bool {
+	return sim.running.Load() > 0
 }
 
 func (sim *CpuSim) FilterMemoryKind(kind string) {
@@ -167,6 +262,30 @@ func (sim *CpuSim) MatchPort(mem MemoryInterface) bool {
 }
 
 func (sim *CpuSim) WriteMemory(address Address, value byte) error {
+	if sim.WriteWatch != nil {
+		sim.WriteWatch(address, value)
+	}
+	physical, err := sim.writeMemory(address, value)
+	if sim.tracer != nil {
+		sim.tracer.access(TraceWrite, address, physical, value)
+	}
+	return err
+}
+
+// writeMemory writes a byte, and returns the address after the mappers that
+// it was written to.
+func (sim *CpuSim) writeMemory(address Address, value byte) (Address, error) {
+	if e := sim.memoryEntry(address); e != nil {
+		address += e.offset
+		if e.device == nil {
+			return address, nil
+		}
+		if mem := e.memory; mem != nil && !mem.ReadOnly {
+			mem.Contents[address-mem.StartAddress] = value
+			return address, nil
+		}
+		return address, e.device.Write(address, value)
+	}
 	for _, mapper := range sim.Mappers {
 		var err error
 		if !mapper.MatchMemory(sim.Memory[0]) {
@@ -174,7 +293,7 @@ func (sim *CpuSim) WriteMemory(address Address, value byte) error {
 		}
 		address, err = mapper.Map(address)
 		if err != nil {
-			return err
+			return address, err
 		}
 	}
 	for _, mem := range sim.Memory {
@@ -182,13 +301,37 @@ func (sim *CpuSim) WriteMemory(address Address, value byte) error {
 			continue
 		}
 		if mem.HasAddress(address) {
-			return mem.Write(address, value)
+			return address, mem.Write(address, value)
 		}
 	}
-	return nil
+	return address, nil
 }
 
 func (sim *CpuSim) ReadMemory(address Address) (byte, error) {
+	if sim.ReadWatch != nil {
+		sim.ReadWatch(address)
+	}
+	value, physical, err := sim.readMemory(address)
+	if sim.tracer != nil && err == nil {
+		sim.tracer.access(TraceRead, address, physical, value)
+	}
+	return value, err
+}
+
+// readMemory reads a byte, and returns the address after the mappers that it
+// was read from.
+func (sim *CpuSim) readMemory(address Address) (byte, Address, error) {
+	if e := sim.memoryEntry(address); e != nil {
+		address += e.offset
+		if e.device == nil {
+			return 0, address, nil
+		}
+		if mem := e.memory; mem != nil {
+			return mem.Contents[address-mem.StartAddress], address, nil
+		}
+		value, err := e.device.Read(address)
+		return value, address, err
+	}
 	for _, mapper := range sim.Mappers {
 		var err error
 		if !mapper.MatchMemory(sim.Memory[0]) {
@@ -196,7 +339,7 @@ func (sim *CpuSim) ReadMemory(address Address) (byte, error) {
 		}
 		address, err = mapper.Map(address)
 		if err != nil {
-			return 0, err
+			return 0, address, err
 		}
 	}
 	for _, mem := range sim.Memory {
@@ -204,10 +347,11 @@ func (sim *CpuSim) ReadMemory(address Address) (byte, error) {
 			continue
 		}
 		if mem.HasAddress(address) {
-			return mem.Read(address)
+			value, err := mem.Read(address)
+			return value, address, err
 		}
 	}
-	return 0, nil
+	return 0, address, nil
 }
 
 func (sim *CpuSim) WriteMemoryStatus(address Address, statusAddr Address, value byte) error {
@@ -235,6 +379,20 @@ func (sim *CpuSim) ReadMemoryStatus(address Address, statusAddr Address) (byte,
 }
 
 func (sim *CpuSim) ReadPort(port Address) (byte, error) {
+	value, err := sim.readPort(port)
+	if sim.tracer != nil && err == nil {
+		sim.tracer.access(TracePortIn, port, port, value)
+	}
+	return value, err
+}
+
+func (sim *CpuSim) readPort(port Address) (byte, error) {
+	if e := sim.portEntry(port); e != nil {
+		if e.device == nil {
+			return 0, nil
+		}
+		return e.device.Read(port)
+	}
 	for _, p := range sim.Ports {
 		if !sim.MatchPort(p) {
 			continue
@@ -247,6 +405,15 @@ func (sim *CpuSim) ReadPort(port Address) (byte, error) {
 }
 
 func (sim *CpuSim) WritePort(port Address, value byte) error {
+	if sim.tracer != nil {
+		sim.tracer.access(TracePortOut, port, port, value)
+	}
+	if e := sim.portEntry(port); e != nil {
+		if e.device == nil {
+			return nil
+		}
+		return e.device.Write(port, value)
+	}
 	for _, p := range sim.Ports {
 		if !sim.MatchPort(p) {
 			continue
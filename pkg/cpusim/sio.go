@@ -1,6 +1,7 @@
 package cpusim
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -38,6 +39,17 @@ import (
 //
 // Channel A is the primary channel with keyboard input. Channel B is a
 // secondary channel that accepts output but has no input source.
+//
+// Interrupts are enabled per channel in WR1: bit 1 enables the Tx buffer
+// empty interrupt and bits 3-4 select the Rx interrupt mode. If an interrupt
+// line is connected, the SIO asserts it while any enabled condition is pending.
+//
+// On a mode 2 daisy chain the SIO supplies the WR2 vector during the
+// acknowledge cycle. If "status affects vector" (WR1 bit 2, channel B) is set,
+// bits 3-1 of the vector identify the source, and RR2 on channel B reads back
+// the same modified vector. Once acknowledged the SIO stays in service, and
+// holds off further interrupts from itself and lower-priority devices, until
+// it sees RETI or a "return from interrupt" command in WR0.
 type SIO struct {
 	Sim          *CpuSim
 	Serial       SerialIO
@@ -53,15 +65,34 @@ type SIO struct {
 	inputEOF     bool
 	chanA        sioChannel
 	chanB        sioChannel
+	Interrupt    *SignalLine
+	InService    *SignalLine
+	ius          bool // an acknowledged interrupt is under service
 }
 
 // sioChannel holds per-channel state for the SIO.
 type sioChannel struct {
 	writeRegs [8]byte // WR0-WR7
-	readRegs  [3]byte // RR0-RR2
 	regPtr    byte    // Next register to read/write (from WR0 bits 0-2)
+	txIP      bool    // Tx buffer empty interrupt pending
+	rxFirst   bool    // armed for "interrupt on first Rx character"
 }
 
+// Status codes placed in bits 3-1 of the modified vector, in priority order
+// from lowest to highest. The SIO and SCC use the same encoding.
+const (
+	zilogVectorTxB     = 0 // channel B transmit buffer empty
+	zilogVectorExtB    = 1 // channel B external/status change
+	zilogVectorRxB     = 2 // channel B receive character available
+	zilogVectorSpecB   = 3 // channel B special receive condition
+	zilogVectorTxA     = 4 // channel A transmit buffer empty
+	zilogVectorExtA    = 5 // channel A external/status change
+	zilogVectorRxA     = 6 // channel A receive character available
+	zilogVectorSpecA   = 7 // channel A special receive condition
+	zilogVectorNone    = zilogVectorSpecB
+	zilogVectorPending = -1
+)
+
 func (s *SIO) GetName() string {
 	return s.Name
 }
@@ -74,6 +105,10 @@ func (s *SIO) HasAddress(address Address) bool {
 		address == s.ControlAddrA || address == s.ControlAddrB
 }
 
+func (s *SIO) DecodeEnabler() EnablerInterface {
+	return s.Enabler
+}
+
 func (s *SIO) Read(address Address) (byte, error) {
 	if !s.HasAddress(address) {
 		return 0, &ErrInvalidAddress{Address: address}
@@ -83,7 +118,7 @@ func (s *SIO) Read(address Address) (byte, error) {
 	defer s.mu.Unlock()
 
 	if s.inputEOF && len(s.Keybuffer) == 0 {
-		s.Sim.Halt()
+		s.Sim.Stop(ExitEndOfInput)
 	}
 
 	// Data port reads
@@ -94,6 +129,8 @@ func (s *SIO) Read(address Address) (byte, error) {
 			if value == 0x0A {
 				value = 0x0D
 			}
+			s.chanA.rxFirst = false
+			s.updateInterrupt()
 			return value, nil
 		}
 		return 0, nil
@@ -134,6 +171,9 @@ func (s *SIO) readControl(ch *sioChannel, isChannelA bool) byte {
 		if isChannelA && len(s.Keybuffer) > 0 {
 			status |= 0x01 // Rx Character Available
 		}
+		if isChannelA && s.interruptPending() {
+			status |= 0x02 // Interrupt Pending
+		}
 		status |= 0x04 // Tx Buffer Empty (always ready)
 		// DCD=0 (asserted), CTS=0 (asserted)
 		return status
@@ -142,7 +182,10 @@ func (s *SIO) readControl(ch *sioChannel, isChannelA bool) byte {
 		return 0x01 // All Sent
 	case 2:
 		// RR2: Interrupt vector (channel B only, returns modified vector)
-		return ch.readRegs[2]
+		if isChannelA {
+			return 0
+		}
+		return s.vector()
 	default:
 		return 0
 	}
@@ -153,24 +196,38 @@ func (s *SIO) Write(address Address, value byte) error {
 		return &ErrInvalidAddress{Address: address}
 	}
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	// Data port writes
 	if address == s.DataAddrA || address == s.DataAddrB {
-		err := s.Serial.WriteByte(value)
+		err := s.Sim.WriteSerial(s.Serial, value)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error writing to serial: %v\n", err)
 		}
 		s.lastCharOut = value
 		s.Sim.IOActivity()
+
+		// The character is sent immediately, so the transmitter is empty
+		// again and a Tx interrupt is pending if enabled.
+		ch := &s.chanA
+		if address == s.DataAddrB {
+			ch = &s.chanB
+		}
+		ch.txIP = ch.writeRegs[1]&0x02 != 0
+		s.updateInterrupt()
 		return nil
 	}
 
 	// Control port writes
 	if address == s.ControlAddrA {
 		s.writeControl(&s.chanA, value)
+		s.updateInterrupt()
 		return nil
 	}
 	if address == s.ControlAddrB {
 		s.writeControl(&s.chanB, value)
+		s.updateInterrupt()
 		return nil
 	}
 
@@ -200,21 +257,126 @@ func (s *SIO) writeControl(ch *sioChannel, value byte) {
 			for i := range ch.writeRegs {
 				ch.writeRegs[i] = 0
 			}
+			ch.txIP = false
+			ch.rxFirst = false
 		case 4:
 			// Enable interrupt on next Rx character
+			ch.rxFirst = true
 		case 5:
 			// Reset Tx interrupt pending
+			ch.txIP = false
 		case 6:
 			// Error reset
 		case 7:
 			// Return from interrupt (channel A only)
+			if ch == &s.chanA {
+				s.ius = false
+			}
 		}
 	} else {
 		// Writing to WR1-WR7
+		if reg == 1 && value&0x18 == 0x08 && ch.writeRegs[1]&0x18 != 0x08 {
+			// Entering "interrupt on first Rx character" mode arms it
+			ch.rxFirst = true
+		}
 		ch.writeRegs[reg] = value
 	}
 }
 
+// ConnectInterrupt connects the SIO's /INT output to a signal line.
+func (s *SIO) ConnectInterrupt(line *SignalLine) {
+	s.Interrupt = line
+}
+
+// ConnectDaisyChain connects the SIO to a mode 2 interrupt daisy chain.
+func (s *SIO) ConnectDaisyChain(request, inService *SignalLine) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Interrupt = request
+	s.InService = inService
+	s.updateInterrupt()
+}
+
+// rxPending returns true if channel A has a receive interrupt condition.
+// Caller holds s.mu.
+func (s *SIO) rxPending() bool {
+	if len(s.Keybuffer) == 0 {
+		return false
+	}
+	switch s.chanA.writeRegs[1] & 0x18 {
+	case 0x00:
+		return false
+	case 0x08:
+		return s.chanA.rxFirst
+	default:
+		return true
+	}
+}
+
+// pendingSource returns the vector status code of the highest-priority
+// pending interrupt, or zilogVectorPending if nothing is pending. Caller holds s.mu.
+func (s *SIO) pendingSource() int {
+	switch {
+	case s.rxPending():
+		return zilogVectorRxA
+	case s.chanA.txIP:
+		return zilogVectorTxA
+	case s.chanB.txIP:
+		return zilogVectorTxB
+	}
+	return zilogVectorPending
+}
+
+// interruptPending returns true if either channel has an enabled interrupt
+// condition. Caller holds s.mu.
+func (s *SIO) interruptPending() bool {
+	return s.pendingSource() != zilogVectorPending
+}
+
+// vector returns the interrupt vector, modified by the highest-priority
+// pending condition if "status affects vector" is enabled. Caller holds s.mu.
+func (s *SIO) vector() byte {
+	vector := s.chanB.writeRegs[2]
+	if s.chanB.writeRegs[1]&0x04 == 0 {
+		return vector
+	}
+	source := s.pendingSource()
+	if source == zilogVectorPending {
+		source = zilogVectorNone
+	}
+	return vector&0xF1 | byte(source)<<1
+}
+
+// InterruptAcknowledge returns the vector and puts the SIO in service.
+func (s *SIO) InterruptAcknowledge() byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vector := s.vector()
+	s.ius = true
+	s.updateInterrupt()
+	return vector
+}
+
+// ReturnFromInterrupt clears the in-service state when RETI is decoded.
+func (s *SIO) ReturnFromInterrupt() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ius = false
+	s.updateInterrupt()
+}
+
+// updateInterrupt drives the interrupt lines from the current state. Caller holds s.mu.
+func (s *SIO) updateInterrupt() {
+	if s.Interrupt != nil {
+		s.Interrupt.Set(s, !s.ius && s.interruptPending())
+	}
+	if s.InService != nil {
+		s.InService.Set(s, s.ius)
+	}
+}
+
 func (s *SIO) WriteStatus(address Address, statusAddr Address, value byte) error {
 	return &ErrNotImplemented{Device: s}
 }
@@ -232,21 +394,33 @@ func (s *SIO) Run() error {
 		if b == 0x03 {
 			s.Sim.CtrlC.Store(true)
 		}
-		s.mu.Lock()
-		s.Keybuffer = append(s.Keybuffer, b)
-		s.mu.Unlock()
+		s.Sim.DeliverInput(s, b)
 	}
 }
 
+// ReceiveInput appends a byte to the receive buffer.
+func (s *SIO) ReceiveInput(b byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Keybuffer = append(s.Keybuffer, b)
+	s.updateInterrupt()
+}
+
+// EndInput marks the end of the input. Once the receive buffer has been read
+// empty, the machine stops with ExitEndOfInput.
+func (s *SIO) EndInput() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inputEOF = true
+}
+
 func (s *SIO) Start(wg *sync.WaitGroup) {
 	go func() {
 		s.Serial.Start()
 		err := s.Run()
 		if err != nil {
 			if err == io.EOF {
-				s.mu.Lock()
-				s.inputEOF = true
-				s.mu.Unlock()
+				s.EndInput()
 			} else {
 				fmt.Fprintf(os.Stderr, "SIO error: %v\n", err)
 			}
@@ -274,3 +448,84 @@ func NewSIO(sim *CpuSim, serial SerialIO, name string, dataAddrA, dataAddrB, con
 		Enabler:      enabler,
 	}
 }
+
+// SIOOptions are the options for the two-channel Zilog serial devices,
+// KIND_SIO and KIND_SCC. Only channel A is connected to Serial.
+type SIOOptions struct {
+	Name            string           `yaml:"name"`
+	DataAddressA    Address          `yaml:"data-a"`
+	DataAddressB    Address          `yaml:"data-b"`
+	ControlAddressA Address          `yaml:"control-a"`
+	ControlAddressB Address          `yaml:"control-b"`
+	Serial          SerialIO         `yaml:"-"`
+	Enabler         EnablerInterface `yaml:"-"`
+}
+
+func init() {
+	RegisterDevice(KIND_SIO, func(sim *CpuSim, o SIOOptions) (DeviceInterface, error) {
+		if o.Serial == nil {
+			return nil, fmt.Errorf("no serial transport")
+		}
+		return NewSIO(sim, o.Serial, o.Name, o.DataAddressA, o.DataAddressB, o.ControlAddressA, o.ControlAddressB, enablerOrAlways(o.Enabler)), nil
+	})
+}
+
+// ResetDevice resets both channels and ends any interrupt under service.
+// Input that has been typed but not read is kept.
+func (s *SIO) ResetDevice(kind ResetKind) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chanA = sioChannel{}
+	s.chanB = sioChannel{}
+	s.ius = false
+	s.updateInterrupt()
+}
+
+type sioChannelState struct {
+	WriteRegs [8]byte `json:"write_regs"`
+	RegPtr    byte    `json:"reg_ptr"`
+	TxIP      bool    `json:"tx_ip"`
+	RxFirst   bool    `json:"rx_first"`
+}
+
+type sioState struct {
+	Keybuffer   []byte             `json:"keybuffer"`
+	LastCharOut byte               `json:"last_char_out"`
+	Channels    [2]sioChannelState `json:"channels"`
+	InService   bool               `json:"in_service"`
+}
+
+func (s *SIO) SaveState() (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := &sioState{
+		Keybuffer:   append([]byte{}, s.Keybuffer...),
+		LastCharOut: s.lastCharOut,
+		InService:   s.ius,
+	}
+	for i, ch := range []*sioChannel{&s.chanA, &s.chanB} {
+		state.Channels[i] = sioChannelState{WriteRegs: ch.writeRegs, RegPtr: ch.regPtr, TxIP: ch.txIP, RxFirst: ch.rxFirst}
+	}
+	return state, nil
+}
+
+func (s *SIO) RestoreState(data json.RawMessage) error {
+	var state sioState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Keybuffer = state.Keybuffer
+	s.lastCharOut = state.LastCharOut
+	s.ius = state.InService
+	for i, ch := range []*sioChannel{&s.chanA, &s.chanB} {
+		saved := state.Channels[i]
+		ch.writeRegs = saved.WriteRegs
+		ch.regPtr = saved.RegPtr
+		ch.txIP = saved.TxIP
+		ch.rxFirst = saved.RxFirst
+	}
+	s.updateInterrupt()
+	return nil
+}
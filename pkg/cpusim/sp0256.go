@@ -45,6 +45,10 @@ func (d *Sp0SpeechDevice) HasAddress(address Address) bool {
 	return (address == d.dataWriteAddress)
 }
 
+func (d *Sp0SpeechDevice) DecodeEnabler() EnablerInterface {
+	return d.Enabler
+}
+
 func (d *Sp0SpeechDevice) Read(address Address) (byte, error) {
 	if address != d.dataWriteAddress {
 		return 0, &ErrInvalidAddress{Device: d, Address: address}
@@ -57,7 +61,9 @@ func (d *Sp0SpeechDevice) Write(address Address, value byte) error {
 	if address != d.dataWriteAddress {
 		return &ErrInvalidAddress{Device: d, Address: address}
 	}
-	fmt.Printf("%s ", d.GetPhoneme(value))
+	if !d.Sim.Replaying() {
+		fmt.Printf("%s ", d.GetPhoneme(value))
+	}
 	return nil
 }
 
@@ -88,3 +94,9 @@ func NewSp0SpeechDevice(sim *CpuSim, name string, dataWriteAddress Address, enab
 		NotReadyValue:    0x00, // Assuming 0 indicates not ready
 	}
 }
+
+func init() {
+	RegisterDevice(KIND_SP0256_SPEECH_DEVICE, func(sim *CpuSim, o PortOptions) (DeviceInterface, error) {
+		return NewSp0SpeechDevice(sim, o.Name, o.Address, enablerOrAlways(o.Enabler)), nil
+	})
+}
@@ -0,0 +1,241 @@
+package cpusim
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// SnapshotVersion is bumped whenever the snapshot format changes in a way
+// that older snapshots can no longer be restored.
+const SnapshotVersion = 1
+
+// Stateful is implemented by CPUs and devices that can be saved to and
+// restored from a machine snapshot. SaveState returns a value that encodes
+// to JSON, and RestoreState is given the same JSON back.
+//
+// Snapshots are taken while the CPUs are stopped. Devices that are also
+// driven from their own goroutines (serial input) must take their locks.
+type Stateful interface {
+	SaveState() (any, error)
+	RestoreState(data json.RawMessage) error
+}
+
+// DeviceContainer is implemented by devices that hold other devices, such as
+// the 4004 bus adapters. The snapshot walks into their children.
+type DeviceContainer interface {
+	Children() []any
+}
+
+// Snapshot is the on-disk form of a machine's state. CPUs and devices are
+// keyed by name; if names are reused, later devices get a "#n" suffix.
+type Snapshot struct {
+	Version int                        `json:"version"`
+	CPUs    map[string]json.RawMessage `json:"cpus"`
+	Devices map[string]json.RawMessage `json:"devices"`
+}
+
+type stateEntry struct {
+	key    string
+	device Stateful
+}
+
+// stateEntries returns the saveable CPUs and devices in a stable order. Each
+// device is listed once even if it appears in several lists (a mapper that is
+// also a port, for example).
+func (sim *CpuSim) stateEntries() (cpus []stateEntry, devices []stateEntry) {
+	keys := make(map[string]int)
+
+	keyFor := func(item any, fallback string) string {
+		name := fallback
+		if dev, ok := item.(DeviceInterface); ok {
+			name = dev.GetName()
+		}
+		keys[name]++
+		if keys[name] > 1 {
+			return fmt.Sprintf("%s#%d", name, keys[name])
+		}
+		return name
+	}
+
+	for i, cpu := range sim.CPU {
+		if s, ok := cpu.(Stateful); ok {
+			cpus = append(cpus, stateEntry{key: keyFor(cpu, fmt.Sprintf("cpu%d", i)), device: s})
+		}
+	}
+
+	keys = make(map[string]int)
+	sim.eachDevice(func(item any) {
+		if s, ok := item.(Stateful); ok {
+			devices = append(devices, stateEntry{key: keyFor(item, "device"), device: s})
+		}
+	})
+	return cpus, devices
+}
+
+// eachDevice calls fn once for each device, including the devices inside
+// others, in a stable order. A device that is in several lists, such as a
+// mapper that is also a port, is only visited once.
+func (sim *CpuSim) eachDevice(fn func(device any)) {
+	seen := make(map[any]bool)
+	var walk func(item any)
+	walk = func(item any) {
+		if item == nil || seen[item] {
+			return
+		}
+		seen[item] = true
+		fn(item)
+		if c, ok := item.(DeviceContainer); ok {
+			for _, child := range c.Children() {
+				walk(child)
+			}
+		}
+	}
+	for _, mem := range sim.Memory {
+		walk(mem)
+	}
+	for _, port := range sim.Ports {
+		walk(port)
+	}
+	for _, mapper := range sim.Mappers {
+		walk(mapper)
+	}
+}
+
+// snapshot captures the state of every CPU and device.
+func (sim *CpuSim) snapshot() (*Snapshot, error) {
+	snapshot := &Snapshot{
+		Version: SnapshotVersion,
+		CPUs:    make(map[string]json.RawMessage),
+		Devices: make(map[string]json.RawMessage),
+	}
+
+	save := func(entries []stateEntry, into map[string]json.RawMessage) error {
+		for _, entry := range entries {
+			state, err := entry.device.SaveState()
+			if err != nil {
+				return fmt.Errorf("save %s: %w", entry.key, err)
+			}
+			data, err := json.Marshal(state)
+			if err != nil {
+				return fmt.Errorf("save %s: %w", entry.key, err)
+			}
+			into[entry.key] = data
+		}
+		return nil
+	}
+
+	cpus, devices := sim.stateEntries()
+	if err := save(cpus, snapshot.CPUs); err != nil {
+		return nil, err
+	}
+	if err := save(devices, snapshot.Devices); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// restoreSnapshot restores every CPU and device from a snapshot.
+func (sim *CpuSim) restoreSnapshot(snapshot *Snapshot) error {
+	if snapshot.Version != SnapshotVersion {
+		return fmt.Errorf("snapshot version %d is not supported (expected %d)", snapshot.Version, SnapshotVersion)
+	}
+
+	restore := func(entries []stateEntry, from map[string]json.RawMessage) error {
+		for _, entry := range entries {
+			data, ok := from[entry.key]
+			if !ok {
+				return fmt.Errorf("snapshot has no state for %s", entry.key)
+			}
+			if err := entry.device.RestoreState(data); err != nil {
+				return fmt.Errorf("restore %s: %w", entry.key, err)
+			}
+		}
+		return nil
+	}
+
+	// CPUs first, so that devices restoring their interrupt outputs drive
+	// the restored CPUs.
+	cpus, devices := sim.stateEntries()
+	if err := restore(cpus, snapshot.CPUs); err != nil {
+		return err
+	}
+	return restore(devices, snapshot.Devices)
+}
+
+// SaveState writes a gzip-compressed JSON snapshot of the machine to w.
+func (sim *CpuSim) SaveState(w io.Writer) error {
+	snapshot, err := sim.snapshot()
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	if err := json.NewEncoder(gz).Encode(snapshot); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// RestoreState reads a snapshot written by SaveState and restores it into
+// the machine. The machine must be built the same way as the one that was
+// saved; a CPU or device with no state in the snapshot is an error.
+func (sim *CpuSim) RestoreState(r io.Reader) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+	defer gz.Close() // nolint:errcheck
+
+	var snapshot Snapshot
+	if err := json.NewDecoder(gz).Decode(&snapshot); err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+	return sim.restoreSnapshot(&snapshot)
+}
+
+// SaveStateFile saves a snapshot of the machine to filename.
+func (sim *CpuSim) SaveStateFile(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := sim.SaveState(f); err != nil {
+		f.Close() // nolint:errcheck
+		return err
+	}
+	return f.Close()
+}
+
+// LoadStateFile restores a snapshot of the machine from filename.
+func (sim *CpuSim) LoadStateFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint:errcheck
+	return sim.RestoreState(f)
+}
+
+// enableBitStates returns the values of the connected enable bits, with
+// false for unconnected positions.
+func enableBitStates(bits []*EnableBit) []bool {
+	values := make([]bool, len(bits))
+	for i, bit := range bits {
+		if bit != nil {
+			values[i] = bit.Value
+		}
+	}
+	return values
+}
+
+// restoreEnableBits sets connected enable bits from saved values.
+func restoreEnableBits(bits []*EnableBit, values []bool) {
+	for i, bit := range bits {
+		if bit != nil && i < len(values) {
+			bit.Set(values[i])
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package cpusim
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFDCRestoreStateBounds(t *testing.T) {
+	fdc := NewFDC(NewCPUSim(), "fdc", 0x50, 0x51, 0x58, 0x48, &AlwaysEnabled)
+	saved, err := fdc.SaveState()
+	require.NoError(t, err)
+
+	for _, corrupt := range []func(s *fdcState){
+		func(s *fdcState) { s.CmdPos = 17 },
+		func(s *fdcState) { s.CmdLen = -1 },
+		func(s *fdcState) { s.ResLen = 20 },
+		func(s *fdcState) { s.ResLen, s.ResPos = 2, 3 },
+		func(s *fdcState) { s.DataPos = 1 },
+		func(s *fdcState) { s.Phase = 9 },
+	} {
+		state := *saved.(*fdcState)
+		corrupt(&state)
+		data, err := json.Marshal(&state)
+		require.NoError(t, err)
+		assert.ErrorContains(t, fdc.RestoreState(data), "in snapshot")
+	}
+
+	data, err := json.Marshal(saved)
+	require.NoError(t, err)
+	assert.NoError(t, fdc.RestoreState(data))
+}
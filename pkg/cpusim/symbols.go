@@ -0,0 +1,427 @@
+package cpusim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NoBank marks a symbol that is the same in every mapper bank.
+const NoBank = -1
+
+// Symbol names an address in the guest's code or data.
+type Symbol struct {
+	Name    string
+	Address Address // as seen by the CPU
+	Bank    int     // mapper bank holding the symbol, or NoBank
+}
+
+// SymbolTable holds symbols loaded from assembler and linker output.
+//
+// A symbol can be tied to a mapper bank: the value of the mapper register
+// that selects the memory it is in, e.g. 0x23 for the fourth RAM page of the
+// RC2014 512K board. Such a symbol only names its address while that bank is
+// mapped there, so that code assembled for different banks at the same CPU
+// address is told apart. Without a BankMapper in the machine, banks are
+// ignored.
+type SymbolTable struct {
+	Sim *CpuSim // used to look up banks; may be nil
+
+	symbols  []Symbol
+	byName   map[string]int
+	unbanked []int         // by address
+	banked   map[int][]int // by bank, then address
+	sorted   bool
+}
+
+func NewSymbolTable(sim *CpuSim) *SymbolTable {
+	return &SymbolTable{Sim: sim, byName: map[string]int{}}
+}
+
+// Add adds a symbol. If the name is already defined, the first definition
+// is kept.
+func (t *SymbolTable) Add(sym Symbol) {
+	if _, ok := t.byName[sym.Name]; ok {
+		return
+	}
+	t.byName[sym.Name] = len(t.symbols)
+	t.symbols = append(t.symbols, sym)
+	t.sorted = false
+}
+
+// Len returns the number of symbols.
+func (t *SymbolTable) Len() int {
+	return len(t.symbols)
+}
+
+// Symbols returns all of the symbols, in the order they were added.
+func (t *SymbolTable) Symbols() []Symbol {
+	return t.symbols
+}
+
+func (t *SymbolTable) sort() {
+	if t.sorted {
+		return
+	}
+	t.unbanked = t.unbanked[:0]
+	t.banked = map[int][]int{}
+	for i, sym := range t.symbols {
+		if sym.Bank == NoBank {
+			t.unbanked = append(t.unbanked, i)
+		} else {
+			t.banked[sym.Bank] = append(t.banked[sym.Bank], i)
+		}
+	}
+	byAddress := func(list []int) {
+		sort.SliceStable(list, func(a, b int) bool {
+			return t.symbols[list[a]].Address < t.symbols[list[b]].Address
+		})
+	}
+	byAddress(t.unbanked)
+	for _, list := range t.banked {
+		byAddress(list)
+	}
+	t.sorted = true
+}
+
+// preceding returns the last symbol in list at or below address.
+func (t *SymbolTable) preceding(list []int, address Address) (Symbol, bool) {
+	i := sort.Search(len(list), func(i int) bool { return t.symbols[list[i]].Address > address })
+	if i == 0 {
+		return Symbol{}, false
+	}
+	// Of several symbols at one address, use the first
+	at := t.symbols[list[i-1]].Address
+	for i > 1 && t.symbols[list[i-2]].Address == at {
+		i--
+	}
+	return t.symbols[list[i-1]], true
+}
+
+// Nearest returns the symbol at or before a CPU address, in the bank that
+// is mapped there at the moment, and the offset of the address from it.
+// A banked symbol is only used within the mapper page that holds it.
+func (t *SymbolTable) Nearest(address Address) (Symbol, Address, bool) {
+	t.sort()
+
+	best, found := t.preceding(t.unbanked, address)
+	offset := address - best.Address
+
+	if len(t.banked) > 0 && t.Sim != nil {
+		if bank, pageBits, ok := t.Sim.Bank(address); ok {
+			sym, ok := t.preceding(t.banked[bank], address)
+			if ok && sym.Address>>pageBits == address>>pageBits && (!found || address-sym.Address <= offset) {
+				return sym, address - sym.Address, true
+			}
+		}
+	}
+	return best, offset, found
+}
+
+// LookupSymbol returns the name of the symbol at exactly address.
+func (t *SymbolTable) LookupSymbol(address Address) (string, bool) {
+	sym, offset, ok := t.Nearest(address)
+	if !ok || offset != 0 {
+		return "", false
+	}
+	return sym.Name, true
+}
+
+// Describe formats an address as NAME or NAME+OFFSET, or in hex if there
+// is no symbol before it.
+func (t *SymbolTable) Describe(address Address) string {
+	sym, offset, ok := t.Nearest(address)
+	switch {
+	case !ok:
+		return fmt.Sprintf("%04X", address)
+	case offset == 0:
+		return sym.Name
+	default:
+		return fmt.Sprintf("%s+%X", sym.Name, offset)
+	}
+}
+
+// Resolve returns the CPU address of NAME or NAME+OFFSET, with the offset
+// in hex.
+func (t *SymbolTable) Resolve(expr string) (Address, bool) {
+	name, offsetStr, hasOffset := strings.Cut(expr, "+")
+	i, ok := t.byName[name]
+	if !ok {
+		return 0, false
+	}
+	var offset uint64
+	if hasOffset {
+		var err error
+		if offset, err = strconv.ParseUint(offsetStr, 16, 32); err != nil {
+			return 0, false
+		}
+	}
+	return t.symbols[i].Address + Address(offset), true
+}
+
+// Symbol file formats accepted by Load
+const (
+	SymbolsASL    = "asl"    // asl listing (.lst), from its symbol table
+	SymbolsZ88DK  = "z88dk"  // z88dk linker map (.map)
+	SymbolsSDCC   = "sdcc"   // SDCC/sdld linker map (.map)
+	SymbolsNoICE  = "noi"    // SDCC NoICE file (.noi)
+	SymbolsSimple = "simple" // name=addr lines
+)
+
+// SymbolFormat guesses the format of a symbol file from its name and, for
+// .map files, its contents.
+func SymbolFormat(filename string) (string, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".lst":
+		return SymbolsASL, nil
+	case ".noi":
+		return SymbolsNoICE, nil
+	case ".map":
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return "", err
+		}
+		if strings.Contains(string(data), "Value  Global") {
+			return SymbolsSDCC, nil
+		}
+		return SymbolsZ88DK, nil
+	}
+	return SymbolsSimple, nil
+}
+
+// Load adds the symbols in a file, guessing its format from the name. The
+// symbols are tied to bank, which may be NoBank.
+func (t *SymbolTable) Load(filename string, bank int) error {
+	format, err := SymbolFormat(filename)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint:errcheck
+	if err := t.Read(f, format, bank); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	return nil
+}
+
+var (
+	aslSymbol   = regexp.MustCompile(`^\*?\s*(\S+)\s*:\s*(.*?)\s+(\S+)$`)
+	z88dkSymbol = regexp.MustCompile(`^\s*(\S+)\s*=\s*\$([0-9A-Fa-f]+)\s*(?:;\s*(\w+))?`)
+	sdccSymbol  = regexp.MustCompile(`^\s*(?:[A-Z]:\s+)?([0-9A-Fa-f]{4,8})\s+([A-Za-z_.$][\w.$]*)`)
+)
+
+// Read adds the symbols from r, which holds a symbol file in format.
+func (t *SymbolTable) Read(r io.Reader, format string, bank int) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+
+	add := func(name string, value string, base int) error {
+		address, err := strconv.ParseUint(value, base, 32)
+		if err != nil {
+			return fmt.Errorf("symbol %s: invalid address %q", name, value)
+		}
+		t.Add(Symbol{Name: name, Address: Address(address), Bank: bank})
+		return nil
+	}
+
+	inTable := false // in the symbol section of a listing or map
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		var err error
+
+		switch format {
+		case SymbolsASL:
+			if strings.Contains(line, "Symbol Table") {
+				inTable = true
+				continue
+			}
+			if !inTable {
+				continue
+			}
+			for _, field := range strings.Split(line, "|") {
+				m := aslSymbol.FindStringSubmatch(strings.TrimSpace(field))
+				// Symbols with no segment are constants, not addresses
+				if m != nil && m[3] != "-" {
+					if err = add(m[1], m[2], 16); err != nil {
+						break
+					}
+				}
+			}
+
+		case SymbolsZ88DK:
+			m := z88dkSymbol.FindStringSubmatch(line)
+			if m != nil && (m[3] == "" || m[3] == "addr") {
+				err = add(m[1], m[2], 16)
+			}
+
+		case SymbolsSDCC:
+			if strings.Contains(line, "Value  Global") {
+				inTable = true
+				continue
+			}
+			if m := sdccSymbol.FindStringSubmatch(line); inTable && m != nil && !strings.HasPrefix(m[2], "l__") {
+				err = add(m[2], m[1], 16)
+			}
+
+		case SymbolsNoICE:
+			fields := strings.Fields(line)
+			if len(fields) == 3 && strings.EqualFold(fields[0], "DEF") && !strings.HasPrefix(fields[1], ".") && !strings.HasPrefix(fields[1], "l__") {
+				err = add(fields[1], strings.TrimPrefix(strings.ToLower(fields[2]), "0x"), 16)
+			}
+
+		case SymbolsSimple:
+			if i := strings.IndexAny(line, "#;"); i >= 0 {
+				line = line[:i]
+			}
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			name, value, ok := strings.Cut(line, "=")
+			if !ok {
+				return fmt.Errorf("line %d: expected name=address", lineNo)
+			}
+			value = strings.ToLower(strings.TrimSpace(value))
+			value = strings.TrimPrefix(value, "0x")
+			value = strings.TrimPrefix(value, "$")
+			value = strings.TrimSuffix(value, "h")
+			err = add(strings.TrimSpace(name), value, 16)
+
+		default:
+			return fmt.Errorf("unknown symbol file format %q", format)
+		}
+
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	t.sort()
+	return nil
+}
+
+// ParseSymbolsArg splits a --symbols argument of the form FILE, FILE:BANK or
+// FILE@BANK, where BANK is decimal or, with a 0x prefix, hex.
+func ParseSymbolsArg(arg string) (string, int, error) {
+	i := strings.LastIndexAny(arg, ":@")
+	if i < 0 {
+		return arg, NoBank, nil
+	}
+	bank, err := strconv.ParseUint(arg[i+1:], 0, 8)
+	if err != nil || i == 0 {
+		return "", NoBank, fmt.Errorf("invalid bank in %q; expected FILE:BANK", arg)
+	}
+	return arg[:i], int(bank), nil
+}
+
+// BankMapper is implemented by mappers that can say which bank is mapped at
+// an address: the value of the register that selects it.
+type BankMapper interface {
+	PageMapper
+	Bank(address Address) int
+}
+
+// Bank returns the bank mapped at a CPU memory address by the first mapper
+// that can say, and the size of the mapper's pages.
+func (sim *CpuSim) Bank(address Address) (bank int, pageBits int, ok bool) {
+	for _, mapper := range sim.Mappers {
+		if bm, isBank := mapper.(BankMapper); isBank && len(sim.Memory) > 0 && bm.MatchMemory(sim.Memory[0]) {
+			return bm.Bank(address), bm.PageBits(), true
+		}
+	}
+	return 0, 0, false
+}
+
+// PhysicalAddress maps a CPU memory address through the mappers, as a memory
+// access would.
+func (sim *CpuSim) PhysicalAddress(address Address) (Address, error) {
+	for _, mapper := range sim.Mappers {
+		if len(sim.Memory) == 0 || !mapper.MatchMemory(sim.Memory[0]) {
+			continue
+		}
+		var err error
+		if address, err = mapper.Map(address); err != nil {
+			return 0, err
+		}
+	}
+	return address, nil
+}
+
+// ParseAddress parses an address given as a symbol with an optional hex
+// offset, e.g. PUTCHAR+3, or as a hex number, with an optional 0x or $ prefix
+// or h suffix. Symbols come first, so that a label such as ADD or C isn't
+// taken for a number; write 0ADD, 0x0C or $C for the number.
+func (sim *CpuSim) ParseAddress(s string) (Address, error) {
+	if address, ok := sim.ResolveSymbol(strings.TrimSpace(s)); ok {
+		return address, nil
+	}
+	str := strings.ToLower(strings.TrimSpace(s))
+	str = strings.TrimPrefix(str, "0x")
+	str = strings.TrimPrefix(str, "$")
+	str = strings.TrimSuffix(str, "h")
+	if value, err := strconv.ParseUint(str, 16, 32); err == nil {
+		return Address(value), nil
+	}
+	return 0, fmt.Errorf("invalid address %q", s)
+}
+
+// DescribeAddress formats an address by symbol, as NAME or NAME+OFFSET, if
+// the machine has symbols that name it, and in hex otherwise.
+func (sim *CpuSim) DescribeAddress(address Address) string {
+	if d, ok := sim.Symbols.(interface{ Describe(Address) string }); ok {
+		return d.Describe(address)
+	}
+	if sim.Symbols != nil {
+		if name, ok := sim.Symbols.LookupSymbol(address); ok {
+			return name
+		}
+	}
+	return fmt.Sprintf("%04X", address)
+}
+
+// ResolveSymbol returns the address of NAME or NAME+OFFSET in the machine's
+// symbols.
+func (sim *CpuSim) ResolveSymbol(expr string) (Address, bool) {
+	if r, ok := sim.Symbols.(interface{ Resolve(string) (Address, bool) }); ok {
+		return r.Resolve(expr)
+	}
+	return 0, false
+}
+
+// DebugLabel prints the symbol at address, if there is one, as a label line
+// in the --debug trace.
+func (sim *CpuSim) DebugLabel(address Address) {
+	if sim.Symbols == nil {
+		return
+	}
+	if name, ok := sim.Symbols.LookupSymbol(address); ok {
+		fmt.Printf("%s:\n", name)
+	}
+}
+
+// LoadSymbols loads symbol files given as FILE or FILE:BANK, as with the
+// --symbols flag, and uses them to name addresses.
+func (sim *CpuSim) LoadSymbols(args []string) error {
+	table := NewSymbolTable(sim)
+	for _, arg := range args {
+		filename, bank, err := ParseSymbolsArg(arg)
+		if err != nil {
+			return err
+		}
+		if err := table.Load(filename, bank); err != nil {
+			return err
+		}
+	}
+	sim.Symbols = table
+	return nil
+}
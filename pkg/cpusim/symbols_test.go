@@ -0,0 +1,119 @@
+package cpusim
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSymbolFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		text   string
+	}{
+		{SymbolsASL, `
+ Symbol Table (* = unused):
+ --------------------------
+
+*ARCHITECTURE :                                      "x86_64-unknown-linux" - |
+ PUTCHAR :                      1234 C |  BUFSIZE :                        80 - |
+*GETCHAR :                      1300 C |
+`},
+		{SymbolsZ88DK, `
+PUTCHAR                         = $1234 ; addr, public, , putchar_asm, code_crt, putchar.asm:12
+BUFSIZE                         = $0080 ; const, local, , , , buf.asm:3
+GETCHAR                         = $1300 ; addr, public, , getchar_asm, code_crt, getchar.asm:8
+`},
+		{SymbolsSDCC, `
+Area                                    Addr        Size        Decimal Bytes (Attributes)
+--------------------------------        ----        ----        ------- ----- ------------
+_CODE                               00001234    00000100 =         256. bytes (REL,CON)
+
+      Value  Global           Global Defined In Module
+      -----  --------------------------------
+     C:   00001234  PUTCHAR           putchar
+     C:   00001300  GETCHAR           getchar
+          00000100  l__CODE
+`},
+		{SymbolsNoICE, `
+DEF PUTCHAR 0x1234
+DEF .__.ABS. 0x0000
+DEF l__CODE 0x0100
+DEF GETCHAR 0x1300
+`},
+		{SymbolsSimple, `
+# console
+PUTCHAR = 0x1234
+GETCHAR=$1300 ; input
+`},
+	}
+
+	for _, tt := range tests {
+		table := NewSymbolTable(nil)
+		require.NoError(t, table.Read(strings.NewReader(tt.text), tt.format, NoBank), tt.format)
+		assert.Equal(t, 2, table.Len(), tt.format)
+
+		address, ok := table.Resolve("PUTCHAR+3")
+		assert.True(t, ok, tt.format)
+		assert.Equal(t, Address(0x1237), address, tt.format)
+		assert.Equal(t, "PUTCHAR+3", table.Describe(0x1237), tt.format)
+		assert.Equal(t, "GETCHAR", table.Describe(0x1300), tt.format)
+		assert.Equal(t, "1000", table.Describe(0x1000), tt.format)
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	sim := NewCPUSim()
+	table := NewSymbolTable(sim)
+	table.Add(Symbol{Name: "ADD", Address: 0x1234, Bank: NoBank})
+	table.Add(Symbol{Name: "C", Address: 0x2000, Bank: NoBank})
+	sim.Symbols = table
+
+	tests := []struct {
+		text    string
+		address Address
+	}{
+		{"ADD", 0x1234},
+		{"ADD+2", 0x1236},
+		{"C", 0x2000},
+		{"0ADD", 0x0ADD},
+		{"0xC", 0x000C},
+		{"$C", 0x000C},
+		{"0Ch", 0x000C},
+		{"BEEF", 0xBEEF},
+	}
+	for _, tt := range tests {
+		address, err := sim.ParseAddress(tt.text)
+		require.NoError(t, err, tt.text)
+		assert.Equal(t, tt.address, address, tt.text)
+	}
+
+	_, err := sim.ParseAddress("NOSUCH")
+	assert.Error(t, err)
+}
+
+func TestParseSymbolsArg(t *testing.T) {
+	tests := []struct {
+		arg      string
+		filename string
+		bank     int
+	}{
+		{"rom.map", "rom.map", NoBank},
+		{"cpm.map:3", "cpm.map", 3},
+		{"cpm.map@0x23", "cpm.map", 0x23},
+		{"cpm.map:0x23", "cpm.map", 0x23},
+	}
+	for _, tt := range tests {
+		filename, bank, err := ParseSymbolsArg(tt.arg)
+		require.NoError(t, err, tt.arg)
+		assert.Equal(t, tt.filename, filename, tt.arg)
+		assert.Equal(t, tt.bank, bank, tt.arg)
+	}
+
+	for _, arg := range []string{"cpm.map@xyz", "cpm.map:256", "cpm.map:-1", "cpm.map:", ":3"} {
+		_, _, err := ParseSymbolsArg(arg)
+		assert.ErrorContains(t, err, "invalid bank", arg)
+	}
+}
@@ -1,39 +1,70 @@
 package cpusim
 
-import "time"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
 
 const throttleBatchSize = 1000
 
-// Throttle limits CPU execution to a target instructions-per-second rate.
-// An ips of zero means no throttling (full speed). To avoid per-instruction
-// overhead, timing is checked every throttleBatchSize instructions.
+// clockBatchSize is the number of clock cycles between timing checks when
+// pacing on clock frequency. It is large enough that even the fastest CPU
+// only checks every few hundred instructions.
+const clockBatchSize = 10000
+
+// Throttle limits CPU execution to a target rate. It either paces on
+// instructions per second, or on clock cycles per second when the CPU reports
+// how many clock cycles each instruction took. A rate of zero means no
+// throttling (full speed). To avoid per-instruction overhead, timing is
+// checked in batches.
 type Throttle struct {
-	ips        int64
+	rate       int64 // instructions or clock cycles per second
+	perClock   bool  // rate is a clock frequency
+	batchSize  int64
 	count      int64
 	batchStart time.Time
 }
 
 func NewThrottle(ips int64) *Throttle {
 	return &Throttle{
-		ips:        ips,
+		rate:       ips,
+		batchSize:  throttleBatchSize,
 		batchStart: time.Now(),
 	}
 }
 
-// Tick is called once per executed instruction. Every throttleBatchSize
-// instructions, it compares elapsed wall time against the expected duration
-// and sleeps the difference if the CPU is running ahead of schedule.
-func (t *Throttle) Tick() {
-	if t.ips <= 0 {
+// NewClockThrottle returns a throttle that paces execution to a clock
+// frequency in Hz.
+func NewClockThrottle(hz int64) *Throttle {
+	return &Throttle{
+		rate:       hz,
+		perClock:   true,
+		batchSize:  clockBatchSize,
+		batchStart: time.Now(),
+	}
+}
+
+// Tick is called once per executed instruction with the number of clock
+// cycles it took. Every batch, it compares elapsed wall time against the
+// expected duration and sleeps the difference if the CPU is running ahead of
+// schedule.
+func (t *Throttle) Tick(clocks uint64) {
+	if t.rate <= 0 {
 		return
 	}
 
-	t.count++
-	if t.count < throttleBatchSize {
+	if t.perClock {
+		t.count += int64(clocks)
+	} else {
+		t.count++
+	}
+	if t.count < t.batchSize {
 		return
 	}
 
-	expected := time.Duration(t.count) * time.Second / time.Duration(t.ips)
+	expected := time.Duration(t.count) * time.Second / time.Duration(t.rate)
 	elapsed := time.Since(t.batchStart)
 
 	if elapsed < expected {
@@ -43,3 +74,37 @@ func (t *Throttle) Tick() {
 	t.count = 0
 	t.batchStart = time.Now()
 }
+
+// CycleCounter is implemented by CPUs that count the clock cycles they have
+// executed, which allows them to be throttled to a clock frequency.
+type CycleCounter interface {
+	ClockCycles() uint64
+}
+
+// ParseFrequency parses a clock frequency such as "7.3728MHz", "740kHz" or
+// "500000" and returns it in Hz. The unit suffix is case insensitive.
+func ParseFrequency(s string) (int64, error) {
+	str := strings.ToLower(strings.TrimSpace(s))
+	multiplier := 1.0
+	for _, unit := range []struct {
+		suffix     string
+		multiplier float64
+	}{
+		{"ghz", 1e9},
+		{"mhz", 1e6},
+		{"khz", 1e3},
+		{"hz", 1},
+	} {
+		if strings.HasSuffix(str, unit.suffix) {
+			str = strings.TrimSpace(strings.TrimSuffix(str, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(str, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid frequency %q", s)
+	}
+	return int64(value*multiplier + 0.5), nil
+}
@@ -0,0 +1,493 @@
+package cpusim
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// A Tracer writes a record of every instruction the CPU executes to a file,
+// for post-processing and diffing. Each record holds the instruction, the
+// registers before and after it, and the memory and port accesses it made.
+// Accesses give both the address seen by the CPU and the physical address
+// after the mappers. Instruction fetches are not listed as accesses; they
+// are the record's bytes.
+//
+// Tracing can be limited to the instructions from a start address up to a
+// stop address, and to ranges of PC addresses.
+//
+// Two formats are written. TraceJSON writes a JSON object per line, and
+// TraceBinary a compact stream that ReadTrace decodes:
+//
+//	header: "CPUTRACE" version:u8 count:u8 (name-length:u8 name)*count
+//	record: 'I' seq:u64 pc:u32 next:u32 cycles:u64 n:u8 bytes[n]
+//	        before[count] after[count]
+//	        accesses:u16 (op:u8 addr:u32 phys:u32 value:u8)*accesses
+//
+// Numbers are little-endian, and the register values are one byte each, in
+// the order of the header's names.
+type Tracer struct {
+	Sim    *CpuSim
+	Format string
+
+	// StartAt, if set, is the PC that turns tracing on; StopAt is the PC
+	// that turns it off for good, after tracing the instruction there.
+	StartAt *Address
+	StopAt  *Address
+
+	// Ranges, if not empty, limits the trace to instructions with a PC in
+	// one of them.
+	Ranges []AddressRange
+
+	w       *bufio.Writer
+	closer  io.Closer
+	cpu     DebugInterface
+	regs    []int    // register numbers traced
+	names   []string // and their names
+	seq     uint64
+	started bool
+	stopped bool
+
+	// The instruction about to execute
+	pc        Address
+	next      TraceRecord
+	recording bool
+}
+
+// Trace formats
+const (
+	TraceJSON   = "json"
+	TraceBinary = "binary"
+)
+
+// Memory and port access kinds in a trace
+const (
+	TraceRead     = "r"
+	TraceWrite    = "w"
+	TracePortIn   = "in"
+	TracePortOut  = "out"
+	traceMagic    = "CPUTRACE"
+	traceVersion  = 1
+	traceInstrTag = 'I'
+)
+
+var traceOps = []string{TraceRead, TraceWrite, TracePortIn, TracePortOut}
+
+// AddressRange is an inclusive range of addresses.
+type AddressRange struct {
+	Start Address
+	End   Address
+}
+
+func (r AddressRange) Contains(address Address) bool {
+	return address >= r.Start && address <= r.End
+}
+
+// TraceAccess is a memory or port access.
+type TraceAccess struct {
+	Op       string  `json:"op"`   // TraceRead, TraceWrite, TracePortIn or TracePortOut
+	Address  Address `json:"addr"` // as seen by the CPU
+	Physical Address `json:"phys"` // after the mappers; ports are not mapped
+	Value    byte    `json:"value"`
+}
+
+// TraceRecord is one executed instruction.
+type TraceRecord struct {
+	Seq      uint64          `json:"seq"`
+	PC       Address         `json:"pc"`
+	Symbol   string          `json:"sym,omitempty"` // the PC as NAME+OFFSET, if there are symbols
+	Bytes    []byte          `json:"-"`
+	Hex      string          `json:"bytes"`
+	Text     string          `json:"text,omitempty"`
+	Next     Address         `json:"next"`             // the PC after the instruction
+	Cycles   uint64          `json:"cycles,omitempty"` // clock cycles taken, if the CPU counts them
+	Before   map[string]byte `json:"before"`
+	After    map[string]byte `json:"after"`
+	Accesses []TraceAccess   `json:"mem,omitempty"`
+}
+
+// NewTracer creates a tracer that writes to w in format, TraceJSON or
+// TraceBinary. If w is an io.Closer, Close closes it. The tracer does
+// nothing until Start is called.
+func NewTracer(sim *CpuSim, w io.Writer, format string) (*Tracer, error) {
+	if format != TraceJSON && format != TraceBinary {
+		return nil, fmt.Errorf("unknown trace format %q (want %s or %s)", format, TraceJSON, TraceBinary)
+	}
+	if len(sim.CPU) == 0 {
+		return nil, fmt.Errorf("no CPU to trace")
+	}
+	cpu, ok := sim.CPU[0].(DebugInterface)
+	if !ok {
+		return nil, fmt.Errorf("CPU does not support tracing")
+	}
+	t := &Tracer{Sim: sim, Format: format, w: bufio.NewWriter(w), cpu: cpu}
+	if c, ok := w.(io.Closer); ok {
+		t.closer = c
+	}
+	for i, name := range cpu.RegisterNames() {
+		if name != "" {
+			t.regs = append(t.regs, i)
+			t.names = append(t.names, name)
+		}
+	}
+	return t, nil
+}
+
+// Start writes the trace header and begins tracing with the next
+// instruction.
+func (t *Tracer) Start() error {
+	if t.Format == TraceBinary {
+		if err := t.writeHeader(); err != nil {
+			return err
+		}
+	}
+	t.started = t.StartAt == nil
+	t.prepare()
+	t.Sim.tracer = t
+	t.Sim.AddStepHook(t.step)
+	return nil
+}
+
+// Close stops tracing, and flushes and closes the output.
+func (t *Tracer) Close() error {
+	if t.Sim.tracer == t {
+		t.Sim.tracer = nil
+	}
+	t.stopped = true
+	err := t.w.Flush()
+	if t.closer != nil {
+		if cerr := t.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (t *Tracer) registers() map[string]byte {
+	regs := make(map[string]byte, len(t.regs))
+	for i, reg := range t.regs {
+		value, _ := t.Sim.CPU[0].GetReg(reg)
+		regs[t.names[i]] = value
+	}
+	return regs
+}
+
+func (t *Tracer) inRange(pc Address) bool {
+	if len(t.Ranges) == 0 {
+		return true
+	}
+	for _, r := range t.Ranges {
+		if r.Contains(pc) {
+			return true
+		}
+	}
+	return false
+}
+
+// prepare sets up the record of the instruction about to execute.
+func (t *Tracer) prepare() {
+	pc := t.cpu.GetPC()
+	t.pc = pc
+	if !t.started && t.StartAt != nil && pc == *t.StartAt {
+		t.started = true
+	}
+	t.recording = t.started && !t.stopped && t.inRange(pc)
+	if !t.recording {
+		return
+	}
+
+	t.next = TraceRecord{PC: pc, Before: t.registers()}
+	if cc, ok := t.Sim.CPU[0].(CycleCounter); ok {
+		t.next.Cycles = cc.ClockCycles()
+	}
+	if dis, ok := t.Sim.CPU[0].(DisassemblerInterface); ok {
+		ins := dis.Disassemble(pc)
+		t.next.Bytes = ins.Bytes
+		t.next.Hex = ins.Hex()
+		t.next.Text = ins.Text()
+	}
+	if t.Sim.Symbols != nil {
+		t.next.Symbol = t.Sim.DescribeAddress(pc)
+	}
+}
+
+// access records a memory or port access by the instruction being traced,
+// with the physical address that the access was decoded to.
+func (t *Tracer) access(op string, address Address, physical Address, value byte) {
+	if !t.recording || t.Sim.Replaying() {
+		return
+	}
+	// Fetches of the instruction itself are already in its bytes
+	if op == TraceRead && address >= t.next.PC && address < t.next.PC+Address(len(t.next.Bytes)) {
+		return
+	}
+	t.next.Accesses = append(t.next.Accesses, TraceAccess{Op: op, Address: address, Physical: physical, Value: value})
+}
+
+// step is the tracer's step hook. It finishes the record of the instruction
+// that has executed, and starts the next.
+func (t *Tracer) step() error {
+	if t.recording && !t.Sim.Replaying() {
+		t.seq++
+		rec := &t.next
+		rec.Seq = t.seq
+		rec.Next = t.cpu.GetPC()
+		rec.After = t.registers()
+		if cc, ok := t.Sim.CPU[0].(CycleCounter); ok {
+			rec.Cycles = cc.ClockCycles() - rec.Cycles
+		}
+		if err := t.write(rec); err != nil {
+			return fmt.Errorf("trace: %w", err)
+		}
+	}
+	if t.started && !t.stopped && t.StopAt != nil && t.pc == *t.StopAt {
+		t.stopped = true
+		if err := t.w.Flush(); err != nil {
+			return fmt.Errorf("trace: %w", err)
+		}
+	}
+	t.prepare()
+	return nil
+}
+
+func (t *Tracer) write(rec *TraceRecord) error {
+	if t.Format == TraceJSON {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		if _, err := t.w.Write(data); err != nil {
+			return err
+		}
+		return t.w.WriteByte('\n')
+	}
+
+	var buf []byte
+	buf = append(buf, traceInstrTag)
+	buf = binary.LittleEndian.AppendUint64(buf, rec.Seq)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(rec.PC))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(rec.Next))
+	buf = binary.LittleEndian.AppendUint64(buf, rec.Cycles)
+	buf = append(buf, byte(len(rec.Bytes)))
+	buf = append(buf, rec.Bytes...)
+	for _, name := range t.names {
+		buf = append(buf, rec.Before[name])
+	}
+	for _, name := range t.names {
+		buf = append(buf, rec.After[name])
+	}
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(rec.Accesses)))
+	for _, a := range rec.Accesses {
+		buf = append(buf, byte(traceOpIndex(a.Op)))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(a.Address))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(a.Physical))
+		buf = append(buf, a.Value)
+	}
+	_, err := t.w.Write(buf)
+	return err
+}
+
+func (t *Tracer) writeHeader() error {
+	buf := []byte(traceMagic)
+	buf = append(buf, traceVersion, byte(len(t.names)))
+	for _, name := range t.names {
+		buf = append(buf, byte(len(name)))
+		buf = append(buf, name...)
+	}
+	_, err := t.w.Write(buf)
+	return err
+}
+
+func traceOpIndex(op string) int {
+	for i, o := range traceOps {
+		if o == op {
+			return i
+		}
+	}
+	return 0
+}
+
+// TraceReader decodes a binary trace.
+type TraceReader struct {
+	r     *bufio.Reader
+	Names []string // register names, from the header
+}
+
+// ReadTrace reads the header of a binary trace.
+func ReadTrace(r io.Reader) (*TraceReader, error) {
+	tr := &TraceReader{r: bufio.NewReader(r)}
+	header := make([]byte, len(traceMagic)+2)
+	if _, err := io.ReadFull(tr.r, header); err != nil {
+		return nil, fmt.Errorf("reading trace header: %w", err)
+	}
+	if string(header[:len(traceMagic)]) != traceMagic {
+		return nil, fmt.Errorf("not a binary trace")
+	}
+	if header[len(traceMagic)] != traceVersion {
+		return nil, fmt.Errorf("unsupported trace version %d", header[len(traceMagic)])
+	}
+	for range int(header[len(traceMagic)+1]) {
+		name, err := tr.readBytes()
+		if err != nil {
+			return nil, err
+		}
+		tr.Names = append(tr.Names, string(name))
+	}
+	return tr, nil
+}
+
+func (tr *TraceReader) readBytes() ([]byte, error) {
+	n, err := tr.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, n)
+	_, err = io.ReadFull(tr.r, data)
+	return data, err
+}
+
+// Next returns the next record, or io.EOF at the end of the trace. The
+// record's Text and Symbol are not stored in a binary trace.
+func (tr *TraceReader) Next() (*TraceRecord, error) {
+	tag, err := tr.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if tag != traceInstrTag {
+		return nil, fmt.Errorf("bad trace record tag %02X", tag)
+	}
+
+	var fixed [24]byte
+	if _, err := io.ReadFull(tr.r, fixed[:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	rec := &TraceRecord{
+		Seq:    binary.LittleEndian.Uint64(fixed[0:]),
+		PC:     Address(binary.LittleEndian.Uint32(fixed[8:])),
+		Next:   Address(binary.LittleEndian.Uint32(fixed[12:])),
+		Cycles: binary.LittleEndian.Uint64(fixed[16:]),
+		Before: map[string]byte{},
+		After:  map[string]byte{},
+	}
+	if rec.Bytes, err = tr.readBytes(); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	rec.Hex = Instruction{Bytes: rec.Bytes}.Hex()
+
+	regs := make([]byte, 2*len(tr.Names))
+	if _, err := io.ReadFull(tr.r, regs); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	for i, name := range tr.Names {
+		rec.Before[name] = regs[i]
+		rec.After[name] = regs[len(tr.Names)+i]
+	}
+
+	var count [2]byte
+	if _, err := io.ReadFull(tr.r, count[:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	for range int(binary.LittleEndian.Uint16(count[:])) {
+		var a [10]byte
+		if _, err := io.ReadFull(tr.r, a[:]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if int(a[0]) >= len(traceOps) {
+			return nil, fmt.Errorf("bad trace access kind %d", a[0])
+		}
+		rec.Accesses = append(rec.Accesses, TraceAccess{
+			Op:       traceOps[a[0]],
+			Address:  Address(binary.LittleEndian.Uint32(a[1:])),
+			Physical: Address(binary.LittleEndian.Uint32(a[5:])),
+			Value:    a[9],
+		})
+	}
+	return rec, nil
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// ParseAddressRange parses a range of the form START-END, or a single
+// address, with each address a hex number or a symbol.
+func (sim *CpuSim) ParseAddressRange(s string) (AddressRange, error) {
+	startStr, endStr, isRange := strings.Cut(s, "-")
+	start, err := sim.ParseAddress(startStr)
+	if err != nil {
+		return AddressRange{}, err
+	}
+	end := start
+	if isRange {
+		if end, err = sim.ParseAddress(endStr); err != nil {
+			return AddressRange{}, err
+		}
+	}
+	if end < start {
+		return AddressRange{}, fmt.Errorf("invalid range %q: end is before start", s)
+	}
+	return AddressRange{Start: start, End: end}, nil
+}
+
+// TraceOptions are the settings of the --trace flags. Addresses may be
+// symbols.
+type TraceOptions struct {
+	Filename string
+	Format   string
+	Start    string   // PC that starts the trace
+	Stop     string   // PC that stops it
+	Ranges   []string // START-END ranges of PC to trace
+}
+
+// StartTrace creates a file and starts tracing to it.
+func (sim *CpuSim) StartTrace(opts TraceOptions) (*Tracer, error) {
+	parse := func(s string) (*Address, error) {
+		if s == "" {
+			return nil, nil
+		}
+		address, err := sim.ParseAddress(s)
+		return &address, err
+	}
+	start, err := parse(opts.Start)
+	if err != nil {
+		return nil, err
+	}
+	stop, err := parse(opts.Stop)
+	if err != nil {
+		return nil, err
+	}
+	var ranges []AddressRange
+	for _, s := range opts.Ranges {
+		r, err := sim.ParseAddressRange(s)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+
+	f, err := os.Create(opts.Filename)
+	if err != nil {
+		return nil, err
+	}
+	t, err := NewTracer(sim, f, opts.Format)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	t.StartAt = start
+	t.StopAt = stop
+	t.Ranges = ranges
+	if err := t.Start(); err != nil {
+		_ = t.Close()
+		return nil, err
+	}
+	return t, nil
+}
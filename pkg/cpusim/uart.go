@@ -1,6 +1,7 @@
 package cpusim
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -37,6 +38,10 @@ func (u *UART) HasAddress(address Address) bool {
 		address == u.ControlReadAddress || address == u.ControlWriteAddress)
 }
 
+func (u *UART) DecodeEnabler() EnablerInterface {
+	return u.Enabler
+}
+
 func (u *UART) Read(address Address) (byte, error) {
 	if !u.HasAddress(address) {
 		return 0, &ErrInvalidAddress{Address: address}
@@ -46,7 +51,7 @@ func (u *UART) Read(address Address) (byte, error) {
 	defer u.mu.Unlock()
 
 	if u.inputEOF && len(u.Keybuffer) == 0 {
-		u.Sim.Halt()
+		u.Sim.Stop(ExitEndOfInput)
 	}
 
 	if address == u.DataReadAddress {
@@ -82,7 +87,7 @@ func (u *UART) Write(address Address, value byte) error {
 	}
 
 	if address == u.DataWriteAddress {
-		err := u.Serial.WriteByte(value)
+		err := u.Sim.WriteSerial(u.Serial, value)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error writing to serial: %v\n", err)
 		}
@@ -115,21 +120,32 @@ func (u *UART) Run() error {
 		if b == 0x03 {
 			u.Sim.CtrlC.Store(true)
 		}
-		u.mu.Lock()
-		u.Keybuffer = append(u.Keybuffer, b)
-		u.mu.Unlock()
+		u.Sim.DeliverInput(u, b)
 	}
 }
 
+// ReceiveInput appends a byte to the receive buffer.
+func (u *UART) ReceiveInput(b byte) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.Keybuffer = append(u.Keybuffer, b)
+}
+
+// EndInput marks the end of the input. Once the receive buffer has been read
+// empty, the machine stops with ExitEndOfInput.
+func (u *UART) EndInput() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.inputEOF = true
+}
+
 func (u *UART) Start(wg *sync.WaitGroup) {
 	go func() {
 		u.Serial.Start()
 		err := u.Run()
 		if err != nil {
 			if err == io.EOF {
-				u.mu.Lock()
-				u.inputEOF = true
-				u.mu.Unlock()
+				u.EndInput()
 			} else {
 				fmt.Fprintf(os.Stderr, "UART error: %v\n", err)
 			}
@@ -157,3 +173,46 @@ func NewUART(sim *CpuSim, serial SerialIO, name string, dataReadAddress, dataWri
 		Enabler:             enabler,
 	}
 }
+
+// UARTOptions are the options for a KIND_UART, an 8251.
+type UARTOptions struct {
+	Name                string           `yaml:"name"`
+	DataReadAddress     Address          `yaml:"data-read"`
+	DataWriteAddress    Address          `yaml:"data-write"`
+	ControlReadAddress  Address          `yaml:"control-read"`
+	ControlWriteAddress Address          `yaml:"control-write"`
+	Serial              SerialIO         `yaml:"-"`
+	Enabler             EnablerInterface `yaml:"-"`
+}
+
+func init() {
+	RegisterDevice(KIND_UART, func(sim *CpuSim, o UARTOptions) (DeviceInterface, error) {
+		if o.Serial == nil {
+			return nil, fmt.Errorf("no serial transport")
+		}
+		return NewUART(sim, o.Serial, o.Name, o.DataReadAddress, o.DataWriteAddress, o.ControlReadAddress, o.ControlWriteAddress, enablerOrAlways(o.Enabler)), nil
+	})
+}
+
+type uartState struct {
+	Keybuffer   []byte `json:"keybuffer"`
+	LastCharOut byte   `json:"last_char_out"`
+}
+
+func (u *UART) SaveState() (any, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return &uartState{Keybuffer: append([]byte{}, u.Keybuffer...), LastCharOut: u.lastCharOut}, nil
+}
+
+func (u *UART) RestoreState(data json.RawMessage) error {
+	var state uartState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.Keybuffer = state.Keybuffer
+	u.lastCharOut = state.LastCharOut
+	return nil
+}
@@ -0,0 +1,568 @@
+// Package gdbstub serves the GDB remote serial protocol, so that gdb and
+// other front-ends that speak it can debug a simulated machine.
+//
+// The stub supports reading and writing registers and memory, software and
+// hardware breakpoints (which are the same thing here), write, read and
+// access watchpoints, single-step and continue. It runs in all-stop mode
+// with a single thread. The CPU is stopped when a debugger attaches, and
+// left running, with its breakpoints cleared, when it detaches.
+package gdbstub
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+)
+
+const packetSize = 0x1000
+
+// Server accepts debugger connections, one at a time.
+type Server struct {
+	Sim      *cpusim.CpuSim
+	Debugger *cpusim.Debugger
+
+	cpu      cpusim.RemoteDebugInterface
+	listener net.Listener
+}
+
+// New creates a server that debugs the machine through debugger, which may be
+// shared with the monitor.
+func New(sim *cpusim.CpuSim, debugger *cpusim.Debugger) (*Server, error) {
+	cpu, ok := debugger.CPU.(cpusim.RemoteDebugInterface)
+	if !ok {
+		return nil, &cpusim.ErrNotImplemented{What: "remote debugging this CPU"}
+	}
+	return &Server{
+		Sim:      sim,
+		Debugger: debugger,
+		cpu:      cpu,
+	}, nil
+}
+
+// Listen starts listening on address, which is host:port for TCP or
+// unix:path for a Unix socket.
+func (s *Server) Listen(address string) error {
+	network := "tcp"
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		network, address = "unix", path
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	return nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Serve handles connections until Close is called.
+func (s *Server) Serve() error {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		s.ServeConn(conn)
+	}
+}
+
+// Close stops listening.
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+// ServeConn runs a debugging session on conn and closes it when the
+// debugger detaches or disconnects.
+func (s *Server) ServeConn(conn io.ReadWriteCloser) {
+	c := &session{
+		server:  s,
+		conn:    conn,
+		regs:    s.cpu.RemoteRegisters(),
+		packets: make(chan string),
+	}
+	defer conn.Close() // nolint:errcheck
+	go c.read()
+
+	if _, err := s.Debugger.Stop(); err != nil {
+		c.exited = true
+	}
+
+	done := false
+	for packet := range c.packets {
+		if done {
+			continue // drain until the reader sees the connection close
+		}
+		reply, last := c.handle(packet)
+		if reply != nil {
+			c.send(*reply)
+		}
+		if last {
+			done = true
+			conn.Close() // nolint:errcheck
+		}
+	}
+	if !done {
+		c.detach()
+	}
+}
+
+type session struct {
+	server  *Server
+	conn    io.ReadWriteCloser
+	regs    []cpusim.RemoteRegister
+	packets chan string
+	noAck   atomic.Bool
+	writeMu sync.Mutex
+	exited  bool
+}
+
+// read splits the incoming stream into packets, acknowledging each, and
+// turns a Ctrl-C into an interrupt of the running CPU.
+func (c *session) read() {
+	defer close(c.packets)
+	r := bufio.NewReader(c.conn)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		switch b {
+		case 0x03:
+			c.server.Debugger.Interrupt()
+		case '$':
+			data, err := r.ReadString('#')
+			if err != nil {
+				return
+			}
+			data = strings.TrimSuffix(data, "#")
+			var sum [2]byte
+			if _, err := io.ReadFull(r, sum[:]); err != nil {
+				return
+			}
+			want, err := strconv.ParseUint(string(sum[:]), 16, 8)
+			if !c.noAck.Load() {
+				if err != nil || byte(want) != checksum(data) {
+					c.write("-")
+					continue
+				}
+				c.write("+")
+			}
+			c.packets <- data
+		}
+		// acknowledgements from the debugger are ignored; packets are
+		// not retransmitted
+	}
+}
+
+func checksum(data string) byte {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+func (c *session) write(s string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, _ = io.WriteString(c.conn, s)
+}
+
+func (c *session) send(data string) {
+	var escaped strings.Builder
+	for i := 0; i < len(data); i++ {
+		switch b := data[i]; b {
+		case '$', '#', '}', '*':
+			escaped.WriteByte('}')
+			escaped.WriteByte(b ^ 0x20)
+		default:
+			escaped.WriteByte(b)
+		}
+	}
+	data = escaped.String()
+	c.write(fmt.Sprintf("$%s#%02x", data, checksum(data)))
+}
+
+func reply(s string) *string {
+	return &s
+}
+
+var (
+	replyOK    = reply("OK")
+	replyEmpty = reply("")
+	replyError = reply("E01")
+)
+
+// handle executes a packet. It returns the reply, or nil for none, and
+// whether the session is over.
+func (c *session) handle(packet string) (*string, bool) {
+	if packet == "" {
+		return replyEmpty, false
+	}
+	d := c.server.Debugger
+	args := packet[1:]
+
+	switch packet[0] {
+	case '?':
+		if c.exited {
+			return reply("W00"), false
+		}
+		return reply("S05"), false
+	case 'g':
+		return c.readRegisters(), false
+	case 'G':
+		return c.writeRegisters(args), false
+	case 'p':
+		return c.readRegister(args), false
+	case 'P':
+		return c.writeRegister(args), false
+	case 'm':
+		return c.readMemory(args), false
+	case 'M':
+		return c.writeMemory(args, false), false
+	case 'X':
+		return c.writeMemory(args, true), false
+	case 'c', 's':
+		if args != "" {
+			pc, err := strconv.ParseUint(args, 16, 32)
+			if err != nil {
+				return replyError, false
+			}
+			d.CPU.SetPC(cpusim.Address(pc))
+		}
+		var ev cpusim.StopEvent
+		var err error
+		if packet[0] == 's' {
+			ev, err = d.Step(1)
+		} else {
+			ev, err = d.Resume()
+		}
+		return c.stopReply(ev, err), false
+	case 'Z', 'z':
+		return c.breakpoint(packet[0] == 'Z', args), false
+	case 'D':
+		c.detach()
+		return replyOK, true
+	case 'k':
+		d.ClearAll()
+		c.server.Sim.CtrlC.Store(true)
+		d.Continue()
+		return nil, true
+	case 'H':
+		return replyOK, false
+	case 'T':
+		return replyOK, false
+	case 'q':
+		return c.query(args), false
+	case 'Q':
+		if args == "StartNoAckMode" {
+			c.noAck.Store(true)
+			return replyOK, false
+		}
+	}
+	return replyEmpty, false
+}
+
+func (c *session) detach() {
+	c.server.Debugger.ClearAll()
+	c.server.Debugger.Continue()
+}
+
+func (c *session) stopReply(ev cpusim.StopEvent, err error) *string {
+	if err != nil {
+		var notRunning *cpusim.ErrNotRunning
+		if errors.As(err, &notRunning) {
+			c.exited = true
+			return reply("W00")
+		}
+		return replyError
+	}
+	switch ev.Reason {
+	case cpusim.StopRequested:
+		return reply("S02")
+	case cpusim.StopWatchpoint:
+		kind := "awatch"
+		switch ev.Watch {
+		case cpusim.WatchWrite:
+			kind = "watch"
+		case cpusim.WatchRead:
+			kind = "rwatch"
+		}
+		return reply(fmt.Sprintf("T05%s:%x;", kind, ev.Address))
+	default:
+		return reply("S05")
+	}
+}
+
+func encodeRegister(reg cpusim.RemoteRegister) string {
+	value := reg.Get()
+	if reg.Bits == 8 {
+		return fmt.Sprintf("%02x", byte(value))
+	}
+	return fmt.Sprintf("%02x%02x", byte(value), byte(value>>8))
+}
+
+// decodeRegister decodes a little-endian register value, returning the
+// number of hex digits used.
+func decodeRegister(reg cpusim.RemoteRegister, s string) (int, error) {
+	n := reg.Bits / 4
+	if len(s) < n {
+		return 0, fmt.Errorf("short register value")
+	}
+	data, err := hex.DecodeString(s[:n])
+	if err != nil {
+		return 0, err
+	}
+	value := uint16(data[0])
+	if len(data) > 1 {
+		value |= uint16(data[1]) << 8
+	}
+	reg.Set(value)
+	return n, nil
+}
+
+func (c *session) readRegisters() *string {
+	var sb strings.Builder
+	for _, reg := range c.regs {
+		sb.WriteString(encodeRegister(reg))
+	}
+	return reply(sb.String())
+}
+
+func (c *session) writeRegisters(args string) *string {
+	for _, reg := range c.regs {
+		n, err := decodeRegister(reg, args)
+		if err != nil {
+			return replyError
+		}
+		args = args[n:]
+	}
+	return replyOK
+}
+
+func (c *session) register(s string) (cpusim.RemoteRegister, bool) {
+	n, err := strconv.ParseUint(s, 16, 16)
+	if err != nil || int(n) >= len(c.regs) {
+		return cpusim.RemoteRegister{}, false
+	}
+	return c.regs[n], true
+}
+
+func (c *session) readRegister(args string) *string {
+	reg, ok := c.register(args)
+	if !ok {
+		return replyError
+	}
+	return reply(encodeRegister(reg))
+}
+
+func (c *session) writeRegister(args string) *string {
+	num, value, ok := strings.Cut(args, "=")
+	if !ok {
+		return replyError
+	}
+	reg, ok := c.register(num)
+	if !ok {
+		return replyError
+	}
+	if _, err := decodeRegister(reg, value); err != nil {
+		return replyError
+	}
+	return replyOK
+}
+
+// parseRange parses "addr,length" as used by the memory packets.
+func parseRange(s string) (cpusim.Address, int, error) {
+	a, l, ok := strings.Cut(s, ",")
+	if !ok {
+		return 0, 0, fmt.Errorf("bad range %q", s)
+	}
+	address, err := strconv.ParseUint(a, 16, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	length, err := strconv.ParseUint(l, 16, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	return cpusim.Address(address), int(length), nil
+}
+
+func (c *session) readMemory(args string) *string {
+	address, length, err := parseRange(args)
+	if err != nil || length > packetSize/2 {
+		return replyError
+	}
+	data := make([]byte, length)
+	for i := range data {
+		if data[i], err = c.server.Sim.ReadMemory(address + cpusim.Address(i)); err != nil {
+			return replyError
+		}
+	}
+	return reply(hex.EncodeToString(data))
+}
+
+func (c *session) writeMemory(args string, binary bool) *string {
+	rng, payload, ok := strings.Cut(args, ":")
+	if !ok {
+		return replyError
+	}
+	address, length, err := parseRange(rng)
+	if err != nil {
+		return replyError
+	}
+	var data []byte
+	if binary {
+		data = unescape(payload)
+	} else if data, err = hex.DecodeString(payload); err != nil {
+		return replyError
+	}
+	if len(data) != length {
+		return replyError
+	}
+	for i, b := range data {
+		if err := c.server.Sim.WriteMemory(address+cpusim.Address(i), b); err != nil {
+			return replyError
+		}
+	}
+	return replyOK
+}
+
+// unescape decodes the binary data of an X packet.
+func unescape(s string) []byte {
+	data := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '}' && i+1 < len(s) {
+			i++
+			data = append(data, s[i]^0x20)
+		} else {
+			data = append(data, s[i])
+		}
+	}
+	return data
+}
+
+// breakpoint handles Z (insert) and z (remove) packets: type,addr,kind.
+func (c *session) breakpoint(insert bool, args string) *string {
+	fields := strings.Split(args, ",")
+	if len(fields) < 3 {
+		return replyError
+	}
+	address, err := strconv.ParseUint(fields[1], 16, 32)
+	if err != nil {
+		return replyError
+	}
+	length, err := strconv.ParseUint(fields[2], 16, 32)
+	if err != nil {
+		return replyError
+	}
+
+	d := c.server.Debugger
+	addr := cpusim.Address(address)
+	var kind cpusim.WatchKind
+	switch fields[0] {
+	case "0", "1":
+		if insert {
+			d.AddBreakpoint(addr)
+		} else {
+			d.RemoveBreakpoint(addr)
+		}
+		return replyOK
+	case "2":
+		kind = cpusim.WatchWrite
+	case "3":
+		kind = cpusim.WatchRead
+	case "4":
+		kind = cpusim.WatchAccess
+	default:
+		return replyEmpty
+	}
+	if insert {
+		d.AddWatchpoint(addr, cpusim.Address(length), kind)
+	} else {
+		d.RemoveWatchpoint(addr, cpusim.Address(length), kind)
+	}
+	return replyOK
+}
+
+func (c *session) query(args string) *string {
+	name, _, _ := strings.Cut(args, ":")
+	switch name {
+	case "Supported":
+		return reply(fmt.Sprintf("PacketSize=%x;qXfer:features:read+;QStartNoAckMode+", packetSize))
+	case "Attached":
+		return reply("1")
+	case "C":
+		return reply("QC1")
+	case "fThreadInfo":
+		return reply("m1")
+	case "sThreadInfo":
+		return reply("l")
+	case "Xfer":
+		return c.features(args)
+	}
+	return replyEmpty
+}
+
+// features serves qXfer:features:read:target.xml:offset,length.
+func (c *session) features(args string) *string {
+	rest, ok := strings.CutPrefix(args, "Xfer:features:read:target.xml:")
+	if !ok {
+		return replyEmpty
+	}
+	offset, length, err := parseRange(rest)
+	if err != nil {
+		return replyError
+	}
+	xml := c.targetXML()
+	if int(offset) >= len(xml) {
+		return reply("l")
+	}
+	chunk := xml[offset:]
+	if len(chunk) > length {
+		return reply("m" + chunk[:length])
+	}
+	return reply("l" + chunk)
+}
+
+func (c *session) targetXML() string {
+	arch := c.server.cpu.RemoteArchitecture()
+	feature := "org.gocpusim.cpu"
+	var sb strings.Builder
+	sb.WriteString("<?xml version=\"1.0\"?>\n<!DOCTYPE target SYSTEM \"gdb-target.dtd\">\n<target version=\"1.0\">\n")
+	if arch != "" {
+		fmt.Fprintf(&sb, "  <architecture>%s</architecture>\n", arch)
+		feature = "org.gnu.gdb." + arch + ".cpu"
+	}
+	fmt.Fprintf(&sb, "  <feature name=\"%s\">\n", feature)
+	for _, reg := range c.regs {
+		typ := "int"
+		switch strings.ToLower(reg.Name) {
+		case "pc":
+			typ = "code_ptr"
+		case "sp":
+			if reg.Bits == 16 {
+				typ = "data_ptr"
+			}
+		}
+		fmt.Fprintf(&sb, "    <reg name=\"%s\" bitsize=\"%d\" type=\"%s\"/>\n", reg.Name, reg.Bits, typ)
+	}
+	sb.WriteString("  </feature>\n</target>\n")
+	return sb.String()
+}
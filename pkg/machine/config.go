@@ -0,0 +1,192 @@
+// Package machine builds a simulated computer from a YAML description of its
+// hardware, so that a new board variant is a configuration file rather than
+// a main.go of its own.
+//
+// A description names the CPU and lists the memory, mappers, ports, serial
+// devices and disks on the board, and devices of any other registered kind.
+// Devices are wired together by name: an enable field names an enable bit
+// that a mapper or latch drives, and a bus field names the 4004 8-bit bus or
+// ROM port that a device sits on instead of the CPU's own.
+package machine
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes a machine.
+type Config struct {
+	Name        string         `yaml:"name"`
+	Description string         `yaml:"description"`
+	CPU         CPUConfig      `yaml:"cpu"`
+	EnableBits  []string       `yaml:"enable-bits"` // chip selects driven by mapper and latch outputs
+	Memory      []MemoryConfig `yaml:"memory"`
+	Ports       []PortConfig   `yaml:"ports"`
+	Mappers     []MapperConfig `yaml:"mappers"`
+	Serial      []SerialConfig `yaml:"serial"`
+	Disks       []DiskConfig   `yaml:"disks"`
+	Devices     []DeviceConfig `yaml:"devices"` // devices of any registered kind
+	ROM         string         `yaml:"rom"`     // loaded into the rom device, like --rom-file
+	Load        []string       `yaml:"load"`    // loaded like --load
+
+	// Coprocessors are more CPUs, each described like a machine of its own
+	// with the devices that only it can reach. Shared names the devices of
+	// the main CPU that a coprocessor shares; they appear at the same
+	// addresses to both. Interleave is how the CPUs take turns: free,
+	// instructions (Quantum at a time) or cycles.
+	Coprocessors []Config `yaml:"coprocessors"`
+	Shared       []string `yaml:"shared"`
+	Interleave   string   `yaml:"interleave"`
+	Quantum      int      `yaml:"quantum"`
+
+	// Dir is the directory that relative file names are relative to. It is
+	// the directory of the configuration file, if there is one.
+	Dir string `yaml:"-"`
+}
+
+// CPUConfig describes the processor.
+type CPUConfig struct {
+	Type     string         `yaml:"type"`      // z80, 8008 or 4004
+	PortMask cpusim.Address `yaml:"port-mask"` // Z80 port address mask; 0xFF for 8-bit ports
+	Clock    string         `yaml:"clock"`     // e.g. 740kHz; for interleaving by cycles
+}
+
+// MemoryConfig describes a RAM or ROM, or the 4004's 8-bit bus.
+type MemoryConfig struct {
+	Name     string         `yaml:"name"`
+	Kind     string         `yaml:"kind"` // ram, rom or bus8
+	Start    cpusim.Address `yaml:"start"`
+	End      cpusim.Address `yaml:"end"`
+	Bits     int            `yaml:"bits"`      // address lines decoded by the chip
+	ReadOnly *bool          `yaml:"read-only"` // defaults to true for rom
+	Enable   string         `yaml:"enable"`
+	Status   *StatusConfig  `yaml:"status"` // 4004 status characters
+	Bus      string         `yaml:"bus"`
+}
+
+// StatusConfig gives the size of a 4004 RAM's status characters.
+type StatusConfig struct {
+	Rows    int `yaml:"rows"`
+	Columns int `yaml:"columns"`
+}
+
+// MapperConfig describes a memory mapper.
+type MapperConfig struct {
+	Name      string         `yaml:"name"`
+	Type      string         `yaml:"type"` // 74670, dual74670 or 74173
+	Address   cpusim.Address `yaml:"address"`
+	SourceBit string         `yaml:"source-bit"` // lowest address bit that selects a register
+	DataBit   string         `yaml:"data-bit"`   // lowest data bit that is written to a register
+	DestBits  []string       `yaml:"dest-bits"`  // address bits that the register outputs drive
+	Enable    string         `yaml:"enable"`
+	MapEnable string         `yaml:"map-enable"`
+	Outputs   map[int]string `yaml:"outputs"` // register bits that drive enable bits
+	Filter    string         `yaml:"filter"`  // only map memory of this kind
+	Bus       string         `yaml:"bus"`     // the bus whose memory is mapped
+	Registers string         `yaml:"registers"`
+}
+
+// PortConfig describes a simple I/O port device.
+type PortConfig struct {
+	Name    string         `yaml:"name"`
+	Type    string         `yaml:"type"` // latch, dipswitch, sp0256 or romport
+	Address cpusim.Address `yaml:"address"`
+	Value   byte           `yaml:"value"`
+	Outputs map[int]string `yaml:"outputs"` // latch bits that drive enable bits
+	Enable  string         `yaml:"enable"`
+	Bus     string         `yaml:"bus"`
+}
+
+// SerialConfig describes a UART. The 8251 has separate read and write
+// addresses; they default to the data and control addresses.
+type SerialConfig struct {
+	Name         string          `yaml:"name"`
+	Type         string          `yaml:"type"` // 8251, acia, sio, scc or asci
+	Address      cpusim.Address  `yaml:"address"`
+	Data         cpusim.Address  `yaml:"data"`
+	Control      cpusim.Address  `yaml:"control"`
+	DataB        cpusim.Address  `yaml:"data-b"`
+	ControlB     cpusim.Address  `yaml:"control-b"`
+	DataWrite    *cpusim.Address `yaml:"data-write"`
+	ControlWrite *cpusim.Address `yaml:"control-write"`
+	Interrupt    bool            `yaml:"interrupt"`
+	Transport    string          `yaml:"transport"` // console, null or file:PATH
+	Enable       string          `yaml:"enable"`
+	Bus          string          `yaml:"bus"`
+}
+
+// DiskConfig describes a CompactFlash card or a floppy disk controller. A
+// disk with no image is left out, as if nothing were plugged in.
+type DiskConfig struct {
+	Name      string         `yaml:"name"`
+	Type      string         `yaml:"type"` // cf or fdc
+	Address   cpusim.Address `yaml:"address"`
+	MSR       cpusim.Address `yaml:"msr"`
+	Data      cpusim.Address `yaml:"data"`
+	DOR       cpusim.Address `yaml:"dor"`
+	DCR       cpusim.Address `yaml:"dcr"`
+	Image     string         `yaml:"image"`
+	Offset    int64          `yaml:"offset"`   // byte offset of sector 0 in a CF image
+	Identify  string         `yaml:"identify"` // CF identify block file
+	Drive     int            `yaml:"drive"`
+	Interrupt bool           `yaml:"interrupt"`
+	Enable    string         `yaml:"enable"`
+	Bus       string         `yaml:"bus"`
+}
+
+// DeviceConfig describes a device by its registered kind, which may be one
+// that another package registers. Options are decoded into the kind's options
+// struct; its name, enabler and serial transport are filled in from the
+// other fields.
+type DeviceConfig struct {
+	Name      string    `yaml:"name"`
+	Kind      string    `yaml:"kind"`  // as cpusim list-devices --kinds shows
+	Space     string    `yaml:"space"` // memory or ports; ports by default
+	Options   yaml.Node `yaml:"options"`
+	Transport string    `yaml:"transport"` // for a serial device
+	Interrupt bool      `yaml:"interrupt"`
+	Enable    string    `yaml:"enable"`
+	Bus       string    `yaml:"bus"`
+}
+
+// LoadConfig reads a machine description from a YAML file.
+func LoadConfig(filename string) (*Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ParseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	cfg.Dir = filepath.Dir(filename)
+	return cfg, nil
+}
+
+// ParseConfig reads a machine description. Unknown fields are errors, so
+// that a misspelt setting isn't silently ignored.
+func ParseConfig(data []byte) (*Config, error) {
+	var cfg Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, err
+	}
+	if cfg.CPU.Type == "" {
+		return nil, fmt.Errorf("no cpu type")
+	}
+	return &cfg, nil
+}
+
+// path resolves a file name in the configuration.
+func (cfg *Config) path(filename string) string {
+	if filename == "" || filepath.IsAbs(filename) || cfg.Dir == "" {
+		return filename
+	}
+	return filepath.Join(cfg.Dir, filename)
+}
@@ -0,0 +1,903 @@
+package machine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+	"github.com/scottmbaker/gocpusim/pkg/cpusim/cpu4004"
+	"github.com/scottmbaker/gocpusim/pkg/cpusim/cpu8008"
+	"github.com/scottmbaker/gocpusim/pkg/cpusim/cpuz80"
+	"gopkg.in/yaml.v3"
+)
+
+// Options are the settings that come from the command line rather than the
+// machine description.
+type Options struct {
+	Console  cpusim.SerialIO      // for the serial devices whose transport is console
+	ROM      string               // replaces the description's rom
+	Load     []string             // loaded after the description's load
+	EraseROM bool                 // fill ROM with FF before loading
+	Disks    map[string]DiskImage // images by disk name, replacing the description's
+}
+
+// DiskImage is an image for one of a machine's disks. Offset and Identify,
+// when given, replace the description's for a CF card.
+type DiskImage struct {
+	Image    string
+	Offset   *int64 // byte offset of sector 0
+	Identify string // identify block file
+}
+
+// ParseDiskImage parses an argument to --disk, which is
+// NAME=IMAGE[,offset=N][,identify=FILE].
+func ParseDiskImage(arg string) (string, DiskImage, error) {
+	name, rest, ok := strings.Cut(arg, "=")
+	if !ok || name == "" {
+		return "", DiskImage{}, fmt.Errorf("invalid --disk %q; expected NAME=IMAGE[,offset=N][,identify=FILE]", arg)
+	}
+	fields := strings.Split(rest, ",")
+	disk := DiskImage{Image: fields[0]}
+	if disk.Image == "" {
+		return "", DiskImage{}, fmt.Errorf("no image in --disk %q", arg)
+	}
+	for _, field := range fields[1:] {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "offset":
+			offset, err := strconv.ParseInt(value, 0, 64)
+			if err != nil || offset < 0 {
+				return "", DiskImage{}, fmt.Errorf("invalid offset %q in --disk %q", value, arg)
+			}
+			disk.Offset = &offset
+		case "identify":
+			if value == "" {
+				return "", DiskImage{}, fmt.Errorf("no identify file in --disk %q", arg)
+			}
+			disk.Identify = value
+		default:
+			return "", DiskImage{}, fmt.Errorf("unknown option %q in --disk %q; expected offset or identify", key, arg)
+		}
+	}
+	return name, disk, nil
+}
+
+// Machine is a computer built from a description. A machine with
+// coprocessors runs them all with Multi.
+type Machine struct {
+	Config       *Config
+	Sim          *cpusim.CpuSim
+	CPU          cpusim.CpuInterface
+	Devices      map[string]any
+	Serial       []cpusim.UartInterface
+	Coprocessors []*Machine
+	Multi        *cpusim.MultiSim
+
+	enableBits map[string]*cpusim.EnableBit
+	intLine    *cpusim.SignalLine
+	daisyChain *cpusim.DaisyChain
+	dcl        func(byte) cpusim.EnablerInterface
+	console    string // the serial device using Options.Console
+
+	serialStarted bool
+}
+
+// New builds the machine that cfg describes and loads its images.
+func New(sim *cpusim.CpuSim, cfg *Config, opts Options) (*Machine, error) {
+	if len(cfg.Shared) > 0 {
+		return nil, fmt.Errorf("only a coprocessor shares devices")
+	}
+	m, err := build(sim, cfg, opts, "")
+	if err != nil {
+		return nil, err
+	}
+	for name := range opts.Disks {
+		if !m.hasDisk(name) {
+			return nil, fmt.Errorf("no disk named %q", name)
+		}
+	}
+	if err := m.addCoprocessors(opts.Console); err != nil {
+		return nil, err
+	}
+	if err := m.load(opts); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// build adds the CPU and the devices that cfg describes. The console may
+// already be used by another CPU's serial device.
+func build(sim *cpusim.CpuSim, cfg *Config, opts Options, console string) (*Machine, error) {
+	m := &Machine{
+		Config:     cfg,
+		Sim:        sim,
+		Devices:    map[string]any{},
+		enableBits: map[string]*cpusim.EnableBit{},
+		console:    console,
+	}
+	if err := m.addCPU(); err != nil {
+		return nil, err
+	}
+	for _, name := range cfg.EnableBits {
+		if _, ok := m.enableBits[name]; ok {
+			return nil, fmt.Errorf("enable bit %q is declared twice", name)
+		}
+		m.enableBits[name] = cpusim.NewEnableBit()
+	}
+
+	// Memory comes first so that the 8-bit bus exists for the devices on it,
+	// and ports before mappers so that the ROM port does.
+	for _, c := range cfg.Memory {
+		if err := m.addMemory(c); err != nil {
+			return nil, fmt.Errorf("memory %s: %w", c.Name, err)
+		}
+	}
+	for _, c := range cfg.Ports {
+		if err := m.addPort(c); err != nil {
+			return nil, fmt.Errorf("port %s: %w", c.Name, err)
+		}
+	}
+	for _, c := range cfg.Mappers {
+		if err := m.addMapper(c); err != nil {
+			return nil, fmt.Errorf("mapper %s: %w", c.Name, err)
+		}
+	}
+	for _, c := range cfg.Serial {
+		if err := m.addSerial(c, opts.Console); err != nil {
+			return nil, fmt.Errorf("serial %s: %w", c.Name, err)
+		}
+	}
+	for _, c := range cfg.Disks {
+		image := cfg.path(c.Image)
+		c.Identify = cfg.path(c.Identify)
+		if override, ok := opts.Disks[c.Name]; ok {
+			image = override.Image
+			if override.Offset != nil {
+				c.Offset = *override.Offset
+			}
+			if override.Identify != "" {
+				c.Identify = override.Identify
+			}
+		}
+		if err := m.addDisk(c, image); err != nil {
+			return nil, fmt.Errorf("disk %s: %w", c.Name, err)
+		}
+	}
+	for _, c := range cfg.Devices {
+		if err := m.addDevice(c, opts.Console); err != nil {
+			return nil, fmt.Errorf("device %s: %w", c.Name, err)
+		}
+	}
+	return m, nil
+}
+
+// load loads the description's images and those of the options.
+func (m *Machine) load(opts Options) error {
+	rom := opts.ROM
+	if rom == "" {
+		rom = m.Config.path(m.Config.ROM)
+	}
+	var images []string
+	for _, arg := range m.Config.Load {
+		spec, err := cpusim.ParseLoadSpec(arg)
+		if err != nil {
+			return err
+		}
+		spec.Filename = m.Config.path(spec.Filename)
+		images = append(images, spec.String())
+	}
+	images = append(images, opts.Load...)
+	err := m.Sim.LoadImages(cpusim.LoadOptions{ROM: rom, Images: images, EraseROM: opts.EraseROM})
+	if err != nil {
+		return fmt.Errorf("failed to load: %w", err)
+	}
+	return nil
+}
+
+// addCoprocessors builds the coprocessors, each with a CpuSim of its own,
+// and puts the devices they share on the bus. Their images come from their
+// own descriptions.
+func (m *Machine) addCoprocessors(console cpusim.SerialIO) error {
+	if len(m.Config.Coprocessors) == 0 {
+		return nil
+	}
+	clock, err := m.clock()
+	if err != nil {
+		return err
+	}
+	m.Multi = cpusim.NewMultiSim()
+	if m.Config.Interleave != "" {
+		if m.Multi.Interleave, err = cpusim.ParseInterleave(m.Config.Interleave); err != nil {
+			return err
+		}
+	}
+	if m.Config.Quantum != 0 {
+		m.Multi.Quantum = m.Config.Quantum
+	}
+	m.Multi.AddProcessor(m.Sim, clock)
+
+	shared := map[string]cpusim.MemoryInterface{}
+	for i := range m.Config.Coprocessors {
+		c := &m.Config.Coprocessors[i]
+		name := c.Name
+		if name == "" {
+			name = fmt.Sprintf("%d", i+1)
+		}
+		if err := m.addCoprocessor(c, console, shared); err != nil {
+			return fmt.Errorf("coprocessor %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (m *Machine) addCoprocessor(c *Config, console cpusim.SerialIO, shared map[string]cpusim.MemoryInterface) error {
+	if len(c.Coprocessors) > 0 || c.Interleave != "" || c.Quantum != 0 {
+		return fmt.Errorf("only the main CPU has coprocessors")
+	}
+	if c.Dir == "" {
+		c.Dir = m.Config.Dir
+	}
+	sim := cpusim.NewCPUSim()
+	sim.SetDebug(m.Sim.Debug)
+	sim.SetMemDebug(m.Sim.MemDebug)
+	// There is one console, whichever CPU's serial device has it
+	sub, err := build(sim, c, Options{Console: console}, m.console)
+	if err != nil {
+		return err
+	}
+	m.console = sub.console
+	clock, err := sub.clock()
+	if err != nil {
+		return err
+	}
+	m.Multi.AddProcessor(sim, clock)
+
+	for _, name := range c.Shared {
+		if err := m.share(sub, name, shared); err != nil {
+			return fmt.Errorf("shared %s: %w", name, err)
+		}
+	}
+	if err := sub.load(Options{}); err != nil {
+		return err
+	}
+	m.Coprocessors = append(m.Coprocessors, sub)
+	return nil
+}
+
+// share puts one of the main CPU's devices on the bus and adds it to a
+// coprocessor, in memory or on the ports as the main CPU has it.
+func (m *Machine) share(sub *Machine, name string, shared map[string]cpusim.MemoryInterface) error {
+	device, ok := m.Devices[name].(cpusim.MemoryInterface)
+	if !ok {
+		return fmt.Errorf("there is no device named %q", name)
+	}
+	if _, ok := device.(cpusim.MapperInterface); ok {
+		return fmt.Errorf("a mapper belongs to one CPU")
+	}
+	onBus, ok := shared[name]
+	if !ok {
+		onBus = m.Multi.Share(device)
+		shared[name] = onBus
+	}
+	switch {
+	case slices.Contains(m.Sim.Memory, onBus):
+		sub.Sim.AddMemory(onBus)
+	case slices.Contains(m.Sim.Ports, onBus):
+		sub.Sim.AddPort(onBus)
+	default:
+		return fmt.Errorf("only the memory and ports of the main CPU can be shared")
+	}
+	return sub.add(name, onBus)
+}
+
+// clock returns the frequency of the CPU, or 0 if the description doesn't
+// give it.
+func (m *Machine) clock() (int64, error) {
+	if m.Config.CPU.Clock == "" {
+		return 0, nil
+	}
+	return cpusim.ParseFrequency(m.Config.CPU.Clock)
+}
+
+// Run starts the serial devices, the first time it is called, and runs the
+// CPUs until the machine stops, as CpuSim.Run does.
+func (m *Machine) Run(ctx context.Context) cpusim.RunResult {
+	if !m.serialStarted {
+		var wg sync.WaitGroup
+		for _, uart := range m.serial() {
+			uart.Start(&wg)
+		}
+		m.serialStarted = true
+	}
+	if m.Multi != nil {
+		return m.Multi.Run(ctx)
+	}
+	return m.Sim.Run(ctx)
+}
+
+// Scheduler returns a scheduler that runs the machine deterministically,
+// with the script's input going to the console. Run it instead of the
+// machine's Run, which would start the serial devices. A machine with
+// coprocessors can't be run this way.
+func (m *Machine) Scheduler(script []cpusim.InputEvent) (*cpusim.Scheduler, error) {
+	if len(m.Coprocessors) > 0 {
+		return nil, fmt.Errorf("a machine with coprocessors can't be run deterministically")
+	}
+	input, _ := m.Devices[m.console].(cpusim.InputReceiver)
+	return cpusim.NewScheduler(m.Sim, input, script)
+}
+
+// Reset resets the CPUs and devices of all of the CPUs.
+func (m *Machine) Reset(kind cpusim.ResetKind) {
+	m.Sim.Reset(kind)
+	for _, sub := range m.Coprocessors {
+		sub.Sim.Reset(kind)
+	}
+}
+
+// Close closes the disk images of all of the CPUs.
+func (m *Machine) Close() error {
+	if m.Multi != nil {
+		return m.Multi.Close()
+	}
+	return m.Sim.Close()
+}
+
+// RestoreTerminal puts the terminal back the way it was.
+func (m *Machine) RestoreTerminal() {
+	for _, uart := range m.serial() {
+		uart.RestoreTerminal()
+	}
+}
+
+// serial returns the serial devices of all of the CPUs.
+func (m *Machine) serial() []cpusim.UartInterface {
+	serial := m.Serial
+	for _, sub := range m.Coprocessors {
+		serial = append(serial, sub.Serial...)
+	}
+	return serial
+}
+
+func (m *Machine) addCPU() error {
+	sim := m.Sim
+	switch m.Config.CPU.Type {
+	case "z80":
+		cpu := cpuz80.NewZ80(sim, "cpu")
+		if m.Config.CPU.PortMask != 0 {
+			cpu.PortAddressMask = uint16(m.Config.CPU.PortMask)
+		}
+		sim.AddCPU(cpu)
+		m.CPU = cpu
+
+		// Devices with an interrupt output drive /INT, the Zilog ones
+		// through the daisy chain.
+		m.intLine = sim.Signal(cpusim.SIGNAL_INT)
+		m.daisyChain = cpusim.NewDaisyChain(sim, "daisy", m.intLine)
+		return cpu.ConnectDaisyChain(m.daisyChain)
+	case "8008":
+		cpu := cpu8008.New8008(sim, "cpu")
+		sim.AddCPU(cpu)
+		m.CPU = cpu
+	case "4004", "4040":
+		cpu := cpu4004.New4004(sim, "cpu")
+		sim.AddCPU(cpu)
+		m.CPU = cpu
+		m.dcl = func(value byte) cpusim.EnablerInterface { return cpu.DCLEnabler(value) }
+	default:
+		return fmt.Errorf("unknown cpu type %q", m.Config.CPU.Type)
+	}
+	return nil
+}
+
+// enabler returns the enabler that a description names: always, never, an
+// enable bit (NAME or NAME.hi for its high side, NAME.lo for its low side),
+// or on the 4004, dcl:N for a RAM bank selected by DCL.
+func (m *Machine) enabler(name string) (cpusim.EnablerInterface, error) {
+	switch name {
+	case "", "always":
+		return &cpusim.AlwaysEnabled, nil
+	case "never":
+		return &cpusim.AlwaysDisabled, nil
+	}
+	if value, ok := strings.CutPrefix(name, "dcl:"); ok {
+		if m.dcl == nil {
+			return nil, fmt.Errorf("%s: only the 4004 has DCL", name)
+		}
+		n, err := strconv.ParseUint(value, 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DCL value in %q", name)
+		}
+		return m.dcl(byte(n)), nil
+	}
+	bitName, side, _ := strings.Cut(name, ".")
+	bit, ok := m.enableBits[bitName]
+	if !ok {
+		return nil, fmt.Errorf("unknown enable bit %q", bitName)
+	}
+	switch side {
+	case "", "hi":
+		return &bit.HiEnable, nil
+	case "lo":
+		return &bit.LoEnable, nil
+	}
+	return nil, fmt.Errorf("invalid enable %q; the side is hi or lo", name)
+}
+
+// connectOutputs wires a register's bits to enable bits.
+func (m *Machine) connectOutputs(outputs map[int]string, connect func(int, *cpusim.EnableBit)) error {
+	for bit, name := range outputs {
+		if bit < 0 || bit > 7 {
+			return fmt.Errorf("output bit %d is not D0-D7", bit)
+		}
+		enableBit, ok := m.enableBits[name]
+		if !ok {
+			return fmt.Errorf("unknown enable bit %q", name)
+		}
+		connect(bit, enableBit)
+	}
+	return nil
+}
+
+// pin parses a bus line name, A0-A19 or D0-D7, with the given prefix. An
+// empty name or "-" is no pin.
+func pin(name string, prefix string, count int) (int, error) {
+	if name == "" || name == "-" {
+		return cpusim.NOPIN, nil
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(name), prefix))
+	if !strings.HasPrefix(strings.ToUpper(name), prefix) || err != nil || n < 0 || n >= count {
+		return 0, fmt.Errorf("invalid pin %q; expected %s0-%s%d", name, prefix, prefix, count-1)
+	}
+	return n, nil
+}
+
+func (m *Machine) add(name string, device any) error {
+	if name == "" {
+		return fmt.Errorf("no name")
+	}
+	if _, ok := m.Devices[name]; ok {
+		return fmt.Errorf("there is already a device named %q", name)
+	}
+	m.Devices[name] = device
+	return nil
+}
+
+// The 4004's 8-bit bus and ROM port hold devices of their own.
+type portBus interface {
+	AddPort(port cpusim.MemoryInterface)
+}
+
+type memoryBus interface {
+	AddMemory(memory cpusim.MemoryInterface)
+}
+
+type mapperBus interface {
+	AddMapper(mapper cpusim.MapperInterface)
+}
+
+func (m *Machine) addToMemory(bus string, mem cpusim.MemoryInterface) error {
+	if bus == "" {
+		m.Sim.AddMemory(mem)
+		return nil
+	}
+	b, ok := m.Devices[bus].(memoryBus)
+	if !ok {
+		return fmt.Errorf("%q is not a bus with memory", bus)
+	}
+	b.AddMemory(mem)
+	return nil
+}
+
+func (m *Machine) addToPorts(bus string, port cpusim.MemoryInterface) error {
+	if bus == "" {
+		m.Sim.AddPort(port)
+		return nil
+	}
+	b, ok := m.Devices[bus].(portBus)
+	if !ok {
+		return fmt.Errorf("%q is not a bus with ports", bus)
+	}
+	b.AddPort(port)
+	return nil
+}
+
+func (m *Machine) addMemory(c MemoryConfig) error {
+	enabler, err := m.enabler(c.Enable)
+	if err != nil {
+		return err
+	}
+	var device cpusim.DeviceInterface
+	switch kind := strings.ToUpper(c.Kind); kind {
+	case cpusim.KIND_RAM, cpusim.KIND_ROM:
+		options := cpusim.MemoryOptions{Name: c.Name, Start: c.Start, End: c.End, AddressBits: c.Bits, Enabler: enabler}
+		if c.Status != nil {
+			options.StatusRows, options.StatusColumns = c.Status.Rows, c.Status.Columns
+		}
+		if device, err = cpusim.NewDevice(m.Sim, kind, options); err != nil {
+			return err
+		}
+		// A ROM is made read-only; the description can say otherwise
+		if c.ReadOnly != nil {
+			device.(*cpusim.Memory).ReadOnly = *c.ReadOnly
+		}
+	case "BUS8":
+		if m.dcl == nil {
+			return fmt.Errorf("the 8-bit bus is for the 4004")
+		}
+		if device, err = cpusim.NewDevice(m.Sim, cpu4004.KIND_BUS8, cpu4004.DeviceOptions{Name: c.Name, Enabler: enabler}); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown kind %q; expected ram, rom or bus8", c.Kind)
+	}
+	mem := device.(cpusim.MemoryInterface)
+	if err := m.add(c.Name, mem); err != nil {
+		return err
+	}
+	return m.addToMemory(c.Bus, mem)
+}
+
+func (m *Machine) addPort(c PortConfig) error {
+	enabler, err := m.enabler(c.Enable)
+	if err != nil {
+		return err
+	}
+	var device cpusim.DeviceInterface
+	options := cpusim.PortOptions{Name: c.Name, Address: c.Address, Value: c.Value, Enabler: enabler}
+	switch c.Type {
+	case "latch":
+		device, err = cpusim.NewDevice(m.Sim, cpusim.KIND_GENERIC_OUTPORT, options)
+	case "dipswitch":
+		device, err = cpusim.NewDevice(m.Sim, cpusim.KIND_INPORT, options)
+	case "sp0256":
+		device, err = cpusim.NewDevice(m.Sim, cpusim.KIND_SP0256_SPEECH_DEVICE, options)
+	case "romport":
+		if m.dcl == nil {
+			return fmt.Errorf("the ROM port is for the 4004")
+		}
+		device, err = cpusim.NewDevice(m.Sim, cpusim.KIND_ROMPORT, cpu4004.DeviceOptions{Name: c.Name, Enabler: enabler})
+	default:
+		return fmt.Errorf("unknown type %q; expected latch, dipswitch, sp0256 or romport", c.Type)
+	}
+	if err != nil {
+		return err
+	}
+	if latch, ok := device.(*cpusim.GenericOutputPort); ok {
+		if err := m.connectOutputs(c.Outputs, latch.ConnectEnableBit); err != nil {
+			return err
+		}
+	} else if len(c.Outputs) > 0 {
+		return fmt.Errorf("only a latch has outputs")
+	}
+	port := device.(cpusim.MemoryInterface)
+	if err := m.add(c.Name, port); err != nil {
+		return err
+	}
+	return m.addToPorts(c.Bus, port)
+}
+
+func (m *Machine) addMapper(c MapperConfig) error {
+	enabler, err := m.enabler(c.Enable)
+	if err != nil {
+		return err
+	}
+	options := cpusim.MapperOptions{Name: c.Name, Address: c.Address, Filter: strings.ToUpper(c.Filter), Enabler: enabler}
+	for _, name := range c.DestBits {
+		bit, err := pin(name, "A", 20)
+		if err != nil {
+			return err
+		}
+		options.DestBits = append(options.DestBits, bit)
+	}
+
+	var kind string
+	switch c.Type {
+	case "74670", "dual74670":
+		kind = cpusim.KIND_74670
+		if c.Type == "dual74670" {
+			kind = cpusim.KIND_DUAL_74670
+		}
+		if options.SourceBit, err = pin(c.SourceBit, "A", 20); err != nil {
+			return err
+		}
+		if options.DataBit, err = pin(c.DataBit, "D", 8); err != nil {
+			return err
+		}
+		if options.MapEnabler, err = m.enabler(c.MapEnable); err != nil {
+			return err
+		}
+	case "74173":
+		if c.SourceBit != "" || c.DataBit != "" || c.MapEnable != "" || len(c.Outputs) > 0 {
+			return fmt.Errorf("a 74173 only has an address, dest-bits, enable and filter")
+		}
+		kind = cpusim.KIND_74173
+	default:
+		return fmt.Errorf("unknown type %q; expected 74670, dual74670 or 74173", c.Type)
+	}
+	device, err := cpusim.NewDevice(m.Sim, kind, options)
+	if err != nil {
+		return err
+	}
+	if m670, ok := device.(*cpusim.Map670); ok {
+		if err := m.connectOutputs(c.Outputs, m670.ConnectEnableBit); err != nil {
+			return err
+		}
+	}
+	mapper := device.(interface {
+		cpusim.MapperInterface
+		cpusim.MemoryInterface
+	})
+	if err := m.add(c.Name, mapper); err != nil {
+		return err
+	}
+
+	if c.Bus == "" {
+		m.Sim.AddMapper(mapper)
+	} else {
+		b, ok := m.Devices[c.Bus].(mapperBus)
+		if !ok {
+			return fmt.Errorf("%q is not a bus with mappers", c.Bus)
+		}
+		b.AddMapper(mapper)
+	}
+
+	// The registers are on the CPU's ports unless they are somewhere else.
+	if c.Registers == "none" {
+		return nil
+	}
+	return m.addToPorts(c.Registers, mapper)
+}
+
+func (m *Machine) addSerial(c SerialConfig, console cpusim.SerialIO) error {
+	enabler, err := m.enabler(c.Enable)
+	if err != nil {
+		return err
+	}
+
+	serialIO, err := m.transport(c.Name, c.Transport, console)
+	if err != nil {
+		return err
+	}
+
+	var device cpusim.DeviceInterface
+	switch c.Type {
+	case "8251":
+		options := cpusim.UARTOptions{Name: c.Name, DataReadAddress: c.Data, DataWriteAddress: c.Data,
+			ControlReadAddress: c.Control, ControlWriteAddress: c.Control, Serial: serialIO, Enabler: enabler}
+		if c.DataWrite != nil {
+			options.DataWriteAddress = *c.DataWrite
+		}
+		if c.ControlWrite != nil {
+			options.ControlWriteAddress = *c.ControlWrite
+		}
+		device, err = cpusim.NewDevice(m.Sim, cpusim.KIND_UART, options)
+	case "acia":
+		device, err = cpusim.NewDevice(m.Sim, cpusim.KIND_ACIA, cpusim.ACIAOptions{Name: c.Name,
+			DataAddress: c.Data, ControlAddress: c.Control, Serial: serialIO, Enabler: enabler})
+	case "sio", "scc":
+		kind := cpusim.KIND_SIO
+		if c.Type == "scc" {
+			kind = cpusim.KIND_SCC
+		}
+		device, err = cpusim.NewDevice(m.Sim, kind, cpusim.SIOOptions{Name: c.Name,
+			DataAddressA: c.Data, DataAddressB: c.DataB, ControlAddressA: c.Control, ControlAddressB: c.ControlB,
+			Serial: serialIO, Enabler: enabler})
+	case "asci":
+		device, err = cpusim.NewDevice(m.Sim, cpusim.KIND_ASCI, cpusim.ASCIOptions{Name: c.Name,
+			BaseAddress: c.Address, Serial: serialIO, Enabler: enabler})
+	default:
+		return fmt.Errorf("unknown type %q; expected 8251, acia, sio, scc or asci", c.Type)
+	}
+	if err != nil {
+		return err
+	}
+	uart := device.(interface {
+		cpusim.UartInterface
+		cpusim.MemoryInterface
+	})
+	if err := m.add(c.Name, uart); err != nil {
+		return err
+	}
+	if c.Interrupt {
+		if err := m.connectInterrupt(c.Name, uart); err != nil {
+			return err
+		}
+	}
+	m.Serial = append(m.Serial, uart)
+	return m.addToPorts(c.Bus, uart)
+}
+
+// transport returns the serial transport that a device's description names.
+func (m *Machine) transport(name string, transport string, console cpusim.SerialIO) (cpusim.SerialIO, error) {
+	switch {
+	case transport == "" || transport == "console":
+		if m.console != "" {
+			return nil, fmt.Errorf("the console is already used by %s", m.console)
+		}
+		if console == nil {
+			console = cpusim.NewStdioSerial(true)
+		}
+		m.console = name
+		return console, nil
+	case transport == "null":
+		return nullSerial{}, nil
+	case strings.HasPrefix(transport, "file:"):
+		return cpusim.NewFileSerial(m.Config.path(strings.TrimPrefix(transport, "file:")), true)
+	}
+	return nil, fmt.Errorf("unknown transport %q; expected console, null or file:PATH", transport)
+}
+
+// connectInterrupt wires a device's interrupt output to the Z80, through the
+// daisy chain if it is a Zilog peripheral.
+func (m *Machine) connectInterrupt(name string, device any) error {
+	if m.intLine == nil {
+		return fmt.Errorf("interrupts are only wired up for the Z80")
+	}
+	if d, ok := device.(cpusim.DaisyChainDevice); ok {
+		m.daisyChain.Add(d)
+		return nil
+	}
+	d, ok := device.(interface{ ConnectInterrupt(*cpusim.SignalLine) })
+	if !ok {
+		return fmt.Errorf("%s has no interrupt output", name)
+	}
+	d.ConnectInterrupt(m.intLine)
+	return nil
+}
+
+func (m *Machine) hasDisk(name string) bool {
+	for _, c := range m.Config.Disks {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Machine) addDisk(c DiskConfig, image string) error {
+	if image == "" {
+		return nil
+	}
+	enabler, err := m.enabler(c.Enable)
+	if err != nil {
+		return err
+	}
+	var disk cpusim.MemoryInterface
+	switch c.Type {
+	case "cf":
+		device, err := cpusim.NewDevice(m.Sim, cpusim.KIND_CF, cpusim.CompactFlashOptions{Name: c.Name, BaseAddress: c.Address, Enabler: enabler})
+		if err != nil {
+			return err
+		}
+		cf := device.(*cpusim.CompactFlash)
+		if err := cf.AttachImage(image, c.Offset); err != nil {
+			return err
+		}
+		if c.Identify != "" {
+			if err := cf.LoadIdentify(c.Identify); err != nil {
+				return fmt.Errorf("loading identify from '%s': %w", c.Identify, err)
+			}
+		} else if c.Offset > 0 {
+			// An offset image without an identify file is taken to be an
+			// emulatorkit-style image, with the identify block at offset 512
+			if err := cf.LoadIdentifyFromImage(); err != nil {
+				return fmt.Errorf("loading identify from image: %w", err)
+			}
+		} else {
+			return fmt.Errorf("identify is required for a raw CF image")
+		}
+		disk = cf
+	case "fdc":
+		device, err := cpusim.NewDevice(m.Sim, cpusim.KIND_FDC, cpusim.FDCOptions{Name: c.Name,
+			PortMSR: c.MSR, PortData: c.Data, PortDOR: c.DOR, PortDCR: c.DCR, Enabler: enabler})
+		if err != nil {
+			return err
+		}
+		fdc := device.(*cpusim.FDC)
+		if err := fdc.AttachImage(c.Drive, image); err != nil {
+			return err
+		}
+		disk = fdc
+	default:
+		return fmt.Errorf("unknown type %q; expected cf or fdc", c.Type)
+	}
+	if err := m.add(c.Name, disk); err != nil {
+		return err
+	}
+	if c.Interrupt {
+		if err := m.connectInterrupt(c.Name, disk); err != nil {
+			return err
+		}
+	}
+	return m.addToPorts(c.Bus, disk)
+}
+
+// addDevice adds a device of any registered kind. Mappers map the CPU's
+// memory and have their registers on the ports, or on the bus.
+func (m *Machine) addDevice(c DeviceConfig, console cpusim.SerialIO) error {
+	options, err := cpusim.NewDeviceOptions(c.Kind)
+	if err != nil {
+		return err
+	}
+	if !c.Options.IsZero() {
+		// Re-encoded so that unknown options are errors, as elsewhere
+		data, err := yaml.Marshal(&c.Options)
+		if err != nil {
+			return err
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(options); err != nil {
+			return fmt.Errorf("options: %w", err)
+		}
+	}
+
+	// The options that every kind may have are set by name
+	fields := reflect.ValueOf(options).Elem()
+	if f := fields.FieldByName("Name"); f.IsValid() && f.Kind() == reflect.String {
+		f.SetString(c.Name)
+	}
+	if f := fields.FieldByName("Enabler"); f.IsValid() {
+		enabler, err := m.enabler(c.Enable)
+		if err != nil {
+			return err
+		}
+		f.Set(reflect.ValueOf(enabler))
+	}
+	if f := fields.FieldByName("Serial"); f.IsValid() {
+		serialIO, err := m.transport(c.Name, c.Transport, console)
+		if err != nil {
+			return err
+		}
+		f.Set(reflect.ValueOf(serialIO))
+	} else if c.Transport != "" {
+		return fmt.Errorf("a %s has no serial transport", c.Kind)
+	}
+
+	device, err := cpusim.NewDevice(m.Sim, c.Kind, options)
+	if err != nil {
+		return err
+	}
+	mem, ok := device.(cpusim.MemoryInterface)
+	if !ok {
+		return fmt.Errorf("a %s is not a memory or port device", c.Kind)
+	}
+	if err := m.add(c.Name, device); err != nil {
+		return err
+	}
+	if c.Interrupt {
+		if err := m.connectInterrupt(c.Name, device); err != nil {
+			return err
+		}
+	}
+	if uart, ok := device.(cpusim.UartInterface); ok {
+		m.Serial = append(m.Serial, uart)
+	}
+	if mapper, ok := device.(cpusim.MapperInterface); ok {
+		m.Sim.AddMapper(mapper)
+		return m.addToPorts(c.Bus, mem)
+	}
+	switch c.Space {
+	case "", "ports":
+		return m.addToPorts(c.Bus, mem)
+	case "memory":
+		return m.addToMemory(c.Bus, mem)
+	}
+	return fmt.Errorf("unknown space %q; expected memory or ports", c.Space)
+}
+
+// nullSerial is a serial transport with nothing connected: there is never
+// any input, and output is discarded.
+type nullSerial struct{}
+
+func (nullSerial) ReadByte() (byte, error) { select {} }
+func (nullSerial) WriteByte(b byte) error  { return nil }
+func (nullSerial) Start()                  {}
+func (nullSerial) RestoreTerminal()        {}
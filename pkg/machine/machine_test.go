@@ -0,0 +1,440 @@
+package machine
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfiles(t *testing.T) {
+	names := Profiles()
+	assert.Contains(t, names, "rc2014")
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := LoadProfile(name)
+			require.NoError(t, err)
+			assert.Equal(t, name, cfg.Name)
+			m, err := New(cpusim.NewCPUSim(), cfg, Options{Console: cpusim.NewChannelSerial()})
+			require.NoError(t, err)
+			assert.Len(t, m.Sim.CPU, 1)
+			assert.Len(t, m.Serial, 1)
+			assert.Contains(t, m.Devices, "rom")
+		})
+	}
+	_, err := LoadProfile("pdp11")
+	assert.ErrorContains(t, err, `no machine named "pdp11"`)
+}
+
+const testMachine = `
+cpu: {type: z80, port-mask: 0xFF}
+enable-bits: [ram-rom, map-enable]
+memory:
+  - {name: ram, kind: ram, start: 0, end: 0xFFFF, bits: 16, enable: ram-rom.hi}
+  - {name: rom, kind: rom, start: 0, end: 0xFFFF, bits: 16, enable: ram-rom.lo}
+ports:
+  - {name: latch, type: latch, address: 0x7C, outputs: {0: map-enable}}
+mappers:
+  - {name: mapper, type: "74670", address: 0x78, source-bit: A14, data-bit: D0, dest-bits: [A14, A15], map-enable: map-enable, outputs: {7: ram-rom}}
+serial:
+  - {name: uart, type: acia, data: 0x81, control: 0x80, interrupt: true}
+`
+
+func TestWiring(t *testing.T) {
+	cfg, err := ParseConfig([]byte(testMachine))
+	require.NoError(t, err)
+	sim := cpusim.NewCPUSim()
+	m, err := New(sim, cfg, Options{Console: cpusim.NewChannelSerial()})
+	require.NoError(t, err)
+
+	ram := m.Devices["ram"].(*cpusim.Memory)
+	rom := m.Devices["rom"].(*cpusim.Memory)
+	rom.Contents[0x0000] = 0xAA
+	ram.Contents[0x4000] = 0x55
+
+	// ROM until the mapper is on, then the page that register 0 selects
+	value, err := sim.ReadMemory(0x0000)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0xAA), value)
+	require.NoError(t, sim.WritePort(0x78, 0x81))
+	require.NoError(t, sim.WritePort(0x7C, 0x01))
+	value, err = sim.ReadMemory(0x0000)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0x55), value)
+}
+
+func TestConfigErrors(t *testing.T) {
+	tests := []struct {
+		config string
+		err    string
+	}{
+		{"cpu: {type: 6502}", `unknown cpu type "6502"`},
+		{"cpu: {type: z80}\nmemroy: []", "field memroy not found"},
+		{"cpu: {type: z80}\nmemory: [{name: ram, kind: ram, end: 0xFF, bits: 8, enable: cs}]", `memory ram: unknown enable bit "cs"`},
+		{"cpu: {type: z80}\nmemory: [{name: ram, kind: ram, end: 0xFF, bits: 8}, {name: ram, kind: rom, end: 0xFF, bits: 8}]", `memory ram: there is already a device named "ram"`},
+		{"cpu: {type: z80}\nmemory: [{name: ram, kind: ram, end: 0xFF, bits: 8, enable: \"dcl:0\"}]", "only the 4004 has DCL"},
+		{"cpu: {type: z80}\nmappers: [{name: m, type: \"74670\", source-bit: D3}]", `mapper m: invalid pin "D3"; expected A0-A19`},
+		{"cpu: {type: \"8008\"}\nserial: [{name: uart, type: \"8251\", interrupt: true}]", "serial uart: interrupts are only wired up for the Z80"},
+		{"cpu: {type: z80}\nserial: [{name: a, type: acia}, {name: b, type: acia}]", "serial b: the console is already used by a"},
+		{"cpu: {type: z80}\nserial: [{name: a, type: acia, bus: nowhere}]", `serial a: "nowhere" is not a bus with ports`},
+	}
+	for _, test := range tests {
+		cfg, err := ParseConfig([]byte(test.config))
+		if err == nil {
+			_, err = New(cpusim.NewCPUSim(), cfg, Options{Console: cpusim.NewChannelSerial()})
+		}
+		assert.ErrorContains(t, err, test.err, test.config)
+	}
+}
+
+func TestDisks(t *testing.T) {
+	dir := t.TempDir()
+	identify := make([]byte, 512)
+	identify[1*2], identify[3*2], identify[6*2] = 1, 1, 4 // one cylinder, one head, four sectors
+	raw := filepath.Join(dir, "raw.img")
+	require.NoError(t, os.WriteFile(raw, make([]byte, 4*512), 0644))
+	identifyFile := filepath.Join(dir, "identify.bin")
+	require.NoError(t, os.WriteFile(identifyFile, identify, 0644))
+	emulatorkit := filepath.Join(dir, "emulatorkit.img")
+	require.NoError(t, os.WriteFile(emulatorkit, append(append(make([]byte, 512), identify...), make([]byte, 4*512)...), 0644))
+
+	tests := []struct {
+		arg string
+		err string
+	}{
+		{"cf=" + raw + ",identify=" + identifyFile, ""},
+		{"cf=" + emulatorkit + ",offset=1024", ""},
+		{"cf=" + emulatorkit + ",offset=0x400", ""},
+		{"cf=" + raw, "identify is required for a raw CF image"},
+		{"cf=" + raw + ",offset=-1", "invalid offset"},
+		{"cf=" + raw + ",size=10", `unknown option "size"`},
+		{"cf", "expected NAME=IMAGE"},
+		{"hd=" + raw, `no disk named "hd"`},
+	}
+	for _, test := range tests {
+		cfg, err := LoadProfile("rc2014")
+		require.NoError(t, err)
+		name, disk, err := ParseDiskImage(test.arg)
+		if err == nil {
+			var m *Machine
+			m, err = New(cpusim.NewCPUSim(), cfg, Options{Console: cpusim.NewChannelSerial(), Disks: map[string]DiskImage{name: disk}})
+			if err == nil {
+				assert.Contains(t, m.Devices, "cf", test.arg)
+				m.Close() // nolint:errcheck
+			}
+		}
+		if test.err == "" {
+			assert.NoError(t, err, test.arg)
+		} else {
+			assert.ErrorContains(t, err, test.err, test.arg)
+		}
+	}
+}
+
+// switchOptions are the options of a device kind registered from outside
+// pkg/cpusim, as a board's own peripheral would be.
+type switchOptions struct {
+	Name     string         `yaml:"name"`
+	Address  cpusim.Address `yaml:"address"`
+	Switches byte           `yaml:"switches"`
+}
+
+func init() {
+	cpusim.RegisterDevice("TEST_SWITCHES", func(sim *cpusim.CpuSim, o switchOptions) (cpusim.DeviceInterface, error) {
+		return cpusim.NewDipSwitch(sim, o.Name, o.Address, o.Switches, &cpusim.AlwaysEnabled), nil
+	})
+}
+
+func TestDevices(t *testing.T) {
+	sim := cpusim.NewCPUSim()
+	device, err := cpusim.NewDevice(sim, cpusim.KIND_RAM, &cpusim.MemoryOptions{Name: "ram", End: 0xFF, AddressBits: 8})
+	require.NoError(t, err)
+	assert.Equal(t, "ram", device.GetName())
+	assert.False(t, device.(*cpusim.Memory).ReadOnly)
+
+	_, err = cpusim.NewDevice(sim, cpusim.KIND_ACIA, cpusim.MemoryOptions{})
+	assert.ErrorContains(t, err, "ACIA options are cpusim.ACIAOptions, not cpusim.MemoryOptions")
+	_, err = cpusim.NewDevice(sim, cpusim.KIND_ACIA, cpusim.ACIAOptions{Name: "uart"})
+	assert.ErrorContains(t, err, "no serial transport")
+	_, err = cpusim.NewDevice(sim, "PDP11", nil)
+	assert.ErrorContains(t, err, `no device kind "PDP11"`)
+	assert.Contains(t, cpusim.DeviceKinds(), "TEST_SWITCHES")
+
+	cfg, err := ParseConfig([]byte(`
+cpu: {type: z80, port-mask: 0xFF}
+devices:
+  - {name: switches, kind: TEST_SWITCHES, options: {address: 0x40, switches: 0x5A}}
+  - {name: uart, kind: ACIA, options: {data: 0x81, control: 0x80}, interrupt: true}
+`))
+	require.NoError(t, err)
+	m, err := New(cpusim.NewCPUSim(), cfg, Options{Console: cpusim.NewChannelSerial()})
+	require.NoError(t, err)
+	value, err := m.Sim.ReadPort(0x40)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0x5A), value)
+	assert.Len(t, m.Serial, 1)
+
+	cfg, err = ParseConfig([]byte("cpu: {type: z80}\ndevices: [{name: s, kind: TEST_SWITCHES, options: {adress: 0x40}}]"))
+	require.NoError(t, err)
+	_, err = New(cpusim.NewCPUSim(), cfg, Options{})
+	assert.ErrorContains(t, err, "field adress not found")
+}
+
+// The Z80 posts 42 to a mailbox in shared RAM and halts when the 8008 answers
+// 43 in the next byte.
+const testMultiprocessor = `
+cpu: {type: z80, clock: 7.3728MHz}
+memory:
+  - {name: rom, kind: rom, start: 0x0000, end: 0x0FFF, bits: 16}
+  - {name: mailbox, kind: ram, start: 0x2000, end: 0x20FF, bits: 16}
+coprocessors:
+  - cpu: {type: "8008", clock: 500kHz}
+    memory:
+      - {name: rom, kind: rom, start: 0x0000, end: 0x0FFF, bits: 16}
+    shared: [mailbox]
+`
+
+var (
+	hostProgram = []byte{
+		0x3E, 0x42, // LD A,42h
+		0x32, 0x00, 0x20, // LD (2000h),A
+		0x3A, 0x01, 0x20, // LD A,(2001h)
+		0xFE, 0x43, // CP 43h
+		0x20, 0xF9, // JR NZ,$-5
+		0x76, // HALT
+	}
+	coprocessorProgram = []byte{
+		0x2E, 0x20, // LHI 20h
+		0x36, 0x00, // LLI 00h
+		0xC7,       // LAM
+		0x3C, 0x42, // CPI 42h
+		0x48, 0x04, 0x00, // JFZ 0004h
+		0x36, 0x01, // LLI 01h
+		0x3E, 0x43, // LMI 43h
+		0x00, // HLT
+	}
+)
+
+func newMultiprocessor(t *testing.T, interleave string) *Machine {
+	cfg, err := ParseConfig([]byte(testMultiprocessor + "interleave: " + interleave + "\n"))
+	require.NoError(t, err)
+	m, err := New(cpusim.NewCPUSim(), cfg, Options{})
+	require.NoError(t, err)
+	require.Len(t, m.Coprocessors, 1)
+	copy(m.Devices["rom"].(*cpusim.Memory).Contents, hostProgram)
+	copy(m.Coprocessors[0].Devices["rom"].(*cpusim.Memory).Contents, coprocessorProgram)
+	return m
+}
+
+func TestMultiprocessor(t *testing.T) {
+	for _, interleave := range []string{"instructions", "cycles"} {
+		t.Run(interleave, func(t *testing.T) {
+			var cycles []uint64
+			for run := 0; run < 2; run++ {
+				m := newMultiprocessor(t, interleave)
+				result := m.Multi.Run(context.Background())
+				require.NoError(t, result.Err)
+				assert.Equal(t, cpusim.ExitHalted, result.Reason)
+				assert.False(t, m.Multi.Running())
+
+				value, err := m.Sim.ReadMemory(0x2001)
+				require.NoError(t, err)
+				assert.Equal(t, byte(0x43), value)
+				value, err = m.Coprocessors[0].Sim.ReadMemory(0x2000)
+				require.NoError(t, err)
+				assert.Equal(t, byte(0x42), value)
+
+				for _, cpu := range []cpusim.CpuInterface{m.CPU, m.Coprocessors[0].CPU} {
+					cycles = append(cycles, cpu.(cpusim.CycleCounter).ClockCycles())
+				}
+			}
+			// Lockstep runs interleave the same way every time
+			assert.Equal(t, cycles[:2], cycles[2:])
+		})
+	}
+
+	cfg, err := ParseConfig([]byte(testMultiprocessor + "interleave: free\n"))
+	require.NoError(t, err)
+	m, err := New(cpusim.NewCPUSim(), cfg, Options{})
+	require.NoError(t, err)
+	copy(m.Devices["rom"].(*cpusim.Memory).Contents, hostProgram)
+	copy(m.Coprocessors[0].Devices["rom"].(*cpusim.Memory).Contents, coprocessorProgram)
+	result := m.Run(context.Background())
+	require.NoError(t, result.Err)
+	value, err := m.Sim.ReadMemory(0x2001)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0x43), value)
+
+	// Free-running CPUs can't share a device whose enable another CPU drives
+	cfg, err = ParseConfig([]byte(strings.Replace(testMultiprocessor+"interleave: free\nenable-bits: [mailbox-on]\n",
+		"bits: 16}\ncoprocessors", "bits: 16, enable: mailbox-on.lo}\ncoprocessors", 1)))
+	require.NoError(t, err)
+	m, err = New(cpusim.NewCPUSim(), cfg, Options{})
+	require.NoError(t, err)
+	result = m.Run(context.Background())
+	assert.Equal(t, cpusim.ExitError, result.Reason)
+	assert.ErrorContains(t, result.Err, "mailbox can't be shared by CPUs that run free")
+
+	for _, test := range []struct{ config, err string }{
+		{"cpu: {type: z80}\ncoprocessors: [{cpu: {type: z80}, shared: [nothing]}]", `coprocessor 1: shared nothing: there is no device named "nothing"`},
+		{"cpu: {type: z80}\ninterleave: sideways\ncoprocessors: [{cpu: {type: z80}}]", `unknown interleave "sideways"`},
+		{"cpu: {type: z80}\nshared: [ram]", "only a coprocessor shares devices"},
+	} {
+		cfg, err := ParseConfig([]byte(test.config))
+		require.NoError(t, err)
+		_, err = New(cpusim.NewCPUSim(), cfg, Options{})
+		assert.ErrorContains(t, err, test.err, test.config)
+	}
+}
+
+// The Z80 counts at 8000h until it is stopped, or halts if 8001h is set.
+const testLifecycle = `
+cpu: {type: z80}
+memory:
+  - {name: rom, kind: rom, start: 0x0000, end: 0x0FFF, bits: 16}
+  - {name: ram, kind: ram, start: 0x8000, end: 0x80FF, bits: 16}
+`
+
+var lifecycleProgram = []byte{
+	0x21, 0x00, 0x80, // LD HL,8000h
+	0x34,             // INC (HL)
+	0x3A, 0x01, 0x80, // LD A,(8001h)
+	0xB7,       // OR A
+	0x28, 0xF9, // JR Z,$-5
+	0x76, // HALT
+}
+
+func TestLifecycle(t *testing.T) {
+	cfg, err := ParseConfig([]byte(testLifecycle))
+	require.NoError(t, err)
+	sim := cpusim.NewCPUSim()
+	sim.SetDebug(false)
+	m, err := New(sim, cfg, Options{})
+	require.NoError(t, err)
+	copy(m.Devices["rom"].(*cpusim.Memory).Contents, lifecycleProgram)
+	ram := m.Devices["ram"].(*cpusim.Memory)
+	pc := func() cpusim.Address { return m.CPU.(cpusim.DebugInterface).GetPC() }
+	var steps atomic.Int64
+	sim.AddStepHook(func() error {
+		steps.Add(1)
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan cpusim.RunResult)
+	go func() { done <- m.Run(ctx) }()
+
+	// A paused machine stays where it is
+	require.Eventually(t, func() bool { return steps.Load() > 0 }, time.Second, time.Millisecond)
+	m.Sim.Pause()
+	count := steps.Load()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, count, steps.Load())
+	assert.True(t, m.Sim.Paused())
+
+	// A warm reset keeps RAM, and a cold one clears it
+	ram.Contents[0] = 0x55
+	m.Reset(cpusim.ResetWarm)
+	assert.Equal(t, cpusim.Address(0), pc())
+	assert.Equal(t, byte(0x55), ram.Contents[0])
+	m.Reset(cpusim.ResetCold)
+	assert.Equal(t, byte(0), ram.Contents[0])
+	assert.True(t, m.Sim.Paused())
+
+	m.Sim.Resume()
+	require.Eventually(t, func() bool { return steps.Load() > count }, time.Second, time.Millisecond)
+	cancel()
+	result := <-done
+	assert.Equal(t, cpusim.ExitCanceled, result.Reason)
+	assert.Equal(t, m.CPU, result.CPU)
+	assert.False(t, m.Sim.Running())
+
+	// Run again carries on until the program halts
+	ram.Contents[1] = 1
+	result = m.Run(context.Background())
+	require.NoError(t, result.Err)
+	assert.Equal(t, cpusim.ExitHalted, result.Reason)
+	assert.Equal(t, cpusim.Address(0x000B), pc())
+	require.NoError(t, m.Close())
+}
+
+const testEcho = `
+cpu: {type: z80, port-mask: 0xFF}
+memory:
+  - {name: rom, kind: rom, start: 0x0000, end: 0x0FFF, bits: 16}
+serial:
+  - {name: uart, type: acia, data: 0x81, control: 0x80}
+`
+
+var echoProgram = []byte{
+	0xDB, 0x80, // IN A,(80h)
+	0xE6, 0x01, // AND 1
+	0x28, 0xFA, // JR Z,$-6
+	0xDB, 0x81, // IN A,(81h)
+	0xD3, 0x81, // OUT (81h),A
+	0x18, 0xF4, // JR $-12
+}
+
+func TestDeterministic(t *testing.T) {
+	script, err := cpusim.ParseInputScript(strings.NewReader(`
+# comments and blank lines are skipped
+
+500   "hello"
++1000 " world\r"
+`))
+	require.NoError(t, err)
+	require.Len(t, script, 2)
+	assert.Equal(t, uint64(1500), script[1].Cycle)
+
+	run := func(filename string) (string, uint64) {
+		cfg, err := ParseConfig([]byte(testEcho))
+		require.NoError(t, err)
+		sim := cpusim.NewCPUSim()
+		sim.SetDebug(false)
+		console := cpusim.NewChannelSerial()
+		m, err := New(sim, cfg, Options{Console: console})
+		require.NoError(t, err)
+		copy(m.Devices["rom"].(*cpusim.Memory).Contents, echoProgram)
+
+		tracer, err := sim.StartTrace(cpusim.TraceOptions{Filename: filename, Format: cpusim.TraceJSON})
+		require.NoError(t, err)
+		scheduler, err := m.Scheduler(script)
+		require.NoError(t, err)
+		scheduler.ExitAtEnd = true
+		result := scheduler.Run(context.Background())
+		require.NoError(t, result.Err)
+		assert.Equal(t, cpusim.ExitEndOfInput, result.Reason)
+		require.NoError(t, tracer.Close())
+
+		var output []byte
+		for len(console.Out) > 0 {
+			output = append(output, <-console.Out)
+		}
+		return string(output), scheduler.Clock()
+	}
+
+	dir := t.TempDir()
+	output1, clock1 := run(filepath.Join(dir, "1.json"))
+	output2, clock2 := run(filepath.Join(dir, "2.json"))
+	assert.Equal(t, "hello world\r", output1)
+	assert.Equal(t, output1, output2)
+	assert.Equal(t, clock1, clock2)
+	assert.GreaterOrEqual(t, clock1, uint64(1500))
+	trace1, err := os.ReadFile(filepath.Join(dir, "1.json"))
+	require.NoError(t, err)
+	trace2, err := os.ReadFile(filepath.Join(dir, "2.json"))
+	require.NoError(t, err)
+	assert.NotEmpty(t, trace1)
+	assert.True(t, bytes.Equal(trace1, trace2), "the traces differ")
+
+	_, err = cpusim.ParseInputScript(strings.NewReader("100 hello"))
+	assert.ErrorContains(t, err, "line 1: invalid string hello")
+}
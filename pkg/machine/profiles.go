@@ -0,0 +1,72 @@
+package machine
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+	"github.com/scottmbaker/gocpusim/pkg/cpusim/cpu4004"
+	"github.com/scottmbaker/gocpusim/pkg/cpusim/cpu8008"
+	"github.com/scottmbaker/gocpusim/pkg/cpusim/cpuz80"
+)
+
+// The built-in machines are the boards that have had commands of their own.
+// They describe the hardware only; the ROM comes from the command line.
+//
+//go:embed profiles/*.yaml
+var profiles embed.FS
+
+// Profiles returns the names of the built-in machines.
+func Profiles() []string {
+	entries, _ := profiles.ReadDir("profiles")
+	var names []string
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), ".yaml"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadProfile returns the description of a built-in machine.
+func LoadProfile(name string) (*Config, error) {
+	data, err := profiles.ReadFile(path.Join("profiles", name+".yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("no machine named %q; try list-machines", name)
+	}
+	cfg, err := ParseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return cfg, nil
+}
+
+// Lookup returns the description of a built-in machine, or reads it from a
+// file if name looks like a file name.
+func Lookup(name string) (*Config, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		return LoadConfig(name)
+	}
+	if strings.ContainsRune(name, filepath.Separator) {
+		return LoadConfig(name)
+	}
+	return LoadProfile(name)
+}
+
+// NewDisassembler returns a disassembler for a CPU type, as a machine
+// description names it.
+func NewDisassembler(cpuType string, symbols cpusim.SymbolLookup) (cpusim.Disassembler, error) {
+	switch cpuType {
+	case "z80":
+		return cpuz80.NewDisassembler(symbols), nil
+	case "8008":
+		return cpu8008.NewDisassembler(true, symbols), nil
+	case "4004", "4040":
+		return cpu4004.NewDisassembler(cpuType == "4040", symbols), nil
+	}
+	return nil, fmt.Errorf("unknown cpu type %q", cpuType)
+}
@@ -0,0 +1,634 @@
+// Package monitor provides a host-side console for examining and changing a
+// running machine.
+//
+// The monitor sits between a serial device and its transport, in the same way
+// as QEMU's console multiplexer. Everything typed goes to the guest until the
+// escape sequence Ctrl-A c, which stops the CPU and hands the console to the
+// monitor. Ctrl-A c (or the continue command) hands it back. Ctrl-A Ctrl-A
+// sends a Ctrl-A to the guest, and Ctrl-A x exits the simulator.
+package monitor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+)
+
+// EscapeChar starts a monitor escape sequence (Ctrl-A).
+const EscapeChar = 0x01
+
+const prompt = "(monitor) "
+
+// Monitor is a SerialIO that shares the console between the guest and the
+// monitor. Pass it to the serial device in place of the transport it wraps.
+type Monitor struct {
+	Sim      *cpusim.CpuSim
+	Debugger *cpusim.Debugger
+	Rewinder *cpusim.Rewinder // optional; enables the back and wback commands
+
+	serial  cpusim.SerialIO
+	cpu     cpusim.CpuInterface
+	active  atomic.Bool // the monitor has the console
+	escaped bool        // the previous byte was EscapeChar
+	line    []byte
+	lastCR  bool
+}
+
+// New attaches a monitor to the machine's CPU. Input read from serial goes to
+// the guest or the monitor; output from both is written to serial.
+func New(sim *cpusim.CpuSim, serial cpusim.SerialIO) (*Monitor, error) {
+	debugger, err := cpusim.NewDebugger(sim)
+	if err != nil {
+		return nil, err
+	}
+	m := &Monitor{
+		Sim:      sim,
+		Debugger: debugger,
+		serial:   serial,
+		cpu:      sim.CPU[0],
+	}
+	debugger.OnStop = m.breakpoint
+	return m, nil
+}
+
+func (m *Monitor) ReadByte() (byte, error) {
+	for {
+		b, err := m.serial.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		if m.escaped {
+			m.escaped = false
+			if b != EscapeChar {
+				m.escape(b)
+				continue
+			}
+			// Ctrl-A Ctrl-A is a literal Ctrl-A
+		} else if b == EscapeChar {
+			m.escaped = true
+			continue
+		}
+
+		if m.active.Load() {
+			m.input(b)
+			continue
+		}
+		return b, nil
+	}
+}
+
+func (m *Monitor) WriteByte(b byte) error {
+	return m.serial.WriteByte(b)
+}
+
+func (m *Monitor) Start() {
+	m.serial.Start()
+}
+
+func (m *Monitor) RestoreTerminal() {
+	m.serial.RestoreTerminal()
+}
+
+func (m *Monitor) printf(format string, args ...any) {
+	for _, b := range []byte(fmt.Sprintf(format, args...)) {
+		_ = m.serial.WriteByte(b)
+	}
+}
+
+func (m *Monitor) escape(b byte) {
+	switch b {
+	case 'c', 'C':
+		if m.active.Load() {
+			m.resume()
+		} else {
+			m.enter()
+		}
+	case 'x', 'X':
+		m.printf("\n")
+		m.quit()
+	case 'h', 'H', '?':
+		m.printf("\nC-a c    switch between the guest console and the monitor\n" +
+			"C-a x    exit the simulator\n" +
+			"C-a C-a  send C-a to the guest\n")
+		if m.active.Load() {
+			m.printf("%s%s", prompt, m.line)
+		}
+	}
+}
+
+// enter stops the CPU and hands the console to the monitor.
+func (m *Monitor) enter() {
+	if m.active.Swap(true) {
+		return
+	}
+	m.printf("\n")
+	if ev, err := m.Debugger.Stop(); err != nil {
+		m.printf("%v\n", err)
+	} else {
+		m.showStop(ev)
+	}
+	m.printf(prompt)
+}
+
+// breakpoint is the Debugger's OnStop, called on the CPU's goroutine.
+func (m *Monitor) breakpoint(ev cpusim.StopEvent) {
+	if m.active.Swap(true) {
+		return
+	}
+	m.printf("\n")
+	m.showStop(ev)
+	m.printf(prompt)
+}
+
+// resume hands the console back to the guest.
+func (m *Monitor) resume() {
+	m.line = m.line[:0]
+	m.active.Store(false)
+	m.printf("\n")
+	m.Debugger.Continue()
+}
+
+func (m *Monitor) quit() {
+	m.active.Store(false)
+	m.Sim.CtrlC.Store(true)
+	m.Debugger.Continue()
+}
+
+// input handles a byte typed at the monitor prompt.
+func (m *Monitor) input(b byte) {
+	cr := m.lastCR
+	m.lastCR = b == '\r'
+
+	switch {
+	case b == '\n' && cr:
+		// second half of CR LF
+	case b == '\r' || b == '\n':
+		m.printf("\n")
+		line := string(m.line)
+		m.line = m.line[:0]
+		m.execute(line)
+		if m.active.Load() {
+			m.printf(prompt)
+		}
+	case b == 0x7F || b == 0x08:
+		if len(m.line) > 0 {
+			m.line = m.line[:len(m.line)-1]
+			m.printf("\b \b")
+		}
+	case b >= 0x20 && b < 0x7F:
+		m.line = append(m.line, b)
+		_ = m.serial.WriteByte(b)
+	}
+}
+
+func (m *Monitor) showStop(ev cpusim.StopEvent) {
+	if ev.Reason == cpusim.StopBreakpoint {
+		m.printf("breakpoint at %s\n", m.Sim.DescribeAddress(ev.PC))
+	}
+	m.showRegisters()
+	if dis, ok := m.cpu.(cpusim.DisassemblerInterface); ok {
+		m.showInstruction(dis.Disassemble(m.Debugger.CPU.GetPC()))
+	}
+}
+
+func (m *Monitor) showInstruction(ins cpusim.Instruction) {
+	if m.Sim.Symbols != nil {
+		if name, ok := m.Sim.Symbols.LookupSymbol(ins.Address); ok {
+			m.printf("%s:\n", name)
+		}
+	}
+	m.printf("%04X: %-11s  %s\n", ins.Address, ins.Hex(), ins.Text())
+}
+
+// showRegisters prints each named register, joining XH and XL pairs into X.
+func (m *Monitor) showRegisters() {
+	names := m.Debugger.CPU.RegisterNames()
+	var fields []string
+	hasPC := false
+	for i := 0; i < len(names); i++ {
+		name := names[i]
+		if name == "" {
+			continue
+		}
+		if i+1 < len(names) && len(name) > 1 && strings.HasSuffix(name, "H") && names[i+1] == strings.TrimSuffix(name, "H")+"L" {
+			hi, errHi := m.cpu.GetReg(i)
+			lo, errLo := m.cpu.GetReg(i + 1)
+			if errHi == nil && errLo == nil {
+				pair := strings.TrimSuffix(name, "H")
+				hasPC = hasPC || pair == "PC"
+				fields = append(fields, fmt.Sprintf("%s=%04X", pair, uint16(hi)<<8|uint16(lo)))
+				i++
+				continue
+			}
+		}
+		if value, err := m.cpu.GetReg(i); err == nil {
+			fields = append(fields, fmt.Sprintf("%s=%02X", name, value))
+		}
+	}
+	if !hasPC {
+		fields = append(fields, fmt.Sprintf("PC=%04X", m.Debugger.CPU.GetPC()))
+	}
+	m.printf("%s\n", strings.Join(fields, " "))
+}
+
+const help = `c, continue          return to the guest
+s, step [N]          execute N instructions (default 1)
+r, regs              show the registers
+r NAME VALUE         set a register; 16-bit pairs such as HL, SP and PC too
+b, break [ADDR]      set a breakpoint, or list the breakpoints
+d, delete ADDR       delete a breakpoint
+x ADDR [LEN]         examine LEN bytes of memory (default 64)
+l [ADDR [N]]         disassemble N instructions (default 10) from ADDR or PC
+m ADDR VALUE...      modify memory
+i PORT               read a port (the device sees the read)
+o PORT VALUE         write a port
+back [N]             step back N instructions (default 1, needs --rewind)
+wback ADDR           rewind to the last write to ADDR (needs --rewind)
+trace on|off         print every instruction, as --debug does
+q, quit              exit the simulator
+Numbers are hexadecimal. An ADDR can also be a symbol, e.g. PUTCHAR+3, and a
+symbol wins over a number spelt the same; write 0ADD or $C for the number.
+`
+
+func (m *Monitor) execute(line string) {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return
+	}
+
+	var err error
+	switch cmd, args := strings.ToLower(args[0]), args[1:]; cmd {
+	case "h", "help", "?":
+		m.printf("%s", help)
+	case "c", "continue":
+		m.resume()
+	case "s", "step":
+		err = m.step(args)
+	case "r", "regs":
+		err = m.registers(args)
+	case "b", "break":
+		err = m.breakCommand(args)
+	case "d", "delete":
+		err = m.delete(args)
+	case "x":
+		err = m.examine(args)
+	case "l", "list":
+		err = m.list(args)
+	case "m":
+		err = m.modify(args)
+	case "i":
+		err = m.in(args)
+	case "o":
+		err = m.out(args)
+	case "back":
+		err = m.back(args)
+	case "wback":
+		err = m.writeBack(args)
+	case "trace":
+		err = m.trace(args)
+	case "q", "quit":
+		m.quit()
+	default:
+		err = fmt.Errorf("unknown command %q, try help", cmd)
+	}
+	if err != nil {
+		m.printf("%v\n", err)
+	}
+}
+
+// parseNumber parses a hexadecimal number, with an optional 0x or $ prefix
+// or h suffix.
+func parseNumber(s string) (uint64, error) {
+	str := strings.ToLower(s)
+	str = strings.TrimPrefix(str, "0x")
+	str = strings.TrimPrefix(str, "$")
+	str = strings.TrimSuffix(str, "h")
+	value, err := strconv.ParseUint(str, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return value, nil
+}
+
+func parseByte(s string) (byte, error) {
+	value, err := parseNumber(s)
+	if err != nil {
+		return 0, err
+	}
+	if value > 0xFF {
+		return 0, fmt.Errorf("%s does not fit in a byte", s)
+	}
+	return byte(value), nil
+}
+
+func parseAddress(s string) (cpusim.Address, error) {
+	value, err := parseNumber(s)
+	return cpusim.Address(value), err
+}
+
+// parseLocation parses a memory address, given as a symbol with an optional
+// hex offset, e.g. PUTCHAR+3, or as a number. A symbol wins over a number
+// that is spelt the same, such as ADD.
+func (m *Monitor) parseLocation(s string) (cpusim.Address, error) {
+	if symbol, ok := m.Sim.ResolveSymbol(s); ok {
+		return symbol, nil
+	}
+	return parseAddress(s)
+}
+
+func (m *Monitor) step(args []string) error {
+	n := uint64(1)
+	if len(args) > 0 {
+		var err error
+		if n, err = parseNumber(args[0]); err != nil {
+			return err
+		}
+	}
+	ev, err := m.Debugger.Step(n)
+	if err != nil {
+		return err
+	}
+	m.showStop(ev)
+	return nil
+}
+
+func (m *Monitor) registers(args []string) error {
+	switch len(args) {
+	case 0:
+		m.showRegisters()
+		return nil
+	case 2:
+		return m.setRegister(args[0], args[1])
+	default:
+		return fmt.Errorf("usage: r [NAME VALUE]")
+	}
+}
+
+func (m *Monitor) setRegister(name string, valueStr string) error {
+	value, err := parseNumber(valueStr)
+	if err != nil {
+		return err
+	}
+	name = strings.ToUpper(name)
+	if name == "PC" {
+		m.Debugger.CPU.SetPC(cpusim.Address(value))
+		return nil
+	}
+
+	names := m.Debugger.CPU.RegisterNames()
+	find := func(name string) int {
+		for i, n := range names {
+			if n != "" && strings.EqualFold(n, name) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	if reg := find(name); reg >= 0 {
+		if value > 0xFF {
+			return fmt.Errorf("%s is an 8-bit register", name)
+		}
+		return m.cpu.SetReg(reg, byte(value))
+	}
+
+	// 16-bit pairs are either XH and XL (SP, IX) or two registers (HL)
+	hi, lo := find(name+"H"), find(name+"L")
+	if (hi < 0 || lo < 0) && len(name) == 2 {
+		hi, lo = find(name[:1]), find(name[1:])
+	}
+	if hi < 0 || lo < 0 {
+		return fmt.Errorf("unknown register %s", name)
+	}
+	if value > 0xFFFF {
+		return fmt.Errorf("%s is a 16-bit register", name)
+	}
+	if err := m.cpu.SetReg(hi, byte(value>>8)); err != nil {
+		return err
+	}
+	return m.cpu.SetReg(lo, byte(value))
+}
+
+func (m *Monitor) breakCommand(args []string) error {
+	if len(args) == 0 {
+		breakpoints := m.Debugger.Breakpoints()
+		if len(breakpoints) == 0 {
+			m.printf("no breakpoints\n")
+		}
+		for _, address := range breakpoints {
+			if name := m.Sim.DescribeAddress(address); name != fmt.Sprintf("%04X", address) {
+				m.printf("%04X  %s\n", address, name)
+				continue
+			}
+			m.printf("%04X\n", address)
+		}
+		return nil
+	}
+	for _, arg := range args {
+		address, err := m.parseLocation(arg)
+		if err != nil {
+			return err
+		}
+		m.Debugger.AddBreakpoint(address)
+	}
+	return nil
+}
+
+func (m *Monitor) delete(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: d ADDR")
+	}
+	for _, arg := range args {
+		address, err := m.parseLocation(arg)
+		if err != nil {
+			return err
+		}
+		if !m.Debugger.RemoveBreakpoint(address) {
+			return fmt.Errorf("no breakpoint at %04X", address)
+		}
+	}
+	return nil
+}
+
+func (m *Monitor) examine(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: x ADDR [LEN]")
+	}
+	address, err := m.parseLocation(args[0])
+	if err != nil {
+		return err
+	}
+	length := uint64(64)
+	if len(args) == 2 {
+		if length, err = parseNumber(args[1]); err != nil {
+			return err
+		}
+	}
+
+	for length > 0 {
+		n := min(length, 16-uint64(address%16))
+		var hex, ascii strings.Builder
+		hex.WriteString(strings.Repeat("   ", int(address%16)))
+		for i := uint64(0); i < n; i++ {
+			value, err := m.Sim.ReadMemory(address + cpusim.Address(i))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&hex, " %02X", value)
+			if value >= 0x20 && value < 0x7F {
+				ascii.WriteByte(value)
+			} else {
+				ascii.WriteByte('.')
+			}
+		}
+		m.printf("%04X:%-48s  %s\n", address&^0xF, hex.String(), ascii.String())
+		address += cpusim.Address(n)
+		length -= n
+	}
+	return nil
+}
+
+func (m *Monitor) list(args []string) error {
+	dis, ok := m.cpu.(cpusim.DisassemblerInterface)
+	if !ok {
+		return fmt.Errorf("this CPU has no disassembler")
+	}
+	if len(args) > 2 {
+		return fmt.Errorf("usage: l [ADDR [N]]")
+	}
+	address := m.Debugger.CPU.GetPC()
+	count := uint64(10)
+	var err error
+	if len(args) >= 1 {
+		if address, err = m.parseLocation(args[0]); err != nil {
+			return err
+		}
+	}
+	if len(args) == 2 {
+		if count, err = parseNumber(args[1]); err != nil {
+			return err
+		}
+	}
+
+	for ; count > 0; count-- {
+		ins := dis.Disassemble(address)
+		m.showInstruction(ins)
+		address += cpusim.Address(ins.Len())
+	}
+	return nil
+}
+
+func (m *Monitor) modify(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: m ADDR VALUE...")
+	}
+	address, err := m.parseLocation(args[0])
+	if err != nil {
+		return err
+	}
+	for _, arg := range args[1:] {
+		value, err := parseByte(arg)
+		if err != nil {
+			return err
+		}
+		if err := m.Sim.WriteMemory(address, value); err != nil {
+			return err
+		}
+		address++
+	}
+	return nil
+}
+
+func (m *Monitor) in(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: i PORT")
+	}
+	port, err := parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+	value, err := m.Sim.ReadPort(port)
+	if err != nil {
+		return err
+	}
+	m.printf("%02X\n", value)
+	return nil
+}
+
+func (m *Monitor) out(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: o PORT VALUE")
+	}
+	port, err := parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+	value, err := parseByte(args[1])
+	if err != nil {
+		return err
+	}
+	return m.Sim.WritePort(port, value)
+}
+
+func (m *Monitor) rewinder() (*cpusim.Rewinder, error) {
+	if m.Rewinder == nil {
+		return nil, fmt.Errorf("rewind is not enabled")
+	}
+	if m.Sim.Running() && !m.Debugger.Stopped() {
+		return nil, fmt.Errorf("the CPU must be stopped to rewind")
+	}
+	return m.Rewinder, nil
+}
+
+func (m *Monitor) back(args []string) error {
+	r, err := m.rewinder()
+	if err != nil {
+		return err
+	}
+	n := uint64(1)
+	if len(args) > 0 {
+		if n, err = parseNumber(args[0]); err != nil {
+			return err
+		}
+	}
+	if err := r.StepBack(n); err != nil {
+		return err
+	}
+	m.showRegisters()
+	return nil
+}
+
+func (m *Monitor) writeBack(args []string) error {
+	r, err := m.rewinder()
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("usage: wback ADDR")
+	}
+	address, err := m.parseLocation(args[0])
+	if err != nil {
+		return err
+	}
+	found, err := r.RunBackToWrite(address)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no recorded write to %04X", address)
+	}
+	m.showRegisters()
+	return nil
+}
+
+func (m *Monitor) trace(args []string) error {
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		return fmt.Errorf("usage: trace on|off")
+	}
+	m.Sim.SetDebug(args[0] == "on")
+	return nil
+}
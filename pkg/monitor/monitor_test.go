@@ -0,0 +1,112 @@
+package monitor
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scottmbaker/gocpusim/pkg/cpusim"
+	"github.com/scottmbaker/gocpusim/pkg/cpusim/cpuz80"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMonitor(t *testing.T) {
+	sim := cpusim.NewCPUSim()
+	sim.SetDebug(false)
+	cpu := cpuz80.NewZ80(sim, "test-cpu")
+	cpu.PortAddressMask = 0xFF
+	sim.AddCPU(cpu)
+	ram := cpusim.NewMemory(sim, "ram", cpusim.KIND_RAM, 0x0000, 0xFFFF, 16, false, &cpusim.AlwaysEnabled)
+	sim.AddMemory(ram)
+
+	// loop: INC A; JR loop
+	copy(ram.Contents[0x0100:], []byte{0x3C, 0x18, 0xFD})
+	cpu.PC = 0x0100
+	cpu.SP = 0xF000
+
+	serial := cpusim.NewChannelSerial()
+	mon, err := New(sim, serial)
+	require.NoError(t, err)
+	acia := cpusim.NewACIA(sim, mon, "acia", 0x81, 0x80, &cpusim.AlwaysEnabled)
+	sim.AddPort(acia)
+
+	var output strings.Builder
+	send := func(s string) {
+		for _, b := range []byte(s) {
+			serial.In <- b
+		}
+	}
+	expect := func(s string) string {
+		t.Helper()
+		deadline := time.After(5 * time.Second)
+		for !strings.HasSuffix(output.String(), s) {
+			select {
+			case b := <-serial.Out:
+				output.WriteByte(b)
+			case <-deadline:
+				require.FailNow(t, "timed out waiting for output", "want %q, got %q", s, output.String())
+			}
+		}
+		out := output.String()
+		output.Reset()
+		return out
+	}
+
+	var wg sync.WaitGroup
+	sim.Start(&wg)
+	acia.Start(&wg)
+
+	command := func(s string) string {
+		t.Helper()
+		send(s + "\r")
+		return expect("(monitor) ")
+	}
+
+	send("\x01c")
+	out := expect("(monitor) ")
+	assert.Contains(t, out, "SP=F000")
+
+	command("r pc 100")
+	command("r a 41")
+	command("r hl 1234")
+	out = command("s")
+	assert.Contains(t, out, "A=42")
+	assert.Contains(t, out, "H=12 L=34")
+	assert.Contains(t, out, "PC=0101")
+
+	out = command("x 100 3")
+	assert.Contains(t, out, "0100: 3C 18 FD")
+
+	out = command("l 100 2")
+	assert.Contains(t, out, "0100: 3C           INC A")
+	assert.Contains(t, out, "0101: 18 FD        JR 0100H")
+
+	command("b 101")
+	out = command("c")
+	assert.Contains(t, out, "breakpoint at 0101")
+
+	send("q\r")
+	wg.Wait()
+}
+
+func TestParseLocation(t *testing.T) {
+	sim := cpusim.NewCPUSim()
+	table := cpusim.NewSymbolTable(sim)
+	table.Add(cpusim.Symbol{Name: "BEACH", Address: 0x0400, Bank: cpusim.NoBank})
+	sim.Symbols = table
+	m := &Monitor{Sim: sim}
+
+	address, err := m.parseLocation("BEACH")
+	require.NoError(t, err)
+	assert.Equal(t, cpusim.Address(0x0400), address)
+
+	address, err = m.parseLocation("BEACH+1")
+	require.NoError(t, err)
+	assert.Equal(t, cpusim.Address(0x0401), address)
+
+	address, err = m.parseLocation("0BEACH")
+	require.NoError(t, err)
+	assert.Equal(t, cpusim.Address(0xBEAC), address)
+}